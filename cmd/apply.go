@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/history"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/cobra"
+)
+
+func applyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply [history-id]",
+		Short: "Apply a suggested diff from a saved review",
+		Example: `  bark apply latest                     // list the suggestions of the last review
+  bark apply 20260101-120000 --suggestion 2 --preview
+  bark apply 20260101-120000 --suggestion 2
+  bark apply 20260101-120000 --undo`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id := history.Latest
+			if len(args) > 0 {
+				id = args[0]
+			}
+
+			if err := runApplyCmd(cmd, id); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	}
+
+	cmd.Flags().IntP("suggestion", "s", 0, "Number of the suggestion to apply (omit to list them)")
+	cmd.Flags().Bool("preview", false, "Show the patch as it would apply to the current file without writing it")
+	cmd.Flags().Bool("undo", false, "Revert the last suggestion applied from this review")
+
+	cmd.MarkFlagsMutuallyExclusive("suggestion", "undo")
+
+	return cmd
+}
+
+func runApplyCmd(cmd *cobra.Command, id string) error {
+	suggestion, _ := cmd.Flags().GetInt("suggestion")
+	preview, _ := cmd.Flags().GetBool("preview")
+	undo, _ := cmd.Flags().GetBool("undo")
+
+	if preview && suggestion == 0 {
+		return fmt.Errorf("--preview requires --suggestion")
+	}
+
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
+	return plain.RunApply(plain.ApplyOptions{
+		Storage:    storage,
+		ID:         id,
+		Suggestion: suggestion,
+		Preview:    preview,
+		Undo:       undo,
+	})
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/cobra"
+)
+
+func cacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect or clear the response cache",
+		Long: `Inspect or clear the response cache in ~/.bark/cache.
+
+When response_cache is enabled in the config, requests repeated with the same provider, model, options and prompts are answered from the cache instead of the provider. Use --no-cache to skip it for one run.`,
+		Example: `  bark cache stats
+  bark cache clear`,
+		Args: cobra.NoArgs,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "stats",
+		Short: "Show the size and contents of the response cache",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCacheCmd(plain.RunCacheStats); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Remove every cached response",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCacheCmd(plain.RunCacheClear); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	})
+
+	return cmd
+}
+
+func runCacheCmd(run func(plain.CacheOptions) error) error {
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
+	return run(plain.CacheOptions{
+		Storage: storage,
+		Config:  config.New(),
+	})
+}
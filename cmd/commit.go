@@ -28,7 +28,8 @@ func commitCmd() *cobra.Command {
 	cmd.Flags().BoolP("all", "a", false, "Include all changes")
 	cmd.Flags().StringP("hint", "i", "", "Provide a hint for the commit message generation (e.g., 'feature/fix/docs')")
 	cmd.Flags().StringP("model", "m", "", "LLM model to use (overrides config)")
-	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama")
+	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama, openai_compatible, replay")
+	cmd.Flags().Bool("dry-run", false, "Print the prompts and estimated token counts without calling the LLM (implies plain mode)")
 
 	return cmd
 }
@@ -38,9 +39,11 @@ func runCommitCmd(cmd *cobra.Command) error {
 	hint, _ := cmd.Flags().GetString("hint")
 	model, _ := cmd.Flags().GetString("model")
 	provider, _ := cmd.Flags().GetString("provider")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	cfg := config.New()
 
+	applyGlobalFlags(cmd, cfg)
 	cfg.OverrideModel(model)
 
 	if err := cfg.OverrideProvider(provider); err != nil {
@@ -53,22 +56,24 @@ func runCommitCmd(cmd *cobra.Command) error {
 		return err
 	}
 
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
 	// Plain mode: stdin piped or --plain flag or stdout piped
 	if stdinDiff != nil || isPlainMode(cmd) {
 		return plain.RunCommit(plain.CommitOptions{
-			Diff:   stdinDiff,
-			All:    all,
-			Hint:   hint,
-			Config: cfg,
+			Storage: storage,
+			Diff:    stdinDiff,
+			All:     all,
+			Hint:    hint,
+			Config:  cfg,
+			DryRun:  dryRun,
 		})
 	}
 
 	// TUI mode
-	storage, err := config.GetStorage()
-	if err != nil {
-		return fmt.Errorf("error getting storage: %w", err)
-	}
-
 	m := tui.New(tui.Options{
 		Task:       tui.TaskCommit,
 		Storage:    storage,
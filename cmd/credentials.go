@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/credentials"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/cobra"
+)
+
+func credentialsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Show where API keys are read from, or store them encrypted",
+		Long: `Show where API keys are read from, or store them encrypted.
+
+Each provider's API key is read from the first source that has one:
+
+  1. its environment variable, e.g. ANTHROPIC_API_KEY
+  2. api_key_command in its [credentials.<provider>] table, e.g. "pass show anthropic"
+  3. api_key_file in its [credentials.<provider>] table, which must not be readable by other users
+  4. the encrypted credentials file, ~/.bark/credentials.enc, unlocked with a passphrase prompted for
+     on the terminal, or with BARK_CREDENTIALS_PASSPHRASE where there is none, such as in CI`,
+		Example: `  bark credentials status
+  pass show anthropic | bark credentials set anthropic
+  bark credentials remove anthropic`,
+		Args: cobra.NoArgs,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the sources tried for each provider's API key, in order",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCredentialsCmd(plain.CredentialsOptions{}, plain.RunCredentialsStatus); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:       "set <provider>",
+		Short:     "Store a provider's API key in the encrypted credentials file",
+		Long:      "Store a provider's API key in the encrypted credentials file. The key is read from stdin when piped, or prompted for.",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: credentials.Providers,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCredentialsSet(args[0]); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:       "remove <provider>",
+		Short:     "Remove a provider's API key from the encrypted credentials file",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: credentials.Providers,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runCredentialsRemove(args[0]); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	})
+
+	return cmd
+}
+
+func runCredentialsSet(provider string) error {
+	var key string
+	if hasStdinData() {
+		data, err := readStdin()
+		if err != nil {
+			return err
+		}
+		key, _, _ = strings.Cut(data, "\n")
+	} else {
+		var err error
+		if key, err = readSecret(fmt.Sprintf("API key for %s: ", provider)); err != nil {
+			return err
+		}
+	}
+
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+	_, statErr := os.Stat(credentials.StorePath(storage))
+	passphrase, err := readPassphrase(errors.Is(statErr, os.ErrNotExist))
+	if err != nil {
+		return err
+	}
+
+	return runCredentialsCmd(plain.CredentialsOptions{
+		Provider:   provider,
+		Key:        strings.TrimSpace(key),
+		Passphrase: passphrase,
+	}, plain.RunCredentialsSet)
+}
+
+func runCredentialsRemove(provider string) error {
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return err
+	}
+
+	return runCredentialsCmd(plain.CredentialsOptions{
+		Provider:   provider,
+		Passphrase: passphrase,
+	}, plain.RunCredentialsRemove)
+}
+
+func runCredentialsCmd(opts plain.CredentialsOptions, run func(plain.CredentialsOptions) error) error {
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
+	opts.Storage = storage
+	opts.Config = config.New()
+	return run(opts)
+}
+
+// readPassphrase returns the passphrase of the credentials file from
+// BARK_CREDENTIALS_PASSPHRASE, or prompts for it, twice when the file is
+// being created.
+func readPassphrase(creating bool) (string, error) {
+	if passphrase := os.Getenv(credentials.PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if hasStdinData() {
+		return "", fmt.Errorf("set %s to unlock the credentials file when stdin is piped", credentials.PassphraseEnv)
+	}
+
+	passphrase, err := readSecret("Passphrase: ")
+	if err != nil || !creating {
+		return passphrase, err
+	}
+
+	again, err := readSecret("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", errors.New("the passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+// readSecret prompts for a value without echoing it.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("error reading input: %w", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
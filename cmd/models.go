@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/cobra"
+)
+
+func modelsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "models",
+		Short: "List the models each provider serves",
+		Long: `List the models each provider serves, with their context window, output limit and capabilities where the provider reports them.
+
+Without --provider, every provider with credentials is listed, and the configured provider.`,
+		Example: `  bark models
+  bark models --provider anthropic`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runModelsCmd(cmd); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringP("provider", "P", "", "Only list the models of this provider: gemini, vertexai, openai, anthropic, ollama, openai_compatible, replay")
+
+	return cmd
+}
+
+func runModelsCmd(cmd *cobra.Command) error {
+	provider, _ := cmd.Flags().GetString("provider")
+
+	cfg := config.New()
+	if err := cfg.OverrideProvider(provider); err != nil {
+		return err
+	}
+
+	return plain.RunModels(plain.ModelsOptions{
+		Config:   cfg,
+		Provider: provider,
+	})
+}
@@ -28,9 +28,10 @@ func prCmd() *cobra.Command {
 	cmd.Flags().StringP("branch", "b", "", "The base branch to compare against (optional)")
 	cmd.Flags().StringP("pr", "p", "", "Generate a description for a GitHub pull request by number (requires gh CLI)")
 	cmd.Flags().StringP("model", "m", "", "LLM model to use (overrides config)")
-	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama")
+	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama, openai_compatible, replay")
 	cmd.Flags().StringP("instructions", "i", "", "Custom instructions (file path or raw text, overrides default PR instructions)")
 	cmd.Flags().Uint32("max-diff-lines", 0, "Maximum number of diff lines to include in the prompt (0 disables the limit)")
+	cmd.Flags().Bool("dry-run", false, "Print the prompts and estimated token counts without calling the LLM (implies plain mode)")
 
 	cmd.MarkFlagsMutuallyExclusive("branch", "pr")
 
@@ -43,6 +44,7 @@ func runPRCmd(cmd *cobra.Command) error {
 	model, _ := cmd.Flags().GetString("model")
 	provider, _ := cmd.Flags().GetString("provider")
 	instructions, _ := cmd.Flags().GetString("instructions")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	cfg := config.New()
 
@@ -51,6 +53,7 @@ func runPRCmd(cmd *cobra.Command) error {
 		return err
 	}
 
+	applyGlobalFlags(cmd, cfg)
 	cfg.OverrideModel(model)
 
 	if err := cfg.OverrideProvider(provider); err != nil {
@@ -62,22 +65,24 @@ func runPRCmd(cmd *cobra.Command) error {
 		cfg.OverrideMaxDiffLines(maxDiffLines)
 	}
 
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
 	if stdinDiff != nil || isPlainMode(cmd) {
 		return plain.RunPR(plain.PROptions{
+			Storage:      storage,
 			Diff:         stdinDiff,
 			Branch:       branch,
 			PR:           pr,
 			Instructions: instructions,
 			Config:       cfg,
+			DryRun:       dryRun,
 		})
 	}
 
 	// TUI mode
-	storage, err := config.GetStorage()
-	if err != nil {
-		return fmt.Errorf("error getting storage: %w", err)
-	}
-
 	m := tui.New(tui.Options{
 		Task:    tui.TaskPRDescription,
 		Storage: storage,
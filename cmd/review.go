@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	tea "charm.land/bubbletea/v2"
 	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/findings"
 	"github.com/ionut-t/bark/v2/internal/plain"
 	"github.com/ionut-t/bark/v2/tui"
 	"github.com/spf13/cobra"
@@ -16,18 +19,25 @@ func reviewCmd() *cobra.Command {
 		Short: "Review code changes",
 		Run: func(cmd *cobra.Command, args []string) {
 			if err := runReviewCmd(cmd); err != nil {
-				if hasStdinData() || isPlainMode(cmd) {
+				// The count summary is already on stderr; only the exit
+				// code is left to report.
+				if _, ok := errors.AsType[*plain.ThresholdError](err); ok {
+					os.Exit(plain.ExitCodeFindings)
+				}
+
+				if hasStdinData() || isPlainReview(cmd) {
 					plain.Errf("%s", err)
 				} else {
 					PrintError(err)
 				}
+				os.Exit(1)
 			}
 		},
 	}
 
 	cmd.Flags().String("as", "", "Specify the reviewer to use directly")
 	cmd.Flags().StringP("model", "m", "", "LLM model to use (overrides config)")
-	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama")
+	cmd.Flags().StringP("provider", "P", "", "LLM provider to use (overrides config): gemini, vertexai, openai, anthropic, ollama, openai_compatible, replay")
 	cmd.Flags().BoolP("commit", "t", false, "Select commit to review")
 	cmd.Flags().BoolP("changes", "c", false, "Review current changes")
 	cmd.Flags().StringP("instructions", "i", "", "Custom instructions to guide the reviewer's feedback")
@@ -38,14 +48,30 @@ func reviewCmd() *cobra.Command {
 	cmd.Flags().BoolP("stream", "S", false, "Stream the review output in real-time (only for plain mode)")
 	cmd.Flags().StringP("pr", "p", "", "Review a GitHub pull request by number (requires gh CLI)")
 	cmd.Flags().Uint32("max-diff-lines", 0, "Maximum number of diff lines to include in the prompt (0 disables the limit)")
+	cmd.Flags().Uint32("token-budget", config.DEFAULT_REVIEW_TOKEN_BUDGET, "Estimated prompt tokens above which the diff is reviewed in chunks and the results merged (0 disables chunking)")
 	cmd.Flags().Bool("with-description", false, "Include the PR description in the review context (only applies with --pr)")
-	cmd.Flags().Bool("with-context", false, "Include enclosing declarations (functions, structs, classes) as context for review")
+	cmd.Flags().Bool("with-context", false, "Include enclosing declarations (functions, structs, classes) and their usages as context for review")
+	cmd.Flags().String("format", plain.FormatMarkdown, "Output format: markdown, json or sarif (json and sarif imply plain mode)")
+	cmd.Flags().StringP("output", "o", "", "Write the review to a file instead of stdout (implies plain mode)")
+	cmd.Flags().Bool("post", false, "Publish the findings as a review on the pull request given by --pr (implies plain mode)")
+	cmd.Flags().String("fail-on", "", fmt.Sprintf("Exit with code %d when any finding is at or above this severity: high, medium, low, info (implies plain mode)", plain.ExitCodeFindings))
+	cmd.Flags().Bool("dry-run", false, "Print the prompts and estimated token counts without calling the LLM (implies plain mode)")
 
 	cmd.MarkFlagsMutuallyExclusive("changes", "commit", "branch", "staged", "hash", "pr")
 
 	return cmd
 }
 
+// isPlainReview reports whether the review must run in plain mode; machine
+// readable formats never go through the TUI.
+func isPlainReview(cmd *cobra.Command) bool {
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
+	failOn, _ := cmd.Flags().GetString("fail-on")
+	post, _ := cmd.Flags().GetBool("post")
+	return isPlainMode(cmd) || format != plain.FormatMarkdown || output != "" || failOn != "" || post
+}
+
 func runReviewCmd(cmd *cobra.Command) error {
 	storage, err := config.GetStorage()
 	if err != nil {
@@ -65,11 +91,28 @@ func runReviewCmd(cmd *cobra.Command) error {
 	withDescription, _ := cmd.Flags().GetBool("with-description")
 	model, _ := cmd.Flags().GetString("model")
 	provider, _ := cmd.Flags().GetString("provider")
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
+	failOnFlag, _ := cmd.Flags().GetString("fail-on")
+	post, _ := cmd.Flags().GetBool("post")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 	if withDescription && pr == "" {
 		return fmt.Errorf("--with-description requires --pr")
 	}
 
+	if post && pr == "" {
+		return fmt.Errorf("--post requires --pr")
+	}
+
+	var failOn findings.Severity
+	if failOnFlag != "" {
+		failOn, err = findings.ParseSeverity(failOnFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --fail-on: %w", err)
+		}
+	}
+
 	cfg := config.New()
 
 	stdinDiff, err := readStdinIfPiped()
@@ -77,6 +120,7 @@ func runReviewCmd(cmd *cobra.Command) error {
 		return err
 	}
 
+	applyGlobalFlags(cmd, cfg)
 	cfg.OverrideModel(model)
 
 	if err := cfg.OverrideProvider(provider); err != nil {
@@ -88,12 +132,17 @@ func runReviewCmd(cmd *cobra.Command) error {
 		cfg.OverrideMaxDiffLines(maxDiffLines)
 	}
 
+	if cmd.Flags().Changed("token-budget") {
+		tokenBudget, _ := cmd.Flags().GetUint32("token-budget")
+		cfg.OverrideReviewTokenBudget(tokenBudget)
+	}
+
 	if cmd.Flags().Changed("with-context") {
 		contextEnrich, _ := cmd.Flags().GetBool("with-context")
 		cfg.OverrideContextEnrichment(contextEnrich)
 	}
 
-	if stdinDiff != nil || isPlainMode(cmd) {
+	if stdinDiff != nil || isPlainReview(cmd) {
 		return plain.RunReview(plain.ReviewOptions{
 			Diff:              stdinDiff,
 			ReviewerName:      reviewerName,
@@ -108,6 +157,11 @@ func runReviewCmd(cmd *cobra.Command) error {
 			Stream:            stream,
 			PR:                pr,
 			WithPRDescription: withDescription,
+			Format:            format,
+			Output:            output,
+			FailOn:            failOn,
+			Post:              post,
+			DryRun:            dryRun,
 		})
 	}
 
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fixtures = "../testdata/replay"
+
+// The review command reports its result through os.Exit, so the failing run
+// happens in a child process running this test binary.
+func TestReviewCmd_FailOnExitsNonZeroOnError(t *testing.T) {
+	if fixture := os.Getenv("BARK_TEST_REVIEW_FIXTURE"); fixture != "" {
+		viper.Set(config.LLMProviderKey, "replay")
+		viper.Set(config.LLMModelKey, "claude-sonnet-4-5")
+		viper.Set(config.ReplayKey+".file", fixture)
+		viper.Set(config.ReplayKey+".instant", true)
+
+		reviewer := filepath.Join(os.Getenv("HOME"), "Rob Pike.md")
+		os.Args = []string{"bark", "review", "--fail-on", "high", "--skip-instruction", "--as", reviewer}
+		if err := Execute(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	home := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(home, "Rob Pike.md"), []byte("You are Rob Pike."), 0o644))
+	fixture, err := filepath.Abs(filepath.Join(fixtures, "unauthorized.jsonl"))
+	require.NoError(t, err)
+	diff, err := os.Open(filepath.Join(fixtures, "mean.diff"))
+	require.NoError(t, err)
+	defer diff.Close()
+
+	child := exec.Command(os.Args[0], "-test.run=^TestReviewCmd_FailOnExitsNonZeroOnError$")
+	child.Env = append(os.Environ(), "HOME="+home, "BARK_TEST_REVIEW_FIXTURE="+fixture)
+	child.Stdin = diff
+	out, err := child.CombinedOutput()
+
+	exitErr, ok := errors.AsType[*exec.ExitError](err)
+	require.True(t, ok, "review exited 0:\n%s", out)
+	assert.NotEqual(t, plain.ExitCodeFindings, exitErr.ExitCode(), "a failed review isn't a blocked merge:\n%s", out)
+	assert.Contains(t, string(out), "401")
+}
@@ -27,21 +27,24 @@ var rootCmd = &cobra.Command{
 	Use:  "bark",
 	Long: "Get your code reviewed by legends, generate commit messages and PR descriptions",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := handleRootCmd(); err != nil {
+		if err := handleRootCmd(cmd); err != nil {
 			PrintError(err)
 		}
 	},
 }
 
-func handleRootCmd() error {
+func handleRootCmd(cmd *cobra.Command) error {
 	storage, err := config.GetStorage()
 	if err != nil {
 		return fmt.Errorf("error getting storage: %w", err)
 	}
 
+	cfg := config.New()
+	applyGlobalFlags(cmd, cfg)
+
 	m := tui.New(tui.Options{
 		Storage: storage,
-		Config:  config.New(),
+		Config:  cfg,
 	})
 
 	p := tea.NewProgram(m)
@@ -69,7 +72,15 @@ func Execute() error {
 	rootCmd.AddCommand(deleteCmd())
 	rootCmd.AddCommand(editCmd())
 	rootCmd.AddCommand(actionsCmd())
+	rootCmd.AddCommand(applyCmd())
+	rootCmd.AddCommand(statsCmd())
+	rootCmd.AddCommand(cacheCmd())
+	rootCmd.AddCommand(credentialsCmd())
+	rootCmd.AddCommand(modelsCmd())
 
+	rootCmd.PersistentFlags().String("profile", "", "Provider profile to use, as defined in a [profiles.<name>] table (overrides the task's default profile)")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "Don't use the response cache for this run, even when response_cache is enabled")
+	rootCmd.PersistentFlags().String("record", "", "Record the provider's responses to this fixture file, to play back with the replay provider")
 	rootCmd.PersistentFlags().Bool("plain", false, "Output plain text instead of TUI (auto-detected when stdout is piped)")
 	rootCmd.Flags().StringP("config", "c", "", "config file (default is $HOME/.bark/config.toml)")
 
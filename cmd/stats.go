@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/ledger"
+	"github.com/ionut-t/bark/v2/internal/plain"
+	"github.com/spf13/cobra"
+)
+
+func statsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show token usage and estimated cost",
+		Long: `Show the token usage and estimated cost of every LLM request, grouped by day, repository, model or task.
+
+Costs are estimated from the price table in ~/.bark/prices.toml, which you can edit.`,
+		Example: `  bark stats                  // usage per day over the last 30 days
+  bark stats --by model --days 7
+  bark stats --by repo --days 0`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runStatsCmd(cmd); err != nil {
+				plain.Errf("%s", err)
+			}
+		},
+	}
+
+	cmd.Flags().String("by", ledger.ByDay, "Group usage by day, repo, model or task")
+	cmd.Flags().Int("days", 30, "Only include the last N days (0 includes everything)")
+
+	return cmd
+}
+
+func runStatsCmd(cmd *cobra.Command) error {
+	by, _ := cmd.Flags().GetString("by")
+	days, _ := cmd.Flags().GetInt("days")
+
+	storage, err := config.GetStorage()
+	if err != nil {
+		return fmt.Errorf("error getting storage: %w", err)
+	}
+
+	return plain.RunStats(plain.StatsOptions{
+		Storage: storage,
+		Config:  config.New(),
+		By:      by,
+		Days:    days,
+	})
+}
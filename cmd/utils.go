@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/config"
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 )
@@ -16,10 +17,28 @@ func PrintError(err error) {
 	fmt.Println(errorStyle.Render("Error: " + err.Error()))
 }
 
+// applyGlobalFlags applies the persistent flags that override the config
+// for this run.
+func applyGlobalFlags(cmd *cobra.Command, cfg config.Config) {
+	profile, _ := cmd.Flags().GetString("profile")
+	cfg.OverrideProfile(profile)
+	if noCache, _ := cmd.Flags().GetBool("no-cache"); noCache {
+		cfg.OverrideResponseCache(false)
+	}
+	if record, _ := cmd.Flags().GetString("record"); record != "" {
+		cfg.OverrideRecord(record)
+		// Cached responses never reach the provider, so they can't be
+		// recorded.
+		cfg.OverrideResponseCache(false)
+	}
+}
+
 // isPlainMode returns true if --plain is set or stdout is not a TTY.
 func isPlainMode(cmd *cobra.Command) bool {
 	plain, _ := cmd.Flags().GetBool("plain")
-	return plain || !isatty.IsTerminal(os.Stdout.Fd())
+	// --dry-run only prints prompts, which the TUI has no view for.
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	return plain || dryRun || !isatty.IsTerminal(os.Stdout.Fd())
 }
 
 // hasStdinData returns true if stdin has piped data (is not a TTY).
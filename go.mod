@@ -9,6 +9,7 @@ require (
 	charm.land/huh/v2 v2.0.3
 	charm.land/lipgloss/v2 v2.0.3
 	github.com/anthropics/anthropic-sdk-go v1.51.0
+	github.com/charmbracelet/x/term v0.2.2
 	github.com/ionut-t/coffee/help v0.0.0-20260404232152-91b6181d4e02
 	github.com/ionut-t/coffee/styles v0.0.0-20260404232152-91b6181d4e02
 	github.com/ionut-t/goeditor v0.4.16
@@ -38,7 +39,6 @@ require (
 	github.com/charmbracelet/x/exp/charmtone v0.0.0-20260602025833-85a30b5e440a // indirect
 	github.com/charmbracelet/x/exp/ordered v0.1.0 // indirect
 	github.com/charmbracelet/x/exp/strings v0.1.0 // indirect
-	github.com/charmbracelet/x/term v0.2.2 // indirect
 	github.com/charmbracelet/x/termios v0.1.1 // indirect
 	github.com/charmbracelet/x/windows v0.2.2 // indirect
 	github.com/clipperhouse/displaywidth v0.11.0 // indirect
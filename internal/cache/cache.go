@@ -0,0 +1,300 @@
+// Package cache stores LLM responses on disk, keyed by everything that
+// determines them, so requests repeated with the same input are answered
+// without calling the provider.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+const (
+	dirName = "cache"
+	fileExt = ".json"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Created  time.Time `json:"created"`
+	Content  string    `json:"content"`
+	Thinking string    `json:"thinking,omitempty"`
+	// Provider and Model name what served the response, when a fallback
+	// chain reported them.
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// Usage is what the request cost when it was made; a hit costs nothing.
+	Usage *llm.Usage `json:"usage,omitempty"`
+}
+
+// Cache is a directory of entries, one file per key. Entries older than ttl
+// are ignored, and the least recently used are removed once the directory
+// grows past maxSize bytes.
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	now     func() time.Time
+}
+
+// New returns the cache stored under storage (usually ~/.bark).
+func New(storage string, ttl time.Duration, maxSize int64) *Cache {
+	return &Cache{dir: filepath.Join(storage, dirName), ttl: ttl, maxSize: maxSize, now: time.Now}
+}
+
+// FromConfig returns the cache under storage configured in cfg, or nil when
+// the response cache is disabled.
+func FromConfig(cfg config.Config, storage string) (*Cache, error) {
+	if !cfg.GetResponseCache() {
+		return nil, nil
+	}
+
+	ttl, err := cfg.GetResponseCacheTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(storage, ttl, cfg.GetResponseCacheMaxSize()), nil
+}
+
+// Dir returns the directory the cache is stored in.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// request is everything that determines a response. Caching options are
+// left out since they don't change what the model answers.
+type request struct {
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Method   string        `json:"method"`
+	Options  llm.Options   `json:"options"`
+	System   string        `json:"system"`
+	Messages []llm.Message `json:"messages"`
+	Schema   llm.Schema    `json:"schema"`
+}
+
+// Key returns the key of a request to provider and model.
+func Key(provider, model, method, system string, messages []llm.Message, schema llm.Schema, opts llm.Options) string {
+	opts.Cache, opts.CachePrefix = false, 0
+
+	// Marshalling a struct of plain values can't fail.
+	data, _ := json.Marshal(request{
+		Provider: provider,
+		Model:    model,
+		Method:   method,
+		Options:  opts,
+		System:   system,
+		Messages: messages,
+		Schema:   schema,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+fileExt)
+}
+
+// Get returns the entry stored under key, unless it has expired. A hit
+// marks the entry as recently used.
+func (c *Cache) Get(key string) (Entry, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil || c.expired(e) {
+		return Entry{}, false
+	}
+
+	now := c.now()
+	_ = os.Chtimes(path, now, now)
+	return e, true
+}
+
+func (c *Cache) expired(e Entry) bool {
+	return c.now().Sub(e.Created) >= c.ttl
+}
+
+// Put stores e under key, then removes expired entries and, above the size
+// limit, the least recently used ones.
+func (c *Cache) Put(key string, e Entry) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	if e.Created.IsZero() {
+		e.Created = c.now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so a concurrent Get never
+	// reads half an entry.
+	tmp, err := os.CreateTemp(c.dir, key+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	_ = os.Chtimes(c.path(key), e.Created, e.Created)
+
+	return c.prune()
+}
+
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// files lists the entry files, least recently used first.
+func (c *Cache) files() ([]file, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var files []file
+	for _, d := range dirEntries {
+		if d.IsDir() || !strings.HasSuffix(d.Name(), fileExt) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{path: filepath.Join(c.dir, d.Name()), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	slices.SortFunc(files, func(a, b file) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	return files, nil
+}
+
+// prune removes entries unused for longer than the TTL, which have expired
+// since a hit touches its entry, then the least recently used ones until the
+// cache fits its size limit.
+func (c *Cache) prune() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	kept := files[:0]
+	for _, f := range files {
+		if c.now().Sub(f.modTime) >= c.ttl {
+			_ = os.Remove(f.path)
+			continue
+		}
+		kept = append(kept, f)
+		total += f.size
+	}
+
+	for _, f := range kept {
+		if c.maxSize <= 0 || total <= c.maxSize {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+
+	return nil
+}
+
+// Clear removes every entry and returns how many there were.
+func (c *Cache) Clear() (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("failed to clear cache: %w", err)
+		}
+	}
+	return len(files), nil
+}
+
+// Stats summarises the cache.
+type Stats struct {
+	Entries int
+	Expired int
+	Size    int64
+	// SavedTokens adds up the tokens of the live entries' original requests,
+	// which every hit on them saves again.
+	SavedTokens    int64
+	Oldest, Newest time.Time
+}
+
+// Stats reads every entry and summarises the cache.
+func (c *Cache) Stats() (Stats, error) {
+	files, err := c.files()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var s Stats
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if json.Unmarshal(data, &e) != nil {
+			continue
+		}
+
+		s.Size += f.size
+		if c.expired(e) {
+			s.Expired++
+			continue
+		}
+
+		s.Entries++
+		if e.Usage != nil {
+			s.SavedTokens += e.Usage.TotalTokens
+		}
+		if s.Oldest.IsZero() || e.Created.Before(s.Oldest) {
+			s.Oldest = e.Created
+		}
+		if e.Created.After(s.Newest) {
+			s.Newest = e.Created
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "diff"}}
+	key := Key("anthropic", "claude-sonnet-4", methodText, "system", messages, llm.Schema{}, llm.Options{})
+
+	// Caching options don't change the answer.
+	assert.Equal(t, key, Key("anthropic", "claude-sonnet-4", methodText, "system", messages, llm.Schema{}, llm.Options{Cache: true, CachePrefix: 3}))
+
+	temperature := 0.2
+	for _, other := range []string{
+		Key("openai", "claude-sonnet-4", methodText, "system", messages, llm.Schema{}, llm.Options{}),
+		Key("anthropic", "claude-opus-4", methodText, "system", messages, llm.Schema{}, llm.Options{}),
+		Key("anthropic", "claude-sonnet-4", methodStructured, "system", messages, llm.Schema{}, llm.Options{}),
+		Key("anthropic", "claude-sonnet-4", methodText, "other", messages, llm.Schema{}, llm.Options{}),
+		Key("anthropic", "claude-sonnet-4", methodText, "system", []llm.Message{{Role: llm.RoleUser, Content: "diff2"}}, llm.Schema{}, llm.Options{}),
+		Key("anthropic", "claude-sonnet-4", methodText, "system", messages, llm.Schema{}, llm.Options{Temperature: &temperature}),
+	} {
+		assert.NotEqual(t, key, other)
+	}
+}
+
+func TestCache_GetPutExpiry(t *testing.T) {
+	c := New(t.TempDir(), time.Hour, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, ok := c.Get("k")
+	assert.False(t, ok)
+
+	require.NoError(t, c.Put("k", Entry{Content: "review"}))
+	e, ok := c.Get("k")
+	require.True(t, ok)
+	assert.Equal(t, "review", e.Content)
+
+	now = now.Add(time.Hour)
+	_, ok = c.Get("k")
+	assert.False(t, ok)
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Entries)
+	assert.Equal(t, 1, stats.Expired)
+}
+
+func TestCache_PrunesLeastRecentlyUsed(t *testing.T) {
+	// Each entry takes about 170 bytes, so two fit.
+	c := New(t.TempDir(), time.Hour, 400)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	content := strings.Repeat("x", 100)
+	for _, key := range []string{"a", "b"} {
+		require.NoError(t, c.Put(key, Entry{Content: content}))
+		now = now.Add(time.Minute)
+	}
+	// Reading a makes b the least recently used.
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	now = now.Add(time.Minute)
+
+	require.NoError(t, c.Put("c", Entry{Content: content}))
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c"} {
+		_, ok = c.Get(key)
+		assert.True(t, ok, key)
+	}
+
+	n, err := c.Clear()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	entries, err := os.ReadDir(c.Dir())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+type fakeLLM struct {
+	llm.LLM
+	calls int
+	err   error
+	// restart makes Chat stream some thinking, then restart the reply as
+	// the retrier does when a stream breaks before any content.
+	restart bool
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	f.calls++
+	return llm.Response{Content: "commit message", Usage: &llm.Usage{InputTokens: 10, OutputTokens: 2, TotalTokens: 12}}, f.err
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	f.calls++
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		if f.restart {
+			respChan <- llm.Response{Thinking: "let me"}
+			respChan <- llm.Response{Retrying: &llm.Retrying{Attempt: 2, MaxAttempts: 5, Restart: true}}
+		}
+		respChan <- llm.Response{Thinking: "hmm"}
+		respChan <- llm.Response{Content: "## Summary\n"}
+		respChan <- llm.Response{Content: "Looks good."}
+		respChan <- llm.Response{Usage: &llm.Usage{TotalTokens: 20}}
+		if f.err != nil {
+			errChan <- f.err
+		}
+	}()
+	return respChan, errChan
+}
+
+func drain(respChan <-chan llm.Response, errChan <-chan error) (string, string, *llm.Usage, error) {
+	var content, thinking string
+	var usage *llm.Usage
+	for resp := range respChan {
+		content += resp.Content
+		thinking += resp.Thinking
+		if resp.Usage != nil {
+			usage = resp.Usage
+		}
+	}
+	return content, thinking, usage, <-errChan
+}
+
+func TestWrap_Generate(t *testing.T) {
+	fake := &fakeLLM{}
+	client := Wrap(fake, New(t.TempDir(), time.Hour, 0), "openai", "gpt-4o")
+
+	resp, err := client.Generate(context.Background(), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.NotNil(t, resp.Usage)
+
+	resp, err = client.Generate(context.Background(), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "commit message", resp.Content)
+	assert.Nil(t, resp.Usage, "a hit is free")
+	assert.Equal(t, 1, fake.calls)
+
+	_, err = client.Generate(context.Background(), "system", "other diff", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestWrap_StreamReplay(t *testing.T) {
+	fake := &fakeLLM{}
+	c := New(t.TempDir(), time.Hour, 0)
+	client := Wrap(fake, c, "anthropic", "claude-sonnet-4")
+
+	content, thinking, usage, err := drain(client.Stream(context.Background(), "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", content)
+	assert.NotNil(t, usage)
+
+	content, thinking, usage, err = drain(client.Stream(context.Background(), "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", content)
+	assert.Equal(t, "hmm", thinking)
+	assert.Nil(t, usage)
+	assert.Equal(t, 1, fake.calls)
+
+	// A streamed reply also answers the same request made with Generate.
+	resp, err := client.Generate(context.Background(), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", resp.Content)
+	assert.Equal(t, 1, fake.calls)
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, int64(20), stats.SavedTokens)
+}
+
+func TestWrap_DoesNotCacheFailures(t *testing.T) {
+	fake := &fakeLLM{err: errors.New("stream broke")}
+	client := Wrap(fake, New(t.TempDir(), time.Hour, 0), "anthropic", "claude-sonnet-4")
+
+	for range 2 {
+		_, _, _, err := drain(client.Stream(context.Background(), "system", "diff", llm.Options{}))
+		require.Error(t, err)
+	}
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestWrap_DropsThinkingOfRestartedReply(t *testing.T) {
+	fake := &fakeLLM{restart: true}
+	client := Wrap(fake, New(t.TempDir(), time.Hour, 0), "anthropic", "claude-sonnet-4")
+
+	_, thinking, _, err := drain(client.Stream(context.Background(), "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "let mehmm", thinking, "the stream is passed on as it is")
+
+	_, thinking, _, err = drain(client.Stream(context.Background(), "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "hmm", thinking)
+	assert.Equal(t, 1, fake.calls)
+}
+
+func TestWrap_RefreshSkipsCachedResponses(t *testing.T) {
+	fake := &fakeLLM{}
+	client := Wrap(fake, New(t.TempDir(), time.Hour, 0), "openai", "gpt-4o")
+
+	_, err := client.Generate(context.Background(), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+
+	// Regenerating asks the provider again and caches what it returns.
+	resp, err := client.Generate(WithRefresh(context.Background()), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.NotNil(t, resp.Usage)
+	_, _, usage, err := drain(client.Stream(WithRefresh(context.Background()), "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.NotNil(t, usage)
+	assert.Equal(t, 3, fake.calls)
+
+	resp, err = client.Generate(context.Background(), "system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", resp.Content, "the newest response is cached")
+	assert.Equal(t, 3, fake.calls)
+}
@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+// Methods a key is made for. Generate, Stream and Chat share a key, since
+// the same request gets the same answer however it is delivered.
+const (
+	methodText       = "text"
+	methodStructured = "structured"
+)
+
+type refreshKey struct{}
+
+// WithRefresh makes requests made with ctx skip the cached responses, e.g.
+// to generate a commit message again, while still caching the new ones.
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+func refreshing(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
+
+// client is an llm.LLM that answers requests from the cache when it can and
+// stores the responses it gets from the wrapped client.
+type client struct {
+	llm.LLM
+	cache    *Cache
+	provider string
+	model    string
+}
+
+// Wrap returns client with its responses cached in c, keyed with provider
+// and model. A nil c returns client unchanged. Cached responses carry no
+// usage, so they are neither billed nor recorded in the ledger. Caching is
+// best effort: a cache that can't be written never fails a request.
+func Wrap(l llm.LLM, c *Cache, provider, model string) llm.LLM {
+	if c == nil {
+		return l
+	}
+	return &client{LLM: l, cache: c, provider: provider, model: model}
+}
+
+func (c *client) key(method, system string, messages []llm.Message, schema llm.Schema, opts llm.Options) string {
+	return Key(c.provider, c.model, method, system, messages, schema, opts)
+}
+
+// get returns the cached response for key, unless ctx asks for a fresh one
+// (see WithRefresh).
+func (c *client) get(ctx context.Context, key string) (Entry, bool) {
+	if refreshing(ctx) {
+		return Entry{}, false
+	}
+	return c.cache.Get(key)
+}
+
+func (c *client) put(key string, resp llm.Response) {
+	if resp.Content == "" {
+		return
+	}
+	_ = c.cache.Put(key, Entry{
+		Content:  resp.Content,
+		Thinking: resp.Thinking,
+		Provider: resp.Provider,
+		Model:    resp.Model,
+		Usage:    resp.Usage,
+	})
+}
+
+func hit(e Entry) llm.Response {
+	return llm.Response{Content: e.Content, Thinking: e.Thinking, Provider: e.Provider, Model: e.Model, Time: time.Now()}
+}
+
+func (c *client) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	key := c.key(methodText, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, llm.Schema{}, opts)
+	if e, ok := c.get(ctx, key); ok {
+		return hit(e), nil
+	}
+
+	resp, err := c.LLM.Generate(ctx, system, prompt, opts)
+	if err == nil {
+		c.put(key, resp)
+	}
+	return resp, err
+}
+
+func (c *client) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	key := c.key(methodStructured, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, schema, opts)
+	if e, ok := c.get(ctx, key); ok {
+		return hit(e), nil
+	}
+
+	resp, err := c.LLM.GenerateStructured(ctx, system, prompt, schema, opts)
+	if err == nil {
+		c.put(key, resp)
+	}
+	return resp, err
+}
+
+func (c *client) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return c.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+// Chat replays a cached reply line by line, or streams the reply of the
+// wrapped client and caches it once it completes without error.
+func (c *client) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	key := c.key(methodText, system, messages, llm.Schema{}, opts)
+	if e, ok := c.get(ctx, key); ok {
+		return replay(ctx, e)
+	}
+
+	in, inErrs := c.LLM.Chat(ctx, system, messages, opts)
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		var (
+			full             llm.Response
+			content, thought strings.Builder
+		)
+		for resp := range in {
+			if resp.Retrying != nil && resp.Retrying.Restart {
+				thought.Reset()
+			}
+			content.WriteString(resp.Content)
+			thought.WriteString(resp.Thinking)
+			if resp.Usage != nil {
+				full.Usage = resp.Usage
+			}
+			if resp.Model != "" {
+				full.Provider, full.Model = resp.Provider, resp.Model
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		if err := <-inErrs; err != nil {
+			errChan <- err
+			return
+		}
+		if ctx.Err() != nil {
+			// The reader gave up, so the reply may be incomplete.
+			return
+		}
+
+		full.Content, full.Thinking = content.String(), thought.String()
+		c.put(key, full)
+	}()
+
+	return out, errChan
+}
+
+// replay streams a cached entry as a provider would: the thinking, then the
+// content a line at a time.
+func replay(ctx context.Context, e Entry) (<-chan llm.Response, <-chan error) {
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		responses := []llm.Response{}
+		if e.Thinking != "" {
+			responses = append(responses, llm.Response{Thinking: e.Thinking})
+		}
+		for line := range strings.SplitAfterSeq(e.Content, "\n") {
+			responses = append(responses, llm.Response{Content: line})
+		}
+
+		for _, resp := range responses {
+			resp.Provider, resp.Model, resp.Time = e.Provider, e.Model, time.Now()
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return out, errChan
+}
@@ -0,0 +1,305 @@
+// Package chunk splits diffs that are too large for a single review request
+// into file groups and reviews them concurrently (the "map" step of a
+// map-reduce review). Merging the partial reviews is left to the caller.
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+// DefaultConcurrency is the number of chunks reviewed at once when the
+// configured concurrency is zero.
+const DefaultConcurrency = 4
+
+// Chunk is a group of whole files, or hunks of a single large file, whose
+// diff fits the token budget.
+type Chunk struct {
+	Files  []string
+	Diff   string
+	Tokens int
+}
+
+// Title describes the chunk for progress output, e.g. "cmd/root.go +2 more".
+func (c Chunk) Title() string {
+	switch len(c.Files) {
+	case 0:
+		return "diff"
+	case 1:
+		return c.Files[0]
+	default:
+		return fmt.Sprintf("%s +%d more", c.Files[0], len(c.Files)-1)
+	}
+}
+
+// Plan returns the chunks to review diff in when a single request would
+// exceed budget tokens. overhead is the estimated size of everything sent
+// with each chunk (system prompt, commits, file stat). It returns nil when the
+// review fits in one request or budget is zero.
+func Plan(diff string, overhead, budget int) []Chunk {
+	if budget <= 0 || overhead+llm.EstimateTokens(diff) <= budget {
+		return nil
+	}
+
+	// Leave at least a quarter of the budget for the diff, even when the
+	// shared context is large.
+	chunks := Split(diff, max(budget-overhead, budget/4))
+	if len(chunks) < 2 {
+		return nil
+	}
+	return chunks
+}
+
+// piece is the smallest unit Split moves between chunks: a whole file, or a
+// run of hunks from a file that alone exceeds the budget.
+type piece struct {
+	file string
+	diff string
+}
+
+// Split groups the files of diff into chunks of at most budget estimated
+// tokens, keeping files in diff order so related paths stay together. A file
+// larger than the budget is split between hunks, with its header repeated in
+// every chunk; a single hunk larger than the budget becomes a chunk of its
+// own. A non-positive budget returns the whole diff as one chunk.
+func Split(diff string, budget int) []Chunk {
+	if strings.TrimSpace(diff) == "" {
+		return nil
+	}
+
+	if budget <= 0 {
+		return []Chunk{newChunk([]piece{{file: "", diff: diff}})}
+	}
+
+	var pieces []piece
+	for _, file := range splitFiles(diff) {
+		if llm.EstimateTokens(file) <= budget {
+			pieces = append(pieces, piece{file: filePath(file), diff: file})
+			continue
+		}
+		pieces = append(pieces, splitHunks(file, budget)...)
+	}
+
+	var (
+		chunks  []Chunk
+		current []piece
+		tokens  int
+	)
+	for _, p := range pieces {
+		n := llm.EstimateTokens(p.diff)
+		if len(current) > 0 && tokens+n > budget {
+			chunks = append(chunks, newChunk(current))
+			current, tokens = nil, 0
+		}
+		current = append(current, p)
+		tokens += n
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, newChunk(current))
+	}
+
+	return chunks
+}
+
+func newChunk(pieces []piece) Chunk {
+	var (
+		c    Chunk
+		diff strings.Builder
+	)
+	for _, p := range pieces {
+		diff.WriteString(p.diff)
+		if p.file != "" && (len(c.Files) == 0 || c.Files[len(c.Files)-1] != p.file) {
+			c.Files = append(c.Files, p.file)
+		}
+	}
+	c.Diff = diff.String()
+	c.Tokens = llm.EstimateTokens(c.Diff)
+	return c
+}
+
+// splitFiles splits a git diff at its "diff --git" headers. Content before
+// the first header (or a diff without headers) is kept as its own section.
+func splitFiles(diff string) []string {
+	var (
+		files   []string
+		current strings.Builder
+	)
+	for line := range strings.Lines(diff) {
+		if strings.HasPrefix(line, "diff --git ") && current.Len() > 0 {
+			files = append(files, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		files = append(files, current.String())
+	}
+	return files
+}
+
+// splitHunks splits one file's diff into pieces of whole hunks that fit the
+// budget, each prefixed with the file header.
+func splitHunks(file string, budget int) []piece {
+	path := filePath(file)
+
+	var (
+		header strings.Builder
+		hunks  []string
+		hunk   strings.Builder
+	)
+	for line := range strings.Lines(file) {
+		if strings.HasPrefix(line, "@@") {
+			if hunk.Len() > 0 {
+				hunks = append(hunks, hunk.String())
+				hunk.Reset()
+			}
+			hunk.WriteString(line)
+			continue
+		}
+		if hunk.Len() == 0 && len(hunks) == 0 {
+			header.WriteString(line)
+			continue
+		}
+		hunk.WriteString(line)
+	}
+	if hunk.Len() > 0 {
+		hunks = append(hunks, hunk.String())
+	}
+
+	if len(hunks) == 0 {
+		return []piece{{file: path, diff: file}}
+	}
+
+	var (
+		pieces  []piece
+		current strings.Builder
+	)
+	headerTokens := llm.EstimateTokens(header.String())
+	for _, h := range hunks {
+		if current.Len() > 0 && headerTokens+llm.EstimateTokens(current.String())+llm.EstimateTokens(h) > budget {
+			pieces = append(pieces, piece{file: path, diff: header.String() + current.String()})
+			current.Reset()
+		}
+		current.WriteString(h)
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, piece{file: path, diff: header.String() + current.String()})
+	}
+
+	return pieces
+}
+
+// filePath returns the new-side path of a file diff, falling back to the old
+// side for deletions.
+func filePath(file string) string {
+	var old string
+	for line := range strings.Lines(file) {
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			path := strings.TrimPrefix(line, "+++ ")
+			if path != "/dev/null" {
+				return strings.TrimPrefix(path, "b/")
+			}
+			return old
+		case strings.HasPrefix(line, "--- "):
+			old = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "@@"):
+			return old
+		}
+	}
+
+	// Binary and mode-only changes have no ---/+++ lines.
+	if header, ok := strings.CutPrefix(strings.SplitN(file, "\n", 2)[0], "diff --git "); ok {
+		if i := strings.LastIndex(header, " b/"); i >= 0 {
+			return header[i+3:]
+		}
+	}
+	return old
+}
+
+// Status is the state of one chunk during Map.
+type Status int
+
+const (
+	StatusPending Status = iota
+	StatusRunning
+	StatusDone
+	StatusFailed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusRunning:
+		return "reviewing"
+	case StatusDone:
+		return "done"
+	case StatusFailed:
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+// Progress reports a status change of the chunk at Index.
+type Progress struct {
+	Index  int
+	Status Status
+	Err    error
+	// Retrying is set on a running chunk whose request failed and is about
+	// to be retried. Map never sets it; reviews report it themselves.
+	Retrying *llm.Retrying
+}
+
+// Map runs review for each of n chunks with at most concurrency running at
+// once, and returns the results in chunk order. The first failure cancels the
+// chunks still running and is returned. progress, when set, is called from
+// the worker goroutines and must be safe for concurrent use.
+func Map[T any](ctx context.Context, n, concurrency int, progress func(Progress), review func(ctx context.Context, index int) (T, error)) ([]T, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]T, n)
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			progress(Progress{Index: i, Status: StatusRunning})
+			result, err := review(ctx, i)
+			if err != nil {
+				progress(Progress{Index: i, Status: StatusFailed, Err: err})
+				cancel(fmt.Errorf("chunk %d of %d: %w", i+1, n, err))
+				return
+			}
+
+			results[i] = result
+			progress(Progress{Index: i, Status: StatusDone})
+		})
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
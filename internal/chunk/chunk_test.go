@@ -0,0 +1,123 @@
+package chunk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fileDiff(path string, hunks ...string) string {
+	var sb strings.Builder
+	sb.WriteString("diff --git a/" + path + " b/" + path + "\n")
+	sb.WriteString("--- a/" + path + "\n")
+	sb.WriteString("+++ b/" + path + "\n")
+	for _, h := range hunks {
+		sb.WriteString(h)
+	}
+	return sb.String()
+}
+
+func hunk(lines int) string {
+	var sb strings.Builder
+	sb.WriteString("@@ -1,1 +1,1 @@\n")
+	for i := range lines {
+		sb.WriteString("+line " + strings.Repeat("x", 30) + string(rune('a'+i%26)) + "\n")
+	}
+	return sb.String()
+}
+
+func TestSplit_GroupsFilesUnderBudget(t *testing.T) {
+	a := fileDiff("a.go", hunk(4))
+	b := fileDiff("b.go", hunk(4))
+	c := fileDiff("c.go", hunk(4))
+	diff := a + b + c
+
+	chunks := Split(diff, len(a+b)/4+1)
+
+	require.Len(t, chunks, 2)
+	assert.Equal(t, []string{"a.go", "b.go"}, chunks[0].Files)
+	assert.Equal(t, a+b, chunks[0].Diff)
+	assert.Equal(t, []string{"c.go"}, chunks[1].Files)
+	assert.Equal(t, c, chunks[1].Diff)
+	assert.Equal(t, "a.go +1 more", chunks[0].Title())
+}
+
+func TestSplit_SplitsLargeFileBetweenHunks(t *testing.T) {
+	h1, h2 := hunk(10), hunk(10)
+	diff := fileDiff("big.go", h1, h2)
+	header := "diff --git a/big.go b/big.go\n--- a/big.go\n+++ b/big.go\n"
+
+	chunks := Split(diff, len(header+h1)/4+1)
+
+	// Every part repeats the file header so it is a valid diff on its own.
+	require.Len(t, chunks, 2)
+	assert.Equal(t, header+h1, chunks[0].Diff)
+	assert.Equal(t, header+h2, chunks[1].Diff)
+	assert.Equal(t, []string{"big.go"}, chunks[1].Files)
+}
+
+func TestSplit_DeletedFileUsesOldPath(t *testing.T) {
+	diff := "diff --git a/gone.go b/gone.go\n--- a/gone.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package gone\n"
+
+	chunks := Split(diff, 1000)
+
+	require.Len(t, chunks, 1)
+	assert.Equal(t, []string{"gone.go"}, chunks[0].Files)
+}
+
+func TestPlan(t *testing.T) {
+	diff := fileDiff("a.go", hunk(40)) + fileDiff("b.go", hunk(40))
+
+	assert.Nil(t, Plan(diff, 100, 0), "a zero budget disables chunking")
+	assert.Nil(t, Plan(diff, 100, 100_000), "a diff within budget is reviewed at once")
+
+	chunks := Plan(diff, 100, len(diff)/4)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, []string{"a.go"}, chunks[0].Files)
+	assert.Equal(t, []string{"b.go"}, chunks[1].Files)
+}
+
+func TestMap_BoundsConcurrencyAndKeepsOrder(t *testing.T) {
+	var running, peak atomic.Int32
+
+	results, err := Map(context.Background(), 8, 3, nil, func(ctx context.Context, i int) (int, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return i * i, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 4, 9, 16, 25, 36, 49}, results)
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+}
+
+func TestMap_FirstErrorCancelsTheRest(t *testing.T) {
+	boom := errors.New("boom")
+
+	var progress []Progress
+	_, err := Map(context.Background(), 4, 1, func(p Progress) {
+		progress = append(progress, p)
+	}, func(ctx context.Context, i int) (string, error) {
+		if i == 1 {
+			return "", boom
+		}
+		return "ok", ctx.Err()
+	})
+
+	require.ErrorIs(t, err, boom)
+	assert.Contains(t, err.Error(), "chunk 2 of 4")
+	assert.Contains(t, progress, Progress{Index: 1, Status: StatusFailed, Err: boom})
+}
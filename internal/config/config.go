@@ -1,9 +1,11 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/ionut-t/bark/v2/internal/templates"
 	"github.com/pelletier/go-toml/v2"
@@ -11,19 +13,40 @@ import (
 )
 
 const (
-	EditorKey            = "editor"
-	LLMProviderKey       = "llm_provider"
-	LLMModelKey          = "llm_model"
-	MaxDiffLinesKey      = "max_diff_lines"
-	RelativeNumberKey    = "relative_number"
-	ContextEnrichmentKey = "context_enrichment"
+	EditorKey             = "editor"
+	LLMProviderKey        = "llm_provider"
+	LLMModelKey           = "llm_model"
+	MaxDiffLinesKey       = "max_diff_lines"
+	RelativeNumberKey     = "relative_number"
+	ContextEnrichmentKey  = "context_enrichment"
+	ContextTokenBudgetKey = "context_token_budget"
+	ReviewTokenBudgetKey  = "review_token_budget"
+	ReviewConcurrencyKey  = "review_concurrency"
+	MonthlyBudgetKey      = "monthly_budget"
+	RunBudgetKey          = "run_budget"
+	BudgetActionKey       = "budget_action"
+	OpenAICompatibleKey   = "openai_compatible"
+	PromptCacheKey        = "prompt_cache"
+	ResponseCacheKey      = "response_cache"
+	ResponseCacheTTLKey   = "response_cache_ttl"
+	ResponseCacheSizeKey  = "response_cache_max_mb"
+	ReplayKey             = "replay"
+	CredentialsKey        = "credentials"
 
 	rootDir                    = ".bark"
 	configFileName             = ".config.toml"
 	commitInstructionsFileName = "commit.md"
 	prInstructionsFileName     = "pull_request_description.md"
 
-	DEFAULT_MAX_DIFF_LINES = 0
+	DEFAULT_MAX_DIFF_LINES       = 0
+	DEFAULT_CONTEXT_TOKEN_BUDGET = 8_000
+	DEFAULT_REVIEW_TOKEN_BUDGET  = 100_000
+	DEFAULT_REVIEW_CONCURRENCY   = 4
+	DEFAULT_RESPONSE_CACHE_TTL   = "168h"
+	DEFAULT_RESPONSE_CACHE_MB    = 100
+
+	BudgetActionWarn  = "warn"
+	BudgetActionAbort = "abort"
 )
 
 type Config interface {
@@ -45,33 +68,141 @@ type Config interface {
 	SetContextEnrichment(enrich bool) error
 	GetContextEnrichment() bool
 	OverrideContextEnrichment(enrich bool)
+	GetContextTokenBudget() uint32
+	GetReviewTokenBudget() uint32
+	OverrideReviewTokenBudget(tokens uint32)
+	GetReviewConcurrency() uint32
+	GetMonthlyBudget() float64
+	GetRunBudget() float64
+	GetBudgetAction() string
+	GetOpenAICompatible() OpenAICompatible
+	GetPromptCache() bool
+	GetResponseCache() bool
+	OverrideResponseCache(enabled bool)
+	GetResponseCacheTTL() (time.Duration, error)
+	GetResponseCacheMaxSize() int64
+	GetReplay() Replay
+	GetCredentials() map[string]Credentials
+	GetRecord() string
+	OverrideRecord(path string)
+	GetProfiles() map[string]Profile
+	OverrideProfile(name string)
+	ResolveProfile(task string) (Profile, error)
+	ResolveFallbacks(profile Profile) ([]Profile, error)
+}
+
+// OpenAICompatible configures the openai_compatible provider, which talks to
+// any server implementing the OpenAI Chat Completions API.
+type OpenAICompatible struct {
+	BaseURL   string            `toml:"base_url" comment:"Base URL of the API, including the version path, e.g. http://localhost:1234/v1 or https://openrouter.ai/api/v1"`
+	APIKeyEnv string            `toml:"api_key_env" comment:"Name of the environment variable holding the API key (default: OPENAI_COMPATIBLE_API_KEY); the key may be unset for local servers"`
+	Headers   map[string]string `toml:"headers" comment:"Extra HTTP headers sent with every request"`
+}
+
+// Replay configures the replay provider, which plays back responses
+// recorded with --record instead of calling a provider.
+type Replay struct {
+	File    string `toml:"file" comment:"Fixture file of recorded responses, written with --record"`
+	Instant bool   `toml:"instant" comment:"Whether to play responses back without their recorded delays (default: false)"`
+}
+
+// Credentials configures where a provider's API key is read from when its
+// environment variable isn't set, in a [credentials.<provider>] table.
+type Credentials struct {
+	// APIKeyCommand is run through the shell; the first line it prints is
+	// the key, e.g. "pass show anthropic".
+	APIKeyCommand string `toml:"api_key_command,omitempty" mapstructure:"api_key_command"`
+	// APIKeyFile holds the key on its first line. It must not be readable
+	// by other users.
+	APIKeyFile string `toml:"api_key_file,omitempty" mapstructure:"api_key_file"`
 }
 
 type configData struct {
-	Editor            string `toml:"editor" comment:"The editor will be used to edit the config file and LLM instructions"`
-	LLMProvider       string `toml:"llm_provider" comment:"It can be set to Gemini, VertexAI, OpenAI, Anthropic or Ollama. If not set, Bark will try to auto-detect the provider based on available credentials."`
-	LLMModel          string `toml:"llm_model" comment:"The LLM model is required for VertexAI/Gemini/OpenAI LLMs, e.g., gemini-2.5-pro"`
-	MaxDiffLines      uint32 `toml:"max_diff_lines" comment:"Maximum number of diff lines to include in the prompt (0 disables the limit)"`
-	RelativeNumber    bool   `toml:"relative_number" comment:"Whether to use relative line numbers in the editor (default: false)"`
-	ContextEnrichment bool   `toml:"context_enrichment" comment:"Whether to include enclosing declarations (functions, structs, classes) as context for review (default: false)"`
+	Editor             string  `toml:"editor" comment:"The editor will be used to edit the config file and LLM instructions"`
+	LLMProvider        string  `toml:"llm_provider" comment:"It can be set to Gemini, VertexAI, OpenAI, Anthropic, Ollama, openai_compatible or replay. If not set, Bark will try to auto-detect the provider based on available credentials."`
+	LLMModel           string  `toml:"llm_model" comment:"The LLM model is required for VertexAI/Gemini/OpenAI LLMs, e.g., gemini-2.5-pro"`
+	MaxDiffLines       uint32  `toml:"max_diff_lines" comment:"Maximum number of diff lines to include in the prompt (0 disables the limit)"`
+	RelativeNumber     bool    `toml:"relative_number" comment:"Whether to use relative line numbers in the editor (default: false)"`
+	ContextEnrichment  bool    `toml:"context_enrichment" comment:"Whether to include enclosing declarations (functions, structs, classes) and their usages as context for review (default: false)"`
+	ContextTokenBudget uint32  `toml:"context_token_budget" comment:"Estimated tokens the enclosing code context may use; the snippets covering the fewest changed lines are shortened first (0 disables the limit)"`
+	ReviewTokenBudget  uint32  `toml:"review_token_budget" comment:"Estimated prompt tokens above which a review is split into chunks that are reviewed separately and merged (0 disables chunking)"`
+	ReviewConcurrency  uint32  `toml:"review_concurrency" comment:"Maximum number of chunks reviewed at the same time (default: 4)"`
+	MonthlyBudget      float64 `toml:"monthly_budget" comment:"Spending limit in USD per calendar month, priced from ~/.bark/prices.toml (0 disables it)"`
+	RunBudget          float64 `toml:"run_budget" comment:"Spending limit in USD for the input of a single request (0 disables it)"`
+	BudgetAction       string  `toml:"budget_action" comment:"What to do when a request would exceed a budget: warn or abort (default: warn)"`
+	PromptCache        bool    `toml:"prompt_cache" comment:"Whether to ask the provider to cache the system prompt and stable part of review prompts; Gemini bills cache storage by the hour (default: true)"`
+	ResponseCache      bool    `toml:"response_cache" comment:"Whether to cache LLM responses in ~/.bark/cache, so requests repeated with the same input are answered from disk (default: false)"`
+	ResponseCacheTTL   string  `toml:"response_cache_ttl" comment:"How long cached responses are used, e.g. 24h (default: 168h)"`
+	ResponseCacheMB    uint32  `toml:"response_cache_max_mb" comment:"Size limit of the response cache in MB; the least recently used responses are removed above it (default: 100)"`
+
+	ReviewProfile string `toml:"review_profile" comment:"Profile used for reviews unless --profile is given (empty uses llm_provider and llm_model)"`
+	CommitProfile string `toml:"commit_profile" comment:"Profile used for commit messages unless --profile is given"`
+	PRProfile     string `toml:"pr_profile" comment:"Profile used for PR descriptions unless --profile is given"`
+	// Fallback applies to every task whose profile doesn't set its own.
+	Fallback []string `toml:"fallback" comment:"Profiles tried in order when the provider fails with an auth, quota or availability error before producing output, e.g. [\"openai\", \"local\"]"`
+
+	OpenAICompatible OpenAICompatible       `toml:"openai_compatible"`
+	Replay           Replay                 `toml:"replay,omitempty"`
+	Credentials      map[string]Credentials `toml:"credentials,omitempty" comment:"Where API keys are read from when their environment variable isn't set, e.g. [credentials.anthropic] with api_key_command or api_key_file"`
+	Profiles         map[string]Profile     `toml:"profiles" comment:"Named provider settings, e.g. [profiles.local] with provider, model, temperature, max_tokens, base_url and timeout"`
 }
 
 type config struct {
 	data configData
+	// profile, providerOverride, modelOverride and record come from flags
+	// and are never written back to the config file.
+	profile          string
+	providerOverride string
+	modelOverride    string
+	record           string
 }
 
 func getConfigData() configData {
 	return configData{
-		Editor:            GetEditor(),
-		LLMProvider:       viper.GetString(LLMProviderKey),
-		LLMModel:          viper.GetString(LLMModelKey),
-		MaxDiffLines:      viper.GetUint32(MaxDiffLinesKey),
-		RelativeNumber:    viper.GetBool(RelativeNumberKey),
-		ContextEnrichment: viper.GetBool(ContextEnrichmentKey),
+		Editor:             GetEditor(),
+		LLMProvider:        viper.GetString(LLMProviderKey),
+		LLMModel:           viper.GetString(LLMModelKey),
+		MaxDiffLines:       viper.GetUint32(MaxDiffLinesKey),
+		RelativeNumber:     viper.GetBool(RelativeNumberKey),
+		ContextEnrichment:  viper.GetBool(ContextEnrichmentKey),
+		ContextTokenBudget: viper.GetUint32(ContextTokenBudgetKey),
+		ReviewTokenBudget:  viper.GetUint32(ReviewTokenBudgetKey),
+		ReviewConcurrency:  viper.GetUint32(ReviewConcurrencyKey),
+		MonthlyBudget:      viper.GetFloat64(MonthlyBudgetKey),
+		RunBudget:          viper.GetFloat64(RunBudgetKey),
+		BudgetAction:       viper.GetString(BudgetActionKey),
+		PromptCache:        viper.GetBool(PromptCacheKey),
+		ResponseCache:      viper.GetBool(ResponseCacheKey),
+		ResponseCacheTTL:   viper.GetString(ResponseCacheTTLKey),
+		ResponseCacheMB:    viper.GetUint32(ResponseCacheSizeKey),
+		OpenAICompatible: OpenAICompatible{
+			BaseURL:   viper.GetString(OpenAICompatibleKey + ".base_url"),
+			APIKeyEnv: viper.GetString(OpenAICompatibleKey + ".api_key_env"),
+			Headers:   viper.GetStringMapString(OpenAICompatibleKey + ".headers"),
+		},
+		Replay: Replay{
+			File:    viper.GetString(ReplayKey + ".file"),
+			Instant: viper.GetBool(ReplayKey + ".instant"),
+		},
+		ReviewProfile: viper.GetString(ReviewProfileKey),
+		CommitProfile: viper.GetString(CommitProfileKey),
+		PRProfile:     viper.GetString(PRProfileKey),
+		Fallback:      viper.GetStringSlice(FallbackKey),
+		Credentials:   getCredentials(),
+		Profiles:      getProfiles(),
 	}
 }
 
 func New() Config {
+	// Config files written before chunked reviews existed don't have these
+	// keys; default them rather than treating a missing key as "disabled".
+	viper.SetDefault(ContextTokenBudgetKey, DEFAULT_CONTEXT_TOKEN_BUDGET)
+	viper.SetDefault(ReviewTokenBudgetKey, DEFAULT_REVIEW_TOKEN_BUDGET)
+	viper.SetDefault(ReviewConcurrencyKey, DEFAULT_REVIEW_CONCURRENCY)
+	viper.SetDefault(ResponseCacheTTLKey, DEFAULT_RESPONSE_CACHE_TTL)
+	viper.SetDefault(ResponseCacheSizeKey, DEFAULT_RESPONSE_CACHE_MB)
+	viper.SetDefault(PromptCacheKey, true)
+
 	return &config{
 		data: getConfigData(),
 	}
@@ -102,7 +233,7 @@ func (c *config) SetLLMProvider(provider string) error {
 }
 
 func (c *config) GetLLMProvider() (string, error) {
-	provider := c.data.LLMProvider
+	provider := cmp.Or(c.providerOverride, c.data.LLMProvider)
 
 	if provider == "" {
 		return "", fmt.Errorf("%s not set in config", LLMProviderKey)
@@ -112,17 +243,15 @@ func (c *config) GetLLMProvider() (string, error) {
 }
 
 func (c *config) OverrideModel(model string) {
-	if model != "" {
-		c.data.LLMModel = model
-	}
+	c.modelOverride = model
 }
 
 func (c *config) OverrideProvider(provider string) error {
 	if provider != "" && !isValidProvider(provider) {
-		return fmt.Errorf("invalid provider: %s. Supported providers are 'gemini', 'vertexai', 'openai', 'anthropic', and 'ollama'", provider)
+		return fmt.Errorf("invalid provider: %s. Supported providers are 'gemini', 'vertexai', 'openai', 'anthropic', 'ollama', 'openai_compatible' and 'replay'", provider)
 	}
 
-	c.data.LLMProvider = provider
+	c.providerOverride = provider
 	return nil
 }
 
@@ -141,7 +270,7 @@ func (c *config) SetLLMModel(model string) error {
 }
 
 func (c *config) GetLLMModel() (string, error) {
-	model := c.data.LLMModel
+	model := cmp.Or(c.modelOverride, c.data.LLMModel)
 
 	if model == "" {
 		return "", fmt.Errorf("%s not set in config", LLMModelKey)
@@ -209,6 +338,96 @@ func (c *config) OverrideContextEnrichment(enrich bool) {
 	c.data.ContextEnrichment = enrich
 }
 
+func (c *config) GetContextTokenBudget() uint32 {
+	return c.data.ContextTokenBudget
+}
+
+func (c *config) GetReviewTokenBudget() uint32 {
+	return c.data.ReviewTokenBudget
+}
+
+func (c *config) OverrideReviewTokenBudget(tokens uint32) {
+	c.data.ReviewTokenBudget = tokens
+}
+
+func (c *config) GetReviewConcurrency() uint32 {
+	return c.data.ReviewConcurrency
+}
+
+func (c *config) GetMonthlyBudget() float64 {
+	return c.data.MonthlyBudget
+}
+
+func (c *config) GetRunBudget() float64 {
+	return c.data.RunBudget
+}
+
+func (c *config) GetBudgetAction() string {
+	if c.data.BudgetAction == "" {
+		return BudgetActionWarn
+	}
+	return c.data.BudgetAction
+}
+
+func (c *config) GetPromptCache() bool {
+	return c.data.PromptCache
+}
+
+func (c *config) GetResponseCache() bool {
+	return c.data.ResponseCache
+}
+
+// OverrideResponseCache turns the response cache on or off for this run,
+// e.g. for --no-cache.
+func (c *config) OverrideResponseCache(enabled bool) {
+	c.data.ResponseCache = enabled
+}
+
+func (c *config) GetResponseCacheTTL() (time.Duration, error) {
+	ttl, err := time.ParseDuration(cmp.Or(c.data.ResponseCacheTTL, DEFAULT_RESPONSE_CACHE_TTL))
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: use a duration such as 24h", ResponseCacheTTLKey, c.data.ResponseCacheTTL)
+	}
+	return ttl, nil
+}
+
+// GetResponseCacheMaxSize returns the size limit of the response cache in
+// bytes.
+func (c *config) GetResponseCacheMaxSize() int64 {
+	return int64(c.data.ResponseCacheMB) << 20
+}
+
+func (c *config) GetOpenAICompatible() OpenAICompatible {
+	return c.data.OpenAICompatible
+}
+
+func (c *config) GetReplay() Replay {
+	return c.data.Replay
+}
+
+// GetCredentials returns the key sources configured for each provider.
+func (c *config) GetCredentials() map[string]Credentials {
+	return c.data.Credentials
+}
+
+func getCredentials() map[string]Credentials {
+	var credentials map[string]Credentials
+	if err := viper.UnmarshalKey(CredentialsKey, &credentials); err != nil {
+		return nil
+	}
+	return credentials
+}
+
+// GetRecord returns the fixture file that provider responses are recorded
+// to, set with --record; empty when not recording.
+func (c *config) GetRecord() string {
+	return c.record
+}
+
+func (c *config) OverrideRecord(path string) {
+	c.record = path
+}
+
 func writeConfig(config configData) error {
 	out, err := toml.Marshal(config)
 	if err != nil {
@@ -264,6 +483,15 @@ func InitialiseConfigFile() (string, error) {
 			viper.SetDefault(MaxDiffLinesKey, DEFAULT_MAX_DIFF_LINES)
 			viper.SetDefault(RelativeNumberKey, false)
 			viper.SetDefault(ContextEnrichmentKey, false)
+			viper.SetDefault(ContextTokenBudgetKey, DEFAULT_CONTEXT_TOKEN_BUDGET)
+			viper.SetDefault(ReviewTokenBudgetKey, DEFAULT_REVIEW_TOKEN_BUDGET)
+			viper.SetDefault(ReviewConcurrencyKey, DEFAULT_REVIEW_CONCURRENCY)
+			viper.SetDefault(MonthlyBudgetKey, 0)
+			viper.SetDefault(RunBudgetKey, 0)
+			viper.SetDefault(BudgetActionKey, BudgetActionWarn)
+			viper.SetDefault(ResponseCacheKey, false)
+			viper.SetDefault(ResponseCacheTTLKey, DEFAULT_RESPONSE_CACHE_TTL)
+			viper.SetDefault(ResponseCacheSizeKey, DEFAULT_RESPONSE_CACHE_MB)
 
 			if err := writeConfig(getConfigData()); err != nil {
 				return "", err
@@ -369,5 +597,5 @@ func getInstructionsFromCurrentDir(fileName string) (string, error) {
 }
 
 func isValidProvider(provider string) bool {
-	return provider == "gemini" || provider == "vertexai" || provider == "openai" || provider == "anthropic" || provider == "ollama"
+	return provider == "gemini" || provider == "vertexai" || provider == "openai" || provider == "anthropic" || provider == "ollama" || provider == "openai_compatible" || provider == "replay"
 }
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	ProfilesKey      = "profiles"
+	ReviewProfileKey = "review_profile"
+	CommitProfileKey = "commit_profile"
+	PRProfileKey     = "pr_profile"
+	FallbackKey      = "fallback"
+)
+
+// Tasks that can default to their own profile.
+const (
+	TaskReview = "review"
+	TaskCommit = "commit"
+	TaskPR     = "pr"
+)
+
+// Profile is a named set of provider settings, defined in a
+// [profiles.<name>] table. Unset provider and model fall back to
+// llm_provider and llm_model.
+type Profile struct {
+	// Name is the profile's key, or empty for the top-level settings.
+	Name        string   `toml:"-" mapstructure:"-"`
+	Provider    string   `toml:"provider" mapstructure:"provider"`
+	Model       string   `toml:"model" mapstructure:"model"`
+	Temperature *float64 `toml:"temperature,omitempty" mapstructure:"temperature"`
+	MaxTokens   int64    `toml:"max_tokens,omitempty" mapstructure:"max_tokens"`
+	TopP        *float64 `toml:"top_p,omitempty" mapstructure:"top_p"`
+	// Stop lists sequences that end the output when generated.
+	Stop []string `toml:"stop,omitempty" mapstructure:"stop"`
+	// Reasoning is the effort of a reasoning model: low, medium or high.
+	// ThinkingBudget sets its thinking tokens directly instead.
+	Reasoning      string `toml:"reasoning,omitempty" mapstructure:"reasoning"`
+	ThinkingBudget int64  `toml:"thinking_budget,omitempty" mapstructure:"thinking_budget"`
+	// BaseURL overrides the provider's API endpoint.
+	BaseURL string `toml:"base_url,omitempty" mapstructure:"base_url"`
+	// Timeout limits each request, e.g. "90s" or "5m".
+	Timeout string `toml:"timeout,omitempty" mapstructure:"timeout"`
+	// Fallback names the profiles tried in order when this one's provider
+	// is unavailable. It replaces the top-level fallback list.
+	Fallback []string `toml:"fallback,omitempty" mapstructure:"fallback"`
+}
+
+// RequestTimeout parses Timeout; zero means the provider's default.
+func (p Profile) RequestTimeout() (time.Duration, error) {
+	if p.Timeout == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(p.Timeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q in profile %q: use a duration such as 90s or 5m", p.Timeout, p.Name)
+	}
+
+	return d, nil
+}
+
+func getProfiles() map[string]Profile {
+	var profiles map[string]Profile
+	if err := viper.UnmarshalKey(ProfilesKey, &profiles); err != nil {
+		return nil
+	}
+	return profiles
+}
+
+func (c *config) GetProfiles() map[string]Profile {
+	return c.data.Profiles
+}
+
+func (c *config) OverrideProfile(name string) {
+	c.profile = name
+}
+
+// ResolveProfile returns the provider settings for task: the profile chosen
+// with --profile, else the task's default profile, else llm_provider and
+// llm_model. --provider and --model apply on top of any of them.
+func (c *config) ResolveProfile(task string) (Profile, error) {
+	name := cmp.Or(c.profile, c.taskProfile(task))
+
+	var profile Profile
+	if name != "" {
+		p, ok := c.data.Profiles[name]
+		if !ok {
+			return Profile{}, c.unknownProfileError(name)
+		}
+		profile = p
+		profile.Name = name
+	}
+
+	profile.Provider = strings.ToLower(strings.TrimSpace(cmp.Or(c.providerOverride, profile.Provider, c.data.LLMProvider)))
+	profile.Model = cmp.Or(c.modelOverride, profile.Model, c.data.LLMModel)
+
+	if profile.Provider != "" && !isValidProvider(profile.Provider) {
+		return Profile{}, fmt.Errorf("invalid provider %q in profile %q", profile.Provider, name)
+	}
+
+	if profile.Model == "" {
+		return Profile{}, fmt.Errorf("%s not set in config", LLMModelKey)
+	}
+
+	if _, err := profile.RequestTimeout(); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
+
+// ResolveFallbacks returns the profiles to fall back on, in order, when the
+// provider of profile is unavailable: its own fallback list, else the
+// top-level one. Flags only apply to profile itself, and a fallback's own
+// fallback list is not followed.
+func (c *config) ResolveFallbacks(profile Profile) ([]Profile, error) {
+	names := profile.Fallback
+	if len(names) == 0 {
+		names = c.data.Fallback
+	}
+
+	var fallbacks []Profile
+	seen := map[string]bool{profile.Name: true}
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		p, ok := c.data.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("fallback: %w", c.unknownProfileError(name))
+		}
+		p.Name = name
+		p.Provider = strings.ToLower(strings.TrimSpace(cmp.Or(p.Provider, c.data.LLMProvider)))
+		p.Model = cmp.Or(p.Model, c.data.LLMModel)
+
+		if p.Provider != "" && !isValidProvider(p.Provider) {
+			return nil, fmt.Errorf("invalid provider %q in profile %q", p.Provider, name)
+		}
+		if p.Model == "" {
+			return nil, fmt.Errorf("no model set in fallback profile %q", name)
+		}
+		if _, err := p.RequestTimeout(); err != nil {
+			return nil, err
+		}
+
+		fallbacks = append(fallbacks, p)
+	}
+
+	return fallbacks, nil
+}
+
+func (c *config) taskProfile(task string) string {
+	switch task {
+	case TaskReview:
+		return c.data.ReviewProfile
+	case TaskCommit:
+		return c.data.CommitProfile
+	case TaskPR:
+		return c.data.PRProfile
+	default:
+		return ""
+	}
+}
+
+func (c *config) unknownProfileError(name string) error {
+	if len(c.data.Profiles) == 0 {
+		return fmt.Errorf("unknown profile %q: no [profiles.<name>] tables are defined in the config", name)
+	}
+
+	names := slices.Sorted(maps.Keys(c.data.Profiles))
+	return fmt.Errorf("unknown profile %q (defined: %s)", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() *config {
+	return &config{data: configData{
+		LLMProvider:   "gemini",
+		LLMModel:      "gemini-2.5-pro",
+		CommitProfile: "local",
+		Profiles: map[string]Profile{
+			"local":  {Provider: "ollama", Model: "qwen2.5-coder", Timeout: "90s"},
+			"claude": {Provider: "anthropic", Model: "claude-sonnet-4"},
+			"broken": {Provider: "ollama", Timeout: "soon"},
+		},
+	}}
+}
+
+func TestResolveProfile_TaskDefaults(t *testing.T) {
+	c := testConfig()
+
+	profile, err := c.ResolveProfile(TaskCommit)
+	require.NoError(t, err)
+	assert.Equal(t, "local", profile.Name)
+	assert.Equal(t, "ollama", profile.Provider)
+	assert.Equal(t, "qwen2.5-coder", profile.Model)
+
+	timeout, err := profile.RequestTimeout()
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, timeout)
+
+	profile, err = c.ResolveProfile(TaskReview)
+	require.NoError(t, err)
+	assert.Empty(t, profile.Name)
+	assert.Equal(t, "gemini", profile.Provider)
+	assert.Equal(t, "gemini-2.5-pro", profile.Model)
+}
+
+func TestResolveProfile_Overrides(t *testing.T) {
+	c := testConfig()
+	c.OverrideProfile("claude")
+
+	profile, err := c.ResolveProfile(TaskCommit)
+	require.NoError(t, err)
+	assert.Equal(t, "claude", profile.Name)
+	assert.Equal(t, "anthropic", profile.Provider)
+
+	c.OverrideModel("claude-opus-4")
+	profile, err = c.ResolveProfile(TaskCommit)
+	require.NoError(t, err)
+	assert.Equal(t, "claude-opus-4", profile.Model)
+}
+
+func TestResolveProfile_Errors(t *testing.T) {
+	c := testConfig()
+
+	c.OverrideProfile("missing")
+	_, err := c.ResolveProfile(TaskReview)
+	assert.ErrorContains(t, err, "defined: broken, claude, local")
+
+	c.OverrideProfile("broken")
+	_, err = c.ResolveProfile(TaskReview)
+	assert.ErrorContains(t, err, `invalid timeout "soon"`)
+}
+
+func TestResolveFallbacks(t *testing.T) {
+	c := testConfig()
+	c.data.Fallback = []string{"claude", "local", "claude"}
+
+	profile, err := c.ResolveProfile(TaskReview)
+	require.NoError(t, err)
+	fallbacks, err := c.ResolveFallbacks(profile)
+	require.NoError(t, err)
+	require.Len(t, fallbacks, 2)
+	assert.Equal(t, "claude", fallbacks[0].Name)
+	assert.Equal(t, "anthropic", fallbacks[0].Provider)
+	assert.Equal(t, "local", fallbacks[1].Name)
+
+	// A profile's own list replaces the top-level one, and never includes
+	// the profile itself.
+	c.data.Profiles["claude"] = Profile{Provider: "anthropic", Model: "claude-sonnet-4", Fallback: []string{"claude", "local"}}
+	c.OverrideProfile("claude")
+	profile, err = c.ResolveProfile(TaskReview)
+	require.NoError(t, err)
+	fallbacks, err = c.ResolveFallbacks(profile)
+	require.NoError(t, err)
+	require.Len(t, fallbacks, 1)
+	assert.Equal(t, "local", fallbacks[0].Name)
+
+	c.data.Fallback = []string{"missing"}
+	_, err = c.ResolveFallbacks(Profile{})
+	assert.ErrorContains(t, err, `unknown profile "missing"`)
+}
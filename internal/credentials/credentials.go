@@ -0,0 +1,338 @@
+// Package credentials reads provider API keys from the first source that
+// has one, in this order:
+//
+//  1. the provider's environment variable, e.g. ANTHROPIC_API_KEY
+//  2. api_key_command in its [credentials.<provider>] table
+//  3. api_key_file in its [credentials.<provider>] table
+//  4. the encrypted credentials file, ~/.bark/credentials.enc, unlocked
+//     with a passphrase prompted for on the terminal, or read from
+//     BARK_CREDENTIALS_PASSPHRASE where there is none, such as in CI
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/ionut-t/bark/v2/internal/config"
+)
+
+// DefaultCompatibleAPIKeyEnv holds the openai_compatible API key unless
+// api_key_env names another variable.
+const DefaultCompatibleAPIKeyEnv = "OPENAI_COMPATIBLE_API_KEY"
+
+// commandTimeout limits how long api_key_command may run, leaving time to
+// unlock a password manager.
+const commandTimeout = time.Minute
+
+// Providers lists the providers that take an API key.
+var Providers = []string{"gemini", "openai", "anthropic", "openai_compatible"}
+
+var envVars = map[string]string{
+	"gemini":    "GEMINI_API_KEY",
+	"openai":    "OPENAI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+}
+
+// Attempt is a source tried for a key.
+type Attempt struct {
+	// Source names the source, e.g. ANTHROPIC_API_KEY or api_key_file.
+	Source string
+	// Status says why the source gave no key, or what it gave.
+	Status string
+	Found  bool
+}
+
+func (a Attempt) String() string {
+	return fmt.Sprintf("%s (%s)", a.Source, a.Status)
+}
+
+// Resolution is the outcome of looking up the key of a provider.
+type Resolution struct {
+	Provider string
+	// Key is empty when no source had one.
+	Key      string
+	Attempts []Attempt
+}
+
+// Source names the source the key was read from.
+func (r Resolution) Source() string {
+	if r.Key == "" {
+		return ""
+	}
+	return r.Attempts[len(r.Attempts)-1].Source
+}
+
+// Err reports every source tried when none had a key.
+func (r Resolution) Err() error {
+	if r.Key != "" {
+		return nil
+	}
+	tried := make([]string, len(r.Attempts))
+	for i, a := range r.Attempts {
+		tried[i] = a.String()
+	}
+	return fmt.Errorf("no API key found; tried %s", strings.Join(tried, ", "))
+}
+
+// Resolver looks up API keys from the sources in the config and the
+// encrypted credentials file.
+type Resolver struct {
+	sources       map[string]config.Credentials
+	compatibleEnv string
+	store         string
+}
+
+// New returns a resolver for the key sources of cfg, with the encrypted
+// credentials file in storage.
+func New(cfg config.Config, storage string) *Resolver {
+	return &Resolver{
+		sources:       cfg.GetCredentials(),
+		compatibleEnv: cmp.Or(cfg.GetOpenAICompatible().APIKeyEnv, DefaultCompatibleAPIKeyEnv),
+		store:         StorePath(storage),
+	}
+}
+
+// EnvVar returns the environment variable holding the key of provider.
+func (r *Resolver) EnvVar(provider string) string {
+	if provider == "openai_compatible" {
+		return r.compatibleEnv
+	}
+	return envVars[provider]
+}
+
+// Configured reports whether provider has a source of a key, without
+// running its command or reading its files, so providers can be detected
+// cheaply.
+func (r *Resolver) Configured(provider string) bool {
+	if os.Getenv(r.EnvVar(provider)) != "" {
+		return true
+	}
+	if source := r.sources[provider]; source.APIKeyCommand != "" || source.APIKeyFile != "" {
+		return true
+	}
+	providers, err := StoreProviders(r.store)
+	return err == nil && slices.Contains(providers, provider)
+}
+
+// Resolve reads the key of provider from the first source that has one.
+// The attempts list every source tried, up to the one the key came from.
+func (r *Resolver) Resolve(ctx context.Context, provider string) Resolution {
+	res := Resolution{Provider: provider}
+	source := r.sources[provider]
+
+	lookups := []func() Attempt{
+		func() Attempt { return lookupEnv(r.EnvVar(provider), &res.Key) },
+		func() Attempt { return lookupCommand(ctx, source.APIKeyCommand, &res.Key) },
+		func() Attempt { return lookupFile(source.APIKeyFile, &res.Key) },
+		func() Attempt { return lookupStore(r.store, provider, &res.Key) },
+	}
+	for _, lookup := range lookups {
+		attempt := lookup()
+		res.Attempts = append(res.Attempts, attempt)
+		if attempt.Found {
+			break
+		}
+	}
+	return res
+}
+
+func lookupEnv(name string, key *string) Attempt {
+	attempt := Attempt{Source: name, Status: "not set"}
+	if *key = os.Getenv(name); *key != "" {
+		attempt.Status, attempt.Found = "set", true
+	}
+	return attempt
+}
+
+func lookupCommand(ctx context.Context, command string, key *string) Attempt {
+	attempt := Attempt{Source: "api_key_command", Status: "not configured"}
+	if command == "" {
+		return attempt
+	}
+	attempt.Source = fmt.Sprintf("api_key_command `%s`", command)
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// Password managers may ask for a passphrase.
+	cmd.Stdin = os.Stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		attempt.Status = "failed: " + err.Error()
+		if msg := lastLine(stderr.String()); msg != "" {
+			attempt.Status += ": " + msg
+		}
+		return attempt
+	}
+
+	if *key = firstLine(string(out)); *key == "" {
+		attempt.Status = "printed no key"
+		return attempt
+	}
+	attempt.Status, attempt.Found = "ran", true
+	return attempt
+}
+
+func lookupFile(path string, key *string) Attempt {
+	attempt := Attempt{Source: "api_key_file", Status: "not configured"}
+	if path == "" {
+		return attempt
+	}
+	path = expandHome(path)
+	attempt.Source = "api_key_file " + path
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		attempt.Status = "not found"
+		return attempt
+	case err != nil:
+		attempt.Status = err.Error()
+		return attempt
+	case !info.Mode().IsRegular():
+		attempt.Status = "not a regular file"
+		return attempt
+	}
+
+	// Windows has no permission bits to check.
+	if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm&0o077 != 0 {
+		attempt.Status = fmt.Sprintf("permissions %04o are too open, run chmod 600 %s", perm, path)
+		return attempt
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		attempt.Status = err.Error()
+		return attempt
+	}
+	if *key = firstLine(string(data)); *key == "" {
+		attempt.Status = "empty"
+		return attempt
+	}
+	attempt.Status, attempt.Found = "read", true
+	return attempt
+}
+
+func lookupStore(path, provider string, key *string) Attempt {
+	attempt := Attempt{Source: path, Status: "not created"}
+
+	providers, err := StoreProviders(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return attempt
+	case err != nil:
+		attempt.Status = err.Error()
+		return attempt
+	case !slices.Contains(providers, provider):
+		attempt.Status = "no " + provider + " key"
+		return attempt
+	}
+
+	unlocked.Lock()
+	defer unlocked.Unlock()
+
+	passphrase := cmp.Or(os.Getenv(PassphraseEnv), unlocked.passphrases[path])
+	if passphrase == "" {
+		if passphrase, err = promptPassphrase(path); err != nil {
+			attempt.Status = err.Error()
+			return attempt
+		}
+	}
+	if passphrase == "" {
+		attempt.Status = "locked, set " + PassphraseEnv
+		return attempt
+	}
+
+	keys, err := ReadStore(path, passphrase)
+	if err != nil {
+		attempt.Status = err.Error()
+		return attempt
+	}
+	unlocked.passphrases[path] = passphrase
+	*key = keys[provider]
+	attempt.Status, attempt.Found = "decrypted", true
+	return attempt
+}
+
+// unlocked holds the passphrases that decrypted a credentials file, by
+// path, so it is prompted for once per process.
+var unlocked = struct {
+	sync.Mutex
+	passphrases map[string]string
+}{passphrases: make(map[string]string)}
+
+// promptPassphrase asks for the passphrase of the credentials file at path
+// on the terminal. It returns an empty passphrase when stdin or stderr isn't
+// a terminal; replaced in tests.
+var promptPassphrase = func(path string) (string, error) {
+	if !term.IsTerminal(os.Stdin.Fd()) || !term.IsTerminal(os.Stderr.Fd()) {
+		return "", nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the passphrase: %w", err)
+	}
+	return strings.TrimSpace(string(passphrase)), nil
+}
+
+// Mask shortens key so it can be shown without revealing it.
+func Mask(key string) string {
+	if len(key) < 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + "…" + key[len(key)-4:]
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}
+
+func lastLine(s string) string {
+	var last string
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	return last
+}
+
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
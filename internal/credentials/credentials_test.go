@@ -0,0 +1,168 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newResolver returns a resolver for the anthropic key sources in source,
+// with an empty storage.
+func newResolver(t *testing.T, source map[string]any) (*Resolver, string) {
+	t.Helper()
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv(PassphraseEnv, "")
+	t.Cleanup(viper.Reset)
+
+	viper.Set(config.CredentialsKey, map[string]any{"anthropic": source})
+	storage := t.TempDir()
+	return New(config.New(), storage), storage
+}
+
+func writeKeyFile(t *testing.T, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "anthropic.key")
+	require.NoError(t, os.WriteFile(path, []byte("sk-ant-from-file-0001\n"), perm))
+	require.NoError(t, os.Chmod(path, perm))
+	return path
+}
+
+func TestResolve_TriesSourcesInOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("api_key_command runs through sh")
+	}
+	keys, _ := newResolver(t, map[string]any{
+		"api_key_command": `printf 'sk-ant-from-command-01\nlogin: me\n'`,
+		"api_key_file":    writeKeyFile(t, 0o600),
+	})
+	ctx := context.Background()
+
+	res := keys.Resolve(ctx, "anthropic")
+	require.NoError(t, res.Err())
+	assert.Equal(t, "sk-ant-from-command-01", res.Key, "only the first line is the key")
+	assert.Equal(t, "api_key_command `printf 'sk-ant-from-command-01\\nlogin: me\\n'`", res.Source())
+	assert.Len(t, res.Attempts, 2, "sources after the key aren't tried")
+
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-from-env-0001")
+	res = keys.Resolve(ctx, "anthropic")
+	assert.Equal(t, "sk-ant-from-env-0001", res.Key)
+	assert.Equal(t, "ANTHROPIC_API_KEY", res.Source())
+	assert.Len(t, res.Attempts, 1)
+}
+
+func TestResolve_RejectsKeyFilesReadableByOthers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no permission bits")
+	}
+	path := writeKeyFile(t, 0o644)
+	keys, _ := newResolver(t, map[string]any{"api_key_file": path})
+
+	res := keys.Resolve(context.Background(), "anthropic")
+	require.Error(t, res.Err())
+	assert.Equal(t, "permissions 0644 are too open, run chmod 600 "+path, res.Attempts[2].Status)
+
+	require.NoError(t, os.Chmod(path, 0o600))
+	res = keys.Resolve(context.Background(), "anthropic")
+	require.NoError(t, res.Err())
+	assert.Equal(t, "sk-ant-from-file-0001", res.Key)
+}
+
+func TestResolve_EncryptedStore(t *testing.T) {
+	keys, storage := newResolver(t, nil)
+	assert.False(t, keys.Configured("anthropic"))
+
+	path := StorePath(storage)
+	require.NoError(t, WriteStore(path, "correct horse", map[string]string{"anthropic": "sk-ant-from-store-01"}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "sk-ant-from-store-01")
+
+	// The providers are readable without the passphrase.
+	assert.True(t, keys.Configured("anthropic"))
+	assert.False(t, keys.Configured("openai"))
+
+	res := keys.Resolve(context.Background(), "anthropic")
+	require.Error(t, res.Err())
+	assert.Equal(t, "locked, set "+PassphraseEnv, res.Attempts[3].Status)
+
+	t.Setenv(PassphraseEnv, "wrong")
+	res = keys.Resolve(context.Background(), "anthropic")
+	assert.Equal(t, ErrWrongPassphrase.Error(), res.Attempts[3].Status)
+
+	t.Setenv(PassphraseEnv, "correct horse")
+	res = keys.Resolve(context.Background(), "anthropic")
+	require.NoError(t, res.Err())
+	assert.Equal(t, "sk-ant-from-store-01", res.Key)
+	assert.Equal(t, path, res.Source())
+
+	stored, err := ReadStore(path, "correct horse")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"anthropic": "sk-ant-from-store-01"}, stored)
+}
+
+func TestResolve_ErrNamesEverySource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("api_key_command runs through sh")
+	}
+	missing := filepath.Join(t.TempDir(), "missing.key")
+	keys, storage := newResolver(t, map[string]any{
+		"api_key_command": "echo locked >&2; exit 1",
+		"api_key_file":    missing,
+	})
+
+	res := keys.Resolve(context.Background(), "anthropic")
+	assert.EqualError(t, res.Err(), "no API key found; tried "+
+		"ANTHROPIC_API_KEY (not set), "+
+		"api_key_command `echo locked >&2; exit 1` (failed: exit status 1: locked), "+
+		"api_key_file "+missing+" (not found), "+
+		StorePath(storage)+" (not created)")
+
+	res = keys.Resolve(context.Background(), "openai")
+	assert.EqualError(t, res.Err(), "no API key found; tried "+
+		"OPENAI_API_KEY (not set), api_key_command (not configured), api_key_file (not configured), "+
+		StorePath(storage)+" (not created)")
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "sk-a…cdef", Mask("sk-ant-0123456789abcdef"))
+	assert.Equal(t, "*****", Mask("short"))
+}
+
+func TestResolve_PromptsForPassphraseOnce(t *testing.T) {
+	keys, storage := newResolver(t, nil)
+	t.Setenv("OPENAI_API_KEY", "")
+	require.NoError(t, WriteStore(StorePath(storage), "correct horse", map[string]string{
+		"anthropic": "sk-ant-from-store-01",
+		"openai":    "sk-from-store-000001",
+	}))
+
+	prompts := 0
+	prompt := promptPassphrase
+	promptPassphrase = func(string) (string, error) {
+		prompts++
+		return "correct horse", nil
+	}
+	t.Cleanup(func() { promptPassphrase = prompt })
+
+	res := keys.Resolve(context.Background(), "anthropic")
+	require.NoError(t, res.Err())
+	assert.Equal(t, "sk-ant-from-store-01", res.Key)
+
+	res = keys.Resolve(context.Background(), "openai")
+	require.NoError(t, res.Err())
+	assert.Equal(t, "sk-from-store-000001", res.Key)
+	assert.Equal(t, 1, prompts)
+}
@@ -0,0 +1,168 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// PassphraseEnv holds the passphrase of the encrypted credentials file.
+const PassphraseEnv = "BARK_CREDENTIALS_PASSPHRASE"
+
+const (
+	storeFileName = "credentials.enc"
+	storeVersion  = 1
+	// iterations of PBKDF2-SHA256, as recommended by OWASP.
+	iterations = 600_000
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted credentials file")
+
+// storeFile is the encrypted credentials file. The providers are kept in
+// the clear, authenticated with the keys, so a provider can be detected
+// and diagnosed without the passphrase.
+type storeFile struct {
+	Version    int      `json:"version"`
+	Providers  []string `json:"providers"`
+	Iterations int      `json:"iterations"`
+	Salt       []byte   `json:"salt"`
+	Nonce      []byte   `json:"nonce"`
+	Ciphertext []byte   `json:"ciphertext"`
+}
+
+// StorePath returns the path of the encrypted credentials file in storage.
+func StorePath(storage string) string {
+	return filepath.Join(storage, storeFileName)
+}
+
+// StoreProviders lists the providers with a key in the credentials file at
+// path, without decrypting them.
+func StoreProviders(path string) ([]string, error) {
+	f, err := readStoreFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Providers, nil
+}
+
+// ReadStore decrypts the keys in the credentials file at path. A missing
+// file holds no keys.
+func ReadStore(path, passphrase string) (map[string]string, error) {
+	f, err := readStoreFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	additional, err := json.Marshal(f.Providers)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, additional)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var keys map[string]string
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// WriteStore encrypts keys with passphrase into the credentials file at
+// path, readable only by the user.
+func WriteStore(path, passphrase string, keys map[string]string) error {
+	if passphrase == "" {
+		return errors.New("the passphrase must not be empty")
+	}
+
+	f := storeFile{
+		Version:    storeVersion,
+		Providers:  slices.Sorted(maps.Keys(keys)),
+		Iterations: iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	additional, err := json.Marshal(f.Providers)
+	if err != nil {
+		return err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, additional)
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it so a failed write never loses
+	// the keys already stored.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func readStoreFile(path string) (storeFile, error) {
+	var f storeFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if f.Version != storeVersion {
+		return f, fmt.Errorf("unsupported version %d of %s", f.Version, path)
+	}
+	return f, nil
+}
+
+func newAEAD(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iter, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,174 @@
+package enclosing
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+const (
+	// elisionContext is the number of lines kept around each changed line
+	// of an elided declaration.
+	elisionContext = 3
+	// maxHeaderLines bounds the signature kept by elision, so that a long
+	// decorator, such as an Angular @Component with an inline template, is
+	// elided too.
+	maxHeaderLines = 10
+)
+
+// snippet is a declaration shown in the enclosing context, with its old
+// version when the diff modifies it.
+type snippet struct {
+	file   string
+	decl   declaration
+	before *declaration
+	// text and beforeText are the code shown for decl and before, which
+	// elide shortens.
+	text, beforeText string
+	// changed and baseChanged are the modified lines of the file on the
+	// new and the old side.
+	changed, baseChanged []int
+	// covered is the number of changed lines within decl, by which
+	// snippets are ranked.
+	covered int
+}
+
+func newSnippet(file string, decl declaration, before *declaration, changed, baseChanged []int) *snippet {
+	s := &snippet{file: file, decl: decl, before: before, text: decl.text, changed: changed, baseChanged: baseChanged}
+	if before != nil {
+		s.beforeText = before.text
+	}
+
+	last := decl.line + strings.Count(decl.text, "\n")
+	for _, line := range changed {
+		if line >= decl.line && line <= last {
+			s.covered++
+		}
+	}
+	return s
+}
+
+// render returns the snippet as Markdown code blocks.
+func (s *snippet) render() string {
+	class := langClass(s.file)
+
+	var sb strings.Builder
+	if s.before != nil {
+		fmt.Fprintf(&sb, "_Before:_\n```%s\n%s\n```\n_After:_\n", class, s.beforeText)
+	}
+	fmt.Fprintf(&sb, "```%s\n%s\n```\n", class, s.text)
+	return sb.String()
+}
+
+func (s *snippet) tokens() int {
+	return llm.EstimateTokens(s.render())
+}
+
+// elide shortens both versions of the snippet to their signature, the
+// lines around the changes and their last line.
+func (s *snippet) elide() {
+	class := langClass(s.file)
+	s.text = elide(s.decl.text, s.decl.line, s.decl.header, s.changed, class)
+	if s.before != nil {
+		s.beforeText = elide(s.before.text, s.before.line, s.before.header, s.baseChanged, class)
+	}
+}
+
+// fitBudget fits the snippets in about budget estimated tokens. It elides
+// the snippets covering the fewest changed lines first and, if they still
+// don't fit, leaves them out from the same end. It returns the snippets
+// kept, ranked by the changed lines they cover, and how many were left
+// out. A budget of 0 disables the limit.
+func fitBudget(snippets []*snippet, budget int) ([]*snippet, int) {
+	ranked := slices.Clone(snippets)
+	slices.SortStableFunc(ranked, func(a, b *snippet) int {
+		return cmp.Compare(b.covered, a.covered)
+	})
+	if budget <= 0 {
+		return ranked, 0
+	}
+
+	total := 0
+	for _, s := range ranked {
+		total += s.tokens()
+	}
+	for i := len(ranked) - 1; i >= 0 && total > budget; i-- {
+		total -= ranked[i].tokens()
+		ranked[i].elide()
+		total += ranked[i].tokens()
+	}
+
+	kept := len(ranked)
+	for kept > 0 && total > budget {
+		kept--
+		total -= ranked[kept].tokens()
+	}
+	return ranked[:kept], len(ranked) - kept
+}
+
+// elide shortens text, a declaration starting at line, to its first header
+// lines, the lines within elisionContext of the changed lines and its last
+// line, such as the closing brace. Each run of lines left out is replaced
+// with a comment saying how many there were.
+func elide(text string, line, header int, changed []int, class string) string {
+	lines := strings.Split(text, "\n")
+
+	keep := make([]bool, len(lines))
+	for i := range min(header, maxHeaderLines, len(lines)) {
+		keep[i] = true
+	}
+	keep[len(lines)-1] = true
+	for _, c := range changed {
+		for i := max(c-line-elisionContext, 0); i <= min(c-line+elisionContext, len(lines)-1); i++ {
+			keep[i] = true
+		}
+	}
+
+	var out []string
+	for i := 0; i < len(lines); {
+		if keep[i] {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+
+		// The marker is indented like the first non-blank line it replaces.
+		j := i
+		indent, indented := "", false
+		for ; j < len(lines) && !keep[j]; j++ {
+			if trimmed := strings.TrimLeft(lines[j], " \t"); !indented && trimmed != "" {
+				indent, indented = lines[j][:len(lines[j])-len(trimmed)], true
+			}
+		}
+
+		// A marker in place of a single line saves nothing.
+		if j-i == 1 {
+			out = append(out, lines[i])
+		} else {
+			out = append(out, indent+elisionMarker(class, j-i))
+		}
+		i = j
+	}
+	return strings.Join(out, "\n")
+}
+
+// elisionMarker returns the comment replacing n elided lines, in the
+// comment syntax of the language of a code block.
+func elisionMarker(class string, n int) string {
+	text := fmt.Sprintf("… %d lines elided …", n)
+	switch class {
+	case "python", "ruby", "bash", "yaml", "toml", "r", "perl", "elixir":
+		return "# " + text
+	case "lua", "sql", "haskell":
+		return "-- " + text
+	case "html", "angular", "xml", "markdown", "svelte", "vue":
+		return "<!-- " + text + " -->"
+	case "css":
+		return "/* " + text + " */"
+	default:
+		return "// " + text
+	}
+}
@@ -0,0 +1,144 @@
+package enclosing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// longFunc returns a Go function named name with n statements, each on its
+// own line.
+func longFunc(name string, n int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func %s(a int) int {\n", name)
+	for i := range n {
+		fmt.Fprintf(&sb, "\ta += %d\n", i)
+	}
+	sb.WriteString("\treturn a\n}")
+	return sb.String()
+}
+
+func TestElide(t *testing.T) {
+	// Lines 10-31 of a file: the signature, 20 statements, return and the
+	// closing brace. Line 20 is `a += 9`.
+	text := longFunc("f", 20)
+
+	expected := "func f(a int) int {\n" +
+		"\t// … 6 lines elided …\n" +
+		"\ta += 6\n\ta += 7\n\ta += 8\n\ta += 9\n\ta += 10\n\ta += 11\n\ta += 12\n" +
+		"\t// … 8 lines elided …\n" +
+		"}"
+	assert.Equal(t, expected, elide(text, 10, 1, []int{20}, "go"))
+
+	// A run of a single line is kept rather than replaced with a marker.
+	elided := elide(text, 10, 1, []int{15}, "go")
+	assert.True(t, strings.HasPrefix(elided, "func f(a int) int {\n\ta += 0\n\ta += 1\n\ta += 2\n"), elided)
+
+	// Markers use the comment syntax of the language.
+	python := "def f(a):\n" + strings.Repeat("    a += 1\n", 10) + "    return a"
+	assert.Equal(t, "def f(a):\n    # … 10 lines elided …\n    return a", elide(python, 1, 1, nil, "python"))
+}
+
+func TestDeclarations_HeaderLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		source string
+		line   int
+		header int
+	}{
+		{
+			name:   "go multi-line signature",
+			path:   "main.go",
+			source: "package main\n\nfunc f(\n\ta int,\n) int {\n\treturn a\n}\n",
+			line:   6,
+			header: 3,
+		},
+		{
+			name:   "python def",
+			path:   "main.py",
+			source: "def f(a):\n    return a\n",
+			line:   2,
+			header: 1,
+		},
+		{
+			name:   "ts class",
+			path:   "main.ts",
+			source: "class A\n  extends B {\n  x = 1;\n}\n",
+			line:   3,
+			header: 2,
+		},
+		{
+			name:   "go type without a body",
+			path:   "main.go",
+			source: "package main\n\ntype ID string\n",
+			line:   3,
+			header: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decls, err := declarations(tt.path, []byte(tt.source), []int{tt.line})
+			require.NoError(t, err)
+			require.Len(t, decls, 1)
+			assert.Equal(t, tt.header, decls[0].header)
+		})
+	}
+}
+
+func TestFitBudget(t *testing.T) {
+	newRanked := func(name string, covered int) *snippet {
+		text := longFunc(name, 40)
+		s := newSnippet("main.go", declaration{name: name, text: text, line: 1, header: 1}, nil, nil, nil)
+		s.covered = covered
+		return s
+	}
+	few, many, some := newRanked("few", 1), newRanked("many", 5), newRanked("some", 3)
+	snippets := []*snippet{few, many, some}
+
+	kept, omitted := fitBudget(snippets, 0)
+	assert.Equal(t, []*snippet{many, some, few}, kept, "ranked by the changed lines covered")
+	assert.Zero(t, omitted)
+	assert.Equal(t, few.decl.text, few.text, "no budget, nothing elided")
+
+	// Room for two snippets in full: the one covering the fewest lines is
+	// elided first.
+	budget := many.tokens() + some.tokens() + few.tokens()/2
+	kept, omitted = fitBudget(snippets, budget)
+	assert.Equal(t, []*snippet{many, some, few}, kept)
+	assert.Zero(t, omitted)
+	assert.Contains(t, few.text, "lines elided")
+	assert.Equal(t, many.decl.text, many.text)
+	assert.Equal(t, some.decl.text, some.text)
+
+	// Room for one elided snippet: the lowest ranked are left out.
+	kept, omitted = fitBudget(snippets, few.tokens()+1)
+	assert.Equal(t, []*snippet{many}, kept)
+	assert.Equal(t, 2, omitted)
+	assert.Contains(t, many.text, "lines elided")
+}
+
+func TestDeclarationsForDiff_Budget(t *testing.T) {
+	before := "package main\n\n" + longFunc("Long", 100) + "\n\n" + longFunc("Short", 3) + "\n"
+	after := strings.NewReplacer(
+		"\ta += 50\n", "\ta += 500\n",
+		"\ta += 0\n\ta += 1\n\ta += 2\n\treturn a", "\ta -= 0\n\ta -= 1\n\ta += 2\n\treturn a",
+	).Replace(before)
+	diff := commitFiles(t, map[string]string{"main.go": before}, map[string]string{"main.go": after})
+
+	out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "", 0)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "lines elided")
+
+	// Short covers more changed lines, so Long is elided first.
+	out, err = DeclarationsForDiff(context.Background(), diff, "HEAD", "", 200)
+	require.NoError(t, err)
+	assert.Contains(t, out, "func Long(a int) int {\n\t// … 47 lines elided …\n\ta += 47\n")
+	assert.Contains(t, out, "\ta += 53\n\t// … 47 lines elided …\n}\n```")
+	assert.Contains(t, out, "### File: main.go\n```go\nfunc Short(a int) int {\n\ta -= 0\n\ta -= 1\n\ta += 2\n\treturn a\n}\n```\n```go\nfunc Long(a int) int {\n")
+}
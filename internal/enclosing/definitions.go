@@ -0,0 +1,413 @@
+package enclosing
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/git"
+	"github.com/odvcencio/gotreesitter"
+)
+
+const (
+	// maxDefinitions bounds the definitions shown for a diff.
+	maxDefinitions = 15
+	// maxDefinitionsPerName bounds the definitions shown for one name, such
+	// as methods of the same name on different types.
+	maxDefinitionsPerName = 2
+	// maxDefinitionLines is the longest definition shown whole; longer ones
+	// are shown as their signature.
+	maxDefinitionLines = 15
+	// maxDefinitionFiles bounds the candidate files parsed.
+	maxDefinitionFiles = 100
+)
+
+// definitionRules describes how a language family declares names.
+type definitionRules struct {
+	// nodes are the node types that declare a name.
+	nodes []string
+	// patterns are git grep -E patterns for a line declaring %s, so only
+	// files that may declare a name are parsed.
+	patterns []string
+	// pathspecs are the files of the family.
+	pathspecs []string
+}
+
+var definitionLanguages = map[string]definitionRules{
+	"go": {
+		nodes: []string{"function_declaration", "method_declaration", "type_spec", "type_alias"},
+		patterns: []string{
+			`func %s`,
+			`func \([^)]*\) %s`,
+			`type %s`,
+			// A type in a type ( ... ) group.
+			`^[[:space:]]+%s[[:space:]]+(=[[:space:]]*)?[[:alnum:]_.*]+`,
+		},
+		pathspecs: []string{"*.go"},
+	},
+	"ecmascript": {
+		nodes: []string{
+			"function_declaration", "generator_function_declaration",
+			"class_declaration", "abstract_class_declaration",
+			"interface_declaration", "type_alias_declaration", "enum_declaration",
+			"method_definition", "method_signature", "abstract_method_signature",
+			"variable_declarator",
+		},
+		patterns: []string{
+			`(function\*?|class|interface|type|enum)[[:space:]]+%s`,
+			`(const|let|var)[[:space:]]+%s`,
+			// Methods, after any modifiers.
+			`^[[:space:]]*((public|private|protected|static|async|abstract|readonly|override|get|set)[[:space:]]+)*%s`,
+		},
+		pathspecs: []string{"*.ts", "*.tsx", "*.mts", "*.cts", "*.js", "*.jsx", "*.mjs", "*.cjs"},
+	},
+	"python": {
+		nodes:     []string{"function_definition", "class_definition"},
+		patterns:  []string{`(def|class)[[:space:]]+%s`},
+		pathspecs: []string{"*.py", "*.pyi"},
+	},
+	"rust": {
+		nodes: []string{
+			"function_item", "function_signature_item", "struct_item", "enum_item",
+			"union_item", "trait_item", "type_item",
+		},
+		patterns:  []string{`(fn|struct|enum|union|trait|type)[[:space:]]+%s`},
+		pathspecs: []string{"*.rs"},
+	},
+}
+
+// callNodes are the node types of calls and constructions, keyed by the
+// field holding what is called.
+var callNodes = map[string]string{
+	"call_expression": "function",
+	"call":            "function",
+	"new_expression":  "constructor",
+}
+
+// qualifiedNodes are the node types that qualify a name, such as
+// pkg.Func, obj.method, self.method, mod::func or func::<T>, keyed by the
+// field holding the name.
+var qualifiedNodes = map[string]string{
+	"selector_expression": "field",
+	"member_expression":   "property",
+	"attribute":           "attribute",
+	"field_expression":    "field",
+	"scoped_identifier":   "name",
+	"generic_function":    "function",
+}
+
+// reference is a name the diff calls or uses as a type.
+type reference struct {
+	name string
+	// qualified is set when the name is called on something, as in
+	// obj.m(x), so it may be a method. Unqualified calls, such as append(x)
+	// or string(x), can't be.
+	qualified bool
+}
+
+// definition is a declaration found for a name referenced by the diff.
+type definition struct {
+	name string
+	file string
+	line int
+	text string
+}
+
+// references returns the names the modified lines of file call or use as
+// types, in the order they appear. Other identifiers, such as local
+// variables and parameters, are left out: they are declared nearby.
+func references(file *parsedFile, modifiedLines []int) []reference {
+	rows := make(map[uint32]bool, len(modifiedLines))
+	for _, line := range modifiedLines {
+		rows[uint32(line-1)] = true
+	}
+
+	var refs []reference
+	index := make(map[string]int)
+	eachIdentifier(file, func(node *gotreesitter.Node, name string) {
+		if !rows[node.StartPoint().Row] || isDeclaredName(node, file) {
+			return
+		}
+
+		var qualified bool
+		switch {
+		case node.Type(file.lang) == "type_identifier", isPythonType(node, file):
+		default:
+			expr, ok := callee(node, file)
+			if !ok {
+				return
+			}
+			qualified = expr != node
+		}
+
+		if i, ok := index[name]; ok {
+			refs[i].qualified = refs[i].qualified || qualified
+			return
+		}
+		index[name] = len(refs)
+		refs = append(refs, reference{name: name, qualified: qualified})
+	})
+	return refs
+}
+
+// callee returns the expression a call calls when node names it, possibly
+// qualified, as in f(x), pkg.F(x), obj.m(x), mod::f(x) or new C(x).
+func callee(node *gotreesitter.Node, file *parsedFile) (*gotreesitter.Node, bool) {
+	for {
+		parent := node.Parent()
+		if parent == nil {
+			return nil, false
+		}
+		t := parent.Type(file.lang)
+		if field, ok := qualifiedNodes[t]; ok && isField(parent, field, node, file) {
+			node = parent
+			continue
+		}
+		field, ok := callNodes[t]
+		return node, ok && isField(parent, field, node, file)
+	}
+}
+
+// isPythonType reports whether node is a Python type annotation.
+func isPythonType(node *gotreesitter.Node, file *parsedFile) bool {
+	parent := node.Parent()
+	return file.entry.Name == "python" && parent != nil && parent.Type(file.lang) == "type"
+}
+
+func isField(parent *gotreesitter.Node, field string, node *gotreesitter.Node, file *parsedFile) bool {
+	child := parent.ChildByFieldName(field, file.lang)
+	return child != nil && child.StartByte() == node.StartByte() && child.EndByte() == node.EndByte()
+}
+
+// findDefinitions looks up the in-repo definitions of names, referenced by
+// the file from, in the files of its language family at ref. Definitions
+// inside shown are left out, since the enclosing context already has them.
+// The closest definitions of each name are kept: in the same file, then in
+// the same directory.
+func findDefinitions(ctx context.Context, ref, family, from string, refs []reference, shown map[string][]declaration) []definition {
+	rules, ok := definitionLanguages[family]
+	if !ok || len(refs) == 0 {
+		return nil
+	}
+
+	var patterns []string
+	for _, r := range refs {
+		for _, pattern := range rules.patterns {
+			patterns = append(patterns, fmt.Sprintf(pattern, regexp.QuoteMeta(r.name)))
+		}
+	}
+
+	files, err := git.GrepFilesMatching(ctx, ref, patterns, rules.pathspecs)
+	if err != nil {
+		return nil
+	}
+	sortByDistance(files, from)
+	if len(files) > maxDefinitionFiles {
+		files = files[:maxDefinitionFiles]
+	}
+
+	wanted := make(map[string]reference, len(refs))
+	for _, r := range refs {
+		wanted[r.name] = r
+	}
+	kinds := make(map[string]bool, len(rules.nodes))
+	for _, kind := range rules.nodes {
+		kinds[kind] = true
+	}
+
+	found := make(map[string][]definition)
+	for _, filePath := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		content, err := git.GetFileContent(ctx, ref, filePath)
+		if err != nil || len(content) > maxUsageFileSize {
+			continue
+		}
+		file, err := parse(filePath, content)
+		if file == nil || err != nil {
+			continue
+		}
+
+		eachNode(file.root, func(node *gotreesitter.Node) {
+			if !kinds[node.Type(file.lang)] {
+				return
+			}
+			name := declarationName(node, file)
+			r, ok := wanted[name]
+			if !ok || len(found[name]) == maxDefinitionsPerName || isShown(shown[filePath], node) {
+				return
+			}
+			if !r.qualified && isMember(node, file) {
+				return
+			}
+			// A TS variable is only a definition when it holds a function.
+			if node.Type(file.lang) == "variable_declarator" && !holdsFunction(node, file) {
+				return
+			}
+			found[name] = append(found[name], definition{
+				name: name,
+				file: filePath,
+				line: int(node.StartPoint().Row) + 1,
+				text: definitionText(node, file),
+			})
+		})
+	}
+
+	var defs []definition
+	for _, r := range refs {
+		defs = append(defs, found[r.name]...)
+	}
+	return defs
+}
+
+// isMember reports whether node is declared in a class, interface, impl
+// or trait, so that it is only called on something.
+func isMember(node *gotreesitter.Node, file *parsedFile) bool {
+	if strings.Contains(node.Type(file.lang), "method") {
+		return true
+	}
+	for parent := node.Parent(); parent != nil; parent = parent.Parent() {
+		t := parent.Type(file.lang)
+		switch {
+		case strings.Contains(t, "class"), strings.Contains(t, "interface"), t == "declaration_list":
+			return true
+		case strings.Contains(t, "function"):
+			return false
+		}
+	}
+	return false
+}
+
+// sortByDistance sorts files so that from comes first, then the files in
+// its directory, then the rest by path.
+func sortByDistance(files []string, from string) {
+	rank := func(f string) int {
+		switch {
+		case f == from:
+			return 0
+		case path.Dir(f) == path.Dir(from):
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if ri, rj := rank(files[i]), rank(files[j]); ri != rj {
+			return ri < rj
+		}
+		return files[i] < files[j]
+	})
+}
+
+// eachNode calls fn with node and its descendants, in source order.
+func eachNode(node *gotreesitter.Node, fn func(node *gotreesitter.Node)) {
+	stack := []*gotreesitter.Node{node}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		fn(node)
+		for i := node.ChildCount() - 1; i >= 0; i-- {
+			stack = append(stack, node.Child(i))
+		}
+	}
+}
+
+func isShown(decls []declaration, node *gotreesitter.Node) bool {
+	for _, decl := range decls {
+		if node.StartByte() >= decl.start && node.EndByte() <= decl.end {
+			return true
+		}
+	}
+	return false
+}
+
+func holdsFunction(node *gotreesitter.Node, file *parsedFile) bool {
+	value := node.ChildByFieldName("value", file.lang)
+	if value == nil {
+		return false
+	}
+	switch value.Type(file.lang) {
+	case "arrow_function", "function_expression", "function", "generator_function":
+		return true
+	default:
+		return false
+	}
+}
+
+// definitionText returns the source of a definition, with the doc comment
+// above it, or only its signature when it is longer than
+// maxDefinitionLines.
+func definitionText(node *gotreesitter.Node, file *parsedFile) string {
+	// Show the keyword of a declaration that declares a single name, such
+	// as `type T struct` rather than `T struct`, or `const f = () => ...`.
+	if parent := node.Parent(); parent != nil && parent.NamedChildCount() == 1 {
+		switch parent.Type(file.lang) {
+		case "type_declaration", "lexical_declaration", "variable_declaration":
+			node = parent
+		}
+	}
+
+	text := strings.TrimSpace(string(file.source[node.StartByte():node.EndByte()]))
+	if strings.Count(text, "\n") >= maxDefinitionLines {
+		text = signature(node, file)
+	}
+	if doc := docComment(node, file); doc != "" {
+		text = doc + "\n" + text
+	}
+	return text
+}
+
+// signature returns the source of a definition up to its body, or its
+// first lines when it has no body field.
+func signature(node *gotreesitter.Node, file *parsedFile) string {
+	end := node.EndByte()
+	if body := node.ChildByFieldName("body", file.lang); body != nil {
+		end = body.StartByte()
+	} else if named := node.NamedChildCount(); named > 0 {
+		// A Go type_declaration: its type_spec's type holds the body.
+		if body := node.NamedChild(0).ChildByFieldName("type", file.lang); body != nil {
+			end = body.StartByte()
+		}
+	}
+
+	text := strings.TrimSpace(string(file.source[node.StartByte():end]))
+	if lines := strings.Split(text, "\n"); len(lines) > maxDefinitionLines {
+		text = strings.Join(lines[:maxDefinitionLines], "\n")
+	}
+	return text + " …"
+}
+
+// docComment returns the comments directly above node.
+func docComment(node *gotreesitter.Node, file *parsedFile) string {
+	var comments []string
+	line := node.StartPoint().Row
+	for prev := node.PrevSibling(); prev != nil; prev = prev.PrevSibling() {
+		if !strings.Contains(prev.Type(file.lang), "comment") || prev.EndPoint().Row+1 < line {
+			break
+		}
+		comments = append([]string{prev.Text(file.source)}, comments...)
+		line = prev.StartPoint().Row
+	}
+	return strings.Join(comments, "\n")
+}
+
+// formatDefinitions renders defs as the "Referenced Definitions" prompt
+// section.
+func formatDefinitions(defs []definition) string {
+	if len(defs) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n## Referenced Definitions\n")
+	sb.WriteString("_Read-only reference showing the in-repo definitions of functions, methods and types the changed lines use but the diff doesn't show, long ones as their signature. This is NOT under review. Use it instead of guessing what they do._\n")
+	for _, def := range defs {
+		fmt.Fprintf(&sb, "\n### `%s` (%s:%d)\n", def.name, def.file, def.line)
+		fmt.Fprintf(&sb, "```%s\n%s\n```\n", langClass(def.file), def.text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+package enclosing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeclarationsForDiff_Definitions(t *testing.T) {
+	long := "func Long() {\n" + strings.Repeat("\tprintln()\n", maxDefinitionLines) + "}\n"
+
+	tests := []struct {
+		name   string
+		before map[string]string
+		after  map[string]string
+		want   []string
+		absent []string
+	}{
+		{
+			name: "go",
+			before: map[string]string{
+				"geo/point.go": "package geo\n\n// Point is a position on a plane.\ntype Point struct {\n\tX, Y float64\n}\n\n// Dist returns the distance to q.\nfunc (p Point) Dist(q Point) float64 {\n\treturn 0\n}\n\n" + long + "\nfunc (p *Point) append(q Point) {}\n",
+				"main.go":      "package main\n\nfunc main() {\n}\n",
+			},
+			after: map[string]string{
+				"main.go": "package main\n\nfunc main() {\n\tp := geo.Point{}\n\terr := p.Dist(p)\n\tgeo.Long()\n\tfmt.Println(append([]error{}, err))\n}\n",
+			},
+			want: []string{
+				"### `Point` (geo/point.go:4)\n```go\n// Point is a position on a plane.\ntype Point struct {\n\tX, Y float64\n}\n```",
+				"### `Dist` (geo/point.go:9)\n```go\n// Dist returns the distance to q.\nfunc (p Point) Dist(q Point) float64 {\n\treturn 0\n}\n```",
+				"### `Long` (geo/point.go:13)\n```go\nfunc Long() …\n```",
+			},
+			// The builtin append, not the method of the same name.
+			absent: []string{"`err`", "`p`", "`Println`", "`append`"},
+		},
+		{
+			name: "typescript",
+			before: map[string]string{
+				"src/user.ts": "export interface User {\n  name: string;\n}\n\nexport const greet = (u: User) => `hi ${u.name}`;\n\nexport class Store {\n  save(u: User): void {}\n}\n",
+				"src/app.ts":  "export function run() {\n}\n",
+			},
+			after: map[string]string{
+				"src/app.ts": "export function run() {\n  const u: User = { name: 'a' };\n  new Store().save(u);\n  console.log(greet(u));\n}\n",
+			},
+			want: []string{
+				"### `User` (src/user.ts:1)\n```typescript\ninterface User {\n  name: string;\n}\n```",
+				"### `Store` (src/user.ts:7)",
+				"### `save` (src/user.ts:8)\n```typescript\nsave(u: User): void {}\n```",
+				"### `greet` (src/user.ts:5)\n```typescript\nconst greet = (u: User) => `hi ${u.name}`;\n```",
+			},
+			absent: []string{"`log`", "`u`"},
+		},
+		{
+			name: "python",
+			before: map[string]string{
+				"shop/cart.py": "class Cart:\n    def total(self) -> float:\n        return 0.0\n\n\ndef checkout(cart: Cart) -> None:\n    pass\n",
+				"main.py":      "def main():\n    pass\n",
+			},
+			after: map[string]string{
+				"main.py": "def main():\n    cart = Cart()\n    print(cart.total())\n    checkout(cart)\n",
+			},
+			want: []string{
+				"### `Cart` (shop/cart.py:1)",
+				"### `total` (shop/cart.py:2)\n```python\ndef total(self) -> float:\n        return 0.0\n```",
+				"### `checkout` (shop/cart.py:6)",
+			},
+			absent: []string{"`print`", "`cart`"},
+		},
+		{
+			name: "rust",
+			before: map[string]string{
+				"src/shape.rs": "pub struct Square {\n    pub side: f64,\n}\n\nimpl Square {\n    pub fn area(&self) -> f64 {\n        self.side * self.side\n    }\n}\n",
+				"src/main.rs":  "fn main() {\n}\n",
+			},
+			after: map[string]string{
+				"src/main.rs": "fn main() {\n    let s = Square { side: 2.0 };\n    let a = s.area();\n    println!(\"{}\", a);\n}\n",
+			},
+			want: []string{
+				"### `Square` (src/shape.rs:1)\n```rust\npub struct Square {\n    pub side: f64,\n}\n```",
+				"### `area` (src/shape.rs:6)",
+			},
+			// Macro arguments are tokens to tree-sitter, so println!'s can't
+			// be resolved.
+			absent: []string{"`println`", "`s`", "`a`"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := commitFiles(t, tt.before, tt.after)
+
+			out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "HEAD~1", 0)
+			require.NoError(t, err)
+			assert.Contains(t, out, "## Referenced Definitions")
+			for _, want := range tt.want {
+				assert.Contains(t, out, want)
+			}
+			for _, absent := range tt.absent {
+				assert.NotContains(t, out, "### "+absent)
+			}
+		})
+	}
+}
+
+func TestDeclarationsForDiff_DefinitionsSkipShownDeclarations(t *testing.T) {
+	source := "package main\n\nfunc helper() int {\n\treturn 1\n}\n\nfunc main() {\n\t_ = helper()\n}\n"
+	diff := commitFiles(t,
+		map[string]string{"main.go": source},
+		map[string]string{"main.go": strings.Replace(source, "return 1", "return helper() - 1", 1)},
+	)
+
+	// helper is changed, so the enclosing context shows it already.
+	out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "HEAD~1", 0)
+	require.NoError(t, err)
+	assert.Contains(t, out, "## Enclosing Code Context")
+	assert.NotContains(t, out, "## Referenced Definitions")
+}
@@ -1,9 +1,11 @@
 package enclosing
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -63,6 +65,84 @@ func isStructuralNode(langName string, nodeType string) bool {
 			nodeType == "defer_statement"
 	case "css":
 		return nodeType == "rule_set"
+	case "java":
+		// Fields and local variables are left out, like Go's var/const: a
+		// changed field resolves to its class and a changed local to its
+		// method. Methods match before the class they're nested in.
+		return nodeType == "method_declaration" ||
+			nodeType == "constructor_declaration" ||
+			nodeType == "compact_constructor_declaration" ||
+			nodeType == "class_declaration" ||
+			nodeType == "interface_declaration" ||
+			nodeType == "enum_declaration" ||
+			nodeType == "record_declaration" ||
+			nodeType == "annotation_type_declaration"
+	case "kotlin":
+		// class_declaration covers interfaces and enum classes too.
+		// property_declaration is left out as it's also a local val/var.
+		return nodeType == "function_declaration" ||
+			nodeType == "secondary_constructor" ||
+			nodeType == "class_declaration" ||
+			nodeType == "object_declaration" ||
+			nodeType == "companion_object"
+	case "c_sharp":
+		// Namespaces are left out: they usually span the whole file, so a
+		// change between its types would emit all of them.
+		return nodeType == "method_declaration" ||
+			nodeType == "constructor_declaration" ||
+			nodeType == "destructor_declaration" ||
+			nodeType == "operator_declaration" ||
+			nodeType == "property_declaration" ||
+			nodeType == "local_function_statement" ||
+			nodeType == "class_declaration" ||
+			nodeType == "struct_declaration" ||
+			nodeType == "interface_declaration" ||
+			nodeType == "enum_declaration" ||
+			nodeType == "record_declaration"
+	case "c", "cpp":
+		// Specifiers only count with a body (see isReference), so
+		// `struct point p;` inside a function resolves to the function.
+		// Namespaces are left out like C#'s, so a change between functions
+		// or classes in one resolves to nothing. Methods match before their
+		// class.
+		return nodeType == "function_definition" ||
+			nodeType == "type_definition" ||
+			nodeType == "struct_specifier" ||
+			nodeType == "union_specifier" ||
+			nodeType == "enum_specifier" ||
+			nodeType == "class_specifier" ||
+			nodeType == "template_declaration"
+	case "ruby":
+		return nodeType == "method" ||
+			nodeType == "singleton_method" ||
+			nodeType == "class" ||
+			nodeType == "singleton_class" ||
+			nodeType == "module"
+	case "php":
+		// Namespaces are left out like C#'s.
+		return nodeType == "function_definition" ||
+			nodeType == "method_declaration" ||
+			nodeType == "class_declaration" ||
+			nodeType == "interface_declaration" ||
+			nodeType == "trait_declaration" ||
+			nodeType == "enum_declaration"
+	case "swift":
+		// class_declaration covers structs, enums and extensions too.
+		// property_declaration is left out as it's also a local let/var.
+		return nodeType == "function_declaration" ||
+			nodeType == "init_declaration" ||
+			nodeType == "deinit_declaration" ||
+			nodeType == "subscript_declaration" ||
+			nodeType == "class_declaration" ||
+			nodeType == "protocol_declaration"
+	case "zig":
+		// Zig types are anonymous containers named by the const they're
+		// assigned to, so only a variable_declaration of a container counts
+		// (see isTypeReference). A struct returned by a generic function
+		// resolves to the function.
+		return nodeType == "function_declaration" ||
+			nodeType == "test_declaration" ||
+			nodeType == "variable_declaration"
 	default:
 		// Fallback for substring matching for any language
 		nt := strings.ToLower(nodeType)
@@ -92,15 +172,20 @@ func detectLanguage(filePath string) *grammars.LangEntry {
 	return entry
 }
 
-// Declarations parses the file source and returns the enclosing definitions for the modified lines.
-func Declarations(filePath string, source []byte, modifiedLines []int) ([]string, error) {
-	if len(modifiedLines) == 0 {
-		return nil, nil
-	}
+// parsedFile is a source file parsed with the grammar of its language.
+type parsedFile struct {
+	entry  *grammars.LangEntry
+	lang   *gotreesitter.Language
+	root   *gotreesitter.Node
+	source []byte
+}
 
+// parse parses source with the grammar detected from filePath. It returns
+// nil for unsupported languages.
+func parse(filePath string, source []byte) (*parsedFile, error) {
 	entry := detectLanguage(filePath)
 	if entry == nil {
-		return nil, nil // Unsupported language, return nil (graceful skip)
+		return nil, nil
 	}
 	lang := entry.Language()
 	if lang == nil {
@@ -117,10 +202,61 @@ func Declarations(filePath string, source []byte, modifiedLines []int) ([]string
 	if root == nil {
 		return nil, nil
 	}
+	return &parsedFile{entry: entry, lang: lang, root: root, source: source}, nil
+}
 
-	sourceLines := strings.Split(string(source), "\n")
+// declaration is a structural node enclosing modified lines.
+type declaration struct {
+	// name is what other code refers to the declaration by, or "" when it
+	// has none, such as a Rust impl block or an HTML element.
+	name string
+	// local is set for declarations inside a function, such as a TS const,
+	// which nothing outside it refers to.
+	local bool
+	// kind is the node type, e.g. function_declaration.
+	kind string
+	text string
+	// start and end are the byte offsets of the declaration in its file.
+	start, end uint32
+	// line is the 1-based line the declaration starts on, and header the
+	// number of lines of its signature, up to where its body starts.
+	line, header int
+}
+
+// Declarations parses the file source and returns the enclosing definitions for the modified lines.
+func Declarations(filePath string, source []byte, modifiedLines []int) ([]string, error) {
+	decls, err := declarations(filePath, source, modifiedLines)
+	if err != nil {
+		return nil, err
+	}
 
 	var snippets []string
+	for _, decl := range decls {
+		snippets = append(snippets, decl.text)
+	}
+	return snippets, nil
+}
+
+// declarations returns the declarations enclosing the modified lines, once
+// each, in the order of the lines.
+func declarations(filePath string, source []byte, modifiedLines []int) ([]declaration, error) {
+	if len(modifiedLines) == 0 {
+		return nil, nil
+	}
+
+	file, err := parse(filePath, source)
+	if file == nil || err != nil {
+		return nil, err // Unsupported language, return nil (graceful skip)
+	}
+	return declarationsIn(file, modifiedLines), nil
+}
+
+// declarationsIn is declarations for a parsed file.
+func declarationsIn(file *parsedFile, modifiedLines []int) []declaration {
+	source := file.source
+	sourceLines := strings.Split(string(source), "\n")
+
+	var decls []declaration
 	seen := make(map[string]bool)
 
 	for _, line := range modifiedLines {
@@ -131,7 +267,7 @@ func Declarations(filePath string, source []byte, modifiedLines []int) ([]string
 		col := firstNonWhitespaceColumn(sourceLines, row)
 		startPoint := gotreesitter.Point{Row: row, Column: col}
 
-		node := root.DescendantForPointRange(startPoint, startPoint)
+		node := file.root.DescendantForPointRange(startPoint, startPoint)
 		if node == nil {
 			continue
 		}
@@ -140,8 +276,10 @@ func Declarations(filePath string, source []byte, modifiedLines []int) ([]string
 		curr := node
 		var structuralNode *gotreesitter.Node
 		for curr != nil {
-			if isStructuralNode(entry.Name, curr.Type(lang)) {
-				structuralNode = curr
+			// Keywords can share a structural node's type, like Ruby's
+			// `class` token and its class node.
+			if curr.IsNamed() && isStructuralNode(file.entry.Name, curr.Type(file.lang)) && !isReference(curr, file) {
+				structuralNode = wrapper(curr, file)
 				break
 			}
 			curr = curr.Parent()
@@ -156,13 +294,134 @@ func Declarations(filePath string, source []byte, modifiedLines []int) ([]string
 				snippet = strings.TrimSpace(snippet)
 				if snippet != "" && !seen[snippet] {
 					seen[snippet] = true
-					snippets = append(snippets, snippet)
+					decls = append(decls, declaration{
+						name:   declarationName(structuralNode, file),
+						local:  isLocal(structuralNode, file),
+						kind:   structuralNode.Type(file.lang),
+						text:   snippet,
+						start:  start,
+						end:    end,
+						line:   int(structuralNode.StartPoint().Row) + 1,
+						header: headerLines(structuralNode, file),
+					})
 				}
 			}
 		}
 	}
 
-	return snippets, nil
+	return decls
+}
+
+// wrappers are, by language, the nodes shown in place of the structural
+// node they directly wrap: a C typedef of a struct and a C++ template of a
+// function or class.
+var wrappers = map[string][]string{
+	"c":   {"type_definition"},
+	"cpp": {"type_definition", "template_declaration"},
+}
+
+// wrapper returns the node wrapping node, if it's one of its language's
+// wrappers, or node itself.
+func wrapper(node *gotreesitter.Node, file *parsedFile) *gotreesitter.Node {
+	if parent := node.Parent(); parent != nil && slices.Contains(wrappers[file.entry.Name], parent.Type(file.lang)) {
+		return parent
+	}
+	return node
+}
+
+// isReference reports whether node, of a structural type, only refers to
+// a type or holds a value rather than declaring a type: a C or C++
+// struct, union, enum or class specifier without a body, as in
+// `struct point p;`, or a Zig const or var of anything but a container.
+func isReference(node *gotreesitter.Node, file *parsedFile) bool {
+	t := node.Type(file.lang)
+	switch file.entry.Name {
+	case "c", "cpp":
+		return strings.HasSuffix(t, "_specifier") && node.ChildByFieldName("body", file.lang) == nil
+	case "zig":
+		if t != "variable_declaration" {
+			return false
+		}
+		for i := range node.NamedChildCount() {
+			switch node.NamedChild(i).Type(file.lang) {
+			case "struct_declaration", "enum_declaration", "union_declaration", "opaque_declaration":
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// headerLines returns the number of lines of node's signature: the lines
+// before its body, including the one the body opens on, such as the line
+// ending in `{` of a function or the `def ...:` line of a Python function.
+// Nodes without a body, such as a const, have a one-line header.
+func headerLines(node *gotreesitter.Node, file *parsedFile) int {
+	body := node.ChildByFieldName("body", file.lang)
+	if decl := node.ChildByFieldName("declaration", file.lang); body == nil && decl != nil {
+		body = decl.ChildByFieldName("body", file.lang)
+	}
+	if body == nil {
+		return 1
+	}
+
+	end := body.StartPoint().Row
+	if prev := body.PrevSibling(); prev != nil {
+		end = prev.EndPoint().Row
+	}
+	return int(end-node.StartPoint().Row) + 1
+}
+
+// isLocal reports whether node is declared inside a function or method.
+func isLocal(node *gotreesitter.Node, file *parsedFile) bool {
+	for parent := node.Parent(); parent != nil; parent = parent.Parent() {
+		t := parent.Type(file.lang)
+		if strings.Contains(t, "function") || strings.Contains(t, "method") {
+			return true
+		}
+	}
+	return false
+}
+
+// declarationName returns the name node declares: its name field, or the
+// name of the declaration it wraps, such as the type_spec of a Go
+// type_declaration, the variable_declarator of a TS const, the
+// declaration of an export_statement or the declarator of a C function.
+func declarationName(node *gotreesitter.Node, file *parsedFile) string {
+	if name := node.ChildByFieldName("name", file.lang); name != nil {
+		return name.Text(file.source)
+	}
+	if decl := node.ChildByFieldName("declaration", file.lang); decl != nil {
+		return declarationName(decl, file)
+	}
+	for i := range node.NamedChildCount() {
+		child := node.NamedChild(i)
+		if t := child.Type(file.lang); strings.HasSuffix(t, "_spec") || strings.HasSuffix(t, "declarator") {
+			return declarationName(child, file)
+		}
+	}
+	// A C++ template is named by the function or class it declares.
+	if node.Type(file.lang) == "template_declaration" && node.NamedChildCount() > 0 {
+		return declarationName(node.NamedChild(node.NamedChildCount()-1), file)
+	}
+	// A C function_declarator names the function in its declarator field.
+	if decl := node.ChildByFieldName("declarator", file.lang); decl != nil {
+		if decl.NamedChildCount() == 0 {
+			return decl.Text(file.source)
+		}
+		return declarationName(decl, file)
+	}
+	// Kotlin and Zig declarations have their name as an identifier child
+	// in no field.
+	if lang := file.entry.Name; lang == "kotlin" || lang == "zig" {
+		for i := range node.NamedChildCount() {
+			if child := node.NamedChild(i); strings.HasSuffix(child.Type(file.lang), "identifier") {
+				return child.Text(file.source)
+			}
+		}
+	}
+	return ""
 }
 
 // firstNonWhitespaceColumn returns the column index of the first non-whitespace character in the line.
@@ -183,7 +442,15 @@ func firstNonWhitespaceColumn(lines []string, row uint32) uint32 {
 // It handles git-quoted paths (`+++ "b/caf\303\251.go"`) and paths containing
 // spaces. Returns "" for `+++ /dev/null` (deleted file) or unparsable lines.
 func newFilePath(line string) string {
-	p := strings.TrimPrefix(line, "+++ ")
+	return diffFilePath(strings.TrimPrefix(line, "+++ "), "b/")
+}
+
+// oldFilePath is newFilePath for the old-side `--- ` header line.
+func oldFilePath(line string) string {
+	return diffFilePath(strings.TrimPrefix(line, "--- "), "a/")
+}
+
+func diffFilePath(p, prefix string) string {
 	if p == "/dev/null" {
 		return ""
 	}
@@ -194,15 +461,39 @@ func newFilePath(line string) string {
 		}
 		p = unquoted
 	}
-	return strings.TrimPrefix(p, "b/")
+	return strings.TrimPrefix(p, prefix)
 }
 
 // ModifiedLinesFromDiff parses a unified diff and returns a map of filename -> modified line numbers.
 func ModifiedLinesFromDiff(diffText string) map[string][]int {
+	modified, _ := linesFromDiff(diffText, false)
+	return modified
+}
+
+// baseLinesFromDiff is ModifiedLinesFromDiff for the old side of the diff:
+// it returns the modified line numbers in the old version of each file,
+// keyed by the new-side filename, and the old-side filename of each file,
+// which differs for renames.
+func baseLinesFromDiff(diffText string) (map[string][]int, map[string]string) {
+	return linesFromDiff(diffText, true)
+}
+
+// linesFromDiff returns the modified lines on the new side of a diff, or
+// on the old side when old is set, keyed by the new-side filename, and the
+// old-side filename of each file.
+func linesFromDiff(diffText string, old bool) (map[string][]int, map[string]string) {
 	result := make(map[string][]int)
+	oldPaths := make(map[string]string)
 	lines := strings.Split(diffText, "\n")
 
-	var currentFile string
+	// added and removed are the line prefixes of the side read and of the
+	// other side.
+	added, removed := "+", "-"
+	if old {
+		added, removed = "-", "+"
+	}
+
+	var currentFile, oldFile string
 	currentLine := -1
 	skipFile := false
 
@@ -214,6 +505,7 @@ func ModifiedLinesFromDiff(diffText string) map[string][]int {
 		// parse robustly.
 		if strings.HasPrefix(line, "diff --git ") {
 			currentFile = ""
+			oldFile = ""
 			skipFile = false
 			currentLine = -1
 			continue
@@ -230,23 +522,32 @@ func ModifiedLinesFromDiff(diffText string) map[string][]int {
 			continue
 		}
 
-		// The `+++ ` header names the new-side file. It only appears while
-		// currentLine == -1, so it can never be miscounted as hunk content.
+		// The `--- ` and `+++ ` headers name the old-side and new-side files.
+		// They only appear while currentLine == -1, so they can never be
+		// miscounted as hunk content.
+		if currentLine == -1 && strings.HasPrefix(line, "--- ") {
+			oldFile = oldFilePath(line)
+			continue
+		}
 		if currentLine == -1 && strings.HasPrefix(line, "+++ ") {
 			currentFile = newFilePath(line)
 			if currentFile == "" {
 				skipFile = true
 			}
+			oldPaths[currentFile] = cmp.Or(oldFile, currentFile)
 			continue
 		}
 
 		if strings.HasPrefix(line, "@@ ") {
 			// Parse hunk header: "@@ -12,5 +12,12 @@"
-			// We look for the "+newStart" part.
+			// We look for the "-oldStart" or "+newStart" part.
 			parts := strings.Split(line, " ")
 			if len(parts) >= 3 {
-				newPart := strings.TrimPrefix(parts[2], "+") // e.g. "12,12" or "12"
-				before, _, _ := strings.Cut(newPart, ",")
+				part := strings.TrimPrefix(parts[2], "+") // e.g. "12,12" or "12"
+				if old {
+					part = strings.TrimPrefix(parts[1], "-")
+				}
+				before, _, _ := strings.Cut(part, ",")
 				if startLine, err := strconv.Atoi(before); err == nil {
 					currentLine = startLine - 1 // We'll increment when processing lines
 				}
@@ -256,18 +557,18 @@ func ModifiedLinesFromDiff(diffText string) map[string][]int {
 
 		// If we are inside a file and a hunk
 		if currentFile != "" && currentLine >= 0 {
-			if strings.HasPrefix(line, "+") {
+			if strings.HasPrefix(line, added) {
 				currentLine++
 				result[currentFile] = append(result[currentFile], currentLine)
 			} else if strings.HasPrefix(line, " ") {
 				currentLine++
-			} else if strings.HasPrefix(line, "-") {
-				// Deletions have no new-side line, so record the line right
-				// after the removed block as contextually modified. Past-EOF
-				// entries (trailing deletions) are harmless: tree-sitter
-				// returns nil for out-of-range points and Declarations skips
-				// them. Consecutive deletions all map to the same line;
-				// record it once.
+			} else if strings.HasPrefix(line, removed) {
+				// Lines only on the other side have no line on this one, so
+				// record the line right after them as contextually modified.
+				// Past-EOF entries (trailing deletions) are harmless:
+				// tree-sitter returns nil for out-of-range points and
+				// Declarations skips them. Consecutive lines all map to the
+				// same line; record it once.
 				if last := result[currentFile]; len(last) == 0 || last[len(last)-1] != currentLine+1 {
 					result[currentFile] = append(result[currentFile], currentLine+1)
 				}
@@ -275,16 +576,25 @@ func ModifiedLinesFromDiff(diffText string) map[string][]int {
 		}
 	}
 
-	return result
+	return result, oldPaths
 }
 
 // DeclarationsForDiff parses a diff, reads the files at the specified ref,
-// and extracts the enclosing declarations for all modified lines.
-func DeclarationsForDiff(ctx context.Context, diffText string, ref string) (string, error) {
+// and extracts the enclosing declarations for all modified lines. They are
+// followed by the definitions of what the modified lines call or use as
+// types, and by the usages of the changed functions, methods and types
+// elsewhere in the repository.
+//
+// The declarations are fitted in about budget estimated tokens, or all
+// shown in full when budget is 0: the ones covering the fewest changed
+// lines are elided to their signature, the lines around the changes and
+// their closing line first, and left out if that isn't enough.
+func DeclarationsForDiff(ctx context.Context, diffText string, ref, baseRef string, budget int) (string, error) {
 	modifiedMap := ModifiedLinesFromDiff(diffText)
 	if len(modifiedMap) == 0 {
 		return "", nil
 	}
+	baseMap, basePaths := baseLinesFromDiff(diffText)
 
 	// Iterate in sorted order so the generated prompt section is deterministic.
 	files := make([]string, 0, len(modifiedMap))
@@ -294,6 +604,18 @@ func DeclarationsForDiff(ctx context.Context, diffText string, ref string) (stri
 	sort.Strings(files)
 
 	var sb strings.Builder
+	var snippets []*snippet
+	var symbols []*symbol
+
+	// The names referenced by each file's changed lines, looked up once the
+	// declarations shown are known.
+	type lookup struct {
+		file string
+		refs []reference
+	}
+	var lookups []lookup
+	shown := make(map[string][]declaration)
+	looked := make(map[string]bool)
 
 	for _, file := range files {
 		// Get file content at ref
@@ -303,28 +625,130 @@ func DeclarationsForDiff(ctx context.Context, diffText string, ref string) (stri
 			continue
 		}
 
-		snippets, err := Declarations(file, content, modifiedMap[file])
-		if err != nil || len(snippets) == 0 {
+		parsed, err := parse(file, content)
+		if parsed == nil || err != nil {
 			continue
 		}
 
-		if sb.Len() == 0 {
-			sb.WriteString("\n## Enclosing Code Context\n")
-			sb.WriteString("_Read-only reference showing how the changed code is defined and used. This is NOT under review — only the diff below is. Use it to judge correctness in context (signatures, types, call sites, surrounding control flow) and to avoid false positives about seemingly undefined or unused symbols._\n")
+		var refs []reference
+		for _, r := range references(parsed, modifiedMap[file]) {
+			if key := languageFamily(file) + ":" + r.name; !looked[key] {
+				looked[key] = true
+				refs = append(refs, r)
+			}
+		}
+		if len(refs) > 0 {
+			lookups = append(lookups, lookup{file: file, refs: refs})
+		}
+
+		decls := declarationsIn(parsed, modifiedMap[file])
+		if len(decls) == 0 {
+			continue
+		}
+
+		var before []declaration
+		if baseRef != "" {
+			before = baseDeclarations(ctx, baseRef, basePaths[file], baseMap[file])
+		}
+
+		for _, decl := range decls {
+			snippets = append(snippets, newSnippet(file, decl, pairDeclaration(decl, before), modifiedMap[file], baseMap[file]))
+
+			if decl.name != "" && !decl.local && len(symbols) < maxUsageSymbols {
+				symbols = append(symbols, &symbol{name: decl.name, file: file, family: languageFamily(file), decl: decl})
+			}
 		}
+	}
 
-		fmt.Fprintf(&sb, "\n### File: %s\n", file)
-		langClass := "text"
-		if entry := grammars.DetectLanguage(file); entry != nil {
-			langClass = entry.Name
-		} else if ext := strings.TrimPrefix(filepath.Ext(file), "."); ext != "" {
-			langClass = ext
+	if len(snippets) > 0 {
+		sb.WriteString("\n## Enclosing Code Context\n")
+		sb.WriteString("_Read-only reference showing how the changed code is defined and used. This is NOT under review — only the diff below is. Use it to judge correctness in context (signatures, types, call sites, surrounding control flow) and to avoid false positives about seemingly undefined or unused symbols. Declarations the diff modifies are shown before and after the change, to reason about the whole change in behaviour._\n")
+	}
+
+	// Files stay in sorted order, and each file's snippets in the order of
+	// the changed lines they cover.
+	kept, omitted := fitBudget(snippets, budget)
+	for _, file := range files {
+		for _, s := range kept {
+			if s.file != file {
+				continue
+			}
+			if len(shown[file]) == 0 {
+				fmt.Fprintf(&sb, "\n### File: %s\n", file)
+			}
+			shown[file] = append(shown[file], s.decl)
+			sb.WriteString(s.render())
 		}
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&sb, "\n_%d more enclosing declarations were left out to fit the context budget._\n", omitted)
+	}
 
-		for _, snippet := range snippets {
-			fmt.Fprintf(&sb, "```%s\n%s\n```\n", langClass, snippet)
+	var defs []definition
+	for _, l := range lookups {
+		if len(defs) >= maxDefinitions {
+			break
 		}
+		defs = append(defs, findDefinitions(ctx, ref, languageFamily(l.file), l.file, l.refs, shown)...)
+	}
+	sb.WriteString(formatDefinitions(defs[:min(len(defs), maxDefinitions)]))
+
+	if len(symbols) > 0 {
+		findUsages(ctx, ref, symbols)
+		sb.WriteString(formatUsages(ctx, ref, symbols))
 	}
 
 	return sb.String(), nil
 }
+
+// baseDeclarations returns the declarations enclosing the modified lines
+// of the old version of a file, read at baseRef.
+func baseDeclarations(ctx context.Context, baseRef, file string, modifiedLines []int) []declaration {
+	content, err := git.GetFileContent(ctx, baseRef, file)
+	if err != nil {
+		return nil
+	}
+	parsed, err := parse(file, content)
+	if parsed == nil || err != nil {
+		return nil
+	}
+	return declarationsIn(parsed, modifiedLines)
+}
+
+// pairDeclaration returns the old version of decl among before: the
+// declaration of the same name and kind, preferring one with the same
+// first line, such as the method of the same receiver. It returns nil when
+// there is none or it is unchanged. Declarations without a name, such as
+// HTML elements, aren't paired.
+func pairDeclaration(decl declaration, before []declaration) *declaration {
+	if decl.name == "" {
+		return nil
+	}
+
+	var match *declaration
+	firstLine, _, _ := strings.Cut(decl.text, "\n")
+	for i, old := range before {
+		if old.name != decl.name || old.kind != decl.kind {
+			continue
+		}
+		if match == nil || strings.HasPrefix(old.text, firstLine+"\n") {
+			match = &before[i]
+		}
+	}
+
+	if match == nil || match.text == decl.text {
+		return nil
+	}
+	return match
+}
+
+// langClass returns the language of a fenced code block for filePath.
+func langClass(filePath string) string {
+	if entry := grammars.DetectLanguage(filePath); entry != nil {
+		return entry.Name
+	}
+	if ext := strings.TrimPrefix(filepath.Ext(filePath), "."); ext != "" {
+		return ext
+	}
+	return "text"
+}
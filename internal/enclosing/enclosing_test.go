@@ -1,6 +1,8 @@
 package enclosing
 
 import (
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -373,3 +375,318 @@ func TestDeclarations_Tsx(t *testing.T) {
 
 	assert.Equal(t, expected, snippets)
 }
+
+func TestDeclarations_Java(t *testing.T) {
+	source := []byte(`package demo;
+
+public class Greeter {
+    private final String name;
+
+    public String greet() {
+        String greeting = "Hello, ";
+        return greeting + name;
+    }
+}
+`)
+
+	// Line 7: a local variable -> belongs to greet(), not the class.
+	// Line 4: a field -> belongs to the class.
+	snippets, err := Declarations("Greeter.java", source, []int{7, 4})
+	require.NoError(t, err)
+
+	expected := []string{
+		"public String greet() {\n        String greeting = \"Hello, \";\n        return greeting + name;\n    }",
+		"public class Greeter {\n    private final String name;\n\n    public String greet() {\n        String greeting = \"Hello, \";\n        return greeting + name;\n    }\n}",
+	}
+
+	assert.Equal(t, expected, snippets)
+}
+
+func TestDeclarations_Kotlin(t *testing.T) {
+	source := []byte(`class Greeter(private val name: String) {
+    companion object {
+        fun create() = Greeter("x")
+    }
+
+    fun greet(): String {
+        val greeting = "Hello, "
+        return greeting + name
+    }
+}
+`)
+
+	// Line 7: a local val -> belongs to greet().
+	// Line 3: a function inside a companion object -> belongs to create().
+	decls, err := declarations("Greeter.kt", source, []int{7, 3})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "fun greet(): String {\n        val greeting = \"Hello, \"\n        return greeting + name\n    }", decls[0].text)
+	assert.Equal(t, "greet", decls[0].name)
+	assert.Equal(t, "fun create() = Greeter(\"x\")", decls[1].text)
+}
+
+func TestDeclarations_CSharp(t *testing.T) {
+	source := []byte(`namespace Demo
+{
+    public class Greeter
+    {
+        private string name;
+
+        public string Greet()
+        {
+            return "Hello, " + name;
+        }
+    }
+}
+`)
+
+	// Line 9: inside a method of a class in a namespace -> belongs to Greet().
+	// Line 5: a field -> belongs to the class, not the whole namespace.
+	decls, err := declarations("Greeter.cs", source, []int{9, 5})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "public string Greet()\n        {\n            return \"Hello, \" + name;\n        }", decls[0].text)
+	assert.Equal(t, "method_declaration", decls[0].kind)
+	assert.Equal(t, "Greeter", decls[1].name)
+	assert.Equal(t, "class_declaration", decls[1].kind)
+}
+
+func TestDeclarations_C(t *testing.T) {
+	source := []byte(`struct point {
+    int x;
+};
+
+typedef struct {
+    int r;
+} color;
+
+static int norm(struct point p) {
+    struct point q = p;
+    return q.x;
+}
+`)
+
+	// Line 10: a struct used as a type -> belongs to norm(), not the struct.
+	// Line 6: a field of a typedef'd struct -> belongs to the whole typedef.
+	decls, err := declarations("point.c", source, []int{10, 6})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "static int norm(struct point p) {\n    struct point q = p;\n    return q.x;\n}", decls[0].text)
+	assert.Equal(t, "norm", decls[0].name)
+	assert.Equal(t, "typedef struct {\n    int r;\n} color;", decls[1].text)
+	assert.Equal(t, "color", decls[1].name)
+}
+
+func TestDeclarations_Cpp(t *testing.T) {
+	source := []byte(`namespace demo {
+
+class Greeter {
+public:
+    std::string greet() const {
+        return "Hello, " + name_;
+    }
+
+private:
+    std::string name_;
+};
+
+template <typename T>
+T max(T a, T b) {
+    return a > b ? a : b;
+}
+
+}  // namespace demo
+`)
+
+	// Line 6: a method of a class in a namespace -> belongs to greet().
+	// Line 10: a field -> belongs to the class, not the namespace.
+	// Line 15: a function template -> belongs to the template, with its
+	// template parameters.
+	decls, err := declarations("greeter.cpp", source, []int{6, 10, 15})
+	require.NoError(t, err)
+	require.Len(t, decls, 3)
+
+	assert.Equal(t, "std::string greet() const {\n        return \"Hello, \" + name_;\n    }", decls[0].text)
+	assert.Equal(t, "greet", decls[0].name)
+	assert.Equal(t, "class_specifier", decls[1].kind)
+	assert.Equal(t, "Greeter", decls[1].name)
+	assert.Equal(t, "template <typename T>\nT max(T a, T b) {\n    return a > b ? a : b;\n}", decls[2].text)
+	assert.Equal(t, "max", decls[2].name)
+}
+
+func TestDeclarations_Ruby(t *testing.T) {
+	source := []byte(`module Demo
+  class Greeter
+    def greet
+      "Hello, #{@name}"
+    end
+  end
+end
+`)
+
+	// Line 4: inside a method of a class in a module -> belongs to greet.
+	// Line 2: the class line itself -> belongs to the class, not its
+	// class keyword.
+	decls, err := declarations("greeter.rb", source, []int{4, 2})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "def greet\n      \"Hello, #{@name}\"\n    end", decls[0].text)
+	assert.Equal(t, "class", decls[1].kind)
+	assert.Equal(t, "Greeter", decls[1].name)
+}
+
+func TestDeclarations_PHP(t *testing.T) {
+	source := []byte(`<?php
+
+namespace Demo;
+
+class Greeter
+{
+    private string $name;
+
+    public function greet(): string
+    {
+        return "Hello, " . $this->name;
+    }
+}
+`)
+
+	// Line 11: inside a method -> belongs to greet().
+	// Line 7: a property -> belongs to the class.
+	decls, err := declarations("Greeter.php", source, []int{11, 7})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "public function greet(): string\n    {\n        return \"Hello, \" . $this->name;\n    }", decls[0].text)
+	assert.Equal(t, "class_declaration", decls[1].kind)
+	assert.Equal(t, "Greeter", decls[1].name)
+}
+
+func TestDeclarations_Swift(t *testing.T) {
+	source := []byte(`struct Point {
+    var x: Int
+}
+
+extension Point {
+    func doubled() -> Point {
+        let x = self.x * 2
+        return Point(x: x)
+    }
+}
+`)
+
+	// Line 7: a local let inside a method of an extension -> belongs to
+	// doubled().
+	// Line 2: a property -> belongs to the struct.
+	decls, err := declarations("Point.swift", source, []int{7, 2})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "func doubled() -> Point {\n        let x = self.x * 2\n        return Point(x: x)\n    }", decls[0].text)
+	assert.Equal(t, "struct Point {\n    var x: Int\n}", decls[1].text)
+	assert.Equal(t, "Point", decls[1].name)
+}
+
+func TestDeclarations_Zig(t *testing.T) {
+	source := []byte(`const std = @import("std");
+
+const Point = struct {
+    x: i32,
+
+    pub fn doubled(self: Point) Point {
+        const x = self.x * 2;
+        return .{ .x = x };
+    }
+};
+`)
+
+	// Line 7: a local const inside a method -> belongs to doubled().
+	// Line 4: a field -> belongs to the const naming the struct.
+	// Line 1: a const holding a value isn't a declaration.
+	decls, err := declarations("point.zig", source, []int{7, 4, 1})
+	require.NoError(t, err)
+	require.Len(t, decls, 2)
+
+	assert.Equal(t, "pub fn doubled(self: Point) Point {\n        const x = self.x * 2;\n        return .{ .x = x };\n    }", decls[0].text)
+	assert.True(t, strings.HasPrefix(decls[1].text, "const Point = struct {\n"), decls[1].text)
+	assert.Equal(t, "Point", decls[1].name)
+}
+
+func TestBaseLinesFromDiff(t *testing.T) {
+	diffText := `diff --git a/main.go b/main.go
+index 1234567..89abcdf 100644
+--- a/main.go
++++ b/main.go
+@@ -5,5 +5,5 @@
+ func main() {
+-	a()
+-	b()
++	c()
+ 	d()
++	e()
+ }
+diff --git a/old.go b/renamed.go
+similarity index 90%
+rename from old.go
+rename to renamed.go
+index 1234567..89abcdf 100644
+--- a/old.go
++++ b/renamed.go
+@@ -1,3 +1,3 @@
+ package main
+-var a = 1
++var a = 2
+`
+
+	// Removed lines are numbered on the old side; added lines map to the
+	// old line after them, once.
+	lines, paths := baseLinesFromDiff(diffText)
+	assert.Equal(t, map[string][]int{
+		"main.go":    {6, 7, 8, 9},
+		"renamed.go": {2, 3},
+	}, lines)
+	assert.Equal(t, map[string]string{"main.go": "main.go", "renamed.go": "old.go"}, paths)
+}
+
+func TestPairDeclaration(t *testing.T) {
+	before := []declaration{
+		{name: "String", kind: "method_declaration", text: "func (a A) String() string {\n\treturn \"a\"\n}"},
+		{name: "String", kind: "method_declaration", text: "func (b B) String() string {\n\treturn \"b\"\n}"},
+		{name: "main", kind: "function_declaration", text: "func main() {\n}"},
+	}
+
+	after := declaration{name: "String", kind: "method_declaration", text: "func (b B) String() string {\n\treturn \"B\"\n}"}
+	assert.Equal(t, &before[1], pairDeclaration(after, before), "the method of the same receiver")
+
+	assert.Nil(t, pairDeclaration(before[2], before), "unchanged")
+	assert.Nil(t, pairDeclaration(declaration{kind: "element", text: "<p></p>"}, before), "unnamed")
+}
+
+func TestDeclarationsForDiff_BeforeAfter(t *testing.T) {
+	before := "package stats\n\n// Mean returns the mean of values.\nfunc Mean(values []float64) float64 {\n\tvar sum float64\n\tfor _, v := range values {\n\t\tsum += v\n\t}\n\treturn sum / float64(len(values))\n}\n\nfunc Max(values []float64) float64 {\n\treturn 0\n}\n"
+	after := strings.NewReplacer(
+		"\tvar sum float64\n", "\tif len(values) == 0 {\n\t\treturn 0\n\t}\n\tvar sum float64\n",
+		"\treturn sum / float64(len(values))", "\treturn sum / float64(len(values)) // never NaN",
+		"func Max(values []float64) float64 {\n\treturn 0\n}\n", "func Max(values []float64) float64 {\n\treturn 0\n}\n\nfunc Min() {}\n",
+	).Replace(before)
+	diff := commitFiles(t, map[string]string{"stats.go": before}, map[string]string{"stats.go": after})
+
+	out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "HEAD~1", 0)
+	require.NoError(t, err)
+
+	// Both hunks of Mean resolve to one before/after pair.
+	assert.Contains(t, out, "### File: stats.go\n"+
+		"_Before:_\n```go\nfunc Mean(values []float64) float64 {\n\tvar sum float64\n\tfor _, v := range values {\n\t\tsum += v\n\t}\n\treturn sum / float64(len(values))\n}\n```\n"+
+		"_After:_\n```go\nfunc Mean(values []float64) float64 {\n\tif len(values) == 0 {\n\t\treturn 0\n\t}\n\tvar sum float64\n\tfor _, v := range values {\n\t\tsum += v\n\t}\n\treturn sum / float64(len(values)) // never NaN\n}\n```\n")
+	assert.Equal(t, 1, strings.Count(out, "_Before:_"), "the added Min has no old version")
+	assert.Contains(t, out, "```go\nfunc Min() {}\n```")
+
+	// Without a base ref, only the new side is shown.
+	out, err = DeclarationsForDiff(context.Background(), diff, "HEAD", "", 0)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "_Before:_")
+}
@@ -0,0 +1,253 @@
+package enclosing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/git"
+	"github.com/odvcencio/gotreesitter"
+)
+
+const (
+	// maxUsages bounds the call sites shown for each changed declaration.
+	maxUsages = 5
+	// maxUsageSymbols bounds the changed declarations whose usages are
+	// looked up, so a large diff doesn't search the repository for each.
+	maxUsageSymbols = 10
+	// usageContextLines is the number of lines shown around a call site.
+	usageContextLines = 2
+	// maxUsageFileSize skips generated and vendored files too large to
+	// parse quickly.
+	maxUsageFileSize = 512 << 10
+)
+
+// symbol is a changed declaration whose usages are looked up.
+type symbol struct {
+	name string
+	file string
+	// family is the language family of file; usages are only looked up in
+	// files of the same family.
+	family string
+	decl   declaration
+	usages []usage
+	// more is set when there are usages beyond maxUsages.
+	more bool
+}
+
+// usage is a line referring to a symbol.
+type usage struct {
+	file string
+	line int
+}
+
+func (s *symbol) full() bool {
+	return len(s.usages) == maxUsages && s.more
+}
+
+// languageFamily groups the grammars whose files can refer to each other's
+// declarations.
+func languageFamily(filePath string) string {
+	entry := detectLanguage(filePath)
+	if entry == nil {
+		return ""
+	}
+	switch entry.Name {
+	case "typescript", "tsx", "javascript":
+		return "ecmascript"
+	default:
+		return entry.Name
+	}
+}
+
+// findUsages fills in the usages of symbols in the files at ref. Candidate
+// files are found with git grep, then parsed so that only identifiers count:
+// mentions in comments and strings are ignored, and so are declarations of
+// the same name.
+func findUsages(ctx context.Context, ref string, symbols []*symbol) {
+	byName := make(map[string][]*symbol)
+	for _, s := range symbols {
+		byName[s.name] = append(byName[s.name], s)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files, err := git.GrepFiles(ctx, ref, names)
+	if err != nil {
+		return
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		if ctx.Err() != nil || allFull(symbols) {
+			return
+		}
+
+		family := languageFamily(path)
+		if !hasFamily(symbols, family) {
+			continue
+		}
+
+		content, err := git.GetFileContent(ctx, ref, path)
+		if err != nil || len(content) > maxUsageFileSize {
+			continue
+		}
+		file, err := parse(path, content)
+		if file == nil || err != nil {
+			continue
+		}
+
+		eachIdentifier(file, func(node *gotreesitter.Node, name string) {
+			for _, s := range byName[name] {
+				if s.family != family || s.full() {
+					continue
+				}
+				// The declaration's own body, recursive calls included.
+				if path == s.file && node.StartByte() >= s.decl.start && node.EndByte() <= s.decl.end {
+					continue
+				}
+				if isDeclaredName(node, file) {
+					continue
+				}
+
+				line := int(node.StartPoint().Row) + 1
+				if n := len(s.usages); n > 0 && s.usages[n-1] == (usage{file: path, line: line}) {
+					continue
+				}
+				if len(s.usages) == maxUsages {
+					s.more = true
+					continue
+				}
+				s.usages = append(s.usages, usage{file: path, line: line})
+			}
+		})
+	}
+}
+
+func allFull(symbols []*symbol) bool {
+	for _, s := range symbols {
+		if !s.full() {
+			return false
+		}
+	}
+	return true
+}
+
+func hasFamily(symbols []*symbol, family string) bool {
+	if family == "" {
+		return false
+	}
+	for _, s := range symbols {
+		if s.family == family {
+			return true
+		}
+	}
+	return false
+}
+
+// eachIdentifier calls fn with every identifier in file, in source order.
+// Grammars name them identifier, field_identifier, type_identifier,
+// property_identifier and so on.
+func eachIdentifier(file *parsedFile, fn func(node *gotreesitter.Node, name string)) {
+	stack := []*gotreesitter.Node{file.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if count := node.ChildCount(); count > 0 {
+			for i := count - 1; i >= 0; i-- {
+				stack = append(stack, node.Child(i))
+			}
+			continue
+		}
+		if node.IsNamed() && strings.Contains(node.Type(file.lang), "identifier") {
+			fn(node, node.Text(file.source))
+		}
+	}
+}
+
+// isDeclaredName reports whether node is the name of a declaration, such as
+// another type's method of the same name, rather than a reference.
+func isDeclaredName(node *gotreesitter.Node, file *parsedFile) bool {
+	parent := node.Parent()
+	if parent == nil {
+		return false
+	}
+	name := parent.ChildByFieldName("name", file.lang)
+	if name == nil || name.StartByte() != node.StartByte() {
+		return false
+	}
+	t := parent.Type(file.lang)
+	return isStructuralNode(file.entry.Name, t) || strings.HasSuffix(t, "_spec")
+}
+
+// formatUsages renders the usages of symbols as the "Usages" prompt
+// section, with the lines around each call site. Nearby call sites in a
+// file share one snippet.
+func formatUsages(ctx context.Context, ref string, symbols []*symbol) string {
+	var sb strings.Builder
+	contents := make(map[string][]string)
+
+	for _, s := range symbols {
+		if len(s.usages) == 0 {
+			continue
+		}
+
+		if sb.Len() == 0 {
+			sb.WriteString("\n## Usages\n")
+			fmt.Fprintf(&sb, "_Read-only reference showing where the functions, methods and types changed in the diff are used, at most %d call sites each. This is NOT under review. Use it to check that callers still match a changed signature or contract._\n", maxUsages)
+		}
+		fmt.Fprintf(&sb, "\n### `%s` (%s)\n", s.name, s.file)
+
+		for _, w := range usageWindows(s.usages) {
+			lines, ok := contents[w.file]
+			if !ok {
+				content, err := git.GetFileContent(ctx, ref, w.file)
+				if err == nil {
+					lines = strings.Split(string(content), "\n")
+				}
+				contents[w.file] = lines
+			}
+			if w.start > len(lines) {
+				continue
+			}
+
+			fmt.Fprintf(&sb, "`%s:%d`\n", w.file, w.line)
+			snippet := strings.Join(lines[w.start-1:min(w.end, len(lines))], "\n")
+			fmt.Fprintf(&sb, "```%s\n%s\n```\n", langClass(w.file), strings.TrimRight(snippet, "\n"))
+		}
+
+		if s.more {
+			sb.WriteString("_More usages are not shown._\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// usageWindow is a range of lines shown around one or more call sites.
+type usageWindow struct {
+	file string
+	// line is the first call site in the window.
+	line       int
+	start, end int
+}
+
+// usageWindows returns the lines to show around usages, merging the
+// windows that overlap in a file.
+func usageWindows(usages []usage) []usageWindow {
+	var windows []usageWindow
+	for _, u := range usages {
+		start, end := max(u.line-usageContextLines, 1), u.line+usageContextLines
+		if n := len(windows); n > 0 && windows[n-1].file == u.file && start <= windows[n-1].end+1 {
+			windows[n-1].end = max(windows[n-1].end, end)
+			continue
+		}
+		windows = append(windows, usageWindow{file: u.file, line: u.line, start: start, end: end})
+	}
+	return windows
+}
@@ -0,0 +1,123 @@
+package enclosing
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitFiles commits files to a new repository in the working directory
+// and returns the diff of the commit.
+func commitFiles(t *testing.T, before, after map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return string(out)
+	}
+	write := func(files map[string]string) {
+		for path, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0o644))
+		}
+		git("add", "-A")
+	}
+
+	git("init", "-q")
+	write(before)
+	git("commit", "-q", "-m", "before")
+	write(after)
+	git("commit", "-q", "-m", "after")
+
+	return git("diff", "HEAD~1", "HEAD")
+}
+
+func TestDeclarationsForDiff_Usages(t *testing.T) {
+	stats := `package stats
+
+// Sum returns the sum of values.
+func Sum(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// Mean returns the arithmetic mean of values.
+func Mean(values []float64) float64 {
+	return Sum(values) / float64(len(values))
+}
+`
+	report := `package report
+
+import "example.com/stats"
+
+// Total prints the Sum of the values.
+func Total(values []float64) string {
+	return fmt.Sprint("Sum", stats.Sum(values))
+}
+`
+	// Sum changes; Mean and report.go call it.
+	diff := commitFiles(t,
+		map[string]string{"stats.go": stats, "report/report.go": report},
+		map[string]string{"stats.go": `package stats
+
+// Sum returns the sum of values.
+func Sum(values []float64) float64 {
+	var total float64
+	for i := range values {
+		total += values[i]
+	}
+	return total
+}
+
+// Mean returns the arithmetic mean of values.
+func Mean(values []float64) float64 {
+	return Sum(values) / float64(len(values))
+}
+`},
+	)
+
+	out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "HEAD~1", 0)
+	require.NoError(t, err)
+
+	assert.Contains(t, out, "## Enclosing Code Context")
+	assert.Contains(t, out, "## Usages")
+	assert.Contains(t, out, "### `Sum` (stats.go)")
+	// The call in report.go, whose comment and string mentions are ignored,
+	// and the call in Mean, but not Sum's own declaration.
+	assert.Contains(t, out, "`report/report.go:7`\n```go\n// Total prints the Sum of the values.\nfunc Total(values []float64) string {\n\treturn fmt.Sprint(\"Sum\", stats.Sum(values))\n}\n```")
+	assert.Contains(t, out, "`stats.go:14`\n```go\n// Mean returns the arithmetic mean of values.\nfunc Mean(values []float64) float64 {\n\treturn Sum(values) / float64(len(values))\n}\n```")
+	assert.NotContains(t, out, "`stats.go:4`")
+	assert.NotContains(t, out, "`report/report.go:5`")
+	assert.NotContains(t, out, "More usages")
+}
+
+func TestFindUsages_Bounded(t *testing.T) {
+	calls := "package main\n\nfunc run() {\n"
+	for range maxUsages + 2 {
+		calls += "\tlog()\n\n\n\n\n\n"
+	}
+	calls += "}\n"
+	before := map[string]string{"log.go": "package main\n\nfunc log() {}\n", "main.go": calls}
+
+	diff := commitFiles(t, before, map[string]string{"log.go": "package main\n\nfunc log() { println() }\n"})
+
+	out, err := DeclarationsForDiff(context.Background(), diff, "HEAD", "HEAD~1", 0)
+	require.NoError(t, err)
+	assert.Contains(t, out, "### `log` (log.go)")
+	assert.Contains(t, out, "`main.go:28`")
+	assert.NotContains(t, out, "`main.go:34`")
+	assert.Contains(t, out, "_More usages are not shown._")
+}
@@ -0,0 +1,437 @@
+package findings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
+)
+
+// Severity ranks how serious a finding is.
+type Severity string
+
+const (
+	SeverityHigh   Severity = "high"
+	SeverityMedium Severity = "medium"
+	SeverityLow    Severity = "low"
+	SeverityInfo   Severity = "info"
+)
+
+// Severities lists every severity from most to least serious.
+var Severities = []Severity{SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo}
+
+// Rank orders severities so that a more serious severity ranks higher.
+// Unknown severities rank below info.
+func (s Severity) Rank() int {
+	switch s {
+	case SeverityHigh:
+		return 4
+	case SeverityMedium:
+		return 3
+	case SeverityLow:
+		return 2
+	case SeverityInfo:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ParseSeverity resolves a case-insensitive severity name.
+func ParseSeverity(s string) (Severity, error) {
+	severity := Severity(strings.ToLower(strings.TrimSpace(s)))
+	for _, known := range Severities {
+		if severity == known {
+			return severity, nil
+		}
+	}
+	return "", fmt.Errorf("unknown severity %q (supported: high, medium, low, info)", s)
+}
+
+// Finding is a single issue raised by a review. Line numbers refer to the
+// new side of the diff; a zero StartLine means the finding is not anchored to
+// a specific line.
+type Finding struct {
+	File      string   `json:"file"`
+	StartLine int      `json:"start_line"`
+	EndLine   int      `json:"end_line"`
+	Severity  Severity `json:"severity"`
+	Category  string   `json:"category"`
+	Message   string   `json:"message"`
+	// Suggestion is an optional unified diff fixing the issue.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// Report is the structured outcome of a review.
+type Report struct {
+	Summary  string    `json:"summary"`
+	Findings []Finding `json:"findings"`
+	// Structured is true when the findings came from the provider's
+	// structured-output mode rather than the markdown fallback parser.
+	Structured bool       `json:"-"`
+	Usage      *llm.Usage `json:"-"`
+}
+
+// Schema returns the JSON schema providers use to produce a Report. Every
+// property is required so the schema is valid in OpenAI's strict mode;
+// optional values are represented by empty strings and zero line numbers.
+func Schema() llm.Schema {
+	finding := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"file":       map[string]any{"type": "string", "description": "Repository-relative path of the file the finding applies to, or an empty string for general remarks."},
+			"start_line": map[string]any{"type": "integer", "description": "First affected line in the new version of the file, or 0 when not tied to a line."},
+			"end_line":   map[string]any{"type": "integer", "description": "Last affected line in the new version of the file, or 0 when not tied to a line."},
+			"severity":   map[string]any{"type": "string", "enum": []string{"high", "medium", "low", "info"}},
+			"category":   map[string]any{"type": "string", "description": "Short lowercase category such as correctness, security, performance, maintainability or style."},
+			"message":    map[string]any{"type": "string", "description": "Explanation of the problem in markdown."},
+			"suggestion": map[string]any{"type": "string", "description": "Optional unified diff fixing the problem, or an empty string."},
+		},
+		"required":             []string{"file", "start_line", "end_line", "severity", "category", "message", "suggestion"},
+		"additionalProperties": false,
+	}
+
+	return llm.Schema{
+		Name:        "review_findings",
+		Description: "Report the outcome of a code review as a summary and a list of findings.",
+		Definition: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"summary":  map[string]any{"type": "string", "description": "Overall assessment of the change in markdown."},
+				"findings": map[string]any{"type": "array", "items": finding},
+			},
+			"required":             []string{"summary", "findings"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// Parse decodes a structured response into a Report.
+func Parse(content string) (Report, error) {
+	var report Report
+	if err := json.Unmarshal([]byte(stripJSONFence(content)), &report); err != nil {
+		return Report{}, fmt.Errorf("failed to parse findings: %w", err)
+	}
+
+	for i := range report.Findings {
+		normalise(&report.Findings[i])
+	}
+	report.Structured = true
+
+	return report, nil
+}
+
+// stripJSONFence removes a ```json fence some models wrap around their output
+// even in structured mode.
+func stripJSONFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	return strings.TrimSpace(content)
+}
+
+func normalise(f *Finding) {
+	if severity, err := ParseSeverity(string(f.Severity)); err == nil {
+		f.Severity = severity
+	} else {
+		f.Severity = SeverityInfo
+	}
+	f.Category = strings.ToLower(strings.TrimSpace(f.Category))
+	if f.Category == "" {
+		f.Category = "general"
+	}
+	if f.StartLine < 0 {
+		f.StartLine = 0
+	}
+	if f.EndLine < f.StartLine {
+		f.EndLine = f.StartLine
+	}
+	f.Message = strings.TrimSpace(f.Message)
+	f.Suggestion = strings.TrimSpace(f.Suggestion)
+}
+
+var (
+	// findingHeadingRe matches the heading format.md asks reviewers to use:
+	// "### [high] correctness: path/to/file.go:12-14".
+	findingHeadingRe = regexp.MustCompile(`(?i)^#{1,6}\s*\[(high|medium|low|info)\]\s*(.*)$`)
+	// locationRe matches a trailing "path:12" or "path:12-14" reference,
+	// optionally wrapped in backticks.
+	locationRe = regexp.MustCompile("`?([^\\s`]+):(\\d+)(?:-(\\d+))?`?\\s*$")
+	headingRe  = regexp.MustCompile(`^#{1,6}\s`)
+)
+
+// ParseMarkdown extracts findings from a markdown review that follows the
+// heading convention in the review formatting requirements. Text outside the
+// findings becomes the summary; the first diff block inside a finding becomes
+// its suggestion.
+func ParseMarkdown(markdown string) Report {
+	var (
+		report  Report
+		summary []string
+		current *Finding
+		body    []string
+		inFence bool
+		inDiff  bool
+		diff    []string
+	)
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Message = strings.TrimSpace(strings.Join(body, "\n"))
+		if current.Suggestion == "" && len(diff) > 0 {
+			current.Suggestion = strings.Join(diff, "\n")
+		}
+		normalise(current)
+		report.Findings = append(report.Findings, *current)
+		current, body, diff = nil, nil, nil
+	}
+
+	for line := range strings.SplitSeq(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			if !inFence {
+				inFence = true
+				inDiff = current != nil && current.Suggestion == "" && len(diff) == 0 &&
+					strings.EqualFold(strings.TrimPrefix(trimmed, "```"), "diff")
+				if inDiff {
+					continue
+				}
+			} else {
+				inFence = false
+				if inDiff {
+					inDiff = false
+					continue
+				}
+			}
+		} else if inDiff {
+			diff = append(diff, line)
+			continue
+		}
+
+		if !inFence {
+			if f, ok := ParseHeading(trimmed); ok {
+				flush()
+				current = &f
+				continue
+			}
+
+			// Any other heading ends the current finding.
+			if current != nil && headingRe.MatchString(trimmed) {
+				flush()
+			}
+		}
+
+		if current != nil {
+			body = append(body, line)
+		} else {
+			summary = append(summary, line)
+		}
+	}
+	flush()
+
+	report.Summary = strings.TrimSpace(strings.Join(summary, "\n"))
+	return report
+}
+
+// ParseHeading parses a finding heading such as
+// "### [high] correctness: internal/git/git.go:10-12". Only the severity,
+// category and location are set on the returned finding.
+func ParseHeading(line string) (Finding, bool) {
+	m := findingHeadingRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return Finding{}, false
+	}
+	return *newFinding(Severity(strings.ToLower(m[1])), m[2]), true
+}
+
+// newFinding builds a finding from the text that follows the severity tag in
+// a finding heading, e.g. "correctness: internal/git/git.go:10-12".
+func newFinding(severity Severity, rest string) *Finding {
+	f := &Finding{Severity: severity}
+	rest = strings.TrimSpace(rest)
+
+	if loc := locationRe.FindStringSubmatchIndex(rest); loc != nil {
+		f.File = rest[loc[2]:loc[3]]
+		f.StartLine, _ = strconv.Atoi(rest[loc[4]:loc[5]])
+		f.EndLine = f.StartLine
+		if loc[6] >= 0 {
+			f.EndLine, _ = strconv.Atoi(rest[loc[6]:loc[7]])
+		}
+		rest = rest[:loc[0]]
+	}
+
+	f.Category = strings.Trim(strings.TrimSpace(rest), ":-–— ")
+	return f
+}
+
+// Generate asks the client for a structured report. If the provider rejects
+// the structured request or returns something that does not parse, the
+// review is requested again as markdown using fallbackSystem and parsed with
+// ParseMarkdown. Errors the markdown request would fail with too, such as
+// auth, quota or context-length errors, are returned as they are.
+func Generate(ctx context.Context, client llm.LLM, system, fallbackSystem, prompt string, opts llm.Options) (Report, error) {
+	resp, err := client.GenerateStructured(ctx, system, prompt, Schema(), opts)
+	if err == nil {
+		report, parseErr := Parse(resp.Content)
+		if parseErr == nil {
+			report.Usage = resp.Usage
+			return report, nil
+		}
+	} else if ctx.Err() != nil || !structuredFailure(err) {
+		return Report{}, err
+	}
+
+	resp, err = client.Generate(ctx, fallbackSystem, prompt, opts)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := ParseMarkdown(resp.Content)
+	report.Usage = resp.Usage
+	return report, nil
+}
+
+// contextLengthErrors are what providers say when the prompt is too long,
+// which they report with the same 400 status as a rejected schema.
+var contextLengthErrors = []string{"context length", "context_length", "too long", "too many tokens", "maximum context", "exceeds the maximum"}
+
+// structuredFailure reports whether err is specific to a structured
+// request, so the review may still succeed as markdown: the provider or
+// model rejecting the schema or structured mode (a 400 or 422, or an error
+// without a status, such as an empty reply). Cancellation, invalid options,
+// transient errors that outlasted their retries, and auth, quota and
+// context-length errors are not.
+func structuredFailure(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, llm.ErrInvalidOptions) {
+		return false
+	}
+	if retry.Transient(err) {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range contextLengthErrors {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+
+	if status, ok := retry.StatusCode(err); ok {
+		return status == http.StatusBadRequest || status == http.StatusUnprocessableEntity
+	}
+	return true
+}
+
+// Merge combines the reports of a review split into chunks. Summaries are
+// joined in order; findings raised for the same lines and category, with
+// the same first line of message, by more than one chunk are kept once, at
+// the highest severity reported. Usage is summed.
+func Merge(reports []Report) Report {
+	type key struct {
+		file            string
+		start, end      int
+		category, title string
+	}
+
+	var (
+		merged    Report
+		summaries []string
+		seen      = make(map[key]int)
+		usage     llm.Usage
+		hasUsage  bool
+	)
+	merged.Structured = len(reports) > 0
+	for _, r := range reports {
+		if s := strings.TrimSpace(r.Summary); s != "" {
+			summaries = append(summaries, s)
+		}
+		merged.Structured = merged.Structured && r.Structured
+		if r.Usage != nil {
+			usage = usage.Add(*r.Usage)
+			hasUsage = true
+		}
+
+		for _, f := range r.Findings {
+			// Distinct issues can share lines and a category, so the title
+			// is part of the key.
+			k := key{file: f.File, start: f.StartLine, end: f.EndLine, category: f.Category, title: title(f.Message)}
+			if i, ok := seen[k]; ok {
+				if f.Severity.Rank() > merged.Findings[i].Severity.Rank() {
+					merged.Findings[i].Severity = f.Severity
+				}
+				continue
+			}
+			seen[k] = len(merged.Findings)
+			merged.Findings = append(merged.Findings, f)
+		}
+	}
+
+	merged.Summary = strings.Join(summaries, "\n\n")
+	if hasUsage {
+		merged.Usage = &usage
+	}
+
+	return merged
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// title normalises the first line of a finding's message for comparison,
+// ignoring case, spacing and the final full stop.
+func title(message string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Join(strings.Fields(firstLine(message)), " ")), ".")
+}
+
+// Count returns the number of findings per severity.
+func Count(findings []Finding) map[Severity]int {
+	counts := make(map[Severity]int, len(Severities))
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	return counts
+}
+
+// AtOrAbove returns the number of findings whose severity is at least threshold.
+func AtOrAbove(findings []Finding, threshold Severity) int {
+	n := 0
+	for _, f := range findings {
+		if f.Severity.Rank() >= threshold.Rank() {
+			n++
+		}
+	}
+	return n
+}
+
+// FormatCounts renders per-severity counts on one line, e.g.
+// "5 findings: 1 high, 2 medium, 2 low, 0 info".
+func FormatCounts(findings []Finding) string {
+	counts := Count(findings)
+	parts := make([]string, len(Severities))
+	for i, severity := range Severities {
+		parts[i] = fmt.Sprintf("%d %s", counts[severity], severity)
+	}
+
+	label := "findings"
+	if len(findings) == 1 {
+		label = "finding"
+	}
+
+	return fmt.Sprintf("%d %s: %s", len(findings), label, strings.Join(parts, ", "))
+}
@@ -0,0 +1,260 @@
+package findings
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	content := `{
+  "summary": "Looks mostly fine.",
+  "findings": [
+    {
+      "file": "internal/git/git.go",
+      "start_line": 12,
+      "end_line": 0,
+      "severity": "HIGH",
+      "category": " Correctness ",
+      "message": "Nil dereference.",
+      "suggestion": ""
+    }
+  ]
+}`
+
+	report, err := Parse(content)
+	require.NoError(t, err)
+
+	// Severity and category are normalised, and an end line before the start
+	// line collapses to a single-line range.
+	assert.True(t, report.Structured)
+	assert.Equal(t, "Looks mostly fine.", report.Summary)
+	assert.Equal(t, []Finding{{
+		File:      "internal/git/git.go",
+		StartLine: 12,
+		EndLine:   12,
+		Severity:  SeverityHigh,
+		Category:  "correctness",
+		Message:   "Nil dereference.",
+	}}, report.Findings)
+}
+
+func TestParse_StripsJSONFence(t *testing.T) {
+	report, err := Parse("```json\n{\"summary\": \"ok\", \"findings\": []}\n```")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", report.Summary)
+	assert.Empty(t, report.Findings)
+}
+
+func TestParseMarkdown(t *testing.T) {
+	markdown := "Overall a solid change.\n" +
+		"\n" +
+		"### [high] correctness: `internal/git/git.go:10-12`\n" +
+		"The error is ignored.\n" +
+		"\n" +
+		"```diff\n" +
+		"-\tout, _ := cmd.Output()\n" +
+		"+\tout, err := cmd.Output()\n" +
+		"```\n" +
+		"\n" +
+		"### [low] style: cmd/root.go:5\n" +
+		"Typo in the comment.\n" +
+		"\n" +
+		"### [info] general\n" +
+		"Consider adding tests.\n" +
+		"\n" +
+		"## Verdict\n" +
+		"Ship it after the fix.\n"
+
+	report := ParseMarkdown(markdown)
+
+	assert.False(t, report.Structured)
+	assert.Equal(t, "Overall a solid change.\n\n## Verdict\nShip it after the fix.", report.Summary)
+	assert.Equal(t, []Finding{
+		{
+			File:       "internal/git/git.go",
+			StartLine:  10,
+			EndLine:    12,
+			Severity:   SeverityHigh,
+			Category:   "correctness",
+			Message:    "The error is ignored.",
+			Suggestion: "-\tout, _ := cmd.Output()\n+\tout, err := cmd.Output()",
+		},
+		{
+			File:      "cmd/root.go",
+			StartLine: 5,
+			EndLine:   5,
+			Severity:  SeverityLow,
+			Category:  "style",
+			Message:   "Typo in the comment.",
+		},
+		{
+			Severity: SeverityInfo,
+			Category: "general",
+			Message:  "Consider adding tests.",
+		},
+	}, report.Findings)
+}
+
+// Headings inside code blocks must not start a finding.
+func TestParseMarkdown_IgnoresHeadingsInCodeBlocks(t *testing.T) {
+	markdown := "### [medium] maintainability: README.md:3\n" +
+		"The example is misleading:\n" +
+		"```markdown\n" +
+		"### [high] not a finding\n" +
+		"```\n"
+
+	report := ParseMarkdown(markdown)
+
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "The example is misleading:\n```markdown\n### [high] not a finding\n```", report.Findings[0].Message)
+}
+
+func TestAtOrAbove(t *testing.T) {
+	list := []Finding{
+		{Severity: SeverityHigh},
+		{Severity: SeverityMedium},
+		{Severity: SeverityLow},
+		{Severity: SeverityLow},
+		{Severity: SeverityInfo},
+	}
+
+	assert.Equal(t, 1, AtOrAbove(list, SeverityHigh))
+	assert.Equal(t, 2, AtOrAbove(list, SeverityMedium))
+	assert.Equal(t, 4, AtOrAbove(list, SeverityLow))
+	assert.Equal(t, "5 findings: 1 high, 1 medium, 2 low, 1 info", FormatCounts(list))
+}
+
+func TestMerge(t *testing.T) {
+	reports := []Report{
+		{
+			Summary:    "Part one is fine.",
+			Structured: true,
+			Usage:      &llm.Usage{InputTokens: 10, OutputTokens: 2, TotalTokens: 12},
+			Findings: []Finding{
+				{File: "a.go", StartLine: 3, EndLine: 4, Severity: SeverityLow, Category: "correctness", Message: "Unchecked error."},
+				{Severity: SeverityInfo, Category: "style", Message: "Consider adding tests."},
+			},
+		},
+		{
+			Summary:    "Part two needs work.",
+			Structured: true,
+			Usage:      &llm.Usage{InputTokens: 5, OutputTokens: 1, TotalTokens: 6},
+			Findings: []Finding{
+				{File: "a.go", StartLine: 3, EndLine: 4, Severity: SeverityHigh, Category: "correctness", Message: "unchecked  error\n\nThe error of Close is dropped."},
+				{Severity: SeverityInfo, Category: "style", Message: "consider adding tests."},
+				{Severity: SeverityInfo, Category: "style", Message: "Document the new flag."},
+			},
+		},
+	}
+
+	merged := Merge(reports)
+
+	assert.True(t, merged.Structured)
+	assert.Equal(t, "Part one is fine.\n\nPart two needs work.", merged.Summary)
+	assert.Equal(t, &llm.Usage{InputTokens: 15, OutputTokens: 3, TotalTokens: 18}, merged.Usage)
+
+	// The duplicate on a.go keeps the first message at the highest severity;
+	// findings only merge when their first lines say the same thing.
+	require.Len(t, merged.Findings, 3)
+	assert.Equal(t, SeverityHigh, merged.Findings[0].Severity)
+	assert.Equal(t, "Unchecked error.", merged.Findings[0].Message)
+	assert.Equal(t, "Consider adding tests.", merged.Findings[1].Message)
+	assert.Equal(t, "Document the new flag.", merged.Findings[2].Message)
+}
+
+func TestMerge_KeepsDistinctFindingsOnSameLines(t *testing.T) {
+	reports := []Report{
+		{Findings: []Finding{
+			{File: "a.go", StartLine: 3, EndLine: 4, Severity: SeverityHigh, Category: "correctness", Message: "Unchecked error."},
+		}},
+		{Findings: []Finding{
+			{File: "a.go", StartLine: 3, EndLine: 4, Severity: SeverityMedium, Category: "correctness", Message: "The loop variable is captured."},
+		}},
+	}
+
+	merged := Merge(reports)
+
+	require.Len(t, merged.Findings, 2)
+	assert.Equal(t, "Unchecked error.", merged.Findings[0].Message)
+	assert.Equal(t, SeverityHigh, merged.Findings[0].Severity)
+	assert.Equal(t, "The loop variable is captured.", merged.Findings[1].Message)
+	assert.Equal(t, SeverityMedium, merged.Findings[1].Severity)
+}
+
+// fakeLLM fails structured requests with structuredErr, or replies with
+// structured, and replies to plain requests with markdown.
+type fakeLLM struct {
+	llm.LLM
+	structured    string
+	structuredErr error
+	markdown      string
+	plainRequests int
+}
+
+func (f *fakeLLM) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	return llm.Response{Content: f.structured}, f.structuredErr
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	f.plainRequests++
+	return llm.Response{Content: f.markdown}, nil
+}
+
+func statusError(status int) error {
+	req, _ := http.NewRequest(http.MethodPost, "https://api.anthropic.com/v1/messages", nil)
+	return &anthropic.Error{StatusCode: status, Request: req, Response: &http.Response{StatusCode: status}}
+}
+
+func TestGenerate_Fallback(t *testing.T) {
+	const markdown = "Looks fine.\n"
+
+	tests := []struct {
+		name          string
+		structured    string
+		structuredErr error
+		fallsBack     bool
+	}{
+		{name: "structured report", structured: `{"summary": "ok", "findings": []}`},
+		{name: "unparseable report", structured: "not json", fallsBack: true},
+		{name: "schema rejected", structuredErr: statusError(http.StatusBadRequest), fallsBack: true},
+		{name: "no status", structuredErr: errors.New("no response from LLM"), fallsBack: true},
+		{name: "unauthorized", structuredErr: statusError(http.StatusUnauthorized)},
+		{name: "quota", structuredErr: statusError(http.StatusTooManyRequests)},
+		{name: "context length", structuredErr: errors.New("400 Bad Request: prompt is too long: 210000 tokens > 200000 maximum")},
+		{name: "invalid options", structuredErr: llm.ErrInvalidOptions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeLLM{structured: tt.structured, structuredErr: tt.structuredErr, markdown: markdown}
+
+			report, err := Generate(context.Background(), client, "system", "fallback", "prompt", llm.Options{})
+			if tt.fallsBack {
+				require.NoError(t, err)
+				assert.Equal(t, "Looks fine.", report.Summary)
+			} else if tt.structuredErr != nil {
+				assert.ErrorIs(t, err, tt.structuredErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.fallsBack, client.plainRequests == 1)
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		client := &fakeLLM{structuredErr: errors.New("request failed"), markdown: markdown}
+
+		_, err := Generate(ctx, client, "system", "fallback", "prompt", llm.Options{})
+		require.Error(t, err)
+		assert.Zero(t, client.plainRequests)
+	})
+}
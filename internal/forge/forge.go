@@ -0,0 +1,201 @@
+package forge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/findings"
+)
+
+// Marker is prepended to every review body bark posts so it can be told
+// apart from human reviews.
+const Marker = "<!-- bark-review -->"
+
+// Client publishes reviews to a code forge.
+type Client interface {
+	// PostReview publishes review on the pull request with the given number
+	// and returns the URL of the created review.
+	PostReview(ctx context.Context, number int, review Review) (string, error)
+}
+
+// Review is a single pull request review: a summary body plus comments
+// anchored to lines on the new side of the diff.
+type Review struct {
+	Body     string
+	Comments []Comment
+}
+
+// Comment is an inline review comment. StartLine is zero for single-line
+// comments; otherwise the comment spans StartLine..Line.
+type Comment struct {
+	Path      string
+	StartLine int
+	Line      int
+	Body      string
+}
+
+// BuildReview turns a findings report into a Review for diff. Findings whose
+// lines fall inside a diff hunk become inline comments; the rest are folded
+// into the summary body, since forges reject comments outside the diff.
+func BuildReview(report findings.Report, diff string) Review {
+	hunks := commentableLines(diff)
+
+	var (
+		comments   []Comment
+		unanchored []findings.Finding
+	)
+
+	for _, f := range report.Findings {
+		comment, ok := anchor(f, hunks[f.File])
+		if !ok {
+			unanchored = append(unanchored, f)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+
+	return Review{
+		Body:     summaryBody(report, unanchored),
+		Comments: comments,
+	}
+}
+
+// anchor places f on the diff. A multi-line range is kept when both ends
+// sit in the same hunk; otherwise the comment collapses onto whichever end
+// is commentable.
+func anchor(f findings.Finding, lines map[int]int) (Comment, bool) {
+	if f.File == "" || f.StartLine == 0 || lines == nil {
+		return Comment{}, false
+	}
+
+	comment := Comment{Path: f.File, Body: commentBody(f)}
+
+	end := max(f.EndLine, f.StartLine)
+	startHunk, startOK := lines[f.StartLine]
+	endHunk, endOK := lines[end]
+
+	switch {
+	case startOK && endOK && end > f.StartLine && startHunk == endHunk:
+		comment.StartLine = f.StartLine
+		comment.Line = end
+	case endOK:
+		comment.Line = end
+	case startOK:
+		comment.Line = f.StartLine
+	default:
+		return Comment{}, false
+	}
+
+	return comment, true
+}
+
+func commentBody(f findings.Finding) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**[%s] %s**: %s", f.Severity, f.Category, f.Message)
+	if f.Suggestion != "" {
+		fmt.Fprintf(&sb, "\n\n```diff\n%s\n```", f.Suggestion)
+	}
+	return sb.String()
+}
+
+func summaryBody(report findings.Report, unanchored []findings.Finding) string {
+	var sb strings.Builder
+	sb.WriteString(Marker + "\n## Bark AI Code Review\n\n")
+
+	if summary := strings.TrimSpace(report.Summary); summary != "" {
+		sb.WriteString(summary + "\n\n")
+	}
+
+	sb.WriteString(findings.FormatCounts(report.Findings) + "\n")
+
+	if len(unanchored) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString("\n### Other findings\n\n")
+	for _, f := range unanchored {
+		fmt.Fprintf(&sb, "- **[%s] %s**", f.Severity, f.Category)
+		if location := findingLocation(f); location != "" {
+			fmt.Fprintf(&sb, " `%s`", location)
+		}
+		fmt.Fprintf(&sb, ": %s\n", f.Message)
+		if f.Suggestion != "" {
+			fmt.Fprintf(&sb, "\n  ```diff\n  %s\n  ```\n", strings.ReplaceAll(f.Suggestion, "\n", "\n  "))
+		}
+	}
+
+	return sb.String()
+}
+
+func findingLocation(f findings.Finding) string {
+	switch {
+	case f.File == "":
+		return ""
+	case f.StartLine == 0:
+		return f.File
+	case f.EndLine > f.StartLine:
+		return fmt.Sprintf("%s:%d-%d", f.File, f.StartLine, f.EndLine)
+	default:
+		return fmt.Sprintf("%s:%d", f.File, f.StartLine)
+	}
+}
+
+// commentableLines maps each file in diff to the new-side lines a review
+// comment may target (added and context lines), each tagged with the index
+// of the hunk it belongs to.
+func commentableLines(diff string) map[string]map[int]int {
+	result := make(map[string]map[int]int)
+
+	var (
+		file  string
+		line  = -1
+		hunk  int
+		lines map[int]int
+	)
+
+	for l := range strings.SplitSeq(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			file, line, lines = "", -1, nil
+
+		case line == -1 && strings.HasPrefix(l, "+++ "):
+			path := strings.TrimPrefix(l, "+++ ")
+			if path == "/dev/null" {
+				continue
+			}
+			file = strings.TrimPrefix(path, "b/")
+			lines = make(map[int]int)
+			result[file] = lines
+
+		case strings.HasPrefix(l, "@@ "):
+			line = hunkStart(l)
+			hunk++
+
+		case lines == nil || line < 0:
+			// Extended headers and "--- " lines before the first hunk.
+
+		case strings.HasPrefix(l, "+"), strings.HasPrefix(l, " "):
+			lines[line] = hunk
+			line++
+		}
+	}
+
+	return result
+}
+
+// hunkStart returns the new-side start line of a "@@ -a,b +c,d @@" header,
+// or -1 when the header is malformed.
+func hunkStart(header string) int {
+	parts := strings.Fields(header)
+	if len(parts) < 3 {
+		return -1
+	}
+	before, _, _ := strings.Cut(strings.TrimPrefix(parts[2], "+"), ",")
+	start, err := strconv.Atoi(before)
+	if err != nil {
+		return -1
+	}
+	return start
+}
@@ -0,0 +1,111 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDiff = `diff --git a/main.go b/main.go
+index 1234567..89abcdf 100644
+--- a/main.go
++++ b/main.go
+@@ -5,4 +5,5 @@
+ func main() {
++	fmt.Println("Hello")
+ 	run()
+ }
+@@ -40,3 +41,3 @@ func run() {
+ 	a := 1
+-	b := 2
++	b := 3
+ 	return
+`
+
+func TestBuildReview(t *testing.T) {
+	report := findings.Report{
+		Summary: "Looks mostly fine.",
+		Findings: []findings.Finding{
+			{File: "main.go", StartLine: 6, EndLine: 7, Severity: findings.SeverityHigh, Category: "bug", Message: "Unchecked."},
+			// Spans two hunks, so it collapses onto the end line.
+			{File: "main.go", StartLine: 7, EndLine: 42, Severity: findings.SeverityLow, Category: "style", Message: "Spread out."},
+			// Outside the diff.
+			{File: "main.go", StartLine: 100, Severity: findings.SeverityMedium, Category: "design", Message: "Too far."},
+			{File: "other.go", StartLine: 3, Severity: findings.SeverityInfo, Category: "general", Message: "Not in diff."},
+			{Severity: findings.SeverityLow, Category: "general", Message: "No location."},
+		},
+	}
+
+	review := BuildReview(report, testDiff)
+
+	assert.Equal(t, []Comment{
+		{Path: "main.go", StartLine: 6, Line: 7, Body: "**[high] bug**: Unchecked."},
+		{Path: "main.go", Line: 42, Body: "**[low] style**: Spread out."},
+	}, review.Comments)
+
+	assert.Contains(t, review.Body, Marker)
+	assert.Contains(t, review.Body, "Looks mostly fine.")
+	assert.Contains(t, review.Body, "5 findings: 1 high, 1 medium, 2 low, 1 info")
+	assert.Contains(t, review.Body, "- **[medium] design** `main.go:100`: Too far.")
+	assert.Contains(t, review.Body, "- **[info] general** `other.go:3`: Not in diff.")
+	assert.Contains(t, review.Body, "- **[low] general**: No location.")
+}
+
+func TestGitHubPostReview(t *testing.T) {
+	var (
+		gotPath string
+		gotAuth string
+		gotBody githubReviewRequest
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.Method + " " + r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBody))
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"id": 1, "html_url": "https://github.com/o/r/pull/42#pullrequestreview-1"}`))
+	}))
+	defer server.Close()
+
+	client := NewGitHub(server.URL, "secret", "o/r")
+	url, err := client.PostReview(context.Background(), 42, Review{
+		Body: "summary",
+		Comments: []Comment{
+			{Path: "main.go", StartLine: 6, Line: 7, Body: "range"},
+			{Path: "main.go", Line: 42, Body: "single"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://github.com/o/r/pull/42#pullrequestreview-1", url)
+	assert.Equal(t, "POST /repos/o/r/pulls/42/reviews", gotPath)
+	assert.Equal(t, "Bearer secret", gotAuth)
+	assert.Equal(t, githubReviewRequest{
+		Body:  "summary",
+		Event: "COMMENT",
+		Comments: []githubReviewComment{
+			{Path: "main.go", StartLine: 6, StartSide: "RIGHT", Line: 7, Side: "RIGHT", Body: "range"},
+			{Path: "main.go", Line: 42, Side: "RIGHT", Body: "single"},
+		},
+	}, gotBody)
+}
+
+func TestGitHubPostReviewError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"message": "Unprocessable Entity", "errors": ["Line could not be resolved"]}`))
+	}))
+	defer server.Close()
+
+	_, err := NewGitHub(server.URL, "", "o/r").PostReview(context.Background(), 1, Review{Body: "x"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "422")
+	assert.Contains(t, err.Error(), "Line could not be resolved")
+}
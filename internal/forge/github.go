@@ -0,0 +1,164 @@
+package forge
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+var ErrNoGitHubToken = errors.New("no GitHub token found: set GH_TOKEN or GITHUB_TOKEN, or run `gh auth login`")
+
+// GitHub is a Client backed by the GitHub REST API.
+type GitHub struct {
+	baseURL    string
+	token      string
+	repo       string
+	httpClient *http.Client
+}
+
+// NewGitHub returns a client for repo ("owner/name"). baseURL is the REST
+// API root; it defaults to https://api.github.com when empty.
+func NewGitHub(baseURL, token, repo string) *GitHub {
+	if baseURL == "" {
+		baseURL = defaultGitHubAPIURL
+	}
+
+	return &GitHub{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		repo:       repo,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewGitHubFromEnv resolves the API URL, token and repository the same way
+// GitHub Actions and the gh CLI do: GITHUB_API_URL, GH_TOKEN/GITHUB_TOKEN and
+// GITHUB_REPOSITORY first, falling back to `gh auth token` and `gh repo view`.
+func NewGitHubFromEnv(ctx context.Context) (*GitHub, error) {
+	token := cmp.Or(os.Getenv("GH_TOKEN"), os.Getenv("GITHUB_TOKEN"))
+	if token == "" {
+		out, err := exec.CommandContext(ctx, "gh", "auth", "token").Output()
+		if err != nil {
+			return nil, ErrNoGitHubToken
+		}
+		token = strings.TrimSpace(string(out))
+	}
+
+	repo := os.Getenv("GITHUB_REPOSITORY")
+	if repo == "" {
+		out, err := exec.CommandContext(ctx, "gh", "repo", "view", "--json", "nameWithOwner", "--jq", ".nameWithOwner").Output()
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve GitHub repository: %w", err)
+		}
+		repo = strings.TrimSpace(string(out))
+	}
+
+	return NewGitHub(os.Getenv("GITHUB_API_URL"), token, repo), nil
+}
+
+type githubReviewComment struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Side      string `json:"side"`
+	StartLine int    `json:"start_line,omitempty"`
+	StartSide string `json:"start_side,omitempty"`
+	Body      string `json:"body"`
+}
+
+type githubReviewRequest struct {
+	Body     string                `json:"body"`
+	Event    string                `json:"event"`
+	Comments []githubReviewComment `json:"comments"`
+}
+
+// PostReview creates a COMMENT review on the pull request. All comments are
+// submitted in one request so the PR gets a single notification.
+func (g *GitHub) PostReview(ctx context.Context, number int, review Review) (string, error) {
+	req := githubReviewRequest{
+		Body:     review.Body,
+		Event:    "COMMENT",
+		Comments: make([]githubReviewComment, len(review.Comments)),
+	}
+	for i, c := range review.Comments {
+		comment := githubReviewComment{Path: c.Path, Line: c.Line, Side: "RIGHT", Body: c.Body}
+		if c.StartLine > 0 && c.StartLine < c.Line {
+			comment.StartLine = c.StartLine
+			comment.StartSide = "RIGHT"
+		}
+		req.Comments[i] = comment
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode review: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d/reviews", g.baseURL, g.repo, number)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	resp, err := g.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("github request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read GitHub response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("github: creating review failed: %s: %s", resp.Status, githubErrorMessage(body))
+	}
+
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("failed to parse GitHub response: %w", err)
+	}
+
+	return created.HTMLURL, nil
+}
+
+// githubErrorMessage extracts the message and any per-field errors from a
+// GitHub error payload, falling back to the raw body.
+func githubErrorMessage(body []byte) string {
+	var payload struct {
+		Message string `json:"message"`
+		Errors  []any  `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Message == "" {
+		return strings.TrimSpace(string(body))
+	}
+
+	if len(payload.Errors) == 0 {
+		return payload.Message
+	}
+
+	details := make([]string, len(payload.Errors))
+	for i, e := range payload.Errors {
+		details[i] = fmt.Sprint(e)
+	}
+	return fmt.Sprintf("%s (%s)", payload.Message, strings.Join(details, "; "))
+}
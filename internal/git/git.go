@@ -656,6 +656,10 @@ type ReviewDiff struct {
 	// Ref is the git revision whose file content matches the diff's new-side
 	// line numbers: a commit hash, ":" for the index, or "" for the working tree.
 	Ref string
+	// BaseRef is the git revision whose file content matches the diff's
+	// old-side line numbers: the parent commit, the compared branch, or HEAD
+	// for working-tree and staged changes.
+	BaseRef string
 	// SkipEnrichment is true when no local ref matches the diff (e.g. a PR head
 	// that is not checked out), so enclosing-context extraction must be skipped.
 	SkipEnrichment bool
@@ -690,11 +694,13 @@ func GetReviewDiff(ctx context.Context, params ReviewDiffParams) (ReviewDiff, er
 		if err != nil {
 			return r, &BranchDiffError{Branch: params.branch, Err: err}
 		}
+		r.BaseRef = params.branch
 		r.Stat = GetBranchDiffStat(ctx, params.branch)
 		r.Commits, _ = GetBranchCommits(ctx, params.branch)
 
 	case params.commitHash != "":
 		r.Ref = params.commitHash
+		r.BaseRef = params.commitHash + "^"
 		var err error
 		r.Diff, err = GetDiff(ctx, params.commitHash)
 		if err != nil {
@@ -708,6 +714,7 @@ func GetReviewDiff(ctx context.Context, params ReviewDiffParams) (ReviewDiff, er
 		if params.stagedOnly {
 			r.Ref = ":"
 		}
+		r.BaseRef = "HEAD"
 		var err error
 		r.Diff, err = GetWorkingTreeDiff(ctx, all)
 		if err != nil {
@@ -817,3 +824,67 @@ func GetFileContent(ctx context.Context, ref string, filePath string) ([]byte, e
 	cmd := exec.CommandContext(ctx, "git", "show", arg)
 	return cmd.Output()
 }
+
+// GrepFiles returns the repo-root-relative paths of the files at ref that
+// contain any of words as a whole word. Like GetFileContent, an empty ref
+// searches the working tree and ":" the index.
+func GrepFiles(ctx context.Context, ref string, words []string) ([]string, error) {
+	if len(words) == 0 {
+		return nil, nil
+	}
+	return grepFiles(ctx, ref, []string{"-F"}, words, nil)
+}
+
+// GrepFilesMatching is GrepFiles for extended regular expressions, matched
+// at word boundaries, in the files matching pathspecs.
+func GrepFilesMatching(ctx context.Context, ref string, patterns, pathspecs []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return grepFiles(ctx, ref, []string{"-E"}, patterns, pathspecs)
+}
+
+func grepFiles(ctx context.Context, ref string, flags, patterns, pathspecs []string) ([]string, error) {
+	args := append([]string{"grep", "-l", "-w", "-I", "--full-name"}, flags...)
+	for _, pattern := range patterns {
+		args = append(args, "-e", pattern)
+	}
+	switch ref {
+	case "":
+	case ":":
+		args = append(args, "--cached")
+	default:
+		args = append(args, ref)
+	}
+
+	// Pathspecs are anchored at the top, so the whole repository is
+	// searched, not just the cwd.
+	args = append(args, "--")
+	if len(pathspecs) == 0 {
+		args = append(args, ":/")
+	}
+	for _, pathspec := range pathspecs {
+		args = append(args, ":(top)"+pathspec)
+	}
+
+	output, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		// git grep exits with 1 when nothing matches.
+		if exitErr, ok := errors.AsType[*exec.ExitError](err); ok && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to search the repository: %w", err)
+	}
+
+	var files []string
+	for line := range strings.Lines(string(output)) {
+		line = strings.TrimSuffix(line, "\n")
+		if ref != "" && ref != ":" {
+			line = strings.TrimPrefix(line, ref+":")
+		}
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
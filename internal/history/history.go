@@ -0,0 +1,373 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/enclosing"
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/ionut-t/bark/v2/internal/patch"
+)
+
+const (
+	dirName = "history"
+	// Latest is accepted wherever an entry ID is, and resolves to the most
+	// recent review.
+	Latest = "latest"
+
+	idLayout = "20060102-150405"
+
+	// MaxEntries is how many reviews the store keeps; adding one removes
+	// the oldest beyond it.
+	MaxEntries = 100
+)
+
+var (
+	ErrNotFound      = errors.New("review not found in history")
+	ErrNothingToUndo = errors.New("no applied suggestion to undo")
+	ErrOutsideRepo   = errors.New("path is outside the repository")
+)
+
+// Entry is a saved review together with what is needed to act on its
+// suggestions later: the repository it ran in and a fingerprint of every
+// file it may touch, taken when the review finished.
+type Entry struct {
+	ID        string             `json:"id"`
+	CreatedAt time.Time          `json:"created_at"`
+	RepoRoot  string             `json:"repo_root"`
+	Reviewer  string             `json:"reviewer"`
+	Model     string             `json:"model,omitempty"`
+	Content   string             `json:"content,omitempty"`
+	Findings  []findings.Finding `json:"findings,omitempty"`
+	// Files maps repository-relative paths to the SHA-256 of their content
+	// at review time, updated as suggestions are applied and undone.
+	Files map[string]string `json:"files"`
+	// Applied is the undo stack of suggestions applied from this review.
+	Applied []Applied `json:"applied,omitempty"`
+}
+
+// Applied records one applied suggestion so it can be undone.
+type Applied struct {
+	Suggestion int       `json:"suggestion"`
+	File       string    `json:"file"`
+	Before     string    `json:"before"`
+	AfterHash  string    `json:"after_hash"`
+	AppliedAt  time.Time `json:"applied_at"`
+}
+
+// Suggestions returns the review's diff blocks, numbered from 1 in the
+// order they appear.
+func (e Entry) Suggestions() []patch.Suggestion {
+	if e.Content != "" {
+		return patch.Extract(e.Content)
+	}
+	return patch.FromFindings(e.Findings)
+}
+
+// Suggestion returns suggestion n (1-based).
+func (e Entry) Suggestion(n int) (patch.Suggestion, error) {
+	suggestions := e.Suggestions()
+	if n < 1 || n > len(suggestions) {
+		return patch.Suggestion{}, fmt.Errorf("review %s has %d suggestion(s); %d is out of range", e.ID, len(suggestions), n)
+	}
+	return suggestions[n-1], nil
+}
+
+// Store keeps review history as one JSON file per review.
+type Store struct {
+	dir string
+}
+
+// New returns a store rooted under the bark storage directory.
+func New(storage string) *Store {
+	return &Store{dir: filepath.Join(storage, dirName)}
+}
+
+// NewEntry builds an entry for a review that just finished in repoRoot,
+// fingerprinting every file the diff and the review's suggestions name.
+func NewEntry(repoRoot, reviewer, model, content string, list []findings.Finding, diff string) Entry {
+	e := Entry{
+		ID:        time.Now().Format(idLayout),
+		CreatedAt: time.Now(),
+		RepoRoot:  repoRoot,
+		Reviewer:  reviewer,
+		Model:     model,
+		Content:   content,
+		Findings:  list,
+		Files:     make(map[string]string),
+	}
+
+	var paths []string
+	for path := range enclosing.ModifiedLinesFromDiff(diff) {
+		paths = append(paths, path)
+	}
+	for _, s := range e.Suggestions() {
+		if s.File != "" {
+			paths = append(paths, s.File)
+		}
+	}
+
+	for _, path := range paths {
+		abs, err := e.path(path)
+		if err != nil {
+			continue
+		}
+		if data, err := os.ReadFile(abs); err == nil {
+			e.Files[path] = hash(data)
+		}
+	}
+
+	return e
+}
+
+// Save writes the entry, replacing any previous version.
+func (s *Store) Save(e *Entry) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode review: %w", err)
+	}
+
+	return os.WriteFile(s.path(e.ID), data, 0o644)
+}
+
+// Add saves a new entry, making its ID unique first, and removes the
+// oldest entries beyond MaxEntries.
+func (s *Store) Add(e *Entry) error {
+	base := e.ID
+	for i := 2; ; i++ {
+		if _, err := os.Stat(s.path(e.ID)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		e.ID = fmt.Sprintf("%s-%d", base, i)
+	}
+	if err := s.Save(e); err != nil {
+		return err
+	}
+	return s.prune(MaxEntries)
+}
+
+// prune removes all but the keep most recent entries.
+func (s *Store) prune(keep int) error {
+	entries, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries[min(keep, len(entries)):] {
+		if err := os.Remove(s.path(e.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove review %s: %w", e.ID, err)
+		}
+	}
+	return nil
+}
+
+// Load returns the entry with the given ID, or the most recent one for
+// Latest.
+func (s *Store) Load(id string) (Entry, error) {
+	if id == Latest {
+		entries, err := s.List()
+		if err != nil {
+			return Entry{}, err
+		}
+		if len(entries) == 0 {
+			return Entry{}, ErrNotFound
+		}
+		return entries[0], nil
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return Entry{}, fmt.Errorf("failed to parse review %s: %w", id, err)
+	}
+	return e, nil
+}
+
+// List returns all entries, most recent first.
+func (s *Store) List() ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok || f.IsDir() {
+			continue
+		}
+		e, err := s.Load(id)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return entries, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+// Preview applies suggestion n in memory and returns the file it targets
+// and the result, without touching the working tree.
+func (e Entry) Preview(n int) (string, patch.Result, error) {
+	s, err := e.Suggestion(n)
+	if err != nil {
+		return "", patch.Result{}, err
+	}
+	if s.File == "" {
+		return "", patch.Result{}, fmt.Errorf("suggestion %d does not name a file", n)
+	}
+
+	hunks, err := patch.Parse(s.Diff)
+	if err != nil {
+		return "", patch.Result{}, fmt.Errorf("suggestion %d: %w", n, err)
+	}
+
+	current, err := e.checkUnchanged(s.File)
+	if err != nil {
+		return "", patch.Result{}, err
+	}
+
+	result, err := patch.Apply(string(current), hunks)
+	if err != nil {
+		return "", patch.Result{}, fmt.Errorf("suggestion %d does not apply to %s: %w", n, s.File, err)
+	}
+
+	return s.File, result, nil
+}
+
+// Apply applies suggestion n to the working tree and records it on the
+// entry's undo stack. The caller saves the entry.
+func (e *Entry) Apply(n int) (patch.Result, error) {
+	file, result, err := e.Preview(n)
+	if err != nil {
+		return patch.Result{}, err
+	}
+
+	path, err := e.path(file)
+	if err != nil {
+		return patch.Result{}, err
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		return patch.Result{}, err
+	}
+
+	if err := writeFile(path, []byte(result.Content)); err != nil {
+		return patch.Result{}, err
+	}
+
+	after := hash([]byte(result.Content))
+	e.Files[file] = after
+	e.Applied = append(e.Applied, Applied{
+		Suggestion: n,
+		File:       file,
+		Before:     string(before),
+		AfterHash:  after,
+		AppliedAt:  time.Now(),
+	})
+
+	return result, nil
+}
+
+// Undo reverts the most recently applied suggestion. Like Apply, it refuses
+// to overwrite a file that has changed since. The caller saves the entry.
+func (e *Entry) Undo() (Applied, error) {
+	if len(e.Applied) == 0 {
+		return Applied{}, ErrNothingToUndo
+	}
+	last := e.Applied[len(e.Applied)-1]
+
+	if _, err := e.checkUnchanged(last.File); err != nil {
+		return Applied{}, err
+	}
+
+	path, err := e.path(last.File)
+	if err != nil {
+		return Applied{}, err
+	}
+	if err := writeFile(path, []byte(last.Before)); err != nil {
+		return Applied{}, err
+	}
+
+	e.Files[last.File] = hash([]byte(last.Before))
+	e.Applied = e.Applied[:len(e.Applied)-1]
+
+	return last, nil
+}
+
+// checkUnchanged reads file and verifies it still matches the fingerprint
+// recorded for it.
+func (e Entry) checkUnchanged(file string) ([]byte, error) {
+	want, ok := e.Files[file]
+	if !ok {
+		return nil, fmt.Errorf("%s was not part of review %s", file, e.ID)
+	}
+
+	path, err := e.path(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash(data) != want {
+		return nil, fmt.Errorf("%s has changed since review %s; refusing to modify it", file, e.ID)
+	}
+
+	return data, nil
+}
+
+// path returns the absolute path of file, a path relative to the
+// repository. Paths come from reviewer output and from the entry's JSON, so
+// any that is absolute or leaves the repository is refused.
+func (e Entry) path(file string) (string, error) {
+	if !filepath.IsLocal(file) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRepo, file)
+	}
+	return filepath.Join(e.RepoRoot, file), nil
+}
+
+// writeFile replaces path, keeping its permissions.
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return os.WriteFile(path, data, mode)
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const review = "### [low] style: main.go:2\nRename.\n\n```diff\n-a := 1\n+count := 1\n```\n"
+
+func TestApplyAndUndo(t *testing.T) {
+	repo := t.TempDir()
+	file := filepath.Join(repo, "main.go")
+	require.NoError(t, os.WriteFile(file, []byte("func f() {\na := 1\n}\n"), 0o600))
+
+	store := New(t.TempDir())
+	entry := NewEntry(repo, "Rob Pike", "gpt-5", review, nil, "")
+	require.NoError(t, store.Add(&entry))
+
+	loaded, err := store.Load(Latest)
+	require.NoError(t, err)
+	require.Len(t, loaded.Suggestions(), 1)
+
+	_, err = loaded.Apply(1)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(&loaded))
+
+	data, _ := os.ReadFile(file)
+	assert.Equal(t, "func f() {\ncount := 1\n}\n", string(data))
+
+	info, _ := os.Stat(file)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// The suggestion no longer applies on top of itself.
+	_, err = loaded.Apply(1)
+	assert.ErrorContains(t, err, "does not apply")
+
+	_, err = loaded.Undo()
+	require.NoError(t, err)
+
+	data, _ = os.ReadFile(file)
+	assert.Equal(t, "func f() {\na := 1\n}\n", string(data))
+
+	_, err = loaded.Undo()
+	assert.ErrorIs(t, err, ErrNothingToUndo)
+}
+
+func TestApplyRefusesChangedFile(t *testing.T) {
+	repo := t.TempDir()
+	file := filepath.Join(repo, "main.go")
+	require.NoError(t, os.WriteFile(file, []byte("a := 1\n"), 0o644))
+
+	entry := NewEntry(repo, "Rob Pike", "", review, nil, "")
+
+	require.NoError(t, os.WriteFile(file, []byte("a := 1\nb := 2\n"), 0o644))
+
+	_, err := entry.Apply(1)
+	assert.ErrorContains(t, err, "has changed since review")
+}
+
+func TestApplyRefusesPathsOutsideRepo(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	require.NoError(t, os.Mkdir(repo, 0o755))
+	outside := filepath.Join(dir, "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("a := 1\n"), 0o644))
+
+	review := "```diff\n--- a/../outside.txt\n+++ b/../outside.txt\n-a := 1\n+count := 1\n```\n"
+	entry := NewEntry(repo, "Rob Pike", "", review, nil, "")
+	assert.Empty(t, entry.Files)
+
+	_, err := entry.Apply(1)
+	assert.ErrorContains(t, err, "does not name a file")
+
+	// Paths read back from a tampered entry are checked again.
+	entry.Files["../outside.txt"] = hash([]byte("a := 1\n"))
+	entry.Applied = []Applied{{Suggestion: 1, File: "../outside.txt", Before: "pwned\n"}}
+	_, err = entry.Undo()
+	assert.ErrorIs(t, err, ErrOutsideRepo)
+
+	data, _ := os.ReadFile(outside)
+	assert.Equal(t, "a := 1\n", string(data))
+}
+
+func TestAddPrunesOldEntries(t *testing.T) {
+	store := New(t.TempDir())
+	var ids []string
+	for i := range 3 {
+		entry := NewEntry(t.TempDir(), "Rob Pike", "", review, nil, "")
+		entry.CreatedAt = entry.CreatedAt.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, store.Add(&entry))
+		ids = append(ids, entry.ID)
+	}
+
+	require.NoError(t, store.prune(2))
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ids[2], entries[0].ID)
+	assert.Equal(t, ids[1], entries[1].ID)
+}
@@ -0,0 +1,240 @@
+// Package ledger persists the token usage of every LLM request so it can be
+// aggregated, priced and checked against budgets.
+package ledger
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+const fileName = "usage.jsonl"
+
+// Tasks a request can be made for.
+const (
+	TaskReview = "review"
+	TaskChat   = "chat"
+	TaskCommit = "commit"
+	TaskPR     = "pr"
+)
+
+// Entry is one LLM request.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Repo     string    `json:"repo,omitempty"`
+	Task     string    `json:"task"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	// InputTokens includes CacheReadTokens and CacheWriteTokens, which are
+	// priced at the model's cache rates.
+	InputTokens      int64         `json:"input_tokens"`
+	CacheReadTokens  int64         `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int64         `json:"cache_write_tokens,omitempty"`
+	CacheTTL         time.Duration `json:"cache_ttl,omitempty"`
+	OutputTokens     int64         `json:"output_tokens"`
+	Duration         time.Duration `json:"duration"`
+}
+
+// NewEntry builds an entry for a request that finished now.
+func NewEntry(repo, task, provider, model string, usage llm.Usage, duration time.Duration) Entry {
+	return Entry{
+		Time:             time.Now(),
+		Repo:             repo,
+		Task:             task,
+		Provider:         provider,
+		Model:            model,
+		InputTokens:      usage.InputTokens,
+		CacheReadTokens:  usage.CacheReadTokens,
+		CacheWriteTokens: usage.CacheWriteTokens,
+		CacheTTL:         usage.CacheTTL,
+		OutputTokens:     usage.OutputTokens,
+		Duration:         duration,
+	}
+}
+
+// Ledger is an append-only JSON-lines file of entries.
+type Ledger struct {
+	path string
+}
+
+// New returns the ledger stored under storage (usually ~/.bark).
+func New(storage string) *Ledger {
+	return &Ledger{path: filepath.Join(storage, fileName)}
+}
+
+// Path returns the location of the ledger file.
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// appendMu serialises appends from concurrent requests in one process;
+// O_APPEND keeps single-line writes from separate processes intact.
+var appendMu sync.Mutex
+
+// Append adds e to the ledger.
+func (l *Ledger) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+
+	return nil
+}
+
+// Entries returns the entries recorded at or after since, oldest first.
+// Lines that don't parse (e.g. a write cut short) are skipped.
+func (l *Ledger) Entries(since time.Time) ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Dimensions entries can be grouped by.
+const (
+	ByDay   = "day"
+	ByRepo  = "repo"
+	ByModel = "model"
+	ByTask  = "task"
+)
+
+// Dimensions lists the supported groupings.
+var Dimensions = []string{ByDay, ByRepo, ByModel, ByTask}
+
+// Row is the aggregated usage of one group.
+type Row struct {
+	Key          string
+	Calls        int
+	InputTokens  int64
+	OutputTokens int64
+	Duration     time.Duration
+	Cost         float64
+	// Unpriced counts the calls whose model has no price, so Cost is a lower
+	// bound when it is non-zero.
+	Unpriced int
+}
+
+// Aggregate groups entries by dimension and prices them. Days are sorted
+// chronologically; other groups by cost, then tokens, descending.
+func Aggregate(entries []Entry, dimension string, prices Prices) ([]Row, error) {
+	key, err := keyFunc(dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	var rows []Row
+	for _, e := range entries {
+		k := key(e)
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, Row{Key: k})
+		}
+
+		r := &rows[i]
+		r.Calls++
+		r.InputTokens += e.InputTokens
+		r.OutputTokens += e.OutputTokens
+		r.Duration += e.Duration
+		if cost, ok := prices.EntryCost(e); ok {
+			r.Cost += cost
+		} else {
+			r.Unpriced++
+		}
+	}
+
+	if dimension == ByDay {
+		slices.SortFunc(rows, func(a, b Row) int {
+			return cmp.Compare(a.Key, b.Key)
+		})
+	} else {
+		slices.SortStableFunc(rows, func(a, b Row) int {
+			return cmp.Or(
+				cmp.Compare(b.Cost, a.Cost),
+				cmp.Compare(b.InputTokens+b.OutputTokens, a.InputTokens+a.OutputTokens),
+			)
+		})
+	}
+
+	return rows, nil
+}
+
+// Total sums rows into one row keyed "total".
+func Total(rows []Row) Row {
+	total := Row{Key: "total"}
+	for _, r := range rows {
+		total.Calls += r.Calls
+		total.InputTokens += r.InputTokens
+		total.OutputTokens += r.OutputTokens
+		total.Duration += r.Duration
+		total.Cost += r.Cost
+		total.Unpriced += r.Unpriced
+	}
+	return total
+}
+
+func keyFunc(dimension string) (func(Entry) string, error) {
+	switch dimension {
+	case ByDay:
+		return func(e Entry) string { return e.Time.Local().Format(time.DateOnly) }, nil
+	case ByRepo:
+		return func(e Entry) string {
+			if e.Repo == "" {
+				return "(none)"
+			}
+			return filepath.Base(e.Repo)
+		}, nil
+	case ByModel:
+		return func(e Entry) string { return e.Provider + "/" + e.Model }, nil
+	case ByTask:
+		return func(e Entry) string { return e.Task }, nil
+	default:
+		return nil, fmt.Errorf("unknown grouping %q (supported: %s, %s, %s, %s)", dimension, ByDay, ByRepo, ByModel, ByTask)
+	}
+}
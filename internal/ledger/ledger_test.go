@@ -0,0 +1,186 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPrices = Prices{Models: map[string]Price{
+	"gpt-4o":      {Input: 2.5, Output: 10},
+	"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+}}
+
+func TestPrices_LookupPrefersLongestPrefix(t *testing.T) {
+	price, ok := testPrices.Lookup("gpt-4o-mini-2024-07-18")
+	require.True(t, ok)
+	assert.Equal(t, 0.15, price.Input)
+
+	price, ok = testPrices.Lookup("gpt-4o-2024-08-06")
+	require.True(t, ok)
+	assert.Equal(t, 2.5, price.Input)
+
+	_, ok = testPrices.Lookup("llama3")
+	assert.False(t, ok)
+}
+
+func TestPrices_Cost(t *testing.T) {
+	cost, ok := testPrices.Cost("gpt-4o", 1_000_000, 100_000)
+	require.True(t, ok)
+	assert.InDelta(t, 3.5, cost, 1e-9)
+}
+
+func TestPrices_EntryCost(t *testing.T) {
+	prices := Prices{Models: map[string]Price{
+		"claude-sonnet-4":  {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+		"gemini-2.5-flash": {Input: 0.3, Output: 2.5, CacheRead: 0.075, CacheWrite: 0.075, CacheStorage: 1},
+	}}
+
+	// 1M prompt tokens, of which 600k were read from the cache and 200k
+	// written to it.
+	e := Entry{Model: "claude-sonnet-4", InputTokens: 1_000_000, CacheReadTokens: 600_000, CacheWriteTokens: 200_000, OutputTokens: 100_000}
+	cost, ok := prices.EntryCost(e)
+	require.True(t, ok)
+	assert.InDelta(t, 0.6+0.18+0.75+1.5, cost, 1e-9)
+
+	// The cache created for a Gemini request is stored for its TTL.
+	e = Entry{Model: "gemini-2.5-flash", InputTokens: 1_000_000, CacheWriteTokens: 1_000_000, CacheTTL: 2 * time.Hour}
+	cost, ok = prices.EntryCost(e)
+	require.True(t, ok)
+	assert.InDelta(t, 0.075+2, cost, 1e-9)
+
+	// Without cache rates, cached tokens cost as much as the rest.
+	cost, ok = testPrices.EntryCost(Entry{Model: "gpt-4o", InputTokens: 1_000_000, CacheReadTokens: 500_000, OutputTokens: 100_000})
+	require.True(t, ok)
+	assert.InDelta(t, 3.5, cost, 1e-9)
+}
+
+func TestLedger_AppendAndEntries(t *testing.T) {
+	l := New(t.TempDir())
+
+	entries, err := l.Entries(time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	old := Entry{Time: time.Now().AddDate(0, 0, -10), Task: TaskReview, Model: "gpt-4o", InputTokens: 10}
+	recent := Entry{Time: time.Now(), Task: TaskCommit, Model: "gpt-4o", InputTokens: 20}
+	require.NoError(t, l.Append(old))
+	require.NoError(t, l.Append(recent))
+
+	entries, err = l.Entries(time.Now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, TaskCommit, entries[0].Task)
+}
+
+func TestAggregate(t *testing.T) {
+	day := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	entries := []Entry{
+		{Time: day, Repo: "/src/a", Task: TaskReview, Provider: "openai", Model: "gpt-4o", InputTokens: 1_000_000},
+		{Time: day.AddDate(0, 0, -1), Repo: "/src/b", Task: TaskReview, Provider: "openai", Model: "gpt-4o-mini", InputTokens: 1_000_000},
+		{Time: day, Repo: "/src/a", Task: TaskCommit, Provider: "ollama", Model: "llama3", InputTokens: 500},
+	}
+
+	rows, err := Aggregate(entries, ByDay, testPrices)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "2026-03-01", rows[0].Key)
+	assert.Equal(t, "2026-03-02", rows[1].Key)
+	assert.Equal(t, 2, rows[1].Calls)
+	assert.Equal(t, 1, rows[1].Unpriced)
+
+	rows, err = Aggregate(entries, ByModel, testPrices)
+	require.NoError(t, err)
+	assert.Equal(t, "openai/gpt-4o", rows[0].Key, "most expensive first")
+
+	rows, err = Aggregate(entries, ByRepo, testPrices)
+	require.NoError(t, err)
+	assert.Equal(t, "a", rows[0].Key)
+
+	total := Total(rows)
+	assert.Equal(t, 3, total.Calls)
+	assert.InDelta(t, 2.65, total.Cost, 1e-9)
+
+	_, err = Aggregate(entries, "week", testPrices)
+	assert.Error(t, err)
+}
+
+func TestLedger_Check(t *testing.T) {
+	l := New(t.TempDir())
+
+	assert.NoError(t, l.Check(Budget{}, testPrices, []string{"gpt-4o"}, 10_000_000), "no budget set")
+	assert.NoError(t, l.Check(Budget{PerRun: 0.01}, testPrices, []string{"llama3"}, 10_000_000), "unpriced model")
+
+	err := l.Check(Budget{PerRun: 1, Abort: true}, testPrices, []string{"gpt-4o"}, 1_000_000)
+	budgetErr, ok := errors.AsType[*BudgetError](err)
+	require.True(t, ok)
+	assert.Equal(t, "per-run", budgetErr.Limit)
+	assert.True(t, budgetErr.Abort)
+
+	// A request may be served by a fallback, so the priciest model counts.
+	err = l.Check(Budget{PerRun: 1}, testPrices, []string{"llama3", "gpt-4o-mini", "gpt-4o"}, 1_000_000)
+	budgetErr, ok = errors.AsType[*BudgetError](err)
+	require.True(t, ok)
+	assert.InDelta(t, 2.5, budgetErr.Cost, 1e-9)
+	assert.NoError(t, l.Check(Budget{PerRun: 1}, testPrices, []string{"llama3", "gpt-4o-mini"}, 1_000_000))
+
+	require.NoError(t, l.Append(Entry{Time: time.Now(), Model: "gpt-4o", InputTokens: 1_000_000, OutputTokens: 100_000}))
+
+	assert.NoError(t, l.Check(Budget{Monthly: 5}, testPrices, []string{"gpt-4o"}, 100_000))
+
+	err = l.Check(Budget{Monthly: 5}, testPrices, []string{"gpt-4o"}, 1_000_000)
+	budgetErr, ok = errors.AsType[*BudgetError](err)
+	require.True(t, ok)
+	assert.Equal(t, "monthly", budgetErr.Limit)
+	assert.InDelta(t, 6, budgetErr.Cost, 1e-9)
+}
+
+type fakeLLM struct {
+	llm.LLM
+}
+
+func (fakeLLM) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		respChan <- llm.Response{Content: "ok"}
+		respChan <- llm.Response{Usage: &llm.Usage{InputTokens: 7, OutputTokens: 3}}
+	}()
+	return respChan, errChan
+}
+
+func (fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	return llm.Response{Content: "ok", Usage: &llm.Usage{InputTokens: 5, OutputTokens: 1}}, nil
+}
+
+func TestRecord(t *testing.T) {
+	l := New(t.TempDir())
+	client := Record(fakeLLM{}, l, "/src/bark", "openai", "gpt-4o")
+
+	_, err := client.Generate(WithTask(context.Background(), TaskCommit), "", "", llm.Options{})
+	require.NoError(t, err)
+
+	respChan, errChan := client.Stream(WithTask(context.Background(), TaskReview), "", "", llm.Options{})
+	var content string
+	for resp := range respChan {
+		content += resp.Content
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, "ok", content)
+
+	entries, err := l.Entries(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, TaskCommit, entries[0].Task)
+	assert.Equal(t, int64(5), entries[0].InputTokens)
+	assert.Equal(t, TaskReview, entries[1].Task)
+	assert.Equal(t, int64(3), entries[1].OutputTokens)
+	assert.Equal(t, "/src/bark", entries[1].Repo)
+}
@@ -0,0 +1,238 @@
+package ledger
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/pelletier/go-toml/v2"
+)
+
+const pricesFileName = "prices.toml"
+
+// defaultPrices seeds prices.toml the first time it is needed. Providers
+// change their prices, so the file is meant to be edited.
+const defaultPrices = `# Prices used by "bark stats" and budget checks, in USD per million tokens.
+# A model matches its exact name, or else the longest key it starts with, so
+# "gpt-4o" also prices "gpt-4o-2024-08-06". Models without a price (e.g. local
+# Ollama models) are counted but not costed. Prompt tokens read from or
+# written to the provider's cache are priced at cache_read and cache_write,
+# which default to the input price, and cache_storage is charged per million
+# tokens per hour they are kept. Check your provider's pricing page; these
+# defaults may be out of date.
+
+[models]
+"gpt-4o" = { input = 2.50, output = 10.00, cache_read = 1.25 }
+"gpt-4o-mini" = { input = 0.15, output = 0.60, cache_read = 0.075 }
+"gpt-4.1" = { input = 2.00, output = 8.00, cache_read = 0.50 }
+"gpt-4.1-mini" = { input = 0.40, output = 1.60, cache_read = 0.10 }
+"claude-opus-4" = { input = 15.00, output = 75.00, cache_read = 1.50, cache_write = 18.75 }
+"claude-sonnet-4" = { input = 3.00, output = 15.00, cache_read = 0.30, cache_write = 3.75 }
+"claude-3-5-haiku" = { input = 0.80, output = 4.00, cache_read = 0.08, cache_write = 1.00 }
+"gemini-2.5-pro" = { input = 1.25, output = 10.00, cache_read = 0.31, cache_write = 0.31, cache_storage = 4.50 }
+"gemini-2.5-flash" = { input = 0.30, output = 2.50, cache_read = 0.075, cache_write = 0.075, cache_storage = 1.00 }
+`
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Input  float64 `toml:"input"`
+	Output float64 `toml:"output"`
+	// CacheRead and CacheWrite price the prompt tokens read from and written
+	// to the cache; zero means the input price. CacheStorage is charged per
+	// hour the written tokens are kept.
+	CacheRead    float64 `toml:"cache_read,omitempty"`
+	CacheWrite   float64 `toml:"cache_write,omitempty"`
+	CacheStorage float64 `toml:"cache_storage,omitempty"`
+}
+
+// Prices maps model names (or name prefixes) to prices.
+type Prices struct {
+	Models map[string]Price `toml:"models"`
+}
+
+// PricesPath returns the location of the price table under storage.
+func PricesPath(storage string) string {
+	return filepath.Join(storage, pricesFileName)
+}
+
+// LoadPrices reads the price table under storage, writing the defaults first
+// if it doesn't exist.
+func LoadPrices(storage string) (Prices, error) {
+	path := PricesPath(storage)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte(defaultPrices)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return Prices{}, fmt.Errorf("failed to write price table: %w", err)
+		}
+	} else if err != nil {
+		return Prices{}, fmt.Errorf("failed to read price table: %w", err)
+	}
+
+	var prices Prices
+	if err := toml.Unmarshal(data, &prices); err != nil {
+		return Prices{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return prices, nil
+}
+
+// Lookup returns the price of model.
+func (p Prices) Lookup(model string) (Price, bool) {
+	if price, ok := p.Models[model]; ok {
+		return price, true
+	}
+
+	var (
+		best  Price
+		found string
+	)
+	for key, price := range p.Models {
+		if strings.HasPrefix(model, key) && len(key) > len(found) {
+			best, found = price, key
+		}
+	}
+
+	return best, found != ""
+}
+
+// Cost returns the cost of a request, or false when model has no price.
+func (p Prices) Cost(model string, inputTokens, outputTokens int64) (float64, bool) {
+	price, ok := p.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1_000_000, true
+}
+
+// EntryCost returns the cost of a recorded request, pricing its cached
+// prompt tokens at the cache rates, or false when its model has no price.
+func (p Prices) EntryCost(e Entry) (float64, bool) {
+	price, ok := p.Lookup(e.Model)
+	if !ok {
+		return 0, false
+	}
+
+	uncached := max(e.InputTokens-e.CacheReadTokens-e.CacheWriteTokens, 0)
+	cost := float64(uncached)*price.Input +
+		float64(e.CacheReadTokens)*cmp.Or(price.CacheRead, price.Input) +
+		float64(e.CacheWriteTokens)*cmp.Or(price.CacheWrite, price.Input) +
+		float64(e.CacheWriteTokens)*e.CacheTTL.Hours()*price.CacheStorage +
+		float64(e.OutputTokens)*price.Output
+	return cost / 1_000_000, true
+}
+
+// Budget limits spending. Zero limits are disabled.
+type Budget struct {
+	Monthly float64
+	PerRun  float64
+	// Abort makes callers refuse the request instead of warning.
+	Abort bool
+}
+
+// BudgetFromConfig reads the budget settings.
+func BudgetFromConfig(cfg config.Config) Budget {
+	return Budget{
+		Monthly: cfg.GetMonthlyBudget(),
+		PerRun:  cfg.GetRunBudget(),
+		Abort:   cfg.GetBudgetAction() == config.BudgetActionAbort,
+	}
+}
+
+// BudgetError reports a request that would exceed a budget.
+type BudgetError struct {
+	// Limit is "monthly" or "per-run".
+	Limit  string
+	Budget float64
+	Cost   float64
+	// Abort is set when the budget action is abort, so the request must not
+	// be sent; otherwise callers only warn.
+	Abort bool
+}
+
+func (e *BudgetError) Error() string {
+	if e.Limit == "monthly" {
+		return fmt.Sprintf("this request would bring this month's spend to about $%.2f, over the monthly budget of $%.2f", e.Cost, e.Budget)
+	}
+	return fmt.Sprintf("this request is estimated to cost $%.2f in input tokens alone, over the per-run budget of $%.2f", e.Cost, e.Budget)
+}
+
+// Check returns a *BudgetError when sending promptTokens to any of models,
+// the model of the request and its fallbacks, would exceed the budget. Which
+// of them serves the request is unknown before it is sent, so the request is
+// priced at the most expensive; output tokens are unknown too, so the
+// estimate only prices the input. Unpriced models never exceed a budget.
+func (l *Ledger) Check(budget Budget, prices Prices, models []string, promptTokens int) error {
+	if budget.Monthly <= 0 && budget.PerRun <= 0 {
+		return nil
+	}
+
+	var (
+		cost   float64
+		priced bool
+	)
+	for _, model := range models {
+		if c, ok := prices.Cost(model, int64(promptTokens), 0); ok {
+			cost, priced = max(cost, c), true
+		}
+	}
+	if !priced {
+		return nil
+	}
+
+	if budget.PerRun > 0 && cost > budget.PerRun {
+		return &BudgetError{Limit: "per-run", Budget: budget.PerRun, Cost: cost, Abort: budget.Abort}
+	}
+
+	if budget.Monthly > 0 {
+		now := time.Now()
+		entries, err := l.Entries(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+		if err != nil {
+			return err
+		}
+
+		var spent float64
+		for _, e := range entries {
+			c, _ := prices.EntryCost(e)
+			spent += c
+		}
+		if spent+cost > budget.Monthly {
+			return &BudgetError{Limit: "monthly", Budget: budget.Monthly, Cost: spent + cost, Abort: budget.Abort}
+		}
+	}
+
+	return nil
+}
+
+// CheckBudget checks a request of promptTokens to the model of profile, or
+// to one of its fallbacks, against the configured budgets, using the ledger
+// and prices under storage.
+func CheckBudget(cfg config.Config, storage string, profile config.Profile, promptTokens int) error {
+	budget := BudgetFromConfig(cfg)
+	if budget.Monthly <= 0 && budget.PerRun <= 0 {
+		return nil
+	}
+
+	prices, err := LoadPrices(storage)
+	if err != nil {
+		return err
+	}
+
+	models := []string{profile.Model}
+	fallbacks, err := cfg.ResolveFallbacks(profile)
+	if err != nil {
+		return err
+	}
+	for _, p := range fallbacks {
+		models = append(models, p.Model)
+	}
+
+	return New(storage).Check(budget, prices, models, promptTokens)
+}
@@ -0,0 +1,105 @@
+package ledger
+
+import (
+	"cmp"
+	"context"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+type taskKey struct{}
+
+// WithTask tags the requests made with ctx as being for task.
+func WithTask(ctx context.Context, task string) context.Context {
+	return context.WithValue(ctx, taskKey{}, task)
+}
+
+func taskFrom(ctx context.Context) string {
+	task, _ := ctx.Value(taskKey{}).(string)
+	return task
+}
+
+// recorder is an llm.LLM that appends an entry to the ledger for every
+// request that reports usage.
+type recorder struct {
+	llm.LLM
+	ledger   *Ledger
+	repo     string
+	provider string
+	model    string
+}
+
+// Record wraps client so every request it makes is added to l. The task is
+// read from the request context (see WithTask). Recording is best effort: a
+// ledger that can't be written never fails a request.
+func Record(client llm.LLM, l *Ledger, repo, provider, model string) llm.LLM {
+	return &recorder{LLM: client, ledger: l, repo: repo, provider: provider, model: model}
+}
+
+// record appends the usage of resp, attributed to the provider and model
+// that served it when a fallback chain says so.
+func (r *recorder) record(ctx context.Context, resp llm.Response, startedAt time.Time) {
+	if resp.Usage == nil {
+		return
+	}
+
+	provider, model := cmp.Or(resp.Provider, r.provider), cmp.Or(resp.Model, r.model)
+	_ = r.ledger.Append(NewEntry(r.repo, taskFrom(ctx), provider, model, *resp.Usage, time.Since(startedAt)))
+}
+
+func (r *recorder) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	startedAt := time.Now()
+	respChan, errChan := r.LLM.Stream(ctx, system, prompt, opts)
+	return r.watch(ctx, respChan, startedAt), errChan
+}
+
+func (r *recorder) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	startedAt := time.Now()
+	respChan, errChan := r.LLM.Chat(ctx, system, messages, opts)
+	return r.watch(ctx, respChan, startedAt), errChan
+}
+
+// watch forwards a stream unchanged and records its usage once it ends. When
+// the reader gives up (ctx is done) the rest of the stream is drained so the
+// provider is never left blocked on a send.
+func (r *recorder) watch(ctx context.Context, in <-chan llm.Response, startedAt time.Time) <-chan llm.Response {
+	out := make(chan llm.Response)
+
+	go func() {
+		defer close(out)
+
+		var last llm.Response
+		for resp := range in {
+			if resp.Usage != nil {
+				last = resp
+			}
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		r.record(ctx, last, startedAt)
+	}()
+
+	return out
+}
+
+func (r *recorder) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	startedAt := time.Now()
+	resp, err := r.LLM.Generate(ctx, system, prompt, opts)
+	if err == nil {
+		r.record(ctx, resp, startedAt)
+	}
+	return resp, err
+}
+
+func (r *recorder) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	startedAt := time.Now()
+	resp, err := r.LLM.GenerateStructured(ctx, system, prompt, schema, opts)
+	if err == nil {
+		r.record(ctx, resp, startedAt)
+	}
+	return resp, err
+}
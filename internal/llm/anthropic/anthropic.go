@@ -1,8 +1,10 @@
 package anthropic
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
@@ -10,28 +12,130 @@ import (
 	"github.com/ionut-t/bark/v2/internal/llm"
 )
 
-const maxTokens = 16000
+// defaultMaxTokens caps the output when no max_tokens is configured.
+const defaultMaxTokens = 16000
+
+// Limits are the generation options Anthropic accepts. Reasoning efforts
+// are sent as thinking budgets.
+var Limits = llm.Limits{Provider: "Anthropic", MaxTemperature: 1, Stop: true, ThinkingBudget: true, MinThinkingBudget: 1024}
 
 type Anthropic struct {
 	model  string
 	client anthropic.Client
 }
 
-func applySystem(params *anthropic.MessageNewParams, system string) {
-	if system != "" {
-		params.System = []anthropic.TextBlockParam{{Text: system}}
+// applySystem sets the system prompt, marking it for caching when opts ask.
+func applySystem(params *anthropic.MessageNewParams, system string, opts llm.Options) {
+	if system == "" {
+		return
+	}
+
+	block := anthropic.TextBlockParam{Text: system}
+	if opts.Cache {
+		block.CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+	params.System = []anthropic.TextBlockParam{block}
+}
+
+// Validate checks opts against Limits and the constraints extended thinking
+// adds: the budget is part of max_tokens, and sampling can't be tuned.
+func Validate(opts llm.Options) error {
+	if err := opts.Validate(Limits); err != nil {
+		return err
 	}
+	if !opts.Thinking() {
+		return nil
+	}
+
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w for Anthropic: %s", llm.ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+	if budget := opts.ThinkingTokens(); opts.MaxTokens > 0 && opts.MaxTokens <= budget {
+		return invalid("max_tokens (%d) must be greater than the thinking budget (%d)", opts.MaxTokens, budget)
+	}
+	if opts.Temperature != nil || opts.TopP != nil {
+		return invalid("temperature and top_p can't be set with reasoning")
+	}
+	return nil
 }
 
-func New(model string, apiKey string) *Anthropic {
-	client := anthropic.NewClient(option.WithAPIKey(apiKey))
+// applyOptions sets opts on params. Without max_tokens, a thinking budget
+// is added to the default so the answer keeps its usual room.
+func applyOptions(params *anthropic.MessageNewParams, opts llm.Options) {
+	params.MaxTokens = cmp.Or(opts.MaxTokens, defaultMaxTokens)
+	if budget := opts.ThinkingTokens(); budget > 0 {
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+		if opts.MaxTokens == 0 {
+			params.MaxTokens += budget
+		}
+	}
+	if opts.Temperature != nil {
+		params.Temperature = anthropic.Float(*opts.Temperature)
+	}
+	if opts.TopP != nil {
+		params.TopP = anthropic.Float(*opts.TopP)
+	}
+	params.StopSequences = opts.Stop
+}
+
+// toMessageParams converts messages. When caching, the cached prefix of the
+// first message gets its own block ending in a cache breakpoint.
+func toMessageParams(messages []llm.Message, opts llm.Options) []anthropic.MessageParam {
+	params := make([]anthropic.MessageParam, len(messages))
+	for i, m := range messages {
+		if m.Role == llm.RoleAssistant {
+			params[i] = anthropic.NewAssistantMessage(anthropic.NewTextBlock(m.Content))
+			continue
+		}
+
+		prefix, rest := "", m.Content
+		if i == 0 {
+			prefix, rest = opts.SplitPrompt(m.Content)
+		}
+		if prefix == "" {
+			params[i] = anthropic.NewUserMessage(anthropic.NewTextBlock(rest))
+			continue
+		}
+
+		cached := anthropic.TextBlockParam{Text: prefix, CacheControl: anthropic.NewCacheControlEphemeralParam()}
+		blocks := []anthropic.ContentBlockParamUnion{{OfText: &cached}}
+		if rest != "" {
+			blocks = append(blocks, anthropic.NewTextBlock(rest))
+		}
+		params[i] = anthropic.NewUserMessage(blocks...)
+	}
+	return params
+}
+
+// toUsage converts usage, counting cached tokens as input. thinking is the
+// response's thinking text, which the API doesn't count separately.
+func toUsage(usage anthropic.Usage, thinking string) *llm.Usage {
+	input := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	return &llm.Usage{
+		InputTokens:      input,
+		CacheReadTokens:  usage.CacheReadInputTokens,
+		CacheWriteTokens: usage.CacheCreationInputTokens,
+		OutputTokens:     usage.OutputTokens,
+		ThinkingTokens:   min(int64(llm.EstimateTokens(thinking)), usage.OutputTokens),
+		TotalTokens:      input + usage.OutputTokens,
+	}
+}
+
+// New returns a client for model. opts can override the endpoint, timeout
+// and other request options.
+func New(model string, apiKey string, opts ...option.RequestOption) *Anthropic {
+	client := anthropic.NewClient(append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)...)
 	return &Anthropic{
 		model:  model,
 		client: client,
 	}
 }
 
-func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan llm.Response, <-chan error) {
+func (a *Anthropic) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return a.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+func (a *Anthropic) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
 	out := make(chan llm.Response)
 	errChan := make(chan error, 1)
 
@@ -44,14 +148,17 @@ func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan l
 			return
 		}
 
+		if err := Validate(opts); err != nil {
+			errChan <- err
+			return
+		}
+
 		params := anthropic.MessageNewParams{
-			Model:     anthropic.Model(a.model),
-			MaxTokens: maxTokens,
-			Messages: []anthropic.MessageParam{
-				anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
-			},
+			Model:    anthropic.Model(a.model),
+			Messages: toMessageParams(messages, opts),
 		}
-		applySystem(&params, system)
+		applySystem(&params, system, opts)
+		applyOptions(&params, opts)
 
 		stream := a.client.Messages.NewStreaming(ctx, params)
 
@@ -61,7 +168,10 @@ func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan l
 			}
 		}()
 
-		var usage *llm.Usage
+		var (
+			usage    *llm.Usage
+			thinking int64
+		)
 		for stream.Next() {
 			select {
 			case <-ctx.Done():
@@ -76,27 +186,39 @@ func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan l
 				if usage == nil {
 					usage = &llm.Usage{}
 				}
-				usage.InputTokens = ev.Message.Usage.InputTokens
+				usage.CacheReadTokens = ev.Message.Usage.CacheReadInputTokens
+				usage.CacheWriteTokens = ev.Message.Usage.CacheCreationInputTokens
+				usage.InputTokens = ev.Message.Usage.InputTokens + usage.CacheReadTokens + usage.CacheWriteTokens
 				usage.TotalTokens = usage.InputTokens + usage.OutputTokens
 			case anthropic.MessageDeltaEvent:
 				if usage == nil {
 					usage = &llm.Usage{}
 				}
 				usage.OutputTokens = ev.Usage.OutputTokens
-				// The delta carries the up-to-date cumulative input count when
-				// the API sends it; otherwise keep the message_start value.
+				// The delta carries the up-to-date cumulative input counts when
+				// the API sends them; otherwise keep the message_start values.
 				if ev.Usage.InputTokens > 0 {
-					usage.InputTokens = ev.Usage.InputTokens
+					usage.CacheReadTokens = ev.Usage.CacheReadInputTokens
+					usage.CacheWriteTokens = ev.Usage.CacheCreationInputTokens
+					usage.InputTokens = ev.Usage.InputTokens + usage.CacheReadTokens + usage.CacheWriteTokens
 				}
 				usage.TotalTokens = usage.InputTokens + usage.OutputTokens
 			case anthropic.ContentBlockDeltaEvent:
-				textDelta, ok := ev.Delta.AsAny().(anthropic.TextDelta)
-				if !ok || textDelta.Text == "" {
+				var resp llm.Response
+				switch delta := ev.Delta.AsAny().(type) {
+				case anthropic.TextDelta:
+					resp.Content = delta.Text
+				case anthropic.ThinkingDelta:
+					resp.Thinking = delta.Thinking
+					thinking += int64(llm.EstimateTokens(delta.Thinking))
+				}
+				if resp.Content == "" && resp.Thinking == "" {
 					continue
 				}
+				resp.Time = time.Now()
 
 				select {
-				case out <- llm.Response{Content: textDelta.Text, Time: time.Now()}:
+				case out <- resp:
 				case <-ctx.Done():
 					errChan <- ctx.Err()
 					return
@@ -107,6 +229,8 @@ func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan l
 		if err := stream.Err(); err != nil {
 			errChan <- err
 		} else if usage != nil {
+			// The API counts thinking as output without breaking it down.
+			usage.ThinkingTokens = min(thinking, usage.OutputTokens)
 			select {
 			case out <- llm.Response{Usage: usage, Time: time.Now()}:
 			case <-ctx.Done():
@@ -119,21 +243,23 @@ func (a *Anthropic) Stream(ctx context.Context, system, prompt string) (<-chan l
 	return out, errChan
 }
 
-func (a *Anthropic) Generate(ctx context.Context, system, prompt string) (llm.Response, error) {
+func (a *Anthropic) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
 	if ctx.Err() != nil {
 		return llm.Response{}, ctx.Err()
 	}
 
+	if err := Validate(opts); err != nil {
+		return llm.Response{}, err
+	}
+
 	params := anthropic.MessageNewParams{
-		Model:     anthropic.Model(a.model),
-		MaxTokens: maxTokens,
-		Messages: []anthropic.MessageParam{
-			anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
-		},
+		Model:    anthropic.Model(a.model),
+		Messages: toMessageParams([]llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts),
 	}
-	applySystem(&params, system)
+	applySystem(&params, system, opts)
+	applyOptions(&params, opts)
 
-	resp, err := a.client.Messages.New(ctx, params)
+	resp, err := a.create(ctx, params)
 	if err != nil {
 		return llm.Response{}, fmt.Errorf("anthropic request failed: %w", err)
 	}
@@ -142,18 +268,160 @@ func (a *Anthropic) Generate(ctx context.Context, system, prompt string) (llm.Re
 		return llm.Response{}, fmt.Errorf("no response from anthropic")
 	}
 
-	text, ok := resp.Content[0].AsAny().(anthropic.TextBlock)
-	if !ok || text.Text == "" {
+	// With thinking enabled, thinking blocks precede the text.
+	var text, thinking strings.Builder
+	for _, block := range resp.Content {
+		switch b := block.AsAny().(type) {
+		case anthropic.TextBlock:
+			text.WriteString(b.Text)
+		case anthropic.ThinkingBlock:
+			thinking.WriteString(b.Thinking)
+		}
+	}
+	if text.Len() == 0 {
 		return llm.Response{}, fmt.Errorf("empty response from anthropic")
 	}
 
 	return llm.Response{
-		Content: text.Text,
-		Time:    time.Now(),
-		Usage: &llm.Usage{
-			InputTokens:  resp.Usage.InputTokens,
-			OutputTokens: resp.Usage.OutputTokens,
-			TotalTokens:  resp.Usage.InputTokens + resp.Usage.OutputTokens,
-		},
+		Content:  text.String(),
+		Thinking: thinking.String(),
+		Time:     time.Now(),
+		Usage:    toUsage(resp.Usage, thinking.String()),
 	}, nil
 }
+
+// GenerateStructured forces a single tool call whose input schema is the
+// requested schema; the tool input is the structured response. Forced tool
+// use can't be combined with extended thinking, so reasoning is ignored.
+func (a *Anthropic) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	if ctx.Err() != nil {
+		return llm.Response{}, ctx.Err()
+	}
+
+	if err := Validate(opts); err != nil {
+		return llm.Response{}, err
+	}
+
+	tool := anthropic.ToolUnionParamOfTool(toolInputSchema(schema.Definition), schema.Name)
+	if schema.Description != "" {
+		tool.OfTool.Description = anthropic.String(schema.Description)
+	}
+
+	params := anthropic.MessageNewParams{
+		Model:      anthropic.Model(a.model),
+		Tools:      []anthropic.ToolUnionParam{tool},
+		ToolChoice: anthropic.ToolChoiceParamOfTool(schema.Name),
+		Messages:   toMessageParams([]llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts),
+	}
+	applySystem(&params, system, opts)
+	opts.Reasoning, opts.ThinkingBudget = "", 0
+	applyOptions(&params, opts)
+
+	resp, err := a.create(ctx, params)
+	if err != nil {
+		return llm.Response{}, fmt.Errorf("anthropic request failed: %w", err)
+	}
+
+	for _, block := range resp.Content {
+		toolUse, ok := block.AsAny().(anthropic.ToolUseBlock)
+		if !ok || toolUse.Name != schema.Name {
+			continue
+		}
+
+		return llm.Response{
+			Content: string(toolUse.Input),
+			Time:    time.Now(),
+			Usage:   toUsage(resp.Usage, ""),
+		}, nil
+	}
+
+	return llm.Response{}, fmt.Errorf("no structured response from anthropic")
+}
+
+// create sends a non-streaming request. The SDK refuses those whose output
+// could take over ten minutes, so requests allowed more than the default
+// output are streamed and accumulated instead.
+func (a *Anthropic) create(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	if params.MaxTokens <= defaultMaxTokens {
+		return a.client.Messages.New(ctx, params)
+	}
+
+	stream := a.client.Messages.NewStreaming(ctx, params)
+	defer func() { _ = stream.Close() }()
+
+	var message anthropic.Message
+	for stream.Next() {
+		if err := message.Accumulate(stream.Current()); err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// toolInputSchema splits a JSON Schema object into the typed fields the SDK
+// exposes and passes everything else through untouched.
+func toolInputSchema(definition map[string]any) anthropic.ToolInputSchemaParam {
+	var schema anthropic.ToolInputSchemaParam
+	extra := make(map[string]any)
+
+	for key, value := range definition {
+		switch key {
+		case "type":
+		case "properties":
+			schema.Properties = value
+		case "required":
+			if required, ok := value.([]string); ok {
+				schema.Required = required
+			}
+		default:
+			extra[key] = value
+		}
+	}
+
+	if len(extra) > 0 {
+		schema.ExtraFields = extra
+	}
+
+	return schema
+}
+
+// ListModels lists the models available to the API key.
+func (a *Anthropic) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var models []llm.Model
+	iter := a.client.Models.ListAutoPaging(ctx, anthropic.ModelListParams{})
+	for iter.Next() {
+		info := iter.Current()
+		models = append(models, llm.Model{
+			ID:              info.ID,
+			DisplayName:     info.DisplayName,
+			ContextWindow:   info.MaxInputTokens,
+			MaxOutputTokens: info.MaxTokens,
+			Capabilities:    capabilities(info.Capabilities),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list Anthropic models: %w", err)
+	}
+	return models, nil
+}
+
+func capabilities(c anthropic.ModelCapabilities) []string {
+	var list []string
+	if c.Thinking.Supported {
+		list = append(list, llm.CapabilityThinking)
+	}
+	if c.ImageInput.Supported {
+		list = append(list, llm.CapabilityVision)
+	}
+	if c.PDFInput.Supported {
+		list = append(list, llm.CapabilityPDF)
+	}
+	if c.StructuredOutputs.Supported {
+		list = append(list, llm.CapabilityStructured)
+	}
+	return list
+}
@@ -0,0 +1,339 @@
+// Package chatcompletions implements llm.LLM over the OpenAI Chat Completions
+// API, which Ollama and most self-hosted and third-party gateways expose.
+package chatcompletions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+	"github.com/openai/openai-go/v3/packages/respjson"
+	"github.com/openai/openai-go/v3/shared"
+)
+
+type ChatCompletions struct {
+	// name identifies the provider in errors, e.g. "Ollama".
+	name   string
+	model  string
+	client openai.Client
+}
+
+// Limits returns the generation options the API accepts, naming the
+// provider as name in errors.
+func Limits(name string) llm.Limits {
+	return llm.Limits{Provider: name, MaxTemperature: 2, Stop: true, MaxStop: 4, Reasoning: true}
+}
+
+func applyOptions(params *openai.ChatCompletionNewParams, opts llm.Options) {
+	if opts.MaxTokens > 0 {
+		params.MaxTokens = openai.Int(opts.MaxTokens)
+	}
+	if opts.Temperature != nil {
+		params.Temperature = openai.Float(*opts.Temperature)
+	}
+	if opts.TopP != nil {
+		params.TopP = openai.Float(*opts.TopP)
+	}
+	if len(opts.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: opts.Stop}
+	}
+	if opts.Reasoning != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(opts.Reasoning)
+	}
+}
+
+func toUsage(usage openai.CompletionUsage) *llm.Usage {
+	if usage.TotalTokens == 0 {
+		return nil
+	}
+	return &llm.Usage{
+		InputTokens:     usage.PromptTokens,
+		CacheReadTokens: usage.PromptTokensDetails.CachedTokens,
+		OutputTokens:    usage.CompletionTokens,
+		ThinkingTokens:  usage.CompletionTokensDetails.ReasoningTokens,
+		TotalTokens:     usage.TotalTokens,
+	}
+}
+
+// reasoningFields are the non-standard fields servers return reasoning in:
+// reasoning_content (DeepSeek, vLLM) and reasoning (Ollama, OpenRouter).
+var reasoningFields = []string{"reasoning_content", "reasoning"}
+
+// reasoning returns the reasoning text in a message or delta's extra fields.
+func reasoning(extra map[string]respjson.Field) string {
+	for _, name := range reasoningFields {
+		field, ok := extra[name]
+		if !ok {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal([]byte(field.Raw()), &text); err == nil && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+func buildMessages(system, prompt string) []openai.ChatCompletionMessageParamUnion {
+	return buildChatMessages(system, []llm.Message{{Role: llm.RoleUser, Content: prompt}})
+}
+
+func buildChatMessages(system string, messages []llm.Message) []openai.ChatCompletionMessageParamUnion {
+	msgs := []openai.ChatCompletionMessageParamUnion{}
+	if system != "" {
+		msgs = append(msgs, openai.SystemMessage(system))
+	}
+	for _, m := range messages {
+		if m.Role == llm.RoleAssistant {
+			msgs = append(msgs, openai.AssistantMessage(m.Content))
+		} else {
+			msgs = append(msgs, openai.UserMessage(m.Content))
+		}
+	}
+	return msgs
+}
+
+// New returns a client for the API at baseURL (including any /v1 suffix).
+// opts can set the API key, headers and other request options.
+func New(name, model, baseURL string, opts ...option.RequestOption) *ChatCompletions {
+	opts = append([]option.RequestOption{option.WithBaseURL(baseURL)}, opts...)
+	return &ChatCompletions{name: name, model: model, client: openai.NewClient(opts...)}
+}
+
+func (c *ChatCompletions) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return c.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+func (c *ChatCompletions) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		if ctx.Err() != nil {
+			errChan <- ctx.Err()
+			return
+		}
+
+		if err := opts.Validate(Limits(c.name)); err != nil {
+			errChan <- err
+			return
+		}
+
+		params := openai.ChatCompletionNewParams{
+			Model:         openai.ChatModel(c.model),
+			Messages:      buildChatMessages(system, messages),
+			StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
+		}
+		applyOptions(&params, opts)
+
+		stream := c.client.Chat.Completions.NewStreaming(ctx, params)
+		defer func() {
+			if err := stream.Close(); err != nil {
+				errChan <- err
+			}
+		}()
+
+		var usage *llm.Usage
+		for stream.Next() {
+			select {
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			default:
+			}
+
+			chunk := stream.Current()
+			if chunkUsage := toUsage(chunk.Usage); chunkUsage != nil {
+				usage = chunkUsage
+			}
+
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+
+			delta := chunk.Choices[0].Delta
+			resp := llm.Response{Content: delta.Content, Thinking: reasoning(delta.JSON.ExtraFields)}
+			if resp.Content == "" && resp.Thinking == "" {
+				continue
+			}
+			resp.Time = time.Now()
+
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			errChan <- err
+		} else if usage != nil {
+			select {
+			case out <- llm.Response{Usage: usage, Time: time.Now()}:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return out, errChan
+}
+
+func (c *ChatCompletions) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	if ctx.Err() != nil {
+		return llm.Response{}, ctx.Err()
+	}
+
+	if err := opts.Validate(Limits(c.name)); err != nil {
+		return llm.Response{}, err
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.ChatModel(c.model),
+		Messages: buildMessages(system, prompt),
+	}
+	applyOptions(&params, opts)
+
+	resp, err := c.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return llm.Response{}, fmt.Errorf("%s request failed: %w", c.name, err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return llm.Response{}, fmt.Errorf("no response from %s", c.name)
+	}
+
+	message := resp.Choices[0].Message
+	if message.Content == "" {
+		return llm.Response{}, fmt.Errorf("empty response from %s", c.name)
+	}
+
+	return llm.Response{
+		Content:  message.Content,
+		Thinking: reasoning(message.JSON.ExtraFields),
+		Time:     time.Now(),
+		Usage:    toUsage(resp.Usage),
+	}, nil
+}
+
+func (c *ChatCompletions) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	if ctx.Err() != nil {
+		return llm.Response{}, ctx.Err()
+	}
+
+	if err := opts.Validate(Limits(c.name)); err != nil {
+		return llm.Response{}, err
+	}
+
+	jsonSchema := shared.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:   schema.Name,
+		Schema: schema.Definition,
+	}
+	if schema.Description != "" {
+		jsonSchema.Description = openai.String(schema.Description)
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.ChatModel(c.model),
+		Messages: buildMessages(system, prompt),
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{JSONSchema: jsonSchema},
+		},
+	}
+	applyOptions(&params, opts)
+
+	resp, err := c.client.Chat.Completions.New(ctx, params)
+	if err != nil {
+		return llm.Response{}, fmt.Errorf("%s request failed: %w", c.name, err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return llm.Response{}, fmt.Errorf("no response from %s", c.name)
+	}
+
+	message := resp.Choices[0].Message
+	if message.Content == "" {
+		return llm.Response{}, fmt.Errorf("empty response from %s", c.name)
+	}
+
+	return llm.Response{
+		Content:  message.Content,
+		Thinking: reasoning(message.JSON.ExtraFields),
+		Time:     time.Now(),
+		Usage:    toUsage(resp.Usage),
+	}, nil
+}
+
+// contextFields are the non-standard fields servers report the context
+// window in: context_length (OpenRouter), max_context_length (LM Studio)
+// and max_model_len (vLLM).
+var contextFields = []string{"context_length", "max_context_length", "max_model_len"}
+
+// capabilityParams maps the supported_parameters OpenRouter reports to
+// capabilities.
+var capabilityParams = map[string]string{
+	"reasoning":          llm.CapabilityThinking,
+	"tools":              llm.CapabilityTools,
+	"structured_outputs": llm.CapabilityStructured,
+}
+
+// ListModels lists the models the server serves, with the context window
+// and capabilities of servers that report them.
+func (c *ChatCompletions) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var models []llm.Model
+	iter := c.client.Models.ListAutoPaging(ctx)
+	for iter.Next() {
+		model := iter.Current()
+		models = append(models, llm.Model{
+			ID:            model.ID,
+			ContextWindow: contextWindow(model.JSON.ExtraFields),
+			Capabilities:  modelCapabilities(model.JSON.ExtraFields),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list %s models: %w", c.name, err)
+	}
+	return models, nil
+}
+
+func contextWindow(extra map[string]respjson.Field) int64 {
+	for _, name := range contextFields {
+		field, ok := extra[name]
+		if !ok {
+			continue
+		}
+		var tokens int64
+		if err := json.Unmarshal([]byte(field.Raw()), &tokens); err == nil && tokens > 0 {
+			return tokens
+		}
+	}
+	return 0
+}
+
+func modelCapabilities(extra map[string]respjson.Field) []string {
+	field, ok := extra["supported_parameters"]
+	if !ok {
+		return nil
+	}
+	var params []string
+	if err := json.Unmarshal([]byte(field.Raw()), &params); err != nil {
+		return nil
+	}
+
+	var list []string
+	for _, param := range params {
+		if capability, ok := capabilityParams[param]; ok {
+			list = append(list, capability)
+		}
+	}
+	return list
+}
@@ -0,0 +1,284 @@
+// Package fallback chains llm.LLM clients so a request the first can't serve
+// is sent to the next.
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
+)
+
+// ErrModelNotFound is returned when a provider doesn't serve the model it is
+// asked for. That is a mistake in the configuration rather than an outage,
+// so the chain stops instead of falling back.
+var ErrModelNotFound = errors.New("model not found")
+
+// cooldown is how long a client that failed is skipped by later requests,
+// so an outage doesn't cost every request its own retries.
+const cooldown = 5 * time.Minute
+
+// Client is one link of a chain.
+type Client struct {
+	llm.LLM
+	// Name is the profile the client was created from, if any.
+	Name     string
+	Provider string
+	Model    string
+	// Options are the generation options of the client's profile. They
+	// replace those a request is made with, except for caching, which
+	// depends on the request rather than the profile.
+	Options llm.Options
+}
+
+func (c Client) String() string {
+	s := c.Provider + "/" + c.Model
+	if c.Name != "" {
+		s = fmt.Sprintf("%s (profile %q)", s, c.Name)
+	}
+	return s
+}
+
+// Switch reports that From failed with Err and the request moves on to To.
+type Switch struct {
+	From, To Client
+	Err      error
+}
+
+type notifyKey struct{}
+
+// WithNotify makes requests made with ctx call notify each time they fall
+// back to the next client.
+func WithNotify(ctx context.Context, notify func(Switch)) context.Context {
+	return context.WithValue(ctx, notifyKey{}, notify)
+}
+
+func notifyFrom(ctx context.Context) func(Switch) {
+	notify, _ := ctx.Value(notifyKey{}).(func(Switch))
+	return notify
+}
+
+// chain is an llm.LLM that sends each request to the first available client.
+type chain struct {
+	clients []Client
+
+	mu     sync.Mutex
+	failed map[int]time.Time
+	now    func() time.Time
+}
+
+// New returns an llm.LLM that tries primary, then each of fallbacks in order,
+// until one serves the request. A client is passed over when it fails with an
+// auth, quota or availability error (see Unavailable) before producing any
+// output; other errors, and errors after output began, are returned.
+//
+// The options of primary are those each request is made with. Responses
+// name the provider and model that served them.
+func New(primary Client, fallbacks ...Client) llm.LLM {
+	return &chain{
+		clients: append([]Client{primary}, fallbacks...),
+		failed:  make(map[int]time.Time),
+		now:     time.Now,
+	}
+}
+
+// order returns the indexes of the clients to try: those that haven't
+// failed recently first, then the rest, each in chain order.
+func (c *chain) order() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var healthy, cooling []int
+	for i := range c.clients {
+		if at, ok := c.failed[i]; ok && c.now().Sub(at) < cooldown {
+			cooling = append(cooling, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, cooling...)
+}
+
+func (c *chain) markFailed(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed[i] = c.now()
+}
+
+func (c *chain) markServed(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.failed, i)
+}
+
+// options are the options to send client i a request made with opts.
+func (c *chain) options(i int, opts llm.Options) llm.Options {
+	if i == 0 {
+		return opts
+	}
+	o := c.clients[i].Options
+	o.Cache, o.CachePrefix = opts.Cache, opts.CachePrefix
+	return o
+}
+
+// next decides what follows a failure of client i with err, returning false
+// when err is final. errs collects the failures so far.
+func (c *chain) next(ctx context.Context, order []int, pos int, err error, errs *[]error) bool {
+	i := order[pos]
+	*errs = append(*errs, fmt.Errorf("%s: %w", c.clients[i], err))
+	if !Unavailable(err) {
+		return false
+	}
+
+	c.markFailed(i)
+	if pos+1 >= len(order) {
+		return false
+	}
+
+	if notify := notifyFrom(ctx); notify != nil {
+		notify(Switch{From: c.clients[i], To: c.clients[order[pos+1]], Err: err})
+	}
+	return true
+}
+
+// modelNotFound wraps err with ErrModelNotFound when client's provider
+// reports that its model doesn't exist.
+func modelNotFound(client Client, err error) error {
+	if status, ok := retry.StatusCode(err); ok && status == http.StatusNotFound {
+		return fmt.Errorf("%w: %q, check the model set for %s: %w", ErrModelNotFound, client.Model, client.Provider, err)
+	}
+	return err
+}
+
+// failure is the error returned once the chain gives up: err alone when
+// only one client was tried, else every client's error.
+func failure(err error, errs []error) error {
+	if len(errs) <= 1 {
+		return err
+	}
+	return fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+func (c *chain) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	return c.generate(ctx, func(client Client, opts llm.Options) (llm.Response, error) {
+		return client.Generate(ctx, system, prompt, opts)
+	}, opts)
+}
+
+func (c *chain) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	return c.generate(ctx, func(client Client, opts llm.Options) (llm.Response, error) {
+		return client.GenerateStructured(ctx, system, prompt, schema, opts)
+	}, opts)
+}
+
+func (c *chain) generate(ctx context.Context, request func(Client, llm.Options) (llm.Response, error), opts llm.Options) (llm.Response, error) {
+	order := c.order()
+
+	var errs []error
+	for pos, i := range order {
+		client := c.clients[i]
+		resp, err := request(client, c.options(i, opts))
+		if err == nil {
+			c.markServed(i)
+			resp.Provider, resp.Model = client.Provider, client.Model
+			return resp, nil
+		}
+		err = modelNotFound(client, err)
+		if !c.next(ctx, order, pos, err, &errs) {
+			return resp, failure(err, errs)
+		}
+	}
+
+	// Unreachable: the last client's failure is always final.
+	return llm.Response{}, failure(nil, errs)
+}
+
+func (c *chain) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return c.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+// Chat falls back while a client fails before streaming any content. Once
+// content has arrived, the reply is committed to that client.
+func (c *chain) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		order := c.order()
+
+		var errs []error
+		for pos, i := range order {
+			client := c.clients[i]
+			respChan, clientErrs := client.Chat(ctx, system, messages, c.options(i, opts))
+
+			started := false
+			for resp := range respChan {
+				if resp.Content != "" || resp.Thinking != "" {
+					started = true
+				}
+				resp.Provider, resp.Model = client.Provider, client.Model
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+				}
+			}
+
+			err := <-clientErrs
+			if err == nil {
+				c.markServed(i)
+				return
+			}
+			if started {
+				errChan <- err
+				return
+			}
+			err = modelNotFound(client, err)
+			if !c.next(ctx, order, pos, err, &errs) {
+				errChan <- failure(err, errs)
+				return
+			}
+		}
+	}()
+
+	return out, errChan
+}
+
+// Unavailable reports whether err means the provider can't serve requests
+// right now, so another provider should be tried: a transient error that
+// outlasted its retries, a rejected key or an exhausted quota, or a client
+// that couldn't be created (see Lazy). Errors in
+// the request itself, a missing model (see ErrModelNotFound) and
+// cancellation are not.
+func Unavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if isBuildError(err) || retry.Transient(err) {
+		return true
+	}
+
+	if status, ok := retry.StatusCode(err); ok {
+		switch status {
+		case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden:
+			return true
+		}
+		return false
+	}
+
+	msg := err.Error()
+	for _, t := range []string{"authentication_error", "permission_error", "insufficient_quota", "billing"} {
+		if strings.Contains(msg, t) {
+			return true
+		}
+	}
+	return false
+}
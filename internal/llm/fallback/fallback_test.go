@@ -0,0 +1,220 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLLM streams content, then fails with err.
+type fakeLLM struct {
+	llm.LLM
+	content  string
+	err      error
+	requests []llm.Options
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	f.requests = append(f.requests, opts)
+
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		if f.content != "" {
+			respChan <- llm.Response{Content: f.content}
+			respChan <- llm.Response{Usage: &llm.Usage{OutputTokens: 1}}
+		}
+		if f.err != nil {
+			errChan <- f.err
+		}
+	}()
+	return respChan, errChan
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	f.requests = append(f.requests, opts)
+	if f.err != nil {
+		return llm.Response{}, f.err
+	}
+	return llm.Response{Content: f.content}, nil
+}
+
+func statusError(status int) error {
+	return &anthropic.Error{StatusCode: status}
+}
+
+func client(name string, l llm.LLM) Client {
+	return Client{LLM: l, Name: name, Provider: name, Model: name + "-model"}
+}
+
+func drain(respChan <-chan llm.Response, errChan <-chan error) ([]llm.Response, error) {
+	var responses []llm.Response
+	for resp := range respChan {
+		responses = append(responses, resp)
+	}
+	return responses, <-errChan
+}
+
+func TestUnavailable(t *testing.T) {
+	assert.True(t, Unavailable(statusError(http.StatusUnauthorized)))
+	assert.True(t, Unavailable(statusError(http.StatusTooManyRequests)))
+	assert.True(t, Unavailable(statusError(http.StatusServiceUnavailable)))
+	assert.False(t, Unavailable(statusError(http.StatusNotFound)), "a mistyped model is a configuration error")
+	assert.True(t, Unavailable(errors.New(`{"error":{"type":"insufficient_quota"}}`)))
+	assert.False(t, Unavailable(statusError(http.StatusBadRequest)))
+	assert.False(t, Unavailable(context.Canceled))
+	assert.False(t, Unavailable(errors.New("invalid schema")))
+}
+
+func TestGenerate_FallsBack(t *testing.T) {
+	primary := &fakeLLM{err: statusError(http.StatusServiceUnavailable)}
+	secondary := &fakeLLM{err: statusError(http.StatusUnauthorized)}
+	local := &fakeLLM{content: "ok"}
+
+	temperature := 0.2
+	third := client("ollama", local)
+	third.Options = llm.Options{Temperature: &temperature}
+	chain := New(client("anthropic", primary), client("openai", secondary), third)
+
+	var switches []Switch
+	ctx := WithNotify(context.Background(), func(s Switch) { switches = append(switches, s) })
+
+	resp, err := chain.Generate(ctx, "", "prompt", llm.Options{Reasoning: llm.ReasoningHigh, Cache: true})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Content)
+	assert.Equal(t, "ollama", resp.Provider)
+	assert.Equal(t, "ollama-model", resp.Model)
+
+	require.Len(t, switches, 2)
+	assert.Equal(t, "anthropic", switches[0].From.Name)
+	assert.Equal(t, "openai", switches[0].To.Name)
+	assert.Equal(t, "ollama", switches[1].To.Name)
+
+	// A fallback gets its own profile's options, but the request's caching.
+	require.Len(t, local.requests, 1)
+	assert.Equal(t, llm.Options{Temperature: &temperature, Cache: true}, local.requests[0])
+}
+
+func TestGenerate_ReturnsRequestErrors(t *testing.T) {
+	primary := &fakeLLM{err: statusError(http.StatusBadRequest)}
+	secondary := &fakeLLM{content: "ok"}
+	chain := New(client("anthropic", primary), client("openai", secondary))
+
+	_, err := chain.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.Equal(t, 400, err.(*anthropic.Error).StatusCode)
+	assert.Empty(t, secondary.requests)
+}
+
+func TestGenerate_StopsAtMissingModel(t *testing.T) {
+	primary := &fakeLLM{err: statusError(http.StatusNotFound)}
+	secondary := &fakeLLM{content: "ok"}
+	chain := New(client("anthropic", primary), client("openai", secondary))
+
+	_, err := chain.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.ErrorIs(t, err, ErrModelNotFound)
+	apiErr, ok := errors.AsType[*anthropic.Error](err)
+	require.True(t, ok)
+	assert.Equal(t, 404, apiErr.StatusCode)
+	assert.Empty(t, secondary.requests)
+}
+
+func TestGenerate_NamesEveryFailure(t *testing.T) {
+	chain := New(
+		client("anthropic", &fakeLLM{err: statusError(http.StatusServiceUnavailable)}),
+		client("openai", &fakeLLM{err: statusError(http.StatusTooManyRequests)}),
+	)
+
+	_, err := chain.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "all providers failed")
+	assert.ErrorContains(t, err, `anthropic/anthropic-model (profile "anthropic")`)
+	assert.ErrorContains(t, err, `openai/openai-model (profile "openai")`)
+}
+
+func TestChat_FallsBackBeforeOutput(t *testing.T) {
+	primary := &fakeLLM{err: statusError(529)}
+	secondary := &fakeLLM{content: "LGTM"}
+	chain := New(client("anthropic", primary), client("openai", secondary))
+
+	responses, err := drain(chain.Stream(context.Background(), "", "prompt", llm.Options{}))
+	require.NoError(t, err)
+	require.Len(t, responses, 2)
+	assert.Equal(t, "LGTM", responses[0].Content)
+	for _, resp := range responses {
+		assert.Equal(t, "openai", resp.Provider)
+		assert.Equal(t, "openai-model", resp.Model)
+	}
+}
+
+func TestChat_KeepsClientAfterOutput(t *testing.T) {
+	primary := &fakeLLM{content: "partial", err: statusError(http.StatusServiceUnavailable)}
+	secondary := &fakeLLM{content: "LGTM"}
+	chain := New(client("anthropic", primary), client("openai", secondary))
+
+	responses, err := drain(chain.Stream(context.Background(), "", "prompt", llm.Options{}))
+	require.Error(t, err)
+	assert.Equal(t, "partial", responses[0].Content)
+	assert.Empty(t, secondary.requests)
+}
+
+func TestChain_SkipsFailedClientDuringCooldown(t *testing.T) {
+	primary := &fakeLLM{err: statusError(http.StatusServiceUnavailable)}
+	secondary := &fakeLLM{content: "ok"}
+	c := New(client("anthropic", primary), client("openai", secondary)).(*chain)
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, err := c.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.NoError(t, err)
+	_, err = c.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.NoError(t, err)
+	assert.Len(t, primary.requests, 1)
+	assert.Len(t, secondary.requests, 2)
+
+	// Once the cooldown is over, the primary is tried first again.
+	now = now.Add(cooldown)
+	primary.err, primary.content = nil, "back"
+	resp, err := c.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "anthropic", resp.Provider)
+}
+
+func TestLazy_PassesOverClientsThatCantBeCreated(t *testing.T) {
+	builds := 0
+	broken := Lazy(func(ctx context.Context) (llm.LLM, error) {
+		builds++
+		return nil, errors.New("missing provider credentials")
+	})
+	local := &fakeLLM{content: "ok"}
+	healthy := Lazy(func(ctx context.Context) (llm.LLM, error) {
+		builds++
+		return local, nil
+	})
+	chain := New(
+		client("anthropic", &fakeLLM{err: statusError(http.StatusServiceUnavailable)}),
+		client("openai", broken),
+		client("ollama", healthy),
+	)
+
+	responses, err := drain(chain.Chat(context.Background(), "", []llm.Message{{Role: llm.RoleUser, Content: "prompt"}}, llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "ollama", responses[0].Provider)
+
+	// The client that was created is kept.
+	resp, err := chain.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Content)
+	assert.Equal(t, 2, builds)
+	assert.Len(t, local.requests, 2)
+}
@@ -0,0 +1,95 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+// buildError is the error of a client that couldn't be created. The chain
+// moves past it like a provider that is down.
+type buildError struct{ err error }
+
+func (e *buildError) Error() string { return e.err.Error() }
+func (e *buildError) Unwrap() error { return e.err }
+
+// lazy is an llm.LLM created the first time a request reaches it.
+type lazy struct {
+	build func(context.Context) (llm.LLM, error)
+
+	mu sync.Mutex
+	l  llm.LLM
+}
+
+// Lazy returns an llm.LLM that calls build on its first request, so a
+// fallback that is never reached never reads its credentials, and one that
+// is misconfigured only fails the requests that reach it. A failed build is
+// tried again by the next request.
+func Lazy(build func(context.Context) (llm.LLM, error)) llm.LLM {
+	return &lazy{build: build}
+}
+
+func (z *lazy) get(ctx context.Context) (llm.LLM, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.l != nil {
+		return z.l, nil
+	}
+	l, err := z.build(ctx)
+	if err != nil {
+		return nil, &buildError{err: err}
+	}
+	z.l = l
+	return l, nil
+}
+
+func (z *lazy) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	l, err := z.get(ctx)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	return l.Generate(ctx, system, prompt, opts)
+}
+
+func (z *lazy) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	l, err := z.get(ctx)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	return l.GenerateStructured(ctx, system, prompt, schema, opts)
+}
+
+func (z *lazy) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	l, err := z.get(ctx)
+	if err != nil {
+		return failed(err)
+	}
+	return l.Stream(ctx, system, prompt, opts)
+}
+
+func (z *lazy) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	l, err := z.get(ctx)
+	if err != nil {
+		return failed(err)
+	}
+	return l.Chat(ctx, system, messages, opts)
+}
+
+// failed returns the channels of a stream that fails with err before any
+// response.
+func failed(err error) (<-chan llm.Response, <-chan error) {
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	close(respChan)
+	errChan <- err
+	close(errChan)
+	return respChan, errChan
+}
+
+func isBuildError(err error) bool {
+	_, ok := errors.AsType[*buildError](err)
+	return ok
+}
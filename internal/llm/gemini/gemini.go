@@ -7,9 +7,10 @@ import (
 	g "google.golang.org/genai"
 )
 
-func New(ctx context.Context, model string, apiKey string) (*genai.GenAI, error) {
+func New(ctx context.Context, model string, apiKey string, httpOptions g.HTTPOptions) (*genai.GenAI, error) {
 	return genai.New(ctx, model, g.ClientConfig{
-		Backend: g.BackendGeminiAPI,
-		APIKey:  apiKey,
+		Backend:     g.BackendGeminiAPI,
+		APIKey:      apiKey,
+		HTTPOptions: httpOptions,
 	})
 }
@@ -0,0 +1,149 @@
+package genai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"google.golang.org/genai"
+)
+
+// cacheTTL is how long cached content lives: long enough for the requests
+// of one review and a few follow-up questions. Storage is billed by the
+// hour, and caches are only reused within the process that created them.
+const cacheTTL = 10 * time.Minute
+
+// caches maps the display names of the caches created by this process to
+// their names and expiry, so finding one doesn't list every cache in the
+// project.
+var caches = struct {
+	sync.Mutex
+	byKey map[string]cache
+}{byKey: make(map[string]cache)}
+
+type cache struct {
+	name   string
+	expiry time.Time
+}
+
+// minCacheTokens returns the smallest content model accepts for explicit
+// caching.
+func minCacheTokens(model string) int {
+	switch {
+	case strings.Contains(model, "gemini-1.5"):
+		return 32768
+	case strings.Contains(model, "pro"):
+		return 4096
+	default:
+		return 1024
+	}
+}
+
+// request builds the contents and config of a request. When opts ask for
+// caching, the system prompt and the first message's cached prefix are
+// moved into cached content, found by a hash of what it holds or created.
+// The returned count is the tokens written to a new cache.
+//
+// Caching is best effort: models without explicit caching are sent
+// uncached, and so are prompts below the model's minimum size, which aren't
+// even tried.
+func (g *GenAI) request(ctx context.Context, system string, messages []llm.Message, opts llm.Options) ([]*genai.Content, *genai.GenerateContentConfig, int64) {
+	config := generateConfig(system, opts)
+
+	prefix, rest := "", ""
+	if len(messages) > 0 {
+		prefix, rest = opts.SplitPrompt(messages[0].Content)
+	}
+	// A request needs contents besides the cached ones.
+	if rest == "" && len(messages) == 1 {
+		prefix, rest = "", messages[0].Content
+	}
+
+	var (
+		name    string
+		written int64
+	)
+	if opts.Cache && llm.EstimateTokens(system+prefix) >= minCacheTokens(g.model) {
+		name, written = g.cachedContent(ctx, config.SystemInstruction, prefix)
+	}
+
+	contents := make([]*genai.Content, 0, len(messages))
+	for i, m := range messages {
+		role := genai.Role(genai.RoleUser)
+		if m.Role == llm.RoleAssistant {
+			role = genai.RoleModel
+		}
+
+		text := m.Content
+		if i == 0 && name != "" {
+			if rest == "" {
+				continue
+			}
+			text = rest
+		}
+		contents = append(contents, genai.NewContentFromText(text, role))
+	}
+
+	if name != "" {
+		// The system prompt is part of the cached content and can't be sent
+		// again.
+		config.SystemInstruction = nil
+		config.CachedContent = name
+	}
+
+	return contents, config, written
+}
+
+// cachedContent returns the name of the cache holding system and prefix,
+// creating it when there is none, and the tokens written if it was created.
+// It returns an empty name when the content can't be cached.
+func (g *GenAI) cachedContent(ctx context.Context, system *genai.Content, prefix string) (string, int64) {
+	hash := sha256.New()
+	hash.Write([]byte(g.model))
+	if system != nil {
+		for _, part := range system.Parts {
+			hash.Write([]byte{0})
+			hash.Write([]byte(part.Text))
+		}
+	}
+	hash.Write([]byte{0})
+	hash.Write([]byte(prefix))
+	key := "bark-" + hex.EncodeToString(hash.Sum(nil))[:32]
+
+	// The lock is held while creating the cache so concurrent requests for
+	// the chunks of a review share it.
+	caches.Lock()
+	defer caches.Unlock()
+	if c, ok := caches.byKey[key]; ok && time.Until(c.expiry) > time.Minute {
+		return c.name, 0
+	}
+
+	cacheConfig := &genai.CreateCachedContentConfig{
+		TTL:               cacheTTL,
+		DisplayName:       key,
+		SystemInstruction: system,
+	}
+	if prefix != "" {
+		cacheConfig.Contents = []*genai.Content{genai.NewContentFromText(prefix, genai.RoleUser)}
+	}
+
+	created, err := g.client.Caches.Create(ctx, g.model, cacheConfig)
+	if err != nil {
+		return "", 0
+	}
+	expiry := created.ExpireTime
+	if expiry.IsZero() {
+		expiry = time.Now().Add(cacheTTL)
+	}
+	caches.byKey[key] = cache{name: created.Name, expiry: expiry}
+
+	var written int64
+	if created.UsageMetadata != nil {
+		written = int64(created.UsageMetadata.TotalTokenCount)
+	}
+	return created.Name, written
+}
@@ -3,6 +3,9 @@ package genai
 import (
 	"context"
 	"fmt"
+	"path"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ionut-t/bark/v2/internal/llm"
@@ -14,13 +17,69 @@ type GenAI struct {
 	client *genai.Client
 }
 
-func systemConfig(system string) *genai.GenerateContentConfig {
-	if system == "" {
+// Limits are the generation options Gemini accepts. Reasoning efforts are
+// sent as thinking budgets.
+var Limits = llm.Limits{Provider: "Gemini", MaxTemperature: 2, Stop: true, MaxStop: 5, ThinkingBudget: true, MinThinkingBudget: 128}
+
+func generateConfig(system string, opts llm.Options) *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{
+		MaxOutputTokens: int32(opts.MaxTokens),
+		StopSequences:   opts.Stop,
+	}
+	if system != "" {
+		config.SystemInstruction = &genai.Content{Parts: []*genai.Part{{Text: system}}}
+	}
+	if opts.Temperature != nil {
+		config.Temperature = genai.Ptr(float32(*opts.Temperature))
+	}
+	if opts.TopP != nil {
+		config.TopP = genai.Ptr(float32(*opts.TopP))
+	}
+	if opts.Thinking() {
+		config.ThinkingConfig = &genai.ThinkingConfig{
+			IncludeThoughts: true,
+			ThinkingBudget:  genai.Ptr(int32(opts.ThinkingTokens())),
+		}
+	}
+	return config
+}
+
+// toUsage converts usage metadata, adding cacheWritten, the tokens written
+// to a cache created for the request. Thoughts are billed as output but not
+// counted in the candidates, so they are added to the output tokens.
+func toUsage(metadata *genai.GenerateContentResponseUsageMetadata, cacheWritten int64) *llm.Usage {
+	if metadata == nil {
 		return nil
 	}
-	return &genai.GenerateContentConfig{
-		SystemInstruction: &genai.Content{Parts: []*genai.Part{{Text: system}}},
+	// The cache created for the request is read by it too; its tokens count
+	// as written rather than read, and are stored for cacheTTL.
+	usage := &llm.Usage{
+		InputTokens:      int64(metadata.PromptTokenCount),
+		CacheReadTokens:  max(int64(metadata.CachedContentTokenCount)-cacheWritten, 0),
+		CacheWriteTokens: cacheWritten,
+		OutputTokens:     int64(metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount),
+		ThinkingTokens:   int64(metadata.ThoughtsTokenCount),
+		TotalTokens:      int64(metadata.TotalTokenCount),
+	}
+	if cacheWritten > 0 {
+		usage.CacheTTL = cacheTTL
+	}
+	return usage
+}
+
+// thoughts joins the thought summaries in result; Text skips them.
+func thoughts(result *genai.GenerateContentResponse) string {
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, part := range result.Candidates[0].Content.Parts {
+		if part.Thought {
+			b.WriteString(part.Text)
+		}
 	}
+	return b.String()
 }
 
 func New(ctx context.Context, model string, config genai.ClientConfig) (*GenAI, error) {
@@ -35,7 +94,11 @@ func New(ctx context.Context, model string, config genai.ClientConfig) (*GenAI,
 	}, nil
 }
 
-func (g *GenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.Response, <-chan error) {
+func (g *GenAI) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return g.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+func (g *GenAI) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
 	out := make(chan llm.Response)
 	errChan := make(chan error, 1)
 
@@ -49,8 +112,13 @@ func (g *GenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.R
 			return
 		}
 
-		contents := genai.Text(prompt)
-		stream := g.client.Models.GenerateContentStream(ctx, g.model, contents, systemConfig(system))
+		if err := opts.Validate(Limits); err != nil {
+			errChan <- err
+			return
+		}
+
+		contents, config, cacheWritten := g.request(ctx, system, messages, opts)
+		stream := g.client.Models.GenerateContentStream(ctx, g.model, contents, config)
 
 		var usage *llm.Usage
 		for resp, err := range stream {
@@ -67,22 +135,20 @@ func (g *GenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.R
 			}
 
 			if resp.UsageMetadata != nil {
-				usage = &llm.Usage{
-					InputTokens:  int64(resp.UsageMetadata.PromptTokenCount),
-					OutputTokens: int64(resp.UsageMetadata.CandidatesTokenCount),
-					TotalTokens:  int64(resp.UsageMetadata.TotalTokenCount),
-				}
+				usage = toUsage(resp.UsageMetadata, cacheWritten)
 			}
 
 			if len(resp.Candidates) > 0 {
 				if content := resp.Candidates[0].Content; content != nil {
 					for _, part := range content.Parts {
+						r := llm.Response{Content: part.Text, Time: time.Now()}
+						if part.Thought {
+							r = llm.Response{Thinking: part.Text, Time: r.Time}
+						}
+
 						// Send response, but also watch for cancellation
 						select {
-						case out <- llm.Response{
-							Content: part.Text,
-							Time:    time.Now(),
-						}:
+						case out <- r:
 						case <-ctx.Done():
 							errChan <- ctx.Err()
 							return
@@ -105,12 +171,17 @@ func (g *GenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.R
 	return out, errChan
 }
 
-func (g *GenAI) Generate(ctx context.Context, system, prompt string) (llm.Response, error) {
+func (g *GenAI) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
 	if ctx.Err() != nil {
 		return llm.Response{}, ctx.Err()
 	}
 
-	result, err := g.client.Models.GenerateContent(ctx, g.model, genai.Text(prompt), systemConfig(system))
+	if err := opts.Validate(Limits); err != nil {
+		return llm.Response{}, err
+	}
+
+	contents, config, cacheWritten := g.request(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+	result, err := g.client.Models.GenerateContent(ctx, g.model, contents, config)
 	if err != nil {
 		return llm.Response{}, fmt.Errorf("genai request failed: %w", err)
 	}
@@ -119,18 +190,69 @@ func (g *GenAI) Generate(ctx context.Context, system, prompt string) (llm.Respon
 		return llm.Response{}, fmt.Errorf("no response from LLM")
 	}
 
-	var usage *llm.Usage
-	if result.UsageMetadata != nil {
-		usage = &llm.Usage{
-			InputTokens:  int64(result.UsageMetadata.PromptTokenCount),
-			OutputTokens: int64(result.UsageMetadata.CandidatesTokenCount),
-			TotalTokens:  int64(result.UsageMetadata.TotalTokenCount),
-		}
+	return llm.Response{
+		Content:  result.Text(),
+		Thinking: thoughts(result),
+		Time:     time.Now(),
+		Usage:    toUsage(result.UsageMetadata, cacheWritten),
+	}, nil
+}
+
+// GenerateStructured sets the response MIME type to JSON with the requested
+// schema, so the reply text is a JSON document matching it.
+func (g *GenAI) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	if ctx.Err() != nil {
+		return llm.Response{}, ctx.Err()
+	}
+
+	if err := opts.Validate(Limits); err != nil {
+		return llm.Response{}, err
+	}
+
+	contents, config, cacheWritten := g.request(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+	config.ResponseMIMEType = "application/json"
+	config.ResponseJsonSchema = schema.Definition
+
+	result, err := g.client.Models.GenerateContent(ctx, g.model, contents, config)
+	if err != nil {
+		return llm.Response{}, fmt.Errorf("genai request failed: %w", err)
+	}
+
+	if result == nil || result.Text() == "" {
+		return llm.Response{}, fmt.Errorf("no response from LLM")
 	}
 
 	return llm.Response{
-		Content: result.Text(),
-		Time:    time.Now(),
-		Usage:   usage,
+		Content:  result.Text(),
+		Thinking: thoughts(result),
+		Time:     time.Now(),
+		Usage:    toUsage(result.UsageMetadata, cacheWritten),
 	}, nil
 }
+
+// ListModels lists the models that can generate content, without the
+// "models/" prefix of their names.
+func (g *GenAI) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var models []llm.Model
+	for model, err := range g.client.Models.All(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models: %w", err)
+		}
+		// Vertex AI doesn't report the actions of its models.
+		if len(model.SupportedActions) > 0 && !slices.Contains(model.SupportedActions, "generateContent") {
+			continue
+		}
+
+		m := llm.Model{
+			ID:              path.Base(model.Name),
+			DisplayName:     model.DisplayName,
+			ContextWindow:   int64(model.InputTokenLimit),
+			MaxOutputTokens: int64(model.OutputTokenLimit),
+		}
+		if model.Thinking {
+			m.Capabilities = append(m.Capabilities, llm.CapabilityThinking)
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
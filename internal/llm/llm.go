@@ -2,22 +2,265 @@ package llm
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+	"unicode/utf8"
 )
 
 type Usage struct {
-	InputTokens  int64
+	// InputTokens counts the whole prompt, cached or not. CacheReadTokens and
+	// CacheWriteTokens are the prompt tokens read from and written to the
+	// provider's prompt cache.
+	InputTokens      int64
+	CacheReadTokens  int64
+	CacheWriteTokens int64
+	// CacheTTL is how long the tokens written are kept, for providers that
+	// bill cache storage by the hour.
+	CacheTTL     time.Duration
 	OutputTokens int64
-	TotalTokens  int64
+	// ThinkingTokens is the part of OutputTokens spent reasoning. Providers
+	// that don't report it separately have it estimated from the thinking
+	// text.
+	ThinkingTokens int64
+	TotalTokens    int64
+}
+
+// Add returns the sum of u and other, e.g. to report the combined usage of
+// several requests made for one review.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + other.InputTokens,
+		CacheReadTokens:  u.CacheReadTokens + other.CacheReadTokens,
+		CacheWriteTokens: u.CacheWriteTokens + other.CacheWriteTokens,
+		CacheTTL:         max(u.CacheTTL, other.CacheTTL),
+		OutputTokens:     u.OutputTokens + other.OutputTokens,
+		ThinkingTokens:   u.ThinkingTokens + other.ThinkingTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// EstimateTokens approximates the number of tokens in s. Providers tokenise
+// differently, so this uses the common rule of thumb of four bytes per token;
+// it is meant for budgeting, not billing.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
 }
 
 type Response struct {
 	Content string
-	Time    time.Time
-	Usage   *Usage
+	// Thinking is the model's reasoning, kept apart from Content. Streams
+	// deliver it in deltas like Content.
+	Thinking string
+	Time     time.Time
+	Usage    *Usage
+	// Retrying is set, on a response without content, when a stream failed
+	// with a transient error and is about to be retried.
+	Retrying *Retrying
+	// Provider and Model name what served the response. They are only set
+	// by a fallback chain, which may not use the configured provider.
+	Provider string
+	Model    string
+}
+
+// Retrying describes a retry of a request that failed with Err.
+type Retrying struct {
+	// Attempt is the attempt about to be made, from 2, of MaxAttempts.
+	Attempt     int
+	MaxAttempts int
+	Delay       time.Duration
+	Err         error
+	// Restart is set when the failed attempt streamed no content, so the
+	// reply starts over and the thinking streamed so far is discarded.
+	Restart bool
+}
+
+func (r Retrying) String() string {
+	return fmt.Sprintf("retrying (%d/%d)…", r.Attempt, r.MaxAttempts)
+}
+
+// Schema describes the JSON document a structured response must conform to.
+// Definition is a JSON Schema object whose root is always of type "object".
+type Schema struct {
+	Name        string
+	Description string
+	Definition  map[string]any
+}
+
+// Role identifies the author of a chat message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// ErrInvalidOptions is returned for generation options a provider doesn't
+// accept.
+var ErrInvalidOptions = errors.New("invalid generation options")
+
+// Reasoning efforts accepted by Options.Reasoning.
+const (
+	ReasoningLow    = "low"
+	ReasoningMedium = "medium"
+	ReasoningHigh   = "high"
+)
+
+// reasoningBudgets are the thinking budgets, in tokens, that the efforts map
+// to on providers configured with a budget rather than an effort.
+var reasoningBudgets = map[string]int64{
+	ReasoningLow:    2048,
+	ReasoningMedium: 8192,
+	ReasoningHigh:   24576,
+}
+
+// Options tunes a request. Zero values keep the provider's defaults.
+type Options struct {
+	Temperature *float64
+	// MaxTokens caps the number of output tokens.
+	MaxTokens int64
+	TopP      *float64
+	// Stop lists sequences that end the output when generated.
+	Stop []string
+	// Reasoning is the reasoning effort of a thinking model: low, medium or
+	// high. ThinkingBudget sets the thinking tokens directly instead.
+	Reasoning      string
+	ThinkingBudget int64
+	// Cache asks providers with prompt caching to cache the system prompt
+	// and the first CachePrefix bytes of the prompt (of the first message in
+	// a chat): the part that stays the same when the request is repeated.
+	Cache       bool
+	CachePrefix int
+}
+
+// SplitPrompt splits prompt into the prefix o caches and the rest. The
+// prefix is empty unless caching is enabled.
+func (o Options) SplitPrompt(prompt string) (prefix, rest string) {
+	if !o.Cache {
+		return "", prompt
+	}
+
+	n := min(max(o.CachePrefix, 0), len(prompt))
+	for n > 0 && n < len(prompt) && !utf8.RuneStart(prompt[n]) {
+		n--
+	}
+	return prompt[:n], prompt[n:]
+}
+
+// Thinking reports whether o enables reasoning.
+func (o Options) Thinking() bool {
+	return o.Reasoning != "" || o.ThinkingBudget > 0
+}
+
+// ThinkingTokens returns the thinking budget o asks for, translating the
+// reasoning effort for providers that only take a budget.
+func (o Options) ThinkingTokens() int64 {
+	if o.ThinkingBudget > 0 {
+		return o.ThinkingBudget
+	}
+	return reasoningBudgets[o.Reasoning]
+}
+
+// Limits describes the generation options a provider accepts.
+type Limits struct {
+	Provider       string
+	MaxTemperature float64
+	// Stop reports whether stop sequences are supported, and MaxStop how many
+	// (zero for no limit).
+	Stop    bool
+	MaxStop int
+	// Reasoning reports whether a reasoning effort is supported, and
+	// ThinkingBudget whether a thinking budget is (at least
+	// MinThinkingBudget tokens).
+	Reasoning         bool
+	ThinkingBudget    bool
+	MinThinkingBudget int64
+}
+
+// Validate returns an error wrapping ErrInvalidOptions when o exceeds l.
+func (o Options) Validate(l Limits) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w for %s: %s", ErrInvalidOptions, l.Provider, fmt.Sprintf(format, args...))
+	}
+
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > l.MaxTemperature) {
+		return invalid("temperature must be between 0 and %g, got %g", l.MaxTemperature, *o.Temperature)
+	}
+	if o.TopP != nil && (*o.TopP <= 0 || *o.TopP > 1) {
+		return invalid("top_p must be greater than 0 and at most 1, got %g", *o.TopP)
+	}
+	if o.MaxTokens < 0 {
+		return invalid("max_tokens must be positive, got %d", o.MaxTokens)
+	}
+	if len(o.Stop) > 0 && !l.Stop {
+		return invalid("stop sequences are not supported")
+	}
+	if l.MaxStop > 0 && len(o.Stop) > l.MaxStop {
+		return invalid("at most %d stop sequences are supported, got %d", l.MaxStop, len(o.Stop))
+	}
+	if o.Reasoning != "" && o.ThinkingBudget != 0 {
+		return invalid("set either reasoning or thinking_budget, not both")
+	}
+	if o.Reasoning != "" {
+		if _, ok := reasoningBudgets[o.Reasoning]; !ok {
+			return invalid("reasoning must be low, medium or high, got %q", o.Reasoning)
+		}
+		if !l.Reasoning && !l.ThinkingBudget {
+			return invalid("reasoning is not supported")
+		}
+	}
+	if o.ThinkingBudget != 0 {
+		if !l.ThinkingBudget {
+			return invalid("thinking_budget is not supported; use reasoning instead")
+		}
+		if o.ThinkingBudget < l.MinThinkingBudget {
+			return invalid("thinking_budget must be at least %d, got %d", l.MinThinkingBudget, o.ThinkingBudget)
+		}
+	}
+
+	return nil
 }
 
 type LLM interface {
-	Stream(ctx context.Context, system, prompt string) (<-chan Response, <-chan error)
-	Generate(ctx context.Context, system, prompt string) (Response, error)
+	Stream(ctx context.Context, system, prompt string, opts Options) (<-chan Response, <-chan error)
+	// Chat streams the assistant's reply to a multi-turn conversation. The
+	// messages alternate between user and assistant and end with a user turn.
+	Chat(ctx context.Context, system string, messages []Message, opts Options) (<-chan Response, <-chan error)
+	Generate(ctx context.Context, system, prompt string, opts Options) (Response, error)
+	// GenerateStructured returns a response whose Content is a JSON document
+	// matching schema, using the provider's native structured-output mode.
+	GenerateStructured(ctx context.Context, system, prompt string, schema Schema, opts Options) (Response, error)
+}
+
+// Capabilities a provider reports for a model.
+const (
+	CapabilityThinking   = "thinking"
+	CapabilityVision     = "vision"
+	CapabilityPDF        = "pdf"
+	CapabilityTools      = "tools"
+	CapabilityStructured = "structured outputs"
+)
+
+// Model describes a model a provider serves. Limits and capabilities the
+// provider doesn't report are zero.
+type Model struct {
+	ID          string
+	DisplayName string
+	// ContextWindow is the number of input tokens the model accepts, and
+	// MaxOutputTokens the number it can generate.
+	ContextWindow   int64
+	MaxOutputTokens int64
+	Capabilities    []string
+}
+
+// ModelLister is implemented by clients that can list the models their
+// provider serves.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]Model, error)
 }
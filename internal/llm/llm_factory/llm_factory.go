@@ -1,19 +1,32 @@
 package llm_factory
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 
+	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/credentials"
 	"github.com/ionut-t/bark/v2/internal/llm"
 	"github.com/ionut-t/bark/v2/internal/llm/anthropic"
+	"github.com/ionut-t/bark/v2/internal/llm/chatcompletions"
+	"github.com/ionut-t/bark/v2/internal/llm/fallback"
 	"github.com/ionut-t/bark/v2/internal/llm/gemini"
+	llmgenai "github.com/ionut-t/bark/v2/internal/llm/genai"
 	"github.com/ionut-t/bark/v2/internal/llm/ollama"
 	"github.com/ionut-t/bark/v2/internal/llm/openai"
+	"github.com/ionut-t/bark/v2/internal/llm/openaicompatible"
+	"github.com/ionut-t/bark/v2/internal/llm/replay"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
 	"github.com/ionut-t/bark/v2/internal/llm/vertexai"
+	openaioption "github.com/openai/openai-go/v3/option"
+	"google.golang.org/genai"
 )
 
 var (
@@ -22,68 +35,71 @@ var (
 	ErrMissingCredentials   = errors.New("missing provider credentials")
 )
 
-// providerCredentials holds the environment variable values for different providers
+// providerCredentials holds what providers need to connect: the API keys,
+// read when a provider is used, and the environment variable values of
+// the others.
 type providerCredentials struct {
-	geminiAPIKey      string
+	keys              *credentials.Resolver
+	sources           map[string]config.Credentials
+	apiKeys           map[string]string
 	vertexAIProjectID string
 	vertexAILocation  string
-	openAIAPIKey      string
 	ollamaHost        string
-	anthropicAPIKey   string
-	hasGemini         bool
+	compatible        config.OpenAICompatible
+	replay            config.Replay
 	hasVertexAI       bool
-	hasOpenAI         bool
 	hasOllama         bool
-	hasAnthropic      bool
 }
 
-// loadCredentials reads and validates environment variables
-func loadCredentials() *providerCredentials {
+// loadCredentials reads the environment variables and the key sources of
+// cfg. Keys are only read when a provider is validated.
+func loadCredentials(cfg config.Config) *providerCredentials {
+	// Without a storage directory, keys come from the other sources.
+	storage, _ := config.GetStorage()
+
 	creds := &providerCredentials{
-		geminiAPIKey:      os.Getenv("GEMINI_API_KEY"),
+		keys:              credentials.New(cfg, storage),
+		sources:           cfg.GetCredentials(),
+		apiKeys:           map[string]string{},
 		vertexAIProjectID: os.Getenv("VERTEXAI_PROJECT_ID"),
 		vertexAILocation:  os.Getenv("VERTEXAI_LOCATION"),
-		openAIAPIKey:      os.Getenv("OPENAI_API_KEY"),
 		ollamaHost:        os.Getenv("OLLAMA_HOST"),
-		anthropicAPIKey:   os.Getenv("ANTHROPIC_API_KEY"),
+		compatible:        cfg.GetOpenAICompatible(),
+		replay:            cfg.GetReplay(),
 	}
 
-	creds.hasGemini = creds.geminiAPIKey != ""
 	creds.hasVertexAI = creds.vertexAIProjectID != "" && creds.vertexAILocation != ""
-	creds.hasOpenAI = creds.openAIAPIKey != ""
 	creds.hasOllama = creds.ollamaHost != ""
-	creds.hasAnthropic = creds.anthropicAPIKey != ""
 
 	return creds
 }
 
 // detectProvider automatically detects which provider to use based on available credentials
 func (c *providerCredentials) detectProvider() (string, error) {
-	if c.hasGemini {
+	if c.keys.Configured("gemini") {
 		return "gemini", nil
 	}
 	if c.hasVertexAI {
 		return "vertexai", nil
 	}
-	if c.hasOpenAI {
+	if c.keys.Configured("openai") {
 		return "openai", nil
 	}
-	if c.hasAnthropic {
+	if c.keys.Configured("anthropic") {
 		return "anthropic", nil
 	}
 	if c.hasOllama {
 		return "ollama", nil
 	}
-	return "", fmt.Errorf("%w: set GEMINI_API_KEY, OPENAI_API_KEY, ANTHROPIC_API_KEY, OLLAMA_HOST, or both VERTEXAI_PROJECT_ID and VERTEXAI_LOCATION", ErrNoProviderConfigured)
+	return "", fmt.Errorf("%w: set GEMINI_API_KEY, OPENAI_API_KEY, ANTHROPIC_API_KEY, OLLAMA_HOST, or both VERTEXAI_PROJECT_ID and VERTEXAI_LOCATION, or configure a key source (see bark credentials status)", ErrNoProviderConfigured)
 }
 
-// validateProvider checks if credentials exist for the specified provider
-func (c *providerCredentials) validateProvider(provider string) error {
+// validateProvider checks if credentials exist for the specified provider,
+// reading its API key; baseURL is the profile's endpoint override, if any.
+func (c *providerCredentials) validateProvider(ctx context.Context, provider, baseURL string) error {
 	switch provider {
 	case "gemini":
-		if !c.hasGemini {
-			return fmt.Errorf("%w for Gemini: GEMINI_API_KEY not set", ErrMissingCredentials)
-		}
+		return c.resolveKey(ctx, "Gemini", provider, true)
 	case "vertexai":
 		if !c.hasVertexAI {
 			missing := []string{}
@@ -96,63 +112,316 @@ func (c *providerCredentials) validateProvider(provider string) error {
 			return fmt.Errorf("%w for Vertex AI: %s not set", ErrMissingCredentials, strings.Join(missing, " and "))
 		}
 	case "openai":
-		if !c.hasOpenAI {
-			return fmt.Errorf("%w for OpenAI: OPENAI_API_KEY not set", ErrMissingCredentials)
-		}
+		return c.resolveKey(ctx, "OpenAI", provider, true)
 	case "anthropic":
-		if !c.hasAnthropic {
-			return fmt.Errorf("%w for Anthropic: ANTHROPIC_API_KEY not set", ErrMissingCredentials)
-		}
+		return c.resolveKey(ctx, "Anthropic", provider, true)
 	case "ollama":
 		// Ollama runs locally; no credentials required
+	case "openai_compatible":
+		if c.compatible.BaseURL == "" && baseURL == "" {
+			return fmt.Errorf("%w for openai_compatible: set base_url in the [openai_compatible] section of the config or in the profile", ErrMissingCredentials)
+		}
+		// The key is optional since local servers don't check it, but one
+		// whose source is named explicitly must be found.
+		source := c.sources[provider]
+		required := c.compatible.APIKeyEnv != "" || source.APIKeyCommand != "" || source.APIKeyFile != ""
+		return c.resolveKey(ctx, "openai_compatible", provider, required)
+	case "replay":
+		if c.replay.File == "" {
+			return fmt.Errorf("%w for replay: set file in the [replay] section of the config", ErrMissingCredentials)
+		}
 	default:
-		return fmt.Errorf("%w: %s (supported: gemini, vertexai, openai, anthropic, ollama)", ErrInvalidProvider, provider)
+		return fmt.Errorf("%w: %s (supported: gemini, vertexai, openai, anthropic, ollama, openai_compatible, replay)", ErrInvalidProvider, provider)
 	}
 
 	return nil
 }
 
-func New(ctx context.Context, cfg config.Config) (llm.LLM, string, error) {
-	creds := loadCredentials()
+// resolveKey reads the API key of provider once, so fallback profiles of
+// the same provider don't run its api_key_command again. name is the
+// provider's name in errors.
+func (c *providerCredentials) resolveKey(ctx context.Context, name, provider string, required bool) error {
+	if _, ok := c.apiKeys[provider]; ok {
+		return nil
+	}
 
-	provider, err := cfg.GetLLMProvider()
-	if err != nil || provider == "" {
-		// Config doesn't specify provider, try to auto-detect
-		provider, err = creds.detectProvider()
-		if err != nil {
-			return nil, "", err
+	res := resolve(ctx, c.keys, provider)
+	if err := res.Err(); err != nil && required {
+		return fmt.Errorf("%w for %s: %w", ErrMissingCredentials, name, err)
+	}
+	c.apiKeys[provider] = res.Key
+	return nil
+}
+
+// resolved memoizes the key resolution of each provider for the process, so
+// creating the clients of several tasks, or switching models, doesn't run
+// an api_key_command or unlock the credentials file again.
+var resolved = struct {
+	sync.Mutex
+	keys map[string]credentials.Resolution
+}{keys: make(map[string]credentials.Resolution)}
+
+func resolve(ctx context.Context, keys *credentials.Resolver, provider string) credentials.Resolution {
+	resolved.Lock()
+	defer resolved.Unlock()
+
+	if res, ok := resolved.keys[provider]; ok {
+		return res
+	}
+	res := keys.Resolve(ctx, provider)
+	// A resolution cut short by the caller is tried again next time.
+	if res.Key != "" || ctx.Err() == nil {
+		resolved.keys[provider] = res
+	}
+	return res
+}
+
+// Options returns the generation options set in profile.
+func Options(profile config.Profile) llm.Options {
+	return llm.Options{
+		Temperature:    profile.Temperature,
+		MaxTokens:      profile.MaxTokens,
+		TopP:           profile.TopP,
+		Stop:           profile.Stop,
+		Reasoning:      profile.Reasoning,
+		ThinkingBudget: profile.ThinkingBudget,
+	}
+}
+
+// validateOptions checks opts against what provider accepts.
+func validateOptions(provider string, opts llm.Options) error {
+	switch provider {
+	case "gemini", "vertexai":
+		return opts.Validate(llmgenai.Limits)
+	case "openai":
+		return opts.Validate(openai.Limits)
+	case "anthropic":
+		return anthropic.Validate(opts)
+	case "ollama":
+		return opts.Validate(chatcompletions.Limits("Ollama"))
+	case "replay":
+		// Recorded responses don't depend on the options.
+		return nil
+	default:
+		return opts.Validate(chatcompletions.Limits(provider))
+	}
+}
+
+// New creates the client for task from the profile it resolves to (see
+// config.ResolveProfile). The resolved profile is returned so callers can
+// report the provider and model actually used.
+//
+// When fallback profiles are configured (see config.ResolveFallbacks), the
+// client is a chain that moves on to them while the profile's provider is
+// unavailable, and its responses name the provider and model that served
+// them.
+//
+// With --record (see config.GetRecord), the requests each provider serves
+// are recorded to a fixture for the replay provider.
+func New(ctx context.Context, cfg config.Config, task string) (llm.LLM, config.Profile, error) {
+	profile, err := cfg.ResolveProfile(task)
+	if err != nil {
+		return nil, config.Profile{}, err
+	}
+
+	return FromProfile(ctx, cfg, profile)
+}
+
+// FromProfile creates the client of a resolved profile like New, e.g. to
+// switch the model of a task's profile.
+func FromProfile(ctx context.Context, cfg config.Config, profile config.Profile) (llm.LLM, config.Profile, error) {
+	creds := loadCredentials(cfg)
+
+	var err error
+	var recorder *replay.Recorder
+	if path := cfg.GetRecord(); path != "" {
+		if recorder, err = replay.NewRecorder(path); err != nil {
+			return nil, config.Profile{}, err
 		}
 	}
 
-	provider = strings.ToLower(strings.TrimSpace(provider))
+	l, profile, err := newClient(ctx, creds, recorder, profile)
+	if err != nil {
+		return nil, config.Profile{}, err
+	}
 
-	if err := creds.validateProvider(provider); err != nil {
-		return nil, "", err
+	fallbacks, err := cfg.ResolveFallbacks(profile)
+	if err != nil {
+		return nil, config.Profile{}, err
+	}
+	if len(fallbacks) == 0 {
+		return l, profile, nil
 	}
 
-	model, err := cfg.GetLLMModel()
+	// Fallbacks are created when the chain first reaches them, so their
+	// credentials are only read when needed and a misconfigured one doesn't
+	// take down a healthy primary. They share creds, hence the lock.
+	var mu sync.Mutex
+	clients := make([]fallback.Client, 0, len(fallbacks))
+	for _, p := range fallbacks {
+		if p.Provider == "" {
+			// Detection fails again, with its error, when the client is
+			// created.
+			p.Provider, _ = creds.detectProvider()
+		}
+		fl := fallback.Lazy(func(ctx context.Context) (llm.LLM, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			l, _, err := newClient(ctx, creds, recorder, p)
+			if err != nil {
+				return nil, fmt.Errorf("fallback profile %q: %w", p.Name, err)
+			}
+			return l, nil
+		})
+		clients = append(clients, fallbackClient(fl, p))
+	}
+
+	return fallback.New(fallbackClient(l, profile), clients...), profile, nil
+}
+
+func fallbackClient(l llm.LLM, profile config.Profile) fallback.Client {
+	return fallback.Client{LLM: l, Name: profile.Name, Provider: profile.Provider, Model: profile.Model, Options: Options(profile)}
+}
+
+// newClient creates the client of a resolved profile, detecting its
+// provider from the credentials when it has none, and returns the profile
+// with the provider set. A non-nil recorder records the provider's
+// requests.
+func newClient(ctx context.Context, creds *providerCredentials, recorder *replay.Recorder, profile config.Profile) (llm.LLM, config.Profile, error) {
+	l, profile, err := newProviderClient(ctx, creds, profile)
 	if err != nil {
-		return nil, "", err
+		return nil, config.Profile{}, err
+	}
+	if recorder != nil {
+		l = recorder.Wrap(l, profile.Provider, profile.Model)
+	}
+	return retry.Wrap(l, retry.DefaultPolicy), profile, nil
+}
+
+// newProviderClient creates the provider's own client for profile, without
+// retries.
+func newProviderClient(ctx context.Context, creds *providerCredentials, profile config.Profile) (llm.LLM, config.Profile, error) {
+	var err error
+	if profile.Provider == "" {
+		// Neither the profile nor the config specifies a provider, try to
+		// auto-detect
+		profile.Provider, err = creds.detectProvider()
+		if err != nil {
+			return nil, config.Profile{}, err
+		}
+	}
+
+	if err := creds.validateProvider(ctx, profile.Provider, profile.BaseURL); err != nil {
+		return nil, config.Profile{}, err
+	}
+
+	// Reject options the provider doesn't accept before any request is made,
+	// naming the profile they come from.
+	if err := validateOptions(profile.Provider, Options(profile)); err != nil {
+		if profile.Name != "" {
+			return nil, config.Profile{}, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+		return nil, config.Profile{}, err
+	}
+
+	// ResolveProfile has validated the timeout.
+	timeout, _ := profile.RequestTimeout()
+
+	// Retries are made by the retry wrapper, which also resumes broken
+	// streams, rather than by each SDK.
+	var (
+		openAIOpts    = []openaioption.RequestOption{openaioption.WithMaxRetries(0)}
+		anthropicOpts = []anthropicoption.RequestOption{anthropicoption.WithMaxRetries(0)}
+		httpOptions   genai.HTTPOptions
+	)
+	if profile.BaseURL != "" {
+		openAIOpts = append(openAIOpts, openaioption.WithBaseURL(profile.BaseURL))
+		anthropicOpts = append(anthropicOpts, anthropicoption.WithBaseURL(profile.BaseURL))
+		httpOptions.BaseURL = profile.BaseURL
 	}
+	if timeout > 0 {
+		openAIOpts = append(openAIOpts, openaioption.WithRequestTimeout(timeout))
+		anthropicOpts = append(anthropicOpts, anthropicoption.WithRequestTimeout(timeout))
+		httpOptions.Timeout = &timeout
+	}
+
+	model := profile.Model
 
 	var l llm.LLM
-	switch provider {
+	switch profile.Provider {
 	case "gemini":
-		l, err = gemini.New(ctx, model, creds.geminiAPIKey)
+		l, err = gemini.New(ctx, model, creds.apiKeys["gemini"], httpOptions)
 	case "vertexai":
-		l, err = vertexai.New(ctx, model, creds.vertexAIProjectID, creds.vertexAILocation)
+		l, err = vertexai.New(ctx, model, creds.vertexAIProjectID, creds.vertexAILocation, httpOptions)
 	case "openai":
-		l = openai.New(model, creds.openAIAPIKey)
+		l = openai.New(model, creds.apiKeys["openai"], openAIOpts...)
 	case "anthropic":
-		l = anthropic.New(model, creds.anthropicAPIKey)
+		l = anthropic.New(model, creds.apiKeys["anthropic"], anthropicOpts...)
 	case "ollama":
-		l = ollama.New(model)
+		l = ollama.New(model, profile.BaseURL, openAIOpts...)
+	case "openai_compatible":
+		baseURL := cmp.Or(profile.BaseURL, creds.compatible.BaseURL)
+		l = openaicompatible.New(model, baseURL, creds.apiKeys["openai_compatible"], creds.compatible.Headers, openAIOpts...)
+	case "replay":
+		l, err = replay.New(creds.replay.File, creds.replay.Instant)
 	default:
-		return nil, "", fmt.Errorf("%w: %s", ErrInvalidProvider, provider)
+		return nil, config.Profile{}, fmt.Errorf("%w: %s", ErrInvalidProvider, profile.Provider)
+	}
+
+	if err != nil {
+		return nil, config.Profile{}, err
+	}
+	return l, profile, nil
+}
+
+// ListModels lists the models provider serves, using the credentials and
+// provider settings in cfg, sorted by ID. baseURL is the endpoint of the
+// profile the models are listed for, if it sets one.
+func ListModels(ctx context.Context, cfg config.Config, provider, baseURL string) ([]llm.Model, error) {
+	l, _, err := newProviderClient(ctx, loadCredentials(cfg), config.Profile{Provider: provider, BaseURL: baseURL})
+	if err != nil {
+		return nil, err
 	}
 
+	lister, ok := l.(llm.ModelLister)
+	if !ok {
+		return nil, fmt.Errorf("%s can't list its models", provider)
+	}
+	models, err := lister.ListModels(ctx)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+
+	slices.SortFunc(models, func(a, b llm.Model) int { return strings.Compare(a.ID, b.ID) })
+	return models, nil
+}
+
+// AvailableProviders returns the providers that credentials or settings are
+// configured for, in the order they are auto-detected, followed by the
+// configured provider if it isn't one of them.
+func AvailableProviders(cfg config.Config) []string {
+	creds := loadCredentials(cfg)
+
+	var providers []string
+	for _, provider := range []string{"gemini", "vertexai", "openai", "anthropic", "ollama", "openai_compatible"} {
+		var available bool
+		switch provider {
+		case "vertexai":
+			available = creds.hasVertexAI
+		case "ollama":
+			available = creds.hasOllama
+		case "openai_compatible":
+			available = creds.compatible.BaseURL != ""
+		default:
+			available = creds.keys.Configured(provider)
+		}
+		if available {
+			providers = append(providers, provider)
+		}
+	}
+
+	if provider, err := cfg.GetLLMProvider(); err == nil && !slices.Contains(providers, provider) {
+		providers = append(providers, provider)
 	}
-	return l, provider, nil
+	return providers
 }
@@ -0,0 +1,105 @@
+package llm_factory
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/credentials"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	temperature := 0.2
+	profile := config.Profile{Name: "terse", Provider: "anthropic", Temperature: &temperature, MaxTokens: 200}
+
+	opts := Options(profile)
+	assert.Equal(t, llm.Options{Temperature: &temperature, MaxTokens: 200}, opts)
+	assert.NoError(t, validateOptions(profile.Provider, opts))
+
+	temperature = 1.5
+	assert.ErrorIs(t, validateOptions(profile.Provider, Options(profile)), llm.ErrInvalidOptions, "Anthropic's temperature is at most 1")
+	assert.NoError(t, validateOptions("openai", Options(profile)))
+}
+
+func TestResolve_RunsKeyCommandOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("api_key_command runs through sh")
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Cleanup(viper.Reset)
+	t.Cleanup(func() { delete(resolved.keys, "anthropic") })
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	viper.Set(config.CredentialsKey, map[string]any{"anthropic": map[string]any{
+		"api_key_command": "echo run >> " + runs + "; echo sk-ant-from-command-01",
+	}})
+
+	// Each task's client gets its own resolver, as in the TUI.
+	for range 3 {
+		res := resolve(context.Background(), credentials.New(config.New(), t.TempDir()), "anthropic")
+		require.NoError(t, res.Err())
+		assert.Equal(t, "sk-ant-from-command-01", res.Key)
+	}
+
+	data, err := os.ReadFile(runs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "run"))
+}
+
+func TestFromProfile_MisconfiguredFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("api_key_command runs through sh")
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Cleanup(viper.Reset)
+	t.Cleanup(func() { delete(resolved.keys, "anthropic") })
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	viper.Set(config.ReplayKey+".file", filepath.Join("..", "..", "..", "testdata", "replay", "review.jsonl"))
+	viper.Set(config.CredentialsKey, map[string]any{"anthropic": map[string]any{
+		"api_key_command": "echo run >> " + runs + "; echo sk-ant-from-command-01",
+	}})
+	// Anthropic's temperature is at most 1.
+	viper.Set(config.ProfilesKey, map[string]any{"broken": map[string]any{
+		"provider": "anthropic", "model": "claude-sonnet-4-5", "temperature": 1.5,
+	}})
+	viper.Set(config.FallbackKey, []string{"broken"})
+
+	cfg := config.New()
+	l, profile, err := FromProfile(context.Background(), cfg, config.Profile{Provider: "replay", Model: "claude-sonnet-4-5"})
+	require.NoError(t, err, "the primary is fine")
+	assert.NotNil(t, l)
+	assert.Equal(t, "replay", profile.Provider)
+	assert.NoFileExists(t, runs, "the fallback's key is read when a request reaches it")
+}
+
+func TestListModels_OllamaAtProfileBaseURL(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "http://127.0.0.1:1")
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"qwen2.5-coder"}]}`))
+		case "/api/show":
+			_, _ = w.Write([]byte(`{"capabilities":["completion","tools"],"model_info":{"qwen2.context_length":32768}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	models, err := ListModels(context.Background(), config.New(), "ollama", srv.URL+"/v1/")
+	require.NoError(t, err)
+	assert.Equal(t, []llm.Model{{ID: "qwen2.5-coder", ContextWindow: 32768, Capabilities: []string{llm.CapabilityTools}}}, models)
+}
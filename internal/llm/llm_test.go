@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	limits := Limits{Provider: "Gemini", MaxTemperature: 2, Stop: true, MaxStop: 2, ThinkingBudget: true, MinThinkingBudget: 1024}
+	float := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name    string
+		opts    Options
+		limits  Limits
+		wantErr string
+	}{
+		{name: "defaults", limits: limits},
+		{name: "within limits", opts: Options{Temperature: float(1.5), MaxTokens: 100, TopP: float(0.9), Stop: []string{"END"}}, limits: limits},
+		{name: "temperature too high", opts: Options{Temperature: float(1.5)}, limits: Limits{Provider: "Anthropic", MaxTemperature: 1, Stop: true}, wantErr: "for Anthropic: temperature must be between 0 and 1, got 1.5"},
+		{name: "negative temperature", opts: Options{Temperature: float(-0.1)}, limits: limits, wantErr: "temperature must be between 0 and 2"},
+		{name: "zero top_p", opts: Options{TopP: float(0)}, limits: limits, wantErr: "top_p must be greater than 0"},
+		{name: "negative max_tokens", opts: Options{MaxTokens: -1}, limits: limits, wantErr: "max_tokens must be positive"},
+		{name: "stop unsupported", opts: Options{Stop: []string{"END"}}, limits: Limits{Provider: "OpenAI", MaxTemperature: 2}, wantErr: "for OpenAI: stop sequences are not supported"},
+		{name: "reasoning", opts: Options{Reasoning: ReasoningHigh}, limits: Limits{Provider: "OpenAI", MaxTemperature: 2, Reasoning: true}},
+		{name: "reasoning as budget", opts: Options{Reasoning: ReasoningLow}, limits: limits},
+		{name: "unknown reasoning effort", opts: Options{Reasoning: "max"}, limits: limits, wantErr: `reasoning must be low, medium or high, got "max"`},
+		{name: "reasoning unsupported", opts: Options{Reasoning: ReasoningLow}, limits: Limits{Provider: "Ollama", MaxTemperature: 2}, wantErr: "reasoning is not supported"},
+		{name: "budget unsupported", opts: Options{ThinkingBudget: 4096}, limits: Limits{Provider: "OpenAI", MaxTemperature: 2, Reasoning: true}, wantErr: "thinking_budget is not supported"},
+		{name: "budget too small", opts: Options{ThinkingBudget: 100}, limits: limits, wantErr: "thinking_budget must be at least 1024, got 100"},
+		{name: "reasoning and budget", opts: Options{Reasoning: ReasoningLow, ThinkingBudget: 4096}, limits: limits, wantErr: "not both"},
+		{name: "too many stop sequences", opts: Options{Stop: []string{"a", "b", "c"}}, limits: limits, wantErr: "at most 2 stop sequences are supported, got 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate(tt.limits)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestOptionsSplitPrompt(t *testing.T) {
+	prompt := "## Branch: main\n\nhéllo diff"
+
+	prefix, rest := Options{CachePrefix: 17}.SplitPrompt(prompt)
+	assert.Empty(t, prefix, "caching disabled")
+	assert.Equal(t, prompt, rest)
+
+	prefix, rest = Options{Cache: true, CachePrefix: 17}.SplitPrompt(prompt)
+	assert.Equal(t, "## Branch: main\n\n", prefix)
+	assert.Equal(t, "héllo diff", rest)
+
+	// A prefix ending inside a multi-byte rune backs off to its start.
+	prefix, rest = Options{Cache: true, CachePrefix: 19}.SplitPrompt(prompt)
+	assert.Equal(t, "## Branch: main\n\nh", prefix)
+	assert.Equal(t, "éllo diff", rest)
+
+	prefix, rest = Options{Cache: true, CachePrefix: 1000}.SplitPrompt(prompt)
+	assert.Equal(t, prompt, prefix)
+	assert.Empty(t, rest)
+}
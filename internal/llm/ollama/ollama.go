@@ -1,153 +1,128 @@
 package ollama
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/ionut-t/bark/v2/internal/llm"
-	"github.com/openai/openai-go/v3"
+	"github.com/ionut-t/bark/v2/internal/llm/chatcompletions"
 	"github.com/openai/openai-go/v3/option"
 )
 
+// Ollama talks to an Ollama server through its OpenAI-compatible API, and
+// lists models through its native one.
 type Ollama struct {
-	model  string
-	client openai.Client
+	*chatcompletions.ChatCompletions
+	host string
 }
 
-func buildMessages(system, prompt string) []openai.ChatCompletionMessageParamUnion {
-	msgs := []openai.ChatCompletionMessageParamUnion{}
-	if system != "" {
-		msgs = append(msgs, openai.SystemMessage(system))
-	}
-	return append(msgs, openai.UserMessage(prompt))
-}
-
-func New(model string) *Ollama {
-	host := os.Getenv("OLLAMA_HOST")
+// New returns a client for the Ollama server at baseURL, the server's
+// OpenAI-compatible endpoint (e.g. http://localhost:11434/v1), else at
+// OLLAMA_HOST. opts can override the timeout and other request options.
+func New(model, baseURL string, opts ...option.RequestOption) *Ollama {
+	// The native API is served at the root of the OpenAI-compatible one.
+	host := strings.TrimSuffix(strings.TrimRight(baseURL, "/"), "/v1")
 	if host == "" {
-		host = "http://localhost:11434"
+		host = cmp.Or(os.Getenv("OLLAMA_HOST"), "http://localhost:11434")
 	}
 	host = strings.TrimRight(host, "/")
 
-	client := openai.NewClient(
-		option.WithBaseURL(host+"/v1"),
-		option.WithAPIKey("ollama"),
-	)
-
-	return &Ollama{model: model, client: client}
+	opts = append([]option.RequestOption{option.WithAPIKey("ollama")}, opts...)
+	return &Ollama{
+		ChatCompletions: chatcompletions.New("Ollama", model, host+"/v1", opts...),
+		host:            host,
+	}
 }
 
-func (o *Ollama) Stream(ctx context.Context, system, prompt string) (<-chan llm.Response, <-chan error) {
-	out := make(chan llm.Response)
-	errChan := make(chan error, 1)
+type tagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
 
-	go func() {
-		defer close(out)
-		defer close(errChan)
+type showResponse struct {
+	Capabilities []string `json:"capabilities"`
+	// ModelInfo holds the architecture's parameters, such as
+	// "llama.context_length".
+	ModelInfo map[string]any `json:"model_info"`
+}
 
-		if ctx.Err() != nil {
-			errChan <- ctx.Err()
-			return
-		}
+// capabilityNames maps Ollama's capabilities to the ones models report.
+var capabilityNames = map[string]string{
+	"thinking": llm.CapabilityThinking,
+	"vision":   llm.CapabilityVision,
+	"tools":    llm.CapabilityTools,
+}
 
-		stream := o.client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-			Model:         openai.ChatModel(o.model),
-			Messages:      buildMessages(system, prompt),
-			StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
-		})
-		defer func() {
-			if err := stream.Close(); err != nil {
-				errChan <- err
-			}
-		}()
-
-		var usage *llm.Usage
-		for stream.Next() {
-			select {
-			case <-ctx.Done():
-				errChan <- ctx.Err()
-				return
-			default:
-			}
+// ListModels lists the models pulled on the server. Their context window
+// and capabilities are read from /api/show, when the server reports them.
+func (o *Ollama) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var tags tagsResponse
+	if err := o.call(ctx, http.MethodGet, "/api/tags", nil, &tags); err != nil {
+		return nil, fmt.Errorf("failed to list Ollama models: %w", err)
+	}
 
-			chunk := stream.Current()
-			if chunk.Usage.TotalTokens > 0 {
-				usage = &llm.Usage{
-					InputTokens:  chunk.Usage.PromptTokens,
-					OutputTokens: chunk.Usage.CompletionTokens,
-					TotalTokens:  chunk.Usage.TotalTokens,
-				}
-			}
+	models := make([]llm.Model, 0, len(tags.Models))
+	for _, tag := range tags.Models {
+		model := llm.Model{ID: tag.Name}
 
-			if len(chunk.Choices) == 0 {
+		var show showResponse
+		if err := o.call(ctx, http.MethodPost, "/api/show", map[string]string{"model": tag.Name}, &show); err == nil {
+			// Embedding models can't generate text.
+			if slices.Contains(show.Capabilities, "embedding") && !slices.Contains(show.Capabilities, "completion") {
 				continue
 			}
-
-			delta := chunk.Choices[0].Delta.Content
-			if delta == "" {
-				continue
-			}
-
-			select {
-			case out <- llm.Response{Content: delta, Time: time.Now()}:
-			case <-ctx.Done():
-				errChan <- ctx.Err()
-				return
+			for _, capability := range show.Capabilities {
+				if name, ok := capabilityNames[capability]; ok {
+					model.Capabilities = append(model.Capabilities, name)
+				}
 			}
-		}
-
-		if err := stream.Err(); err != nil {
-			errChan <- err
-		} else if usage != nil {
-			select {
-			case out <- llm.Response{Usage: usage, Time: time.Now()}:
-			case <-ctx.Done():
-				errChan <- ctx.Err()
-				return
+			for key, value := range show.ModelInfo {
+				if tokens, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+					model.ContextWindow = int64(tokens)
+				}
 			}
 		}
-	}()
 
-	return out, errChan
+		models = append(models, model)
+	}
+	return models, nil
 }
 
-func (o *Ollama) Generate(ctx context.Context, system, prompt string) (llm.Response, error) {
-	if ctx.Err() != nil {
-		return llm.Response{}, ctx.Err()
+// call sends body, if any, as JSON to the native API and decodes the
+// response into out.
+func (o *Ollama) call(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
 	}
 
-	resp, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model:    openai.ChatModel(o.model),
-		Messages: buildMessages(system, prompt),
-	})
+	req, err := http.NewRequestWithContext(ctx, method, o.host+path, reader)
 	if err != nil {
-		return llm.Response{}, fmt.Errorf("ollama request failed: %w", err)
-	}
-
-	if len(resp.Choices) == 0 {
-		return llm.Response{}, fmt.Errorf("no response from Ollama")
+		return err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	content := resp.Choices[0].Message.Content
-	if content == "" {
-		return llm.Response{}, fmt.Errorf("empty response from Ollama")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	var usage *llm.Usage
-	if resp.Usage.TotalTokens > 0 {
-		usage = &llm.Usage{
-			InputTokens:  resp.Usage.PromptTokens,
-			OutputTokens: resp.Usage.CompletionTokens,
-			TotalTokens:  resp.Usage.TotalTokens,
-		}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
 	}
-
-	return llm.Response{
-		Content: content,
-		Time:    time.Now(),
-		Usage:   usage,
-	}, nil
+	return json.NewDecoder(resp.Body).Decode(out)
 }
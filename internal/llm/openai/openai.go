@@ -3,14 +3,20 @@ package openai
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ionut-t/bark/v2/internal/llm"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 )
 
+// Limits are the generation options the Responses API accepts; it has no
+// stop sequences, and reasoning models take an effort rather than a budget.
+var Limits = llm.Limits{Provider: "OpenAI", MaxTemperature: 2, Reasoning: true}
+
 type OpenAI struct {
 	model  string
 	client openai.Client
@@ -22,15 +28,79 @@ func applySystem(params *responses.ResponseNewParams, system string) {
 	}
 }
 
-func New(model string, apiKey string) *OpenAI {
-	client := openai.NewClient(option.WithAPIKey(apiKey))
+func applyOptions(params *responses.ResponseNewParams, opts llm.Options) {
+	if opts.MaxTokens > 0 {
+		params.MaxOutputTokens = openai.Int(opts.MaxTokens)
+	}
+	if opts.Temperature != nil {
+		params.Temperature = openai.Float(*opts.Temperature)
+	}
+	if opts.TopP != nil {
+		params.TopP = openai.Float(*opts.TopP)
+	}
+	if opts.Reasoning != "" {
+		// Reasoning itself is never returned; ask for a summary of it.
+		params.Reasoning = shared.ReasoningParam{
+			Effort:  shared.ReasoningEffort(opts.Reasoning),
+			Summary: shared.ReasoningSummaryAuto,
+		}
+	}
+}
+
+// toUsage converts usage. OpenAI caches long prompt prefixes on its own, so
+// cache reads are reported without Options.Cache.
+func toUsage(usage responses.ResponseUsage) *llm.Usage {
+	return &llm.Usage{
+		InputTokens:     usage.InputTokens,
+		CacheReadTokens: usage.InputTokensDetails.CachedTokens,
+		OutputTokens:    usage.OutputTokens,
+		ThinkingTokens:  usage.OutputTokensDetails.ReasoningTokens,
+		TotalTokens:     usage.TotalTokens,
+	}
+}
+
+// reasoningSummary joins the reasoning summaries in output.
+func reasoningSummary(output []responses.ResponseOutputItemUnion) string {
+	var parts []string
+	for _, item := range output {
+		if item.Type != "reasoning" {
+			continue
+		}
+		for _, summary := range item.AsReasoning().Summary {
+			parts = append(parts, summary.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// New returns a client for model. opts can override the endpoint, timeout
+// and other request options.
+func New(model string, apiKey string, opts ...option.RequestOption) *OpenAI {
+	client := openai.NewClient(append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)...)
 	return &OpenAI{
 		model:  model,
 		client: client,
 	}
 }
 
-func (o *OpenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.Response, <-chan error) {
+func (o *OpenAI) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return o.stream(ctx, system, responses.ResponseNewParamsInputUnion{OfString: openai.String(prompt)}, opts)
+}
+
+func (o *OpenAI) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	items := make(responses.ResponseInputParam, len(messages))
+	for i, m := range messages {
+		role := responses.EasyInputMessageRoleUser
+		if m.Role == llm.RoleAssistant {
+			role = responses.EasyInputMessageRoleAssistant
+		}
+		items[i] = responses.ResponseInputItemParamOfMessage(m.Content, role)
+	}
+
+	return o.stream(ctx, system, responses.ResponseNewParamsInputUnion{OfInputItemList: items}, opts)
+}
+
+func (o *OpenAI) stream(ctx context.Context, system string, input responses.ResponseNewParamsInputUnion, opts llm.Options) (<-chan llm.Response, <-chan error) {
 	out := make(chan llm.Response)
 	errChan := make(chan error, 1)
 
@@ -43,11 +113,17 @@ func (o *OpenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.
 			return
 		}
 
+		if err := opts.Validate(Limits); err != nil {
+			errChan <- err
+			return
+		}
+
 		params := responses.ResponseNewParams{
-			Input: responses.ResponseNewParamsInputUnion{OfString: openai.String(prompt)},
+			Input: input,
 			Model: openai.ChatModel(o.model),
 		}
 		applySystem(&params, system)
+		applyOptions(&params, opts)
 
 		stream := o.client.Responses.NewStreaming(ctx, params)
 		defer func() {
@@ -66,30 +142,29 @@ func (o *OpenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.
 			}
 
 			event := stream.Current()
-			if event.Type == "response.completed" {
-				respUsage := event.AsResponseCompleted().Response.Usage
-				usage = &llm.Usage{
-					InputTokens:  respUsage.InputTokens,
-					OutputTokens: respUsage.OutputTokens,
-					TotalTokens:  respUsage.TotalTokens,
-				}
-				continue
-			}
 
-			if event.Type != "response.output_text.delta" {
+			var resp llm.Response
+			switch event.Type {
+			case "response.completed":
+				usage = toUsage(event.AsResponseCompleted().Response.Usage)
 				continue
+			case "response.output_text.delta":
+				resp.Content = event.AsResponseOutputTextDelta().Delta
+			case "response.reasoning_summary_text.delta":
+				resp.Thinking = event.AsResponseReasoningSummaryTextDelta().Delta
+			case "response.reasoning_summary_part.added":
+				// Separate the summary's paragraphs.
+				if event.AsResponseReasoningSummaryPartAdded().SummaryIndex > 0 {
+					resp.Thinking = "\n\n"
+				}
 			}
-
-			delta := event.AsResponseOutputTextDelta()
-			if delta.Delta == "" {
+			if resp.Content == "" && resp.Thinking == "" {
 				continue
 			}
+			resp.Time = time.Now()
 
 			select {
-			case out <- llm.Response{
-				Content: delta.Delta,
-				Time:    time.Now(),
-			}:
+			case out <- resp:
 			case <-ctx.Done():
 				errChan <- ctx.Err()
 				return
@@ -111,16 +186,21 @@ func (o *OpenAI) Stream(ctx context.Context, system, prompt string) (<-chan llm.
 	return out, errChan
 }
 
-func (o *OpenAI) Generate(ctx context.Context, system, prompt string) (llm.Response, error) {
+func (o *OpenAI) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
 	if ctx.Err() != nil {
 		return llm.Response{}, ctx.Err()
 	}
 
+	if err := opts.Validate(Limits); err != nil {
+		return llm.Response{}, err
+	}
+
 	params := responses.ResponseNewParams{
 		Input: responses.ResponseNewParamsInputUnion{OfString: openai.String(prompt)},
 		Model: openai.ChatModel(o.model),
 	}
 	applySystem(&params, system)
+	applyOptions(&params, opts)
 
 	resp, err := o.client.Responses.New(ctx, params)
 	if err != nil {
@@ -132,15 +212,70 @@ func (o *OpenAI) Generate(ctx context.Context, system, prompt string) (llm.Respo
 		return llm.Response{}, fmt.Errorf("no response from LLM")
 	}
 
-	usage := &llm.Usage{
-		InputTokens:  resp.Usage.InputTokens,
-		OutputTokens: resp.Usage.OutputTokens,
-		TotalTokens:  resp.Usage.TotalTokens,
+	return llm.Response{
+		Content:  text,
+		Thinking: reasoningSummary(resp.Output),
+		Time:     time.Now(),
+		Usage:    toUsage(resp.Usage),
+	}, nil
+}
+
+func (o *OpenAI) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	if ctx.Err() != nil {
+		return llm.Response{}, ctx.Err()
+	}
+
+	if err := opts.Validate(Limits); err != nil {
+		return llm.Response{}, err
+	}
+
+	format := &responses.ResponseFormatTextJSONSchemaConfigParam{
+		Name:   schema.Name,
+		Schema: schema.Definition,
+		Strict: openai.Bool(true),
+	}
+	if schema.Description != "" {
+		format.Description = openai.String(schema.Description)
+	}
+
+	params := responses.ResponseNewParams{
+		Input: responses.ResponseNewParamsInputUnion{OfString: openai.String(prompt)},
+		Model: openai.ChatModel(o.model),
+		Text: responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigUnionParam{OfJSONSchema: format},
+		},
+	}
+	applySystem(&params, system)
+	applyOptions(&params, opts)
+
+	resp, err := o.client.Responses.New(ctx, params)
+	if err != nil {
+		return llm.Response{}, fmt.Errorf("openai request failed: %w", err)
+	}
+
+	text := resp.OutputText()
+	if text == "" {
+		return llm.Response{}, fmt.Errorf("no response from LLM")
 	}
 
 	return llm.Response{
-		Content: text,
-		Time:    time.Now(),
-		Usage:   usage,
+		Content:  text,
+		Thinking: reasoningSummary(resp.Output),
+		Time:     time.Now(),
+		Usage:    toUsage(resp.Usage),
 	}, nil
 }
+
+// ListModels lists the models available to the API key. OpenAI doesn't
+// report their limits or capabilities.
+func (o *OpenAI) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var models []llm.Model
+	iter := o.client.Models.ListAutoPaging(ctx)
+	for iter.Next() {
+		models = append(models, llm.Model{ID: iter.Current().ID})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list OpenAI models: %w", err)
+	}
+	return models, nil
+}
@@ -0,0 +1,28 @@
+// Package openaicompatible connects to any server that implements the OpenAI
+// Chat Completions API, such as LM Studio, vLLM, llama.cpp server, OpenRouter,
+// Groq or an internal gateway.
+package openaicompatible
+
+import (
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/llm/chatcompletions"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// New returns a client for the API at baseURL. apiKey may be empty for local
+// servers that don't check it; headers are sent with every request. opts can
+// set the timeout and other request options.
+func New(model, baseURL, apiKey string, headers map[string]string, opts ...option.RequestOption) *chatcompletions.ChatCompletions {
+	// The SDK requires a key, and local servers ignore whatever is sent.
+	if apiKey == "" {
+		apiKey = "none"
+	}
+
+	opts = append([]option.RequestOption{option.WithAPIKey(apiKey)}, opts...)
+	for name, value := range headers {
+		opts = append(opts, option.WithHeader(name, value))
+	}
+
+	return chatcompletions.New("OpenAI-compatible server", model, strings.TrimRight(baseURL, "/"), opts...)
+}
@@ -0,0 +1,188 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
+)
+
+// Recorder appends the calls made by the clients it wraps to a fixture.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var (
+	recordersMu sync.Mutex
+	recorders   = map[string]*Recorder{}
+)
+
+// NewRecorder returns the recorder of the fixture at path. The file is
+// truncated the first time it is opened, and later calls return the same
+// recorder, so the clients of every task record to one fixture.
+func NewRecorder(path string) (*Recorder, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve replay fixture path: %w", err)
+	}
+
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+
+	if r, ok := recorders[abs]; ok {
+		return r, nil
+	}
+
+	f, err := os.Create(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replay fixture: %w", err)
+	}
+
+	r := &Recorder{file: f}
+	recorders[abs] = r
+	return r, nil
+}
+
+func (r *Recorder) write(c Call) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode recorded call: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to record call: %w", err)
+	}
+	return nil
+}
+
+// Wrap returns l with every request it serves recorded, naming provider and
+// model. Wrap the provider's own client, inside any retries, so that failed
+// attempts are recorded and retried again on replay.
+func (r *Recorder) Wrap(l llm.LLM, provider, model string) llm.LLM {
+	return &recording{LLM: l, recorder: r, provider: provider, model: model}
+}
+
+type recording struct {
+	llm.LLM
+	recorder        *Recorder
+	provider, model string
+}
+
+func (c *recording) call(method, key string) Call {
+	return Call{Method: method, Key: key, Provider: c.provider, Model: c.model}
+}
+
+// recordedError keeps what retries and fallbacks look at in err.
+func recordedError(err error, after time.Duration) *Error {
+	status, _ := retry.StatusCode(err)
+	return &Error{AfterMS: after.Milliseconds(), Message: err.Error(), Status: status}
+}
+
+func (c *recording) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	key := Key(MethodText, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, llm.Schema{})
+	return c.generate(MethodText, key, func() (llm.Response, error) {
+		return c.LLM.Generate(ctx, system, prompt, opts)
+	})
+}
+
+func (c *recording) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	key := Key(MethodStructured, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, schema)
+	return c.generate(MethodStructured, key, func() (llm.Response, error) {
+		return c.LLM.GenerateStructured(ctx, system, prompt, schema, opts)
+	})
+}
+
+// generate records a whole response as one chunk that took as long as the
+// request.
+func (c *recording) generate(method, key string, request func() (llm.Response, error)) (llm.Response, error) {
+	startedAt := time.Now()
+	resp, err := request()
+
+	call := c.call(method, key)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return resp, err
+		}
+		call.Error = recordedError(err, time.Since(startedAt))
+	} else {
+		call.Chunks = []Chunk{{
+			AfterMS:  time.Since(startedAt).Milliseconds(),
+			Content:  resp.Content,
+			Thinking: resp.Thinking,
+			Usage:    resp.Usage,
+		}}
+	}
+
+	if recordErr := c.recorder.write(call); recordErr != nil && err == nil {
+		return resp, recordErr
+	}
+	return resp, err
+}
+
+func (c *recording) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return c.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+// Chat forwards the stream of the wrapped client, recording each chunk with
+// its delay. A reply the reader gave up on is not recorded.
+func (c *recording) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	startedAt := time.Now()
+	in, inErrs := c.LLM.Chat(ctx, system, messages, opts)
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		call := c.call(MethodText, Key(MethodText, system, messages, llm.Schema{}))
+		last := startedAt
+		for resp := range in {
+			if resp.Content != "" || resp.Thinking != "" || resp.Usage != nil {
+				now := time.Now()
+				call.Chunks = append(call.Chunks, Chunk{
+					AfterMS:  now.Sub(last).Milliseconds(),
+					Content:  resp.Content,
+					Thinking: resp.Thinking,
+					Usage:    resp.Usage,
+				})
+				last = now
+			}
+
+			select {
+			case out <- resp:
+			case <-ctx.Done():
+			}
+		}
+
+		err := <-inErrs
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			if err != nil {
+				errChan <- err
+			}
+			return
+		}
+		if err != nil {
+			call.Error = recordedError(err, time.Since(last))
+		}
+
+		if recordErr := c.recorder.write(call); recordErr != nil && err == nil {
+			err = recordErr
+		}
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
+	return out, errChan
+}
@@ -0,0 +1,299 @@
+// Package replay plays back provider responses recorded in fixture files,
+// and records them from real providers, so the commit, review and PR flows
+// run without network access or API keys.
+//
+// A fixture is a JSON Lines file with one Call per line, in the order the
+// requests were made.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+)
+
+// Methods a call is made with. Generate, Stream and Chat are interchangeable,
+// so a streamed call can answer a Generate request and the other way round.
+const (
+	MethodText       = "text"
+	MethodStructured = "structured"
+)
+
+// ErrNoResponse is returned when a fixture has no recorded call left for a
+// request.
+var ErrNoResponse = errors.New("no recorded response left")
+
+// Call is one recorded request: the chunks its response streamed, then the
+// error it failed with, if any.
+type Call struct {
+	// Method is MethodText (the default) or MethodStructured.
+	Method string `json:"method,omitempty"`
+	// Key identifies the request (see Key). Calls without a key, such as
+	// hand-written ones, answer requests in order.
+	Key string `json:"key,omitempty"`
+	// Provider and Model name what the call was recorded from.
+	Provider string  `json:"provider,omitempty"`
+	Model    string  `json:"model,omitempty"`
+	Chunks   []Chunk `json:"chunks,omitempty"`
+	Error    *Error  `json:"error,omitempty"`
+}
+
+// Chunk is one streamed response.
+type Chunk struct {
+	// AfterMS is the delay in milliseconds since the previous chunk, or
+	// since the request for the first one.
+	AfterMS  int64      `json:"after_ms,omitempty"`
+	Content  string     `json:"content,omitempty"`
+	Thinking string     `json:"thinking,omitempty"`
+	Usage    *llm.Usage `json:"usage,omitempty"`
+}
+
+// Error is a recorded failure. It reports its HTTP status like the provider
+// error it was recorded from, so retries and fallbacks treat it the same.
+type Error struct {
+	AfterMS int64  `json:"after_ms,omitempty"`
+	Message string `json:"message"`
+	// Status is the HTTP status of a provider API error, if any.
+	Status int `json:"status,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) HTTPStatus() int {
+	return e.Status
+}
+
+// request is what a key is made from. Options are left out so a fixture
+// keeps working when the profile's options change.
+type request struct {
+	Method   string        `json:"method"`
+	System   string        `json:"system"`
+	Messages []llm.Message `json:"messages"`
+	Schema   llm.Schema    `json:"schema"`
+}
+
+// Key returns the key of a request.
+func Key(method, system string, messages []llm.Message, schema llm.Schema) string {
+	// Marshalling a struct of plain values can't fail.
+	data, _ := json.Marshal(request{Method: method, System: system, Messages: messages, Schema: schema})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Replay is an llm.LLM that answers requests with the calls of a fixture.
+// Each call is played once: a request gets the first unplayed call recorded
+// with its key, else the next unplayed call of its method. Requests that
+// differ from the recorded ones, e.g. after a prompt change, are still
+// answered in order.
+type Replay struct {
+	path    string
+	instant bool
+
+	mu     sync.Mutex
+	calls  []Call
+	played []bool
+}
+
+// New loads the fixture at path. Responses are delayed as recorded unless
+// instant is set.
+func New(path string, instant bool) (*Replay, error) {
+	calls, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replay{path: path, instant: instant, calls: calls, played: make([]bool, len(calls))}, nil
+}
+
+// Load reads the calls of the fixture at path. Blank lines are skipped.
+func Load(path string) ([]Call, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay fixture: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var calls []Call
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var c Call
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("invalid replay fixture %s:%d: %w", path, line, err)
+		}
+		calls = append(calls, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read replay fixture: %w", err)
+	}
+
+	return calls, nil
+}
+
+// ListModels lists the models the fixture was recorded from, in the order
+// they were first used. Any of them plays back the same responses.
+func (r *Replay) ListModels(ctx context.Context) ([]llm.Model, error) {
+	var models []llm.Model
+	for _, c := range r.calls {
+		if c.Model != "" && !slices.ContainsFunc(models, func(m llm.Model) bool { return m.ID == c.Model }) {
+			models = append(models, llm.Model{ID: c.Model})
+		}
+	}
+	return models, nil
+}
+
+// next takes the call that answers a request.
+func (r *Replay) next(method, key string) (Call, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, c := range r.calls {
+		if r.played[i] || methodOf(c) != method {
+			continue
+		}
+		if c.Key == key {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return Call{}, fmt.Errorf("%w in %s", ErrNoResponse, r.path)
+	}
+
+	r.played[match] = true
+	return r.calls[match], nil
+}
+
+func methodOf(c Call) string {
+	if c.Method == "" {
+		return MethodText
+	}
+	return c.Method
+}
+
+// wait sleeps for a recorded delay, unless playing back instantly.
+func (r *Replay) wait(ctx context.Context, ms int64) error {
+	if r.instant || ms <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Replay) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	messages := []llm.Message{{Role: llm.RoleUser, Content: prompt}}
+	return r.generate(ctx, MethodText, Key(MethodText, system, messages, llm.Schema{}))
+}
+
+func (r *Replay) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	messages := []llm.Message{{Role: llm.RoleUser, Content: prompt}}
+	return r.generate(ctx, MethodStructured, Key(MethodStructured, system, messages, schema))
+}
+
+// generate plays a call back whole, after the delays of all its chunks.
+func (r *Replay) generate(ctx context.Context, method, key string) (llm.Response, error) {
+	call, err := r.next(method, key)
+	if err != nil {
+		return llm.Response{}, err
+	}
+
+	var (
+		resp             llm.Response
+		content, thought strings.Builder
+	)
+	for _, chunk := range call.Chunks {
+		if err := r.wait(ctx, chunk.AfterMS); err != nil {
+			return llm.Response{}, err
+		}
+		content.WriteString(chunk.Content)
+		thought.WriteString(chunk.Thinking)
+		if chunk.Usage != nil {
+			resp.Usage = chunk.Usage
+		}
+	}
+
+	if call.Error != nil {
+		if err := r.wait(ctx, call.Error.AfterMS); err != nil {
+			return llm.Response{}, err
+		}
+		return llm.Response{}, call.Error
+	}
+
+	resp.Content, resp.Thinking, resp.Time = content.String(), thought.String(), time.Now()
+	return resp, nil
+}
+
+func (r *Replay) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return r.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+// Chat streams the chunks of a call with their recorded delays, then fails
+// with its error, if any.
+func (r *Replay) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		call, err := r.next(MethodText, Key(MethodText, system, messages, llm.Schema{}))
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		for _, chunk := range call.Chunks {
+			if err := r.wait(ctx, chunk.AfterMS); err != nil {
+				errChan <- err
+				return
+			}
+
+			select {
+			case out <- llm.Response{Content: chunk.Content, Thinking: chunk.Thinking, Usage: chunk.Usage, Time: time.Now()}:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+
+		if call.Error != nil {
+			if err := r.wait(ctx, call.Error.AfterMS); err != nil {
+				errChan <- err
+				return
+			}
+			errChan <- call.Error
+		}
+	}()
+
+	return out, errChan
+}
@@ -0,0 +1,195 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLLM streams a review, answers Generate with a commit message and fails
+// structured requests as overloaded.
+type fakeLLM struct {
+	llm.LLM
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		respChan <- llm.Response{Thinking: "hmm"}
+		respChan <- llm.Response{Content: "## Summary\n"}
+		respChan <- llm.Response{Content: "Looks good."}
+		respChan <- llm.Response{Usage: &llm.Usage{InputTokens: 10, OutputTokens: 4, TotalTokens: 14}}
+	}()
+	return respChan, errChan
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	return llm.Response{Content: "feat: add replay", Usage: &llm.Usage{TotalTokens: 7}}, nil
+}
+
+func (f *fakeLLM) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	return llm.Response{}, &Error{Message: "overloaded", Status: 529}
+}
+
+func drain(respChan <-chan llm.Response, errChan <-chan error) (string, string, *llm.Usage, error) {
+	var content, thinking string
+	var usage *llm.Usage
+	for resp := range respChan {
+		content += resp.Content
+		thinking += resp.Thinking
+		if resp.Usage != nil {
+			usage = resp.Usage
+		}
+	}
+	return content, thinking, usage, <-errChan
+}
+
+func writeFixture(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.jsonl")
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line+"\n"...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	recorder, err := NewRecorder(path)
+	require.NoError(t, err)
+
+	again, err := NewRecorder(path)
+	require.NoError(t, err)
+	assert.Same(t, recorder, again, "clients of every task share the fixture")
+
+	ctx := context.Background()
+	client := recorder.Wrap(&fakeLLM{}, "anthropic", "claude-sonnet-4")
+
+	content, _, _, err := drain(client.Stream(ctx, "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", content)
+
+	_, err = client.Generate(ctx, "commit system", "diff", llm.Options{})
+	require.NoError(t, err)
+
+	_, err = client.GenerateStructured(ctx, "system", "diff", llm.Schema{Name: "findings"}, llm.Options{})
+	require.Error(t, err)
+
+	calls, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, calls, 3)
+	assert.Equal(t, "anthropic", calls[0].Provider)
+	assert.Equal(t, "claude-sonnet-4", calls[0].Model)
+	assert.Len(t, calls[0].Chunks, 4)
+	assert.Equal(t, MethodStructured, calls[2].Method)
+	assert.Equal(t, 529, calls[2].Error.Status)
+
+	replay, err := New(path, true)
+	require.NoError(t, err)
+
+	// The calls are found by key, whatever the order of the requests.
+	_, err = replay.GenerateStructured(ctx, "system", "diff", llm.Schema{Name: "findings"}, llm.Options{})
+	require.Error(t, err)
+	status, ok := retry.StatusCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, 529, status)
+	assert.True(t, retry.Transient(err))
+
+	resp, err := replay.Generate(ctx, "commit system", "diff", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "feat: add replay", resp.Content)
+	assert.Equal(t, int64(7), resp.Usage.TotalTokens)
+
+	content, thinking, usage, err := drain(replay.Stream(ctx, "system", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nLooks good.", content)
+	assert.Equal(t, "hmm", thinking)
+	assert.Equal(t, &llm.Usage{InputTokens: 10, OutputTokens: 4, TotalTokens: 14}, usage)
+
+	_, err = replay.Generate(ctx, "system", "diff", llm.Options{})
+	assert.ErrorIs(t, err, ErrNoResponse)
+}
+
+func TestReplay_AnswersUnknownRequestsInOrder(t *testing.T) {
+	path := writeFixture(t,
+		`{"chunks":[{"content":"first"}]}`,
+		``,
+		`{"method":"structured","chunks":[{"content":"{\"findings\":[]}"}]}`,
+		`{"chunks":[{"content":"second"}]}`,
+	)
+	replay, err := New(path, true)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, want := range []string{"first", "second"} {
+		resp, err := replay.Generate(ctx, "", "a prompt never recorded", llm.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, want, resp.Content)
+	}
+
+	resp, err := replay.GenerateStructured(ctx, "", "prompt", llm.Schema{}, llm.Options{})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"findings":[]}`, resp.Content)
+}
+
+func TestReplay_InjectsErrorsMidStream(t *testing.T) {
+	path := writeFixture(t,
+		`{"chunks":[{"content":"The change "},{"content":"looks"}],"error":{"message":"connection reset","status":503}}`,
+		`{"error":{"message":"invalid api key","status":401}}`,
+	)
+	replay, err := New(path, true)
+	require.NoError(t, err)
+
+	content, _, _, err := drain(replay.Stream(context.Background(), "", "diff", llm.Options{}))
+	assert.Equal(t, "The change looks", content)
+	require.EqualError(t, err, "connection reset")
+	assert.True(t, retry.Transient(err))
+
+	_, err = replay.Generate(context.Background(), "", "diff", llm.Options{})
+	require.Error(t, err)
+	status, _ := retry.StatusCode(err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.False(t, retry.Transient(err))
+}
+
+func TestReplay_KeepsRecordedDelays(t *testing.T) {
+	path := writeFixture(t, `{"chunks":[{"after_ms":30,"content":"slow"},{"after_ms":30,"content":" reply"}]}`)
+
+	replay, err := New(path, false)
+	require.NoError(t, err)
+	startedAt := time.Now()
+	content, _, _, err := drain(replay.Stream(context.Background(), "", "diff", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "slow reply", content)
+	assert.GreaterOrEqual(t, time.Since(startedAt), 60*time.Millisecond)
+
+	// A cancelled request stops waiting.
+	replay, err = New(path, false)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = replay.Generate(ctx, "", "diff", llm.Options{})
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestNew_RejectsInvalidFixtures(t *testing.T) {
+	_, err := New(writeFixture(t, `{"chunks":[]}`, `not json`), true)
+	assert.ErrorContains(t, err, "fixture.jsonl:2")
+
+	_, err = New(filepath.Join(t.TempDir(), "missing.jsonl"), true)
+	assert.Error(t, err)
+}
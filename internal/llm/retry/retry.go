@@ -0,0 +1,350 @@
+// Package retry wraps an llm.LLM so transient provider errors are retried
+// with backoff, and streams that break part way are resumed.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+)
+
+// maxRetryAfter is the longest wait a server can ask for before the error is
+// returned instead: a rate limit that far out won't clear within a review.
+const maxRetryAfter = 2 * time.Minute
+
+// continuePrompt asks the model to resume a reply that was cut off.
+const continuePrompt = "Your previous response was cut off. Continue it from exactly where it stopped, without repeating anything or adding any preamble."
+
+// Policy controls how often and how long a failed request is retried.
+type Policy struct {
+	// MaxAttempts is the number of attempts, the first included.
+	MaxAttempts int
+	// BaseDelay is the delay before the second attempt. It doubles with
+	// every attempt up to MaxDelay, and is jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultPolicy is the policy bark's clients use.
+var DefaultPolicy = Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+type notifyKey struct{}
+
+// WithNotify makes requests made with ctx call notify before each retry.
+// Streams also report retries as responses (see llm.Response.Retrying);
+// notify is how callers of Generate and GenerateStructured learn of them.
+func WithNotify(ctx context.Context, notify func(llm.Retrying)) context.Context {
+	return context.WithValue(ctx, notifyKey{}, notify)
+}
+
+func notifyFrom(ctx context.Context) func(llm.Retrying) {
+	notify, _ := ctx.Value(notifyKey{}).(func(llm.Retrying))
+	return notify
+}
+
+// retrier is an llm.LLM that retries the requests of the wrapped client.
+type retrier struct {
+	llm.LLM
+	policy Policy
+	// sleep waits for d or until ctx is done; replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Wrap returns client with transient errors retried according to policy.
+func Wrap(client llm.LLM, policy Policy) llm.LLM {
+	return &retrier{LLM: client, policy: policy, sleep: sleep}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next returns the retry to make after attempt failed with err, or false
+// when err is final or ctx, the caller's, is done.
+func (r *retrier) next(ctx context.Context, attempt int, err error) (llm.Retrying, bool) {
+	if attempt >= r.policy.MaxAttempts || ctx.Err() != nil || !Transient(err) {
+		return llm.Retrying{}, false
+	}
+
+	delay, ok := retryAfter(err)
+	if !ok {
+		delay = r.backoff(attempt)
+	}
+	if delay > maxRetryAfter {
+		return llm.Retrying{}, false
+	}
+
+	return llm.Retrying{Attempt: attempt + 1, MaxAttempts: r.policy.MaxAttempts, Delay: delay, Err: err}, true
+}
+
+// backoff is the jittered delay after the given failed attempt: a random
+// duration in the upper half of the exponential delay.
+func (r *retrier) backoff(attempt int) time.Duration {
+	d := r.policy.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		d = min(r.policy.BaseDelay<<shift, r.policy.MaxDelay)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func (r *retrier) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	return r.generate(ctx, func() (llm.Response, error) {
+		return r.LLM.Generate(ctx, system, prompt, opts)
+	})
+}
+
+func (r *retrier) GenerateStructured(ctx context.Context, system, prompt string, schema llm.Schema, opts llm.Options) (llm.Response, error) {
+	return r.generate(ctx, func() (llm.Response, error) {
+		return r.LLM.GenerateStructured(ctx, system, prompt, schema, opts)
+	})
+}
+
+func (r *retrier) generate(ctx context.Context, request func() (llm.Response, error)) (llm.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := request()
+		if err == nil {
+			return resp, nil
+		}
+
+		retrying, ok := r.next(ctx, attempt, err)
+		if !ok {
+			return resp, err
+		}
+		if notify := notifyFrom(ctx); notify != nil {
+			notify(retrying)
+		}
+		if err := r.sleep(ctx, retrying.Delay); err != nil {
+			return llm.Response{}, err
+		}
+	}
+}
+
+func (r *retrier) Stream(ctx context.Context, system, prompt string, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	return r.Chat(ctx, system, []llm.Message{{Role: llm.RoleUser, Content: prompt}}, opts)
+}
+
+// Chat retries a conversation that fails before any content arrives. Once
+// the reply has started, a failure is followed by a request to continue it,
+// so the content already streamed stays valid. Usage is summed over every
+// attempt and sent once the stream ends.
+func (r *retrier) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	out := make(chan llm.Response)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errChan)
+
+		send := func(resp llm.Response) bool {
+			select {
+			case out <- resp:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var (
+			usage   *llm.Usage
+			partial strings.Builder
+		)
+		finish := func(err error) {
+			if usage != nil {
+				send(llm.Response{Usage: usage, Time: time.Now()})
+			}
+			if err != nil {
+				errChan <- err
+			}
+		}
+
+		for attempt := 1; ; attempt++ {
+			request := messages
+			if partial.Len() > 0 {
+				request = append(append([]llm.Message(nil), messages...),
+					llm.Message{Role: llm.RoleAssistant, Content: partial.String()},
+					llm.Message{Role: llm.RoleUser, Content: continuePrompt},
+				)
+			}
+
+			respChan, attemptErrs := r.LLM.Chat(ctx, system, request, opts)
+			for resp := range respChan {
+				if resp.Usage != nil {
+					if usage == nil {
+						usage = &llm.Usage{}
+					}
+					*usage = usage.Add(*resp.Usage)
+					resp.Usage = nil
+				}
+				if resp.Content == "" && resp.Thinking == "" {
+					continue
+				}
+				partial.WriteString(resp.Content)
+				if !send(resp) {
+					// Let the provider finish so it isn't blocked on a send.
+					for range respChan {
+					}
+				}
+			}
+
+			err := <-attemptErrs
+			if err == nil {
+				finish(nil)
+				return
+			}
+
+			retrying, ok := r.next(ctx, attempt, err)
+			if !ok {
+				finish(err)
+				return
+			}
+			retrying.Restart = partial.Len() == 0
+			if !send(llm.Response{Retrying: &retrying, Time: time.Now()}) {
+				finish(ctx.Err())
+				return
+			}
+			if notify := notifyFrom(ctx); notify != nil {
+				notify(retrying)
+			}
+			if err := r.sleep(ctx, retrying.Delay); err != nil {
+				finish(err)
+				return
+			}
+		}
+	}()
+
+	return out, errChan
+}
+
+// retryableStatus are the HTTP statuses of errors worth retrying: timeouts,
+// rate limits, server errors and Anthropic's overloaded status.
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true,
+}
+
+// retryableTypes are the error types providers report in the body of a
+// stream that fails after it started, when there is no status to go by.
+var retryableTypes = []string{"overloaded_error", "rate_limit_error", "api_error", "server_error", "rate_limit_exceeded"}
+
+// Transient reports whether err is worth retrying: a rate limit, a server
+// error, or a connection that dropped. Cancellation, deadlines and client
+// timeouts are not: the caller set them and they would expire again.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if status, ok := StatusCode(err); ok {
+		return retryableStatus[status]
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if e, ok := errors.AsType[net.Error](err); ok {
+		return !e.Timeout()
+	}
+
+	msg := err.Error()
+	for _, t := range retryableTypes {
+		if strings.Contains(msg, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpStatusError is implemented by errors that carry an HTTP status without
+// being an SDK error, such as replayed ones.
+type httpStatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// StatusCode returns the HTTP status of a provider API error.
+func StatusCode(err error) (int, bool) {
+	if e, ok := errors.AsType[*anthropic.Error](err); ok {
+		return e.StatusCode, true
+	}
+	if e, ok := errors.AsType[*openai.Error](err); ok {
+		return e.StatusCode, true
+	}
+	if e, ok := errors.AsType[genai.APIError](err); ok {
+		return e.Code, true
+	}
+	if e, ok := errors.AsType[httpStatusError](err); ok && e.HTTPStatus() != 0 {
+		return e.HTTPStatus(), true
+	}
+	return 0, false
+}
+
+// retryAfter returns the wait the server asked for with err, from the
+// Retry-After headers or, for Gemini, the RetryInfo detail.
+func retryAfter(err error) (time.Duration, bool) {
+	var header http.Header
+	if e, ok := errors.AsType[*anthropic.Error](err); ok && e.Response != nil {
+		header = e.Response.Header
+	} else if e, ok := errors.AsType[*openai.Error](err); ok && e.Response != nil {
+		header = e.Response.Header
+	} else if e, ok := errors.AsType[genai.APIError](err); ok {
+		for _, detail := range e.Details {
+			if delay, ok := detail["retryDelay"].(string); ok {
+				if d, err := time.ParseDuration(delay); err == nil {
+					return d, true
+				}
+			}
+		}
+	}
+
+	return parseRetryAfter(header)
+}
+
+func parseRetryAfter(header http.Header) (time.Duration, bool) {
+	if header == nil {
+		return 0, false
+	}
+
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+
+	value := header.Get("retry-after")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
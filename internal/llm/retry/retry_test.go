@@ -0,0 +1,265 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+// attempt scripts one request of fakeLLM: the content it streams, then err.
+type attempt struct {
+	content []string
+	err     error
+}
+
+type fakeLLM struct {
+	llm.LLM
+	attempts []attempt
+	requests [][]llm.Message
+}
+
+func (f *fakeLLM) Chat(ctx context.Context, system string, messages []llm.Message, opts llm.Options) (<-chan llm.Response, <-chan error) {
+	a := f.attempts[len(f.requests)]
+	f.requests = append(f.requests, messages)
+
+	respChan := make(chan llm.Response)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(respChan)
+		defer close(errChan)
+		for _, c := range a.content {
+			respChan <- llm.Response{Content: c}
+		}
+		respChan <- llm.Response{Usage: &llm.Usage{InputTokens: 10, OutputTokens: 2, TotalTokens: 12}}
+		if a.err != nil {
+			errChan <- a.err
+		}
+	}()
+	return respChan, errChan
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, system, prompt string, opts llm.Options) (llm.Response, error) {
+	a := f.attempts[len(f.requests)]
+	f.requests = append(f.requests, []llm.Message{{Role: llm.RoleUser, Content: prompt}})
+	if a.err != nil {
+		return llm.Response{}, a.err
+	}
+	return llm.Response{Content: a.content[0]}, nil
+}
+
+func newRetrier(client llm.LLM) (*retrier, *[]time.Duration) {
+	var delays []time.Duration
+	r := &retrier{LLM: client, policy: DefaultPolicy, sleep: func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}}
+	return r, &delays
+}
+
+func statusError(status int, header http.Header) error {
+	return &anthropic.Error{StatusCode: status, Response: &http.Response{StatusCode: status, Header: header}}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", statusError(http.StatusTooManyRequests, nil), true},
+		{"overloaded", statusError(529, nil), true},
+		{"bad request", statusError(http.StatusBadRequest, nil), false},
+		{"unauthorized", statusError(http.StatusUnauthorized, nil), false},
+		{"gemini unavailable", genai.APIError{Code: http.StatusServiceUnavailable}, true},
+		{"gemini invalid", genai.APIError{Code: http.StatusBadRequest}, false},
+		{"connection dropped", fmt.Errorf("reading stream: %w", io.ErrUnexpectedEOF), true},
+		{"stream error event", errors.New(`received error while streaming: {"type":"overloaded_error"}`), true},
+		{"cancelled", context.Canceled, false},
+		{"deadline", fmt.Errorf("request: %w", context.DeadlineExceeded), false},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"client timeout", fmt.Errorf("request: %w", &net.DNSError{Err: "i/o timeout", IsTimeout: true}), false},
+		{"other", errors.New("invalid schema"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Transient(tt.err))
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	d, ok := retryAfter(statusError(http.StatusTooManyRequests, http.Header{"Retry-After": {"7"}}))
+	require.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	d, ok = retryAfter(statusError(http.StatusTooManyRequests, http.Header{"Retry-After-Ms": {"1500"}, "Retry-After": {"2"}}))
+	require.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, d)
+
+	d, ok = retryAfter(genai.APIError{Code: http.StatusTooManyRequests, Details: []map[string]any{
+		{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "12s"},
+	}})
+	require.True(t, ok)
+	assert.Equal(t, 12*time.Second, d)
+
+	_, ok = retryAfter(statusError(http.StatusServiceUnavailable, nil))
+	assert.False(t, ok)
+}
+
+func TestBackoff(t *testing.T) {
+	r, _ := newRetrier(nil)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 30 * time.Second, 100: 30 * time.Second} {
+		d := r.backoff(attempt)
+		assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, d, want, "attempt %d", attempt)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	client := &fakeLLM{attempts: []attempt{
+		{err: statusError(http.StatusTooManyRequests, http.Header{"Retry-After": {"3"}})},
+		{err: statusError(http.StatusBadGateway, nil)},
+		{content: []string{"ok"}},
+	}}
+	r, delays := newRetrier(client)
+
+	var notified []llm.Retrying
+	ctx := WithNotify(context.Background(), func(retrying llm.Retrying) {
+		notified = append(notified, retrying)
+	})
+
+	resp, err := r.Generate(ctx, "", "prompt", llm.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Content)
+	assert.Len(t, client.requests, 3)
+	require.Len(t, *delays, 2)
+	assert.Equal(t, 3*time.Second, (*delays)[0])
+	require.Len(t, notified, 2)
+	assert.Equal(t, 2, notified[0].Attempt)
+	assert.Equal(t, 3, notified[1].Attempt)
+	assert.Equal(t, 5, notified[1].MaxAttempts)
+}
+
+func TestGenerate_GivesUp(t *testing.T) {
+	// A permanent error is returned at once.
+	client := &fakeLLM{attempts: []attempt{{err: statusError(http.StatusBadRequest, nil)}}}
+	r, _ := newRetrier(client)
+	_, err := r.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.Len(t, client.requests, 1)
+
+	// A transient one is returned once the attempts run out.
+	var attempts []attempt
+	for range DefaultPolicy.MaxAttempts {
+		attempts = append(attempts, attempt{err: statusError(http.StatusServiceUnavailable, nil)})
+	}
+	client = &fakeLLM{attempts: attempts}
+	r, _ = newRetrier(client)
+	_, err = r.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.Len(t, client.requests, DefaultPolicy.MaxAttempts)
+
+	// So is one the server asks to wait too long for.
+	client = &fakeLLM{attempts: []attempt{{err: statusError(http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}})}}}
+	r, _ = newRetrier(client)
+	_, err = r.Generate(context.Background(), "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.Len(t, client.requests, 1)
+}
+
+func drain(respChan <-chan llm.Response, errChan <-chan error) (string, []llm.Retrying, *llm.Usage, error) {
+	var (
+		content  string
+		retrying []llm.Retrying
+		usage    *llm.Usage
+	)
+	for resp := range respChan {
+		content += resp.Content
+		if resp.Retrying != nil {
+			retrying = append(retrying, *resp.Retrying)
+		}
+		if resp.Usage != nil {
+			usage = resp.Usage
+		}
+	}
+	return content, retrying, usage, <-errChan
+}
+
+func TestGenerate_StopsWhenCallerIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &fakeLLM{attempts: []attempt{{err: statusError(529, nil)}}}
+	r, delays := newRetrier(client)
+
+	_, err := r.Generate(ctx, "", "prompt", llm.Options{})
+	require.Error(t, err)
+	assert.Len(t, client.requests, 1)
+	assert.Empty(t, *delays)
+}
+
+func TestStream_RetriesBeforeContent(t *testing.T) {
+	client := &fakeLLM{attempts: []attempt{
+		{err: statusError(529, nil)},
+		{content: []string{"fine"}},
+	}}
+	r, _ := newRetrier(client)
+
+	content, retrying, usage, err := drain(r.Stream(context.Background(), "", "prompt", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "fine", content)
+	require.Len(t, retrying, 1)
+	assert.Equal(t, 2, retrying[0].Attempt)
+	assert.True(t, retrying[0].Restart, "the thinking streamed so far is replayed")
+	require.NotNil(t, usage)
+	assert.Equal(t, int64(20), usage.InputTokens)
+
+	// Nothing was streamed, so the request is sent again unchanged.
+	assert.Equal(t, client.requests[0], client.requests[1])
+}
+
+func TestStream_ContinuesAfterBreak(t *testing.T) {
+	client := &fakeLLM{attempts: []attempt{
+		{content: []string{"## Summary\n", "The change"}, err: fmt.Errorf("stream: %w", io.ErrUnexpectedEOF)},
+		{content: []string{" looks good."}},
+	}}
+	r, _ := newRetrier(client)
+
+	content, retrying, usage, err := drain(r.Stream(context.Background(), "", "prompt", llm.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "## Summary\nThe change looks good.", content)
+	require.Len(t, retrying, 1)
+	assert.False(t, retrying[0].Restart)
+	assert.Equal(t, int64(4), usage.OutputTokens)
+
+	require.Len(t, client.requests, 2)
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleUser, Content: "prompt"},
+		{Role: llm.RoleAssistant, Content: "## Summary\nThe change"},
+		{Role: llm.RoleUser, Content: continuePrompt},
+	}, client.requests[1])
+}
+
+func TestStream_ReturnsPermanentError(t *testing.T) {
+	client := &fakeLLM{attempts: []attempt{{content: []string{"partial"}, err: statusError(http.StatusBadRequest, nil)}}}
+	r, _ := newRetrier(client)
+
+	content, retrying, usage, err := drain(r.Stream(context.Background(), "", "prompt", llm.Options{}))
+	require.Error(t, err)
+	assert.Equal(t, "partial", content)
+	assert.Empty(t, retrying)
+	// Usage of the failed request is still reported.
+	assert.NotNil(t, usage)
+}
@@ -7,10 +7,11 @@ import (
 	g "google.golang.org/genai"
 )
 
-func New(ctx context.Context, model, project, location string) (*genai.GenAI, error) {
+func New(ctx context.Context, model, project, location string, httpOptions g.HTTPOptions) (*genai.GenAI, error) {
 	return genai.New(ctx, model, g.ClientConfig{
-		Backend:  g.BackendVertexAI,
-		Project:  project,
-		Location: location,
+		Backend:     g.BackendVertexAI,
+		Project:     project,
+		Location:    location,
+		HTTPOptions: httpOptions,
 	})
 }
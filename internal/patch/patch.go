@@ -0,0 +1,415 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/findings"
+)
+
+var ErrNoHunks = errors.New("suggestion contains no changes")
+
+// Suggestion is a diff block proposed by a reviewer.
+type Suggestion struct {
+	// File is the repository-relative path the diff applies to, taken from
+	// its "+++" header or the finding heading it appears under. It is empty
+	// when neither names a file.
+	File string `json:"file"`
+	Diff string `json:"diff"`
+}
+
+// Title is a one-line description of the suggestion for lists.
+func (s Suggestion) Title() string {
+	file := s.File
+	if file == "" {
+		file = "(unknown file)"
+	}
+
+	added, removed := 0, 0
+	for line := range strings.SplitSeq(s.Diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+
+	return fmt.Sprintf("%s (+%d -%d)", file, added, removed)
+}
+
+// Extract returns every ```diff block in a markdown review, in order.
+func Extract(markdown string) []Suggestion {
+	var (
+		suggestions []Suggestion
+		headingFile string
+		inFence     bool
+		inDiff      bool
+		block       []string
+	)
+
+	for line := range strings.SplitSeq(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			switch {
+			case !inFence:
+				inFence = true
+				inDiff = strings.EqualFold(strings.TrimPrefix(trimmed, "```"), "diff")
+			case inDiff:
+				diff := strings.Join(block, "\n")
+				suggestions = append(suggestions, Suggestion{File: fileOf(diff, headingFile), Diff: diff})
+				inFence, inDiff, block = false, false, nil
+			default:
+				inFence = false
+			}
+			continue
+		}
+
+		if inDiff {
+			block = append(block, line)
+			continue
+		}
+
+		if inFence || !strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Suggestions inherit the file of the finding they sit under; any
+		// other heading ends that finding.
+		if f, ok := findings.ParseHeading(trimmed); ok {
+			headingFile = f.File
+		} else {
+			headingFile = ""
+		}
+	}
+
+	return suggestions
+}
+
+// FromFindings returns the suggestions attached to structured findings.
+func FromFindings(list []findings.Finding) []Suggestion {
+	var suggestions []Suggestion
+	for _, f := range list {
+		if f.Suggestion != "" {
+			suggestions = append(suggestions, Suggestion{File: fileOf(f.Suggestion, f.File), Diff: f.Suggestion})
+		}
+	}
+	return suggestions
+}
+
+// fileOf prefers the path in the diff's "+++" header over fallback. Both
+// come from the reviewer's output, so a path that is absolute or leaves
+// the repository, like "../../.bashrc", is dropped rather than returned.
+func fileOf(diff, fallback string) string {
+	file := fallback
+	for line := range strings.SplitSeq(diff, "\n") {
+		if path, ok := strings.CutPrefix(line, "+++ "); ok {
+			path = strings.TrimSpace(path)
+			if path != "/dev/null" {
+				file = strings.TrimPrefix(path, "b/")
+				break
+			}
+		}
+	}
+	if !filepath.IsLocal(file) {
+		return ""
+	}
+	return file
+}
+
+// Op is the kind of a diff line.
+type Op byte
+
+const (
+	OpContext Op = ' '
+	OpDelete  Op = '-'
+	OpAdd     Op = '+'
+)
+
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Hunk is a run of diff lines. OldStart is the 1-based line the hunk header
+// claims it starts at, or 0 when the block had no header.
+type Hunk struct {
+	OldStart int
+	Lines    []Line
+}
+
+// Parse splits a diff block into hunks. It is lenient the way reviewer
+// output needs it to be: line counts in "@@" headers are ignored, a block
+// without any header is treated as a single unlocated hunk, and lines
+// missing their leading space are read as context.
+func Parse(diff string) ([]Hunk, error) {
+	var (
+		hunks   []Hunk
+		current *Hunk
+	)
+
+	for line := range strings.SplitSeq(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunks = append(hunks, Hunk{OldStart: oldStart(line)})
+			current = &hunks[len(hunks)-1]
+			continue
+
+		case current == nil && isFileHeader(line):
+			continue
+
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file"
+			continue
+		}
+
+		if current == nil {
+			hunks = append(hunks, Hunk{})
+			current = &hunks[len(hunks)-1]
+		}
+
+		switch {
+		case line == "":
+			current.Lines = append(current.Lines, Line{Op: OpContext})
+		case line[0] == '+' || line[0] == '-' || line[0] == ' ':
+			current.Lines = append(current.Lines, Line{Op: Op(line[0]), Text: line[1:]})
+		default:
+			current.Lines = append(current.Lines, Line{Op: OpContext, Text: line})
+		}
+	}
+
+	result := hunks[:0]
+	for _, h := range hunks {
+		// Blocks often end in a blank line that is not part of the change.
+		for len(h.Lines) > 0 && h.Lines[len(h.Lines)-1] == (Line{Op: OpContext}) {
+			h.Lines = h.Lines[:len(h.Lines)-1]
+		}
+		if h.changes() {
+			result = append(result, h)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoHunks
+	}
+
+	return result, nil
+}
+
+func isFileHeader(line string) bool {
+	for _, prefix := range []string{"diff --git ", "index ", "--- ", "+++ ", "new file mode ", "deleted file mode "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// oldStart reads the old-side start line from "@@ -12,5 +12,6 @@".
+func oldStart(header string) int {
+	fields := strings.Fields(header)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "-") {
+		return 0
+	}
+	before, _, _ := strings.Cut(strings.TrimPrefix(fields[1], "-"), ",")
+	n, err := strconv.Atoi(before)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func (h Hunk) changes() bool {
+	for _, l := range h.Lines {
+		if l.Op != OpContext {
+			return true
+		}
+	}
+	return false
+}
+
+// old returns the lines the hunk expects to find in the file.
+func (h Hunk) old() []string {
+	var old []string
+	for _, l := range h.Lines {
+		if l.Op != OpAdd {
+			old = append(old, l.Text)
+		}
+	}
+	return old
+}
+
+// Result is the outcome of applying hunks to a file.
+type Result struct {
+	Content string
+	// Hunks are the applied hunks with recounted positions and the file's
+	// actual text on context and deleted lines.
+	Hunks []AppliedHunk
+}
+
+type AppliedHunk struct {
+	OldStart, NewStart int
+	Lines              []Line
+}
+
+// Apply applies hunks to content. Each hunk is located by its context and
+// deleted lines rather than by its header: an exact match nearest to the
+// claimed line wins, falling back to a whitespace-insensitive match, much
+// like `git apply --recount` with whitespace fuzz. A hunk that matches
+// nowhere, or that has no line number and matches more than once, fails the
+// whole apply.
+func Apply(content string, hunks []Hunk) (Result, error) {
+	lines, trailingNewline := splitLines(content)
+
+	var (
+		result Result
+		offset int
+	)
+
+	for i, h := range hunks {
+		old := h.old()
+
+		var pos int
+		if len(old) == 0 {
+			if h.OldStart == 0 {
+				return Result{}, fmt.Errorf("hunk %d only adds lines and has no line number to place them at", i+1)
+			}
+			pos = min(h.OldStart+offset, len(lines))
+		} else {
+			hint := -1
+			if h.OldStart > 0 {
+				hint = h.OldStart - 1 + offset
+			}
+
+			var err error
+			pos, err = locate(lines, old, hint, exactMatch)
+			if errors.Is(err, errNoMatch) {
+				pos, err = locate(lines, old, hint, fuzzyMatch)
+			}
+			if err != nil {
+				return Result{}, fmt.Errorf("hunk %d %w", i+1, err)
+			}
+		}
+
+		var (
+			replacement []string
+			applied     = AppliedHunk{OldStart: pos - offset + 1, NewStart: pos + 1}
+			j           = pos
+		)
+		for _, l := range h.Lines {
+			switch l.Op {
+			case OpContext:
+				replacement = append(replacement, lines[j])
+				applied.Lines = append(applied.Lines, Line{Op: OpContext, Text: lines[j]})
+				j++
+			case OpDelete:
+				applied.Lines = append(applied.Lines, Line{Op: OpDelete, Text: lines[j]})
+				j++
+			case OpAdd:
+				replacement = append(replacement, l.Text)
+				applied.Lines = append(applied.Lines, l)
+			}
+		}
+
+		lines = append(lines[:pos:pos], append(replacement, lines[pos+len(old):]...)...)
+		offset += len(replacement) - len(old)
+		result.Hunks = append(result.Hunks, applied)
+	}
+
+	result.Content = strings.Join(lines, "\n")
+	if trailingNewline {
+		result.Content += "\n"
+	}
+
+	return result, nil
+}
+
+var errNoMatch = errors.New("does not match the file")
+
+func exactMatch(a, b string) bool { return a == b }
+
+func fuzzyMatch(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// locate finds where old occurs in lines. With a hint the closest match wins;
+// without one the match must be unique.
+func locate(lines, old []string, hint int, eq func(a, b string) bool) (int, error) {
+	var matches []int
+	for i := 0; i+len(old) <= len(lines); i++ {
+		ok := true
+		for j := range old {
+			if !eq(lines[i+j], old[j]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matches = append(matches, i)
+		}
+	}
+
+	switch {
+	case len(matches) == 0:
+		return 0, errNoMatch
+	case len(matches) == 1:
+		return matches[0], nil
+	case hint < 0:
+		return 0, fmt.Errorf("matches the file in %d places", len(matches))
+	}
+
+	best := matches[0]
+	for _, m := range matches[1:] {
+		if abs(m-hint) < abs(best-hint) {
+			best = m
+		}
+	}
+	return best, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, false
+	}
+	trailing := strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), trailing
+}
+
+// Diff renders the applied hunks as a unified diff against path.
+func (r Result) Diff(path string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", path, path)
+
+	for _, h := range r.Hunks {
+		oldCount, newCount := 0, 0
+		for _, l := range h.Lines {
+			if l.Op != OpAdd {
+				oldCount++
+			}
+			if l.Op != OpDelete {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", h.OldStart, oldCount, h.NewStart, newCount)
+		for _, l := range h.Lines {
+			sb.WriteByte(byte(l.Op))
+			sb.WriteString(l.Text)
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const source = `package main
+
+func main() {
+	err := run()
+	if err != nil {
+		panic(err)
+	}
+}
+`
+
+func TestExtract(t *testing.T) {
+	review := "Summary.\n\n" +
+		"### [high] correctness: main.go:4-6\n" +
+		"Don't panic.\n\n" +
+		"```diff\n-\t\tpanic(err)\n+\t\tlog.Fatal(err)\n```\n\n" +
+		"## Other notes\n\n" +
+		"```diff\n--- a/util.go\n+++ b/util.go\n@@ -1 +1 @@\n-a\n+b\n```\n\n" +
+		"```go\n// not a suggestion\n```\n\n" +
+		"```diff\n-x\n+y\n```\n"
+
+	suggestions := Extract(review)
+	require.Len(t, suggestions, 3)
+	assert.Equal(t, "main.go", suggestions[0].File)
+	assert.Equal(t, "util.go", suggestions[1].File)
+	assert.Empty(t, suggestions[2].File)
+	assert.Equal(t, "main.go (+1 -1)", suggestions[0].Title())
+}
+
+func TestExtract_RejectsPathsOutsideRepo(t *testing.T) {
+	review := "```diff\n--- a/../../.bashrc\n+++ b/../../.bashrc\n-a\n+b\n```\n\n" +
+		"```diff\n+++ /etc/passwd\n-a\n+b\n```\n\n" +
+		"### [low] style: ../main.go:2\nRename.\n\n```diff\n-a\n+b\n```\n"
+
+	suggestions := Extract(review)
+	require.Len(t, suggestions, 3)
+	for _, s := range suggestions {
+		assert.Empty(t, s.File)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want string
+	}{
+		{
+			name: "wrong line numbers are recounted",
+			diff: "@@ -40,3 +40,3 @@\n \tif err != nil {\n-\t\tpanic(err)\n+\t\tlog.Fatal(err)\n \t}",
+			want: "package main\n\nfunc main() {\n\terr := run()\n\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n}\n",
+		},
+		{
+			name: "no header and mangled indentation",
+			diff: "  if err != nil {\n-    panic(err)\n+\t\treturn\n  }",
+			want: "package main\n\nfunc main() {\n\terr := run()\n\tif err != nil {\n\t\treturn\n\t}\n}\n",
+		},
+		{
+			name: "pure addition placed by header",
+			diff: "@@ -1,0 +2,1 @@\n+// Package main runs things.",
+			want: "package main\n// Package main runs things.\n\nfunc main() {\n\terr := run()\n\tif err != nil {\n\t\tpanic(err)\n\t}\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := Parse(tt.diff)
+			require.NoError(t, err)
+
+			result, err := Apply(source, hunks)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result.Content)
+		})
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	hunks, err := Parse("-\treturn nil\n+\treturn err")
+	require.NoError(t, err)
+
+	_, err = Apply(source, hunks)
+	assert.ErrorContains(t, err, "does not match")
+
+	// "x" appears twice and there is no line number to choose between them.
+	hunks, err = Parse("-x\n+y")
+	require.NoError(t, err)
+
+	_, err = Apply("a\nx\nb\nx\n", hunks)
+	assert.ErrorContains(t, err, "matches the file in 2 places")
+
+	_, err = Parse(" context only")
+	assert.ErrorIs(t, err, ErrNoHunks)
+}
+
+func TestResultDiff(t *testing.T) {
+	hunks, err := Parse("@@ -99 +99 @@\n-\t\tpanic(err)\n+\t\treturn")
+	require.NoError(t, err)
+
+	result, err := Apply(source, hunks)
+	require.NoError(t, err)
+
+	assert.Equal(t, "--- a/main.go\n+++ b/main.go\n@@ -6,1 +6,1 @@\n-\t\tpanic(err)\n+\t\treturn\n", result.Diff("main.go"))
+}
@@ -1,24 +1,56 @@
 package plain
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
+	"github.com/ionut-t/bark/v2/internal/cache"
+	"github.com/ionut-t/bark/v2/internal/chunk"
 	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/credentials"
 	"github.com/ionut-t/bark/v2/internal/enclosing"
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/ionut-t/bark/v2/internal/forge"
 	"github.com/ionut-t/bark/v2/internal/git"
+	"github.com/ionut-t/bark/v2/internal/history"
 	"github.com/ionut-t/bark/v2/internal/instructions"
+	"github.com/ionut-t/bark/v2/internal/ledger"
 	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/fallback"
 	"github.com/ionut-t/bark/v2/internal/llm/llm_factory"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
 	"github.com/ionut-t/bark/v2/internal/prompt"
 	"github.com/ionut-t/bark/v2/internal/reviewers"
+	"github.com/ionut-t/bark/v2/internal/sarif"
 	"github.com/ionut-t/bark/v2/internal/utils"
 )
 
 const gitTimeout = 30 * time.Second
 
+// ExitCodeFindings is the process exit code used when a review produces
+// findings at or above the --fail-on threshold. It is distinct from the
+// generic failure code so CI can tell a blocked merge from a broken run.
+const ExitCodeFindings = 3
+
+// Review output formats.
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+	FormatSARIF    = "sarif"
+)
+
 // ReviewOptions configures the plain text review runner.
 type ReviewOptions struct {
 	Diff              *string
@@ -29,6 +61,20 @@ type ReviewOptions struct {
 	Config            config.Config
 	Stream            bool
 	WithPRDescription bool
+	// Format selects the review output: FormatMarkdown (default), FormatJSON
+	// or FormatSARIF.
+	Format string
+	// Output is the file the review is written to; stdout when empty.
+	Output string
+	// FailOn makes RunReview return a *ThresholdError when any finding is at
+	// or above this severity. Empty disables the gate.
+	FailOn findings.Severity
+	// Post publishes the findings as a review on the pull request named by
+	// PR, with comments anchored to their diff lines.
+	Post bool
+	// DryRun prints the prompts and their estimated token counts instead of
+	// calling the provider.
+	DryRun bool
 
 	// Diff source flags (used when Diff is empty)
 	Staged bool
@@ -38,12 +84,25 @@ type ReviewOptions struct {
 	PR     string
 }
 
+// ThresholdError is returned by RunReview when findings meet the FailOn
+// threshold.
+type ThresholdError struct {
+	Threshold findings.Severity
+	Count     int
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("%d finding(s) at or above %s severity", e.Count, e.Threshold)
+}
+
 // CommitOptions configures the plain text commit runner.
 type CommitOptions struct {
-	Diff   *string
-	All    bool
-	Hint   string
-	Config config.Config
+	Diff    *string
+	All     bool
+	Hint    string
+	Storage string
+	Config  config.Config
+	DryRun  bool
 }
 
 // PROptions configures the plain text PR runner.
@@ -52,11 +111,21 @@ type PROptions struct {
 	Branch       string
 	PR           string
 	Instructions string
+	Storage      string
 	Config       config.Config
+	DryRun       bool
 }
 
 // RunReview runs a code review and writes the output to stdout.
 func RunReview(opts ReviewOptions) error {
+	if opts.Format != "" && opts.Format != FormatMarkdown && opts.Stream {
+		return fmt.Errorf("--stream is only supported with markdown output")
+	}
+
+	if opts.Post && (opts.PR == "" || opts.Diff != nil) {
+		return fmt.Errorf("--post requires --pr")
+	}
+
 	var reviewDiff git.ReviewDiff
 
 	if opts.Diff == nil {
@@ -107,11 +176,20 @@ func RunReview(opts ReviewOptions) error {
 	}
 	system := prompt.FormatReviewSystem(reviewer.Prompt, reviewInstructions)
 
+	enrich := opts.Config.GetContextEnrichment() && !reviewDiff.SkipEnrichment
+
+	// Enclosing context is left out of the budget: chunked reviews extract
+	// it per chunk, so it only grows with the chunk it belongs to.
+	overhead := llm.EstimateTokens(system) + llm.EstimateTokens(prompt.FormatReviewContent(reviewDiff.ContextHeader, reviewDiff.Stat, reviewDiff.Commits, "", ""))
+	chunks := chunk.Plan(reviewDiff.Diff, overhead, int(opts.Config.GetReviewTokenBudget()))
+	concurrency := int(opts.Config.GetReviewConcurrency())
+	contextBudget := int(opts.Config.GetContextTokenBudget())
+
 	var enclosingContext string
-	if opts.Config.GetContextEnrichment() && !reviewDiff.SkipEnrichment {
+	if enrich && (len(chunks) == 0 || opts.DryRun) {
 		enclosingCtx, enclosingCancel := context.WithTimeout(context.Background(), gitTimeout)
 		var err error
-		enclosingContext, err = enclosing.DeclarationsForDiff(enclosingCtx, reviewDiff.Diff, reviewDiff.Ref)
+		enclosingContext, err = enclosing.DeclarationsForDiff(enclosingCtx, reviewDiff.Diff, reviewDiff.Ref, reviewDiff.BaseRef, contextBudget)
 		enclosingCancel()
 		if err != nil {
 			// Gracefully continue without enclosing context if extraction fails
@@ -121,19 +199,321 @@ func RunReview(opts ReviewOptions) error {
 
 	promptText := prompt.FormatReviewContent(reviewDiff.ContextHeader, reviewDiff.Stat, reviewDiff.Commits, reviewDiff.Diff, enclosingContext)
 
-	client, _, err := llm_factory.New(context.Background(), opts.Config)
+	// chunkPrompt builds the review prompt for the i-th chunk.
+	chunkPrompt := func(ctx context.Context, i int) string {
+		c := chunks[i]
+
+		var chunkContext string
+		if enrich {
+			enclosingCtx, enclosingCancel := context.WithTimeout(ctx, gitTimeout)
+			chunkContext, _ = enclosing.DeclarationsForDiff(enclosingCtx, c.Diff, reviewDiff.Ref, reviewDiff.BaseRef, contextBudget)
+			enclosingCancel()
+		}
+
+		header := reviewDiff.ContextHeader + prompt.FormatChunkHeader(i, len(chunks))
+		return prompt.FormatReviewContent(header, reviewDiff.Stat, reviewDiff.Commits, c.Diff, chunkContext)
+	}
+
+	switch opts.Format {
+	case "", FormatMarkdown, FormatJSON, FormatSARIF:
+	default:
+		return fmt.Errorf("unsupported format %q (supported: %s, %s, %s)", opts.Format, FormatMarkdown, FormatJSON, FormatSARIF)
+	}
+
+	out, err := openOutput(opts.Output)
 	if err != nil {
-		return fmt.Errorf("error creating LLM client: %w", err)
+		return err
+	}
+	defer out.close()
+
+	if opts.DryRun {
+		dryRunSystem := system
+		if opts.Format == FormatJSON || opts.Format == FormatSARIF {
+			dryRunSystem = prompt.FormatFindingsSystem(reviewer.Prompt, reviewInstructions)
+		}
+
+		var note string
+		if len(chunks) > 0 {
+			note = fmt.Sprintf("The prompt exceeds review_token_budget (%s tokens), so the review would run in %d chunks plus a merge pass.", formatTokens(int(opts.Config.GetReviewTokenBudget())), len(chunks))
+		}
+
+		sections := prompt.ReviewSections(reviewDiff.ContextHeader, reviewDiff.Stat, reviewDiff.Commits, reviewDiff.Diff, enclosingContext)
+		if err := writeDryRun(out, dryRunSystem, sections, note); err != nil {
+			return err
+		}
+		return out.commit()
+	}
+
+	// Chunked reviews send the shared prompt once per chunk, and each diff
+	// line once.
+	promptTokens := llm.EstimateTokens(system) + llm.EstimateTokens(promptText)
+	if len(chunks) > 0 {
+		promptTokens = len(chunks)*overhead + llm.EstimateTokens(reviewDiff.Diff)
+	}
+	client, profile, err := newClient(opts.Config, opts.Storage, config.TaskReview)
+	if err != nil {
+		return err
+	}
+	// Reviews are often repeated after small fixes, so cache the system
+	// prompt and the prompt's stable prefix. Chunk prompts differ from the
+	// single-pass one, so only the system prompt is cached for them.
+	llmOpts := llm_factory.Options(profile)
+	llmOpts.Cache = opts.Config.GetPromptCache()
+	if llmOpts.Cache && len(chunks) == 0 {
+		llmOpts.CachePrefix = prompt.CacheablePrefix(reviewDiff.ContextHeader, enclosingContext)
 	}
 
-	llmCtx, llmCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if err := checkBudget(opts.Config, opts.Storage, profile, promptTokens); err != nil {
+		return err
+	}
+
+	served := &servedBy{provider: profile.Provider, model: profile.Model}
+	llmCtx := fallback.WithNotify(retry.WithNotify(ledger.WithTask(context.Background(), ledger.TaskReview), reportRetry), served.notify)
+	llmCtx, llmCancel := context.WithTimeout(llmCtx, reviewTimeout(len(chunks), concurrency))
 	defer llmCancel()
 
-	if opts.Stream {
-		return streamResponse(llmCtx, client, system, promptText)
+	var (
+		report  findings.Report
+		content string
+	)
+	if opts.Format == FormatJSON || opts.Format == FormatSARIF {
+		findingsSystem := prompt.FormatFindingsSystem(reviewer.Prompt, reviewInstructions)
+		generate := func(ctx context.Context, promptText string) (findings.Report, error) {
+			return findings.Generate(ctx, client, findingsSystem, system, promptText, llmOpts)
+		}
+
+		if len(chunks) > 0 {
+			var reports []findings.Report
+			reports, err = reviewChunks(llmCtx, chunks, concurrency, chunkPrompt, generate)
+			report = findings.Merge(reports)
+		} else {
+			report, err = generate(llmCtx, promptText)
+		}
+		if err != nil {
+			return fmt.Errorf("error during review: %w", err)
+		}
+
+		if opts.Format == FormatSARIF {
+			provider, model := served.get()
+			log := sarif.Build(report, reviewDiff.Diff, sarif.Metadata{
+				Reviewer: reviewer.Name,
+				Provider: provider,
+				Model:    model,
+			})
+			err = sarif.Write(out, log)
+		} else {
+			err = writeFindings(out, report)
+		}
+	} else {
+		if len(chunks) > 0 {
+			// Map: review each chunk on its own. Reduce: stream one review
+			// merged from the partial ones, like a single-pass review.
+			reviews, err := reviewChunks(llmCtx, chunks, concurrency, chunkPrompt, func(ctx context.Context, promptText string) (string, error) {
+				resp, err := client.Generate(ctx, system, promptText, llmOpts)
+				return resp.Content, err
+			})
+			if err != nil {
+				return fmt.Errorf("error during review: %w", err)
+			}
+
+			system = prompt.FormatMergeSystem(reviewer.Prompt, reviewInstructions)
+			promptText = prompt.FormatMergeContent(reviewDiff.ContextHeader, reviewDiff.Stat, reviewDiff.Commits, reviews)
+			fmt.Fprintln(os.Stderr, "Merging partial reviews")
+		}
+
+		if opts.Stream {
+			content, err = streamResponse(llmCtx, out, client, system, promptText, llmOpts)
+		} else {
+			content, err = fullResponse(llmCtx, out, client, system, promptText, llmOpts)
+		}
+		report = findings.ParseMarkdown(content)
+	}
+
+	if err != nil {
+		return err
+	}
+	if err := out.commit(); err != nil {
+		return err
+	}
+
+	// Markdown reviews are kept verbatim and their suggestions re-extracted
+	// on demand; structured reviews keep their findings.
+	var structured []findings.Finding
+	if content == "" {
+		structured = report.Findings
+	}
+	_, model := served.get()
+	saveHistory(opts.Storage, reviewer.Name, model, content, structured, reviewDiff.Diff)
+
+	if opts.Post {
+		if err := postReview(opts.PR, report, reviewDiff.Diff); err != nil {
+			return err
+		}
+	}
+
+	return checkThreshold(report.Findings, opts.FailOn)
+}
+
+// reviewTimeout allows one request-length slot per round of concurrent
+// chunk reviews, plus one for the merge or single-pass review.
+func reviewTimeout(chunks, concurrency int) time.Duration {
+	if concurrency <= 0 {
+		concurrency = chunk.DefaultConcurrency
+	}
+	rounds := (chunks + concurrency - 1) / concurrency
+	return time.Duration(1+rounds) * 5 * time.Minute
+}
+
+// reviewChunks reviews each chunk with review and reports progress on
+// stderr, so stdout only carries the final review.
+func reviewChunks[T any](ctx context.Context, chunks []chunk.Chunk, concurrency int, buildPrompt func(context.Context, int) string, review func(context.Context, string) (T, error)) ([]T, error) {
+	fmt.Fprintf(os.Stderr, "Diff exceeds the review token budget; reviewing it in %d chunks\n", len(chunks))
+
+	var (
+		mu   sync.Mutex
+		done int
+	)
+	progress := func(p chunk.Progress) {
+		if p.Status != chunk.StatusDone {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		fmt.Fprintf(os.Stderr, "Reviewed chunk %d/%d: %s\n", done, len(chunks), chunks[p.Index].Title())
+	}
+
+	return chunk.Map(ctx, len(chunks), concurrency, progress, func(ctx context.Context, i int) (T, error) {
+		return review(ctx, buildPrompt(ctx, i))
+	})
+}
+
+// saveHistory records the review so its suggestions can be applied later
+// with `bark apply`. Reviews without suggestions have nothing to apply and
+// aren't saved. History is a convenience, so failures are ignored.
+func saveHistory(storage, reviewer, model, content string, list []findings.Finding, diff string) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	root, err := git.RepoRoot(ctx)
+	if err != nil {
+		return
+	}
+
+	entry := history.NewEntry(root, reviewer, model, content, list, diff)
+	n := len(entry.Suggestions())
+	if n == 0 {
+		return
+	}
+	if err := history.New(storage).Add(&entry); err != nil {
+		return
 	}
 
-	return fullResponse(llmCtx, client, system, promptText)
+	fmt.Fprintf(os.Stderr, "Saved review %s with %d suggestion(s); apply one with `bark apply %s --suggestion N`\n", entry.ID, n, entry.ID)
+}
+
+// postReview publishes report as a single review on the pull request.
+func postReview(pr string, report findings.Report, diff string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	// --pr accepts anything gh does (number, URL or branch); the REST API
+	// needs the number.
+	number, err := strconv.Atoi(pr)
+	if err != nil {
+		meta, metaErr := git.GetPRMeta(ctx, pr)
+		if metaErr != nil {
+			return fmt.Errorf("could not resolve pull request %q: %w", pr, metaErr)
+		}
+		number = meta.Number
+	}
+
+	client, err := forge.NewGitHubFromEnv(ctx)
+	if err != nil {
+		return err
+	}
+
+	review := forge.BuildReview(report, diff)
+	url, err := client.PostReview(ctx, number, review)
+	if err != nil {
+		return fmt.Errorf("error posting review: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Posted review with %d inline comment(s): %s\n", len(review.Comments), url)
+	return nil
+}
+
+// checkThreshold prints a one-line count summary to stderr and returns a
+// *ThresholdError when any finding meets the threshold.
+func checkThreshold(list []findings.Finding, threshold findings.Severity) error {
+	if threshold == "" {
+		return nil
+	}
+
+	fmt.Fprintln(os.Stderr, findings.FormatCounts(list))
+
+	if n := findings.AtOrAbove(list, threshold); n > 0 {
+		return &ThresholdError{Threshold: threshold, Count: n}
+	}
+
+	return nil
+}
+
+// output is where the review goes: stdout, or a temporary file next to the
+// --output file that replaces it once the review succeeded, so a failed or
+// cancelled review leaves the previous report in place.
+type output struct {
+	io.Writer
+	file *os.File
+	path string
+}
+
+// openOutput returns the output for the named file, or for stdout when path
+// is empty.
+func openOutput(path string) (*output, error) {
+	if path == "" {
+		return &output{Writer: os.Stdout}, nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %w", err)
+	}
+
+	return &output{Writer: f, file: f, path: path}, nil
+}
+
+// commit replaces the output file with what was written to it.
+func (o *output) commit() error {
+	if o.file == nil {
+		return nil
+	}
+
+	f := o.file
+	o.file = nil
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	if err := os.Rename(f.Name(), o.path); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	return nil
+}
+
+// close discards what was written unless it was committed.
+func (o *output) close() {
+	if o.file != nil {
+		_ = o.file.Close()
+		_ = os.Remove(o.file.Name())
+	}
 }
 
 // RunCommit generates a commit message and writes it to stdout.
@@ -163,15 +543,23 @@ func RunCommit(opts CommitOptions) error {
 	}
 	commitSystem := prompt.FormatCommitSystem(commitInstructions, opts.Hint)
 
-	client, _, err := llm_factory.New(context.Background(), opts.Config)
+	if opts.DryRun {
+		return writeDryRun(os.Stdout, commitSystem, []prompt.Section{{Name: "diff", Content: diff}}, "")
+	}
+
+	client, profile, err := newClient(opts.Config, opts.Storage, config.TaskCommit)
 	if err != nil {
-		return fmt.Errorf("error creating LLM client: %w", err)
+		return err
+	}
+
+	if err := checkBudget(opts.Config, opts.Storage, profile, llm.EstimateTokens(commitSystem)+llm.EstimateTokens(diff)); err != nil {
+		return err
 	}
 
-	llmCtx, llmCancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	llmCtx, llmCancel := context.WithTimeout(fallback.WithNotify(retry.WithNotify(ledger.WithTask(context.Background(), ledger.TaskCommit), reportRetry), reportFallback), 3*time.Minute)
 	defer llmCancel()
 
-	result, err := client.Generate(llmCtx, commitSystem, diff)
+	result, err := client.Generate(llmCtx, commitSystem, diff, llm_factory.Options(profile))
 	if err != nil {
 		return fmt.Errorf("error generating commit message: %w", err)
 	}
@@ -215,15 +603,23 @@ func RunPR(opts PROptions) error {
 
 	prSystem := prompt.FormatPRSystem(prInstructions)
 
-	client, _, err := llm_factory.New(context.Background(), opts.Config)
+	if opts.DryRun {
+		return writeDryRun(os.Stdout, prSystem, []prompt.Section{{Name: "commits and diff", Content: content}}, "")
+	}
+
+	client, profile, err := newClient(opts.Config, opts.Storage, config.TaskPR)
 	if err != nil {
-		return fmt.Errorf("error creating LLM client: %w", err)
+		return err
+	}
+
+	if err := checkBudget(opts.Config, opts.Storage, profile, llm.EstimateTokens(prSystem)+llm.EstimateTokens(content)); err != nil {
+		return err
 	}
 
-	llmCtx, llmCancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	llmCtx, llmCancel := context.WithTimeout(fallback.WithNotify(retry.WithNotify(ledger.WithTask(context.Background(), ledger.TaskPR), reportRetry), reportFallback), 3*time.Minute)
 	defer llmCancel()
 
-	result, err := client.Generate(llmCtx, prSystem, content)
+	result, err := client.Generate(llmCtx, prSystem, content, llm_factory.Options(profile))
 	if err != nil {
 		return fmt.Errorf("error generating PR description: %w", err)
 	}
@@ -234,30 +630,156 @@ func RunPR(opts PROptions) error {
 	return nil
 }
 
-// streamResponse streams LLM response chunks to stdout.
-func streamResponse(ctx context.Context, client llm.LLM, system, promptText string) error {
-	responseChan, errChan := client.Stream(ctx, system, promptText)
+// newClient creates the LLM client for task, wrapped so that every request
+// is recorded in the usage ledger under storage and, when enabled, answered
+// from the response cache, and returns the profile it was created from.
+func newClient(cfg config.Config, storage, task string) (llm.LLM, config.Profile, error) {
+	client, profile, err := llm_factory.New(context.Background(), cfg, task)
+	if err != nil {
+		return nil, config.Profile{}, fmt.Errorf("error creating LLM client: %w", err)
+	}
+
+	responses, err := cache.FromConfig(cfg, storage)
+	if err != nil {
+		return nil, config.Profile{}, err
+	}
+	client = cache.Wrap(client, responses, profile.Provider, profile.Model)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	// Input piped from outside a repository is recorded without one.
+	root, _ := git.RepoRoot(ctx)
 
+	return ledger.Record(client, ledger.New(storage), root, profile.Provider, profile.Model), profile, nil
+}
+
+// reportRetry tells the user a request failed and is being retried, on
+// stderr so the output stays clean.
+func reportRetry(r llm.Retrying) {
+	fmt.Fprintf(os.Stderr, "Retrying (%d/%d) in %s: %v\n", r.Attempt, r.MaxAttempts, r.Delay.Round(100*time.Millisecond), r.Err)
+}
+
+// reportFallback tells the user a request moved on to the next provider of
+// a fallback chain.
+func reportFallback(s fallback.Switch) {
+	fmt.Fprintf(os.Stderr, "%s is unavailable (%v); falling back to %s\n", s.From, s.Err, s.To)
+}
+
+// servedBy tracks the provider and model a review's requests are served by,
+// following the switches of a fallback chain.
+type servedBy struct {
+	mu              sync.Mutex
+	provider, model string
+}
+
+func (s *servedBy) notify(sw fallback.Switch) {
+	reportFallback(sw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.provider, s.model = sw.To.Provider, sw.To.Model
+}
+
+func (s *servedBy) get() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.provider, s.model
+}
+
+// checkBudget checks a request of promptTokens to profile against the
+// configured budgets. Exceeding one prints a warning to stderr, or returns
+// the error when budget_action is abort.
+func checkBudget(cfg config.Config, storage string, profile config.Profile, promptTokens int) error {
+	err := ledger.CheckBudget(cfg, storage, profile, promptTokens)
+	if budgetErr, ok := errors.AsType[*ledger.BudgetError](err); ok && !budgetErr.Abort {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", budgetErr)
+		return nil
+	}
+	return err
+}
+
+// streamResponse streams LLM response chunks to out and returns the full content.
+func streamResponse(ctx context.Context, out io.Writer, client llm.LLM, system, promptText string, llmOpts llm.Options) (string, error) {
+	responseChan, errChan := client.Stream(ctx, system, promptText, llmOpts)
+
+	var content strings.Builder
 	for chunk := range responseChan {
-		fmt.Print(chunk.Content)
+		fmt.Fprint(out, chunk.Content)
+		content.WriteString(chunk.Content)
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 
 	if err := <-errChan; err != nil {
-		return fmt.Errorf("error during review: %w", err)
+		return "", fmt.Errorf("error during review: %w", err)
 	}
 
-	return nil
+	return content.String(), nil
 }
 
-func fullResponse(ctx context.Context, client llm.LLM, system, promptText string) error {
-	response, err := client.Generate(ctx, system, promptText)
+func fullResponse(ctx context.Context, out io.Writer, client llm.LLM, system, promptText string, llmOpts llm.Options) (string, error) {
+	response, err := client.Generate(ctx, system, promptText, llmOpts)
 	if err != nil {
-		return fmt.Errorf("error during review: %w", err)
+		return "", fmt.Errorf("error during review: %w", err)
 	}
 
-	fmt.Print(response.Content)
-	fmt.Println()
+	fmt.Fprint(out, response.Content)
+	fmt.Fprintln(out)
+
+	return response.Content, nil
+}
+
+// writeDryRun prints the system and user prompts exactly as they would be
+// sent, followed by an estimated token count per section and an optional
+// note about how the request would be made.
+func writeDryRun(out io.Writer, system string, sections []prompt.Section, note string) error {
+	var user strings.Builder
+	for _, section := range sections {
+		user.WriteString(section.Content)
+	}
+
+	fmt.Fprintf(out, "# System prompt\n\n%s\n\n", strings.TrimSpace(system))
+	fmt.Fprintf(out, "# User prompt\n\n%s\n\n", strings.TrimSpace(user.String()))
+	fmt.Fprintln(out, "# Estimated tokens")
+	fmt.Fprintln(out)
+
+	rows := append([]prompt.Section{{Name: "system prompt", Content: system}}, sections...)
+	total := 0
+	for _, row := range rows {
+		tokens := llm.EstimateTokens(row.Content)
+		total += tokens
+		fmt.Fprintf(out, "%-20s %10s\n", row.Name, formatTokens(tokens))
+	}
+	fmt.Fprintf(out, "%-20s %10s\n", "total", formatTokens(total))
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Estimates assume about four characters per token; providers count differently.")
+	if note != "" {
+		fmt.Fprintln(out, note)
+	}
+
+	return nil
+}
+
+// formatTokens renders n with thousands separators, e.g. "12,345".
+func formatTokens(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// writeFindings writes the review as a JSON findings report.
+func writeFindings(out io.Writer, report findings.Report) error {
+	if report.Findings == nil {
+		report.Findings = []findings.Finding{}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("error encoding findings: %w", err)
+	}
 
 	return nil
 }
@@ -328,3 +850,366 @@ func resolvePRInstructions(instruction string, cfg config.Config) (string, error
 func Errf(format string, args ...any) {
 	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
 }
+
+// ApplyOptions configures the plain text suggestion runner.
+type ApplyOptions struct {
+	Storage string
+	// ID names the review in history; history.Latest picks the most recent.
+	ID string
+	// Suggestion is the 1-based suggestion to apply. Zero lists them.
+	Suggestion int
+	Preview    bool
+	Undo       bool
+}
+
+// RunApply lists, previews, applies or undoes suggestions from a saved review.
+func RunApply(opts ApplyOptions) error {
+	store := history.New(opts.Storage)
+
+	entry, err := store.Load(opts.ID)
+	if err != nil {
+		return err
+	}
+
+	if opts.Undo {
+		applied, err := entry.Undo()
+		if err != nil {
+			return err
+		}
+		if err := store.Save(&entry); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted suggestion %d in %s\n", applied.Suggestion, applied.File)
+		return nil
+	}
+
+	if opts.Suggestion == 0 {
+		suggestions := entry.Suggestions()
+		if len(suggestions) == 0 {
+			fmt.Printf("Review %s has no suggestions\n", entry.ID)
+			return nil
+		}
+		for i, s := range suggestions {
+			fmt.Printf("%d. %s\n", i+1, s.Title())
+		}
+		return nil
+	}
+
+	if opts.Preview {
+		file, result, err := entry.Preview(opts.Suggestion)
+		if err != nil {
+			return err
+		}
+		fmt.Print(result.Diff(file))
+		return nil
+	}
+
+	result, err := entry.Apply(opts.Suggestion)
+	if err != nil {
+		return err
+	}
+	if err := store.Save(&entry); err != nil {
+		return err
+	}
+
+	last := entry.Applied[len(entry.Applied)-1]
+	fmt.Print(result.Diff(last.File))
+	fmt.Printf("Applied suggestion %d to %s (undo with `bark apply %s --undo`)\n", opts.Suggestion, last.File, entry.ID)
+	return nil
+}
+
+// StatsOptions configures the usage report.
+type StatsOptions struct {
+	Storage string
+	Config  config.Config
+	// By is the ledger dimension rows are grouped by.
+	By string
+	// Days limits the report to the last Days days; zero includes everything.
+	Days int
+}
+
+// RunStats prints the usage recorded in the ledger, grouped and priced.
+func RunStats(opts StatsOptions) error {
+	prices, err := ledger.LoadPrices(opts.Storage)
+	if err != nil {
+		return err
+	}
+
+	var since time.Time
+	if opts.Days > 0 {
+		now := time.Now()
+		since = time.Date(now.Year(), now.Month(), now.Day()-opts.Days+1, 0, 0, 0, 0, now.Location())
+	}
+
+	l := ledger.New(opts.Storage)
+	entries, err := l.Entries(since)
+	if err != nil {
+		return err
+	}
+
+	rows, err := ledger.Aggregate(entries, opts.By, prices)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No usage recorded yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tcalls\tinput\toutput\tcost\t\n", strings.ToUpper(opts.By))
+	for _, row := range append(rows, ledger.Total(rows)) {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t\n", row.Key, row.Calls, formatTokens(int(row.InputTokens)), formatTokens(int(row.OutputTokens)), formatCost(row))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if total := ledger.Total(rows); total.Unpriced > 0 {
+		fmt.Printf("\n%d call(s) used models without a price; add them to %s.\n", total.Unpriced, ledger.PricesPath(opts.Storage))
+	}
+
+	if budget := opts.Config.GetMonthlyBudget(); budget > 0 {
+		now := time.Now()
+		month, err := l.Entries(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+		if err != nil {
+			return err
+		}
+		monthRows, _ := ledger.Aggregate(month, ledger.ByDay, prices)
+		fmt.Printf("\nSpent this month: $%.2f of $%.2f\n", ledger.Total(monthRows).Cost, budget)
+	}
+
+	return nil
+}
+
+// CacheOptions configures the response cache commands.
+type CacheOptions struct {
+	Storage string
+	Config  config.Config
+}
+
+// openCache returns the response cache under storage with the configured
+// limits, whether or not it is enabled.
+func openCache(opts CacheOptions) (*cache.Cache, error) {
+	ttl, err := opts.Config.GetResponseCacheTTL()
+	if err != nil {
+		return nil, err
+	}
+	return cache.New(opts.Storage, ttl, opts.Config.GetResponseCacheMaxSize()), nil
+}
+
+// RunCacheStats prints a summary of the response cache.
+func RunCacheStats(opts CacheOptions) error {
+	c, err := openCache(opts)
+	if err != nil {
+		return err
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		return err
+	}
+
+	enabled := "no (set response_cache = true to enable it)"
+	if opts.Config.GetResponseCache() {
+		enabled = "yes"
+	}
+	ttl, _ := opts.Config.GetResponseCacheTTL()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Location:\t%s\n", c.Dir())
+	fmt.Fprintf(w, "Enabled:\t%s\n", enabled)
+	fmt.Fprintf(w, "Entries:\t%d (%d expired)\n", stats.Entries, stats.Expired)
+	fmt.Fprintf(w, "Size:\t%s of %s\n", formatBytes(stats.Size), formatBytes(opts.Config.GetResponseCacheMaxSize()))
+	fmt.Fprintf(w, "TTL:\t%s\n", ttl)
+	if stats.Entries > 0 {
+		fmt.Fprintf(w, "Oldest:\t%s\n", stats.Oldest.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "Newest:\t%s\n", stats.Newest.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "Tokens per full replay:\t%s\n", formatTokens(int(stats.SavedTokens)))
+	}
+	return w.Flush()
+}
+
+// RunCacheClear removes every cached response.
+func RunCacheClear(opts CacheOptions) error {
+	c, err := openCache(opts)
+	if err != nil {
+		return err
+	}
+
+	n, err := c.Clear()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d cached response(s) from %s\n", n, c.Dir())
+	return nil
+}
+
+// modelsTimeout limits how long listing the models of a provider takes.
+const modelsTimeout = 30 * time.Second
+
+// ModelsOptions configures the model listing. Provider limits it to one
+// provider; otherwise every provider with credentials is listed.
+type ModelsOptions struct {
+	Config   config.Config
+	Provider string
+}
+
+// RunModels lists the models of each provider with their context window,
+// output limit and capabilities, where the provider reports them.
+func RunModels(opts ModelsOptions) error {
+	providers := []string{opts.Provider}
+	if opts.Provider == "" {
+		providers = llm_factory.AvailableProviders(opts.Config)
+	}
+	if len(providers) == 0 {
+		return fmt.Errorf("%w: set an API key or llm_provider (see bark credentials status)", llm_factory.ErrNoProviderConfigured)
+	}
+
+	var failed []string
+	for i, provider := range providers {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(provider)
+
+		ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+		models, err := llm_factory.ListModels(ctx, opts.Config, provider, "")
+		cancel()
+		if err != nil {
+			fmt.Printf("  %s\n", err)
+			failed = append(failed, provider)
+			continue
+		}
+		if len(models) == 0 {
+			fmt.Println("  no models")
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  MODEL\tCONTEXT\tMAX OUTPUT\tCAPABILITIES")
+		for _, model := range models {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", model.ID, formatLimit(model.ContextWindow), formatLimit(model.MaxOutputTokens), cmp.Or(strings.Join(model.Capabilities, ", "), "-"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to list the models of %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// formatLimit renders a token limit, or "-" when it isn't reported.
+func formatLimit(tokens int64) string {
+	if tokens == 0 {
+		return "-"
+	}
+	return formatTokens(int(tokens))
+}
+
+// CredentialsOptions configures the credentials commands. Passphrase
+// unlocks the encrypted credentials file.
+type CredentialsOptions struct {
+	Storage    string
+	Config     config.Config
+	Provider   string
+	Key        string
+	Passphrase string
+}
+
+// RunCredentialsStatus prints, for each provider that takes an API key, the
+// sources tried in order and the one its key is read from.
+func RunCredentialsStatus(opts CredentialsOptions) error {
+	keys := credentials.New(opts.Config, opts.Storage)
+	fmt.Printf("API keys are read from the first source that has one: the environment variable, api_key_command, api_key_file, then %s.\n", credentials.StorePath(opts.Storage))
+
+	for _, provider := range credentials.Providers {
+		res := keys.Resolve(context.Background(), provider)
+
+		fmt.Printf("\n%s\n", provider)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for i, attempt := range res.Attempts {
+			fmt.Fprintf(w, "  %d. %s\t%s\n", i+1, attempt.Source, attempt.Status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if res.Key == "" {
+			fmt.Println("  no key")
+		} else {
+			fmt.Printf("  using %s from %s\n", credentials.Mask(res.Key), res.Source())
+		}
+	}
+	return nil
+}
+
+// RunCredentialsSet stores the key of a provider in the encrypted
+// credentials file, creating it if needed.
+func RunCredentialsSet(opts CredentialsOptions) error {
+	if !slices.Contains(credentials.Providers, opts.Provider) {
+		return fmt.Errorf("%s doesn't take an API key (supported: %s)", opts.Provider, strings.Join(credentials.Providers, ", "))
+	}
+	if opts.Key == "" {
+		return errors.New("the API key must not be empty")
+	}
+
+	path := credentials.StorePath(opts.Storage)
+	keys, err := credentials.ReadStore(path, opts.Passphrase)
+	if err != nil {
+		return err
+	}
+	keys[opts.Provider] = opts.Key
+	if err := credentials.WriteStore(path, opts.Passphrase, keys); err != nil {
+		return err
+	}
+
+	fmt.Printf("Stored the %s key in %s\n", opts.Provider, path)
+	return nil
+}
+
+// RunCredentialsRemove removes the key of a provider from the encrypted
+// credentials file.
+func RunCredentialsRemove(opts CredentialsOptions) error {
+	path := credentials.StorePath(opts.Storage)
+	keys, err := credentials.ReadStore(path, opts.Passphrase)
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[opts.Provider]; !ok {
+		return fmt.Errorf("%s has no %s key", path, opts.Provider)
+	}
+	delete(keys, opts.Provider)
+	if err := credentials.WriteStore(path, opts.Passphrase, keys); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed the %s key from %s\n", opts.Provider, path)
+	return nil
+}
+
+// formatBytes renders n in B, KB or MB.
+func formatBytes(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+}
+
+// formatCost renders the priced cost of row, or "-" when none of its calls
+// could be priced.
+func formatCost(row ledger.Row) string {
+	if row.Unpriced == row.Calls {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", row.Cost)
+}
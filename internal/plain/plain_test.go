@@ -0,0 +1,210 @@
+package plain
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/ionut-t/bark/v2/internal/history"
+	"github.com/ionut-t/bark/v2/internal/ledger"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures were recorded from a real provider with --record, for the
+// diff in mean.diff.
+const fixtures = "../../testdata/replay"
+
+// replayConfig returns a config that plays back fixture, and the storage
+// the run writes its ledger and history to.
+func replayConfig(t *testing.T, fixture string) (config.Config, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(viper.Reset)
+
+	viper.Set(config.LLMProviderKey, "replay")
+	viper.Set(config.LLMModelKey, "claude-sonnet-4-5")
+	viper.Set(config.ReplayKey+".file", filepath.Join(fixtures, fixture))
+	viper.Set(config.ReplayKey+".instant", true)
+
+	return config.New(), t.TempDir()
+}
+
+func readDiff(t *testing.T) *string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(fixtures, "mean.diff"))
+	require.NoError(t, err)
+	diff := string(data)
+	return &diff
+}
+
+// captureStdout returns what run prints to stdout.
+func captureStdout(t *testing.T, run func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+
+	runErr := run()
+	_ = w.Close()
+	return <-out, runErr
+}
+
+func writeReviewer(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Rob Pike.md")
+	require.NoError(t, os.WriteFile(path, []byte("You are Rob Pike."), 0o644))
+	return path
+}
+
+func ledgerEntries(t *testing.T, storage string) []ledger.Entry {
+	t.Helper()
+	entries, err := ledger.New(storage).Entries(time.Time{})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestRunCommit_Replay(t *testing.T) {
+	cfg, storage := replayConfig(t, "commit.jsonl")
+
+	out, err := captureStdout(t, func() error {
+		return RunCommit(CommitOptions{Diff: readDiff(t), Storage: storage, Config: cfg})
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "feat(stats): add Mean function")
+	assert.NotContains(t, out, "```")
+
+	entries := ledgerEntries(t, storage)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ledger.TaskCommit, entries[0].Task)
+	assert.Equal(t, "replay", entries[0].Provider)
+	assert.Equal(t, int64(728), entries[0].InputTokens)
+}
+
+func TestRunReview_ReplayStream(t *testing.T) {
+	cfg, storage := replayConfig(t, "review.jsonl")
+	output := filepath.Join(t.TempDir(), "review.md")
+
+	require.NoError(t, RunReview(ReviewOptions{
+		Diff:            readDiff(t),
+		ReviewerName:    writeReviewer(t),
+		SkipInstruction: true,
+		Storage:         storage,
+		Config:          cfg,
+		Stream:          true,
+		Output:          output,
+	}))
+
+	review, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(review), "The `Mean` function will silently return `NaN`")
+
+	entries, err := history.New(storage).List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Rob Pike", entries[0].Reviewer)
+	assert.Equal(t, "claude-sonnet-4-5", entries[0].Model)
+	assert.Len(t, entries[0].Suggestions(), 2)
+}
+
+func TestRunReview_ReplayJSON(t *testing.T) {
+	cfg, storage := replayConfig(t, "review-json.jsonl")
+	output := filepath.Join(t.TempDir(), "review.json")
+
+	err := RunReview(ReviewOptions{
+		Diff:            readDiff(t),
+		ReviewerName:    writeReviewer(t),
+		SkipInstruction: true,
+		Storage:         storage,
+		Config:          cfg,
+		Format:          FormatJSON,
+		Output:          output,
+		FailOn:          findings.SeverityHigh,
+	})
+	thresholdErr, ok := errors.AsType[*ThresholdError](err)
+	require.True(t, ok, "the review has a high severity finding: %v", err)
+	assert.Equal(t, 1, thresholdErr.Count)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	var report findings.Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Findings, 3)
+	assert.Equal(t, findings.SeverityHigh, report.Findings[0].Severity)
+	assert.Equal(t, "stats.go", report.Findings[0].File)
+}
+
+func TestRunReview_FailureKeepsOutput(t *testing.T) {
+	cfg, storage := replayConfig(t, "unauthorized.jsonl")
+	dir := t.TempDir()
+	output := filepath.Join(dir, "review.md")
+	require.NoError(t, os.WriteFile(output, []byte("previous review"), 0o644))
+
+	err := RunReview(ReviewOptions{
+		Diff:            readDiff(t),
+		ReviewerName:    writeReviewer(t),
+		SkipInstruction: true,
+		Storage:         storage,
+		Config:          cfg,
+		Output:          output,
+	})
+	require.Error(t, err)
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "previous review", string(data))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "the temporary output file is removed")
+}
+
+func TestRunPR_Replay(t *testing.T) {
+	cfg, storage := replayConfig(t, "pr.jsonl")
+
+	out, err := captureStdout(t, func() error {
+		return RunPR(PROptions{Diff: readDiff(t), Storage: storage, Config: cfg})
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "# Add Mean function to stats package")
+	assert.Len(t, ledgerEntries(t, storage), 1)
+}
+
+func TestRunCommit_ReplayedError(t *testing.T) {
+	cfg, storage := replayConfig(t, "unauthorized.jsonl")
+
+	_, err := captureStdout(t, func() error {
+		return RunCommit(CommitOptions{Diff: readDiff(t), Storage: storage, Config: cfg})
+	})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "invalid x-api-key")
+	assert.Empty(t, ledgerEntries(t, storage))
+}
+
+func TestRunModels_Replay(t *testing.T) {
+	cfg, _ := replayConfig(t, "review.jsonl")
+
+	out, err := captureStdout(t, func() error {
+		return RunModels(ModelsOptions{Config: cfg, Provider: "replay"})
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "replay\n")
+	assert.Contains(t, out, "MODEL")
+	assert.Regexp(t, `claude-sonnet-4-5\s+-\s+-\s+-`, out)
+}
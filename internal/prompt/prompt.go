@@ -3,6 +3,7 @@ package prompt
 import (
 	_ "embed"
 	"fmt"
+	"strings"
 
 	"github.com/ionut-t/bark/v2/internal/git"
 )
@@ -10,6 +11,12 @@ import (
 //go:embed format.md
 var formattingRequirements string
 
+//go:embed findings.md
+var findingsRequirements string
+
+//go:embed merge.md
+var mergeRequirements string
+
 // FormatReviewSystem builds the system prompt for a code review.
 func FormatReviewSystem(reviewerPrompt, instructions string) string {
 	system := reviewerPrompt + "\n" + formattingRequirements
@@ -19,14 +26,80 @@ func FormatReviewSystem(reviewerPrompt, instructions string) string {
 	return system
 }
 
-// FormatReviewContent assembles the user-facing review prompt from fetched git context.
-func FormatReviewContent(contextHeader, stat string, commits []git.Commit, diff string, enclosingContext string) string {
-	commitsSection := git.FormatCommitsSection(commits)
+// FormatFindingsSystem builds the system prompt for a code review whose
+// output is a structured findings report rather than markdown.
+func FormatFindingsSystem(reviewerPrompt, instructions string) string {
+	system := reviewerPrompt + "\n" + findingsRequirements
+	if instructions != "" {
+		system += fmt.Sprintf("\nFollow the instructions below when analysing code:\n\n%s", instructions)
+	}
+	return system
+}
+
+// Section is a named part of a prompt, used to break token estimates down.
+type Section struct {
+	Name    string
+	Content string
+}
+
+// ReviewSections splits the review prompt into its parts, in prompt order.
+// Joining their contents yields FormatReviewContent. The parts least likely
+// to change when a branch is reviewed again come first, and the diff last,
+// so providers can cache the leading ones (see CacheablePrefix).
+func ReviewSections(contextHeader, stat string, commits []git.Commit, diff string, enclosingContext string) []Section {
 	statSection := ""
 	if stat != "" {
 		statSection = fmt.Sprintf("## Files Changed\n%s\n\n", stat)
 	}
-	return fmt.Sprintf("%s%s%s%s**Code to review:**\n%s", contextHeader, commitsSection, statSection, enclosingContext, diff)
+	return []Section{
+		{Name: "context header", Content: contextHeader},
+		{Name: "enclosing context", Content: enclosingContext},
+		{Name: "commits", Content: git.FormatCommitsSection(commits)},
+		{Name: "stat", Content: statSection},
+		{Name: "diff", Content: "**Code to review:**\n" + diff},
+	}
+}
+
+// CacheablePrefix returns the length of the review prompt's stable prefix,
+// the context header and enclosing context, for llm.Options.CachePrefix.
+func CacheablePrefix(contextHeader, enclosingContext string) int {
+	return len(contextHeader) + len(enclosingContext)
+}
+
+// FormatReviewContent assembles the user-facing review prompt from fetched git context.
+func FormatReviewContent(contextHeader, stat string, commits []git.Commit, diff string, enclosingContext string) string {
+	var sb strings.Builder
+	for _, section := range ReviewSections(contextHeader, stat, commits, diff, enclosingContext) {
+		sb.WriteString(section.Content)
+	}
+	return sb.String()
+}
+
+// FormatChunkHeader introduces one part of a review split into chunks; it is
+// prepended to the context header of the part's prompt.
+func FormatChunkHeader(index, total int) string {
+	return fmt.Sprintf("**Part %d of %d:** the change is too large to review at once, so only part of it is shown below. Review this part on its own merits.\n\n", index+1, total)
+}
+
+// FormatMergeSystem builds the system prompt for the pass that merges the
+// partial reviews of a chunked review into one.
+func FormatMergeSystem(reviewerPrompt, instructions string) string {
+	return FormatReviewSystem(reviewerPrompt, instructions) + "\n" + mergeRequirements
+}
+
+// FormatMergeContent assembles the merge prompt from the change's context
+// and the partial reviews, in chunk order.
+func FormatMergeContent(contextHeader, stat string, commits []git.Commit, reviews []string) string {
+	var sb strings.Builder
+	sb.WriteString(contextHeader)
+	sb.WriteString(git.FormatCommitsSection(commits))
+	if stat != "" {
+		fmt.Fprintf(&sb, "## Files Changed\n%s\n\n", stat)
+	}
+	for i, review := range reviews {
+		fmt.Fprintf(&sb, "## Partial review %d of %d\n\n%s\n\n", i+1, len(reviews), strings.TrimSpace(review))
+	}
+	return sb.String()
 }
 
 // FormatCommitSystem builds the system prompt for commit message generation.
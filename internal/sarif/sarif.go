@@ -0,0 +1,229 @@
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ionut-t/bark/v2/internal/enclosing"
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/ionut-t/bark/v2/internal/version"
+)
+
+const (
+	Version   = "2.1.0"
+	SchemaURI = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	toolName           = "bark"
+	toolInformationURI = "https://github.com/ionut-t/bark"
+
+	// srcRoot is the uriBaseId artifact locations are relative to. SARIF
+	// consumers (GitHub code scanning included) resolve it to the checkout.
+	srcRoot = "%SRCROOT%"
+)
+
+// Log is the root object of a SARIF 2.1.0 document.
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+type Driver struct {
+	Name           string         `json:"name"`
+	Version        string         `json:"version,omitempty"`
+	InformationURI string         `json:"informationUri,omitempty"`
+	Rules          []Rule         `json:"rules"`
+	Properties     map[string]any `json:"properties,omitempty"`
+}
+
+type Rule struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	ShortDescription *Message `json:"shortDescription,omitempty"`
+}
+
+type Result struct {
+	RuleID    string     `json:"ruleId"`
+	RuleIndex int        `json:"ruleIndex"`
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+type Message struct {
+	Text     string `json:"text"`
+	Markdown string `json:"markdown,omitempty"`
+}
+
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+type ArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type Region struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+// Metadata identifies who produced the review; it is recorded on the tool
+// driver so dashboards can tell runs from different reviewers apart.
+type Metadata struct {
+	Reviewer string
+	Provider string
+	Model    string
+}
+
+// Build converts a findings report into a SARIF log. Findings without a line
+// number are anchored to the first line the diff modifies in their file, so
+// every result that names a changed file gets a physical location.
+func Build(report findings.Report, diff string, meta Metadata) Log {
+	modified := enclosing.ModifiedLinesFromDiff(diff)
+
+	var (
+		rules     []Rule
+		ruleIndex = make(map[string]int)
+		results   = make([]Result, 0, len(report.Findings))
+	)
+
+	for _, f := range report.Findings {
+		index, ok := ruleIndex[f.Category]
+		if !ok {
+			index = len(rules)
+			ruleIndex[f.Category] = index
+			rules = append(rules, Rule{
+				ID:               f.Category,
+				Name:             ruleName(f.Category),
+				ShortDescription: &Message{Text: fmt.Sprintf("%s findings reported by the reviewer", f.Category)},
+			})
+		}
+
+		result := Result{
+			RuleID:    f.Category,
+			RuleIndex: index,
+			Level:     level(f.Severity),
+			Message:   message(f),
+		}
+
+		if location, ok := location(f, modified); ok {
+			result.Locations = []Location{location}
+		}
+
+		results = append(results, result)
+	}
+
+	if rules == nil {
+		rules = []Rule{}
+	}
+
+	properties := map[string]any{}
+	for key, value := range map[string]string{"reviewer": meta.Reviewer, "provider": meta.Provider, "model": meta.Model} {
+		if value != "" {
+			properties[key] = value
+		}
+	}
+
+	driver := Driver{
+		Name:           toolName,
+		Version:        version.Version(),
+		InformationURI: toolInformationURI,
+		Rules:          rules,
+	}
+	if len(properties) > 0 {
+		driver.Properties = properties
+	}
+
+	return Log{
+		Schema:  SchemaURI,
+		Version: Version,
+		Runs: []Run{{
+			Tool:    Tool{Driver: driver},
+			Results: results,
+		}},
+	}
+}
+
+// Write encodes the log as indented JSON.
+func Write(w io.Writer, log Log) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func location(f findings.Finding, modified map[string][]int) (Location, bool) {
+	if f.File == "" {
+		return Location{}, false
+	}
+
+	loc := Location{PhysicalLocation: PhysicalLocation{
+		ArtifactLocation: ArtifactLocation{URI: f.File, URIBaseID: srcRoot},
+	}}
+
+	switch {
+	case f.StartLine > 0:
+		loc.PhysicalLocation.Region = &Region{StartLine: f.StartLine, EndLine: max(f.EndLine, f.StartLine)}
+	case len(modified[f.File]) > 0:
+		line := modified[f.File][0]
+		loc.PhysicalLocation.Region = &Region{StartLine: line, EndLine: line}
+	}
+
+	return loc, true
+}
+
+func message(f findings.Finding) Message {
+	text := f.Message
+	if text == "" {
+		text = f.Category
+	}
+
+	msg := Message{Text: text}
+	if f.Suggestion != "" {
+		msg.Markdown = fmt.Sprintf("%s\n\n```diff\n%s\n```", text, f.Suggestion)
+	}
+	return msg
+}
+
+// level maps a finding severity onto the SARIF result levels.
+func level(severity findings.Severity) string {
+	switch severity {
+	case findings.SeverityHigh:
+		return "error"
+	case findings.SeverityMedium:
+		return "warning"
+	case findings.SeverityLow:
+		return "note"
+	default:
+		return "none"
+	}
+}
+
+// ruleName turns a category such as "error-handling" into "ErrorHandling",
+// the PascalCase form SARIF recommends for rule names.
+func ruleName(category string) string {
+	var sb strings.Builder
+	for word := range strings.FieldsFuncSeq(category, func(r rune) bool {
+		return r == '-' || r == '_' || r == ' '
+	}) {
+		sb.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return sb.String()
+}
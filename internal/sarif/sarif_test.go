@@ -0,0 +1,53 @@
+package sarif
+
+import (
+	"testing"
+
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+index 1234567..89abcdf 100644
+--- a/main.go
++++ b/main.go
+@@ -5,4 +5,5 @@
+ func main() {
++	fmt.Println("Hello")
+ }
+`
+
+	report := findings.Report{Findings: []findings.Finding{
+		{File: "main.go", StartLine: 6, EndLine: 7, Severity: findings.SeverityHigh, Category: "error-handling", Message: "Unchecked error."},
+		{File: "main.go", Severity: findings.SeverityLow, Category: "style", Message: "Prefer log.", Suggestion: "-a\n+b"},
+		{Severity: findings.SeverityMedium, Category: "error-handling", Message: "General remark."},
+	}}
+
+	log := Build(report, diff, Metadata{Reviewer: "Rob Pike", Model: "gpt-5"})
+	require.Len(t, log.Runs, 1)
+	run := log.Runs[0]
+
+	// Rules are keyed by category, one per distinct category.
+	assert.Equal(t, []Rule{
+		{ID: "error-handling", Name: "ErrorHandling", ShortDescription: &Message{Text: "error-handling findings reported by the reviewer"}},
+		{ID: "style", Name: "Style", ShortDescription: &Message{Text: "style findings reported by the reviewer"}},
+	}, run.Tool.Driver.Rules)
+	assert.Equal(t, map[string]any{"reviewer": "Rob Pike", "model": "gpt-5"}, run.Tool.Driver.Properties)
+
+	require.Len(t, run.Results, 3)
+
+	assert.Equal(t, "error", run.Results[0].Level)
+	assert.Equal(t, &Region{StartLine: 6, EndLine: 7}, run.Results[0].Locations[0].PhysicalLocation.Region)
+
+	// A finding without a line is anchored to the first modified line of its
+	// file, and its suggestion is carried in the markdown message.
+	assert.Equal(t, 1, run.Results[1].RuleIndex)
+	assert.Equal(t, &Region{StartLine: 6, EndLine: 6}, run.Results[1].Locations[0].PhysicalLocation.Region)
+	assert.Equal(t, "Prefer log.\n\n```diff\n-a\n+b\n```", run.Results[1].Message.Markdown)
+
+	// A finding without a file has no location.
+	assert.Equal(t, 0, run.Results[2].RuleIndex)
+	assert.Empty(t, run.Results[2].Locations)
+}
@@ -1,20 +1,24 @@
 package tui
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"charm.land/bubbles/v2/list"
 	"charm.land/bubbles/v2/viewport"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/cache"
 	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/enclosing"
 	"github.com/ionut-t/bark/v2/internal/git"
 	"github.com/ionut-t/bark/v2/internal/instructions"
+	"github.com/ionut-t/bark/v2/internal/ledger"
 	"github.com/ionut-t/bark/v2/internal/llm"
-	"github.com/ionut-t/bark/v2/internal/llm/llm_factory"
 	"github.com/ionut-t/bark/v2/internal/prompt"
 	"github.com/ionut-t/bark/v2/internal/reviewers"
 	"github.com/ionut-t/bark/v2/internal/utils"
@@ -53,7 +57,11 @@ type Model struct {
 
 	currentView view
 
-	llm     llm.LLM
+	// clients holds the LLM client of each task that has run, created from
+	// the profile the task resolves to; client is the one of the selected
+	// task.
+	clients map[string]taskClient
+	client  taskClient
 	config  config.Config
 	storage string
 
@@ -105,10 +113,19 @@ type Model struct {
 	isDarkMode bool
 	pendingCmd tea.Cmd
 
-	llmProvider  string
 	lastUsage    *usageStats
 	genStartedAt time.Time
-	showStats    bool
+	// chunkUsage is the combined usage of the chunk reviews of a chunked
+	// review, reported together with the merge pass.
+	chunkUsage llm.Usage
+	showStats  bool
+	// modelPicker switches the model of the current task while showModels
+	// is set.
+	modelPicker modelPickerModel
+	showModels  bool
+	// budgetNotice warns that the request in flight exceeds a spending
+	// budget; any key dismisses it.
+	budgetNotice string
 
 	viewport viewport.Model
 }
@@ -132,13 +149,6 @@ type Options struct {
 func New(options Options) *Model {
 	isDarkMode := styles.IsDark()
 
-	llm, provider, err := llm_factory.New(context.Background(), options.Config)
-	if err != nil {
-		return &Model{
-			error: err,
-		}
-	}
-
 	if !git.IsGitRepo() {
 		return &Model{
 			error: git.ErrNotAGitRepository,
@@ -161,8 +171,7 @@ func New(options Options) *Model {
 		width:                80,
 		height:               24,
 		currentView:          currentView,
-		llm:                  llm,
-		llmProvider:          provider,
+		clients:              make(map[string]taskClient),
 		config:               options.Config,
 		storage:              options.Storage,
 		selectCommit:         options.SelectCommit,
@@ -196,11 +205,13 @@ func New(options Options) *Model {
 	return m
 }
 
-func (m *Model) recordUsage(usage llm.Usage, duration time.Duration) {
+// recordUsage sets the usage stats of the last request, naming the provider
+// and model that served it when a fallback took over.
+func (m *Model) recordUsage(usage llm.Usage, duration time.Duration, served servedBy) {
 	m.lastUsage = &usageStats{
 		usage:    usage,
-		provider: m.llmProvider,
-		model:    m.getLlmModelName(),
+		provider: cmp.Or(served.provider, m.client.profile.Provider),
+		model:    cmp.Or(served.model, m.getLlmModelName()),
 		duration: duration,
 	}
 }
@@ -213,24 +224,51 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case streamReadyMsg:
 		m.genStartedAt = msg.startedAt
+		m.chunkUsage = llm.Usage{}
+	case chunkReviewReadyMsg:
+		m.genStartedAt = msg.startedAt
+		m.chunkUsage = llm.Usage{}
+	case chunksReviewedMsg:
+		// Chunk reviews are billed too; the merge pass adds to them.
+		if msg.err == nil {
+			m.chunkUsage = msg.usage
+			m.recordUsage(m.chunkUsage, time.Since(m.genStartedAt), msg.served)
+		}
 	case streamChunkMsg:
 		if msg.hasUsage {
-			m.recordUsage(msg.usage, time.Since(m.genStartedAt))
+			m.recordUsage(m.chunkUsage.Add(msg.usage), time.Since(m.genStartedAt), msg.served)
 		}
 	case streamCompleteMsg:
 		if msg.hasUsage {
-			m.recordUsage(msg.usage, time.Since(m.genStartedAt))
+			m.recordUsage(m.chunkUsage.Add(msg.usage), time.Since(m.genStartedAt), msg.served)
+		}
+	case chatReadyMsg:
+		m.genStartedAt = msg.startedAt
+	case chatChunkMsg:
+		if msg.hasUsage {
+			m.recordUsage(msg.usage, time.Since(m.genStartedAt), msg.served)
+		}
+	case chatCompleteMsg:
+		if msg.hasUsage {
+			m.recordUsage(msg.usage, time.Since(m.genStartedAt), msg.served)
 		}
 	case commitResponseMsg:
 		if msg.hasUsage {
-			m.recordUsage(msg.usage, msg.duration)
+			m.recordUsage(msg.usage, msg.duration, msg.served)
 		}
 	case prResponseMsg:
 		if msg.hasUsage {
-			m.recordUsage(msg.usage, msg.duration)
+			m.recordUsage(msg.usage, msg.duration, msg.served)
 		}
 	}
 
+	// The picker's list filters in the background.
+	if _, ok := msg.(list.FilterMatchesMsg); ok && m.showModels {
+		var cmd tea.Cmd
+		m.modelPicker, cmd = m.modelPicker.Update(msg)
+		return m, cmd
+	}
+
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -256,6 +294,37 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case editor.RelativeNumbersChangeMsg:
 		return m, setRelativeNumberCmd(m.config, msg.Enabled)
 
+	case modelsLoadedMsg:
+		m.modelPicker.setModels(msg.models, msg.err)
+		return m, nil
+
+	case modelSelectedMsg:
+		if msg.model == m.client.profile.Model {
+			m.showModels = false
+			return m, nil
+		}
+		m.modelPicker.switching = msg.model
+		return m, switchModelCmd(m.config, m.storage, m.client.profile, msg.model)
+
+	case modelSwitchedMsg:
+		return m.handleModelSwitched(msg.client)
+
+	case taskClientCreatedMsg:
+		// The task may have been selected again while its client was
+		// created, or another selected since.
+		if _, ok := m.clients[msg.task]; ok {
+			return m, nil
+		}
+		m.clients[msg.task] = msg.client
+		if msg.task != m.selectedTask.profileTask() {
+			return m, nil
+		}
+		return m.handleSelectedTask(m.selectedTask)
+
+	case cancelModelSelectionMsg:
+		m.showModels = false
+		return m, nil
+
 	case taskSelectedMsg:
 		return m.handleSelectedTask(msg.task)
 
@@ -391,7 +460,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.useGitHubPR {
 			m.currentView = viewPRNumberInput
 		} else {
-			m.pr = newPRModel(m.llm, m.width, m.height)
+			m.pr = newPRModel(m.client.llm, m.client.opts, m.width, m.height)
 			m.pr.setStyles(m.styles, m.isDarkMode)
 			m.pr.displayUsedModel(m.getLlmModelName())
 			m.currentView = viewPRDescription
@@ -415,7 +484,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case prNumberSelectedMsg:
 		m.prNumber = msg.prNumber
 		if m.selectedTask == TaskPRDescription {
-			m.pr = newPRModel(m.llm, m.width, m.height)
+			m.pr = newPRModel(m.client.llm, m.client.opts, m.width, m.height)
 			m.pr.setStyles(m.styles, m.isDarkMode)
 			m.pr.displayUsedModel(m.getLlmModelName())
 			m.currentView = viewPRDescription
@@ -457,6 +526,19 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.selectedInstruction = ""
 
 	case tea.KeyMsg:
+		if m.budgetNotice != "" && msg.String() != "ctrl+c" {
+			m.budgetNotice = ""
+			return m, nil
+		}
+
+		if m.showModels {
+			if msg.String() != "ctrl+c" {
+				var cmd tea.Cmd
+				m.modelPicker, cmd = m.modelPicker.Update(msg)
+				return m, cmd
+			}
+		}
+
 		if m.showStats {
 			switch msg.String() {
 			case "esc", "ctrl+t":
@@ -488,6 +570,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.showStats = !m.showStats
 			return m, nil
 
+		case "ctrl+o":
+			if m.canSwitchModel() {
+				m.showModels = true
+				m.modelPicker = newModelPickerModel(m.client.profile.Provider, m.client.profile.Model, m.styles, m.isDarkMode)
+				return m, loadModelsCmd(m.config, m.client.profile)
+			}
+
 		case "esc":
 			m.showHelp = false
 
@@ -517,7 +606,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch m.currentView {
 			case viewReview:
 				if m.review.error != nil {
-					ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+					ctx, cancel := context.WithTimeout(cache.WithRefresh(context.Background()), ctxTimeout)
 
 					if m.reviewCancelFunc != nil {
 						m.reviewCancelFunc()
@@ -539,7 +628,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case viewPRDescription:
 				if m.pr.error != nil {
-					ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+					ctx, cancel := context.WithTimeout(cache.WithRefresh(context.Background()), ctxTimeout)
 
 					if m.operationCancelFunc != nil {
 						m.operationCancelFunc()
@@ -610,6 +699,12 @@ func (m Model) View() tea.View {
 	if m.showStats {
 		content = overlayCenter(content, renderUsageStats(m.lastUsage, m.styles), m.width, m.height)
 	}
+	if m.showModels {
+		content = overlayCenter(content, m.modelPicker.View(), m.width, m.height)
+	}
+	if m.budgetNotice != "" {
+		content = overlayCenter(content, renderBudgetNotice(m.budgetNotice, m.styles), m.width, m.height)
+	}
 
 	view := tea.NewView(content)
 	view.AltScreen = true
@@ -727,6 +822,16 @@ func (m Model) createView() string {
 func (m *Model) handleSelectedTask(task Task) (tea.Model, tea.Cmd) {
 	m.selectedTask = task
 
+	client, ok := m.clients[task.profileTask()]
+	if !ok {
+		return m, newTaskClientCmd(m.config, m.storage, task.profileTask())
+	}
+	m.client = client
+	if m.client.err != nil {
+		m.error = m.client.err
+		return m, nil
+	}
+
 	switch m.selectedTask {
 	case TaskReview:
 		if m.selectedReviewOption != ReviewOptionNone {
@@ -739,7 +844,7 @@ func (m *Model) handleSelectedTask(task Task) (tea.Model, tea.Cmd) {
 
 	case TaskPRDescription:
 		if m.prNumber != "" || m.branch != "" {
-			m.pr = newPRModel(m.llm, m.width, m.height)
+			m.pr = newPRModel(m.client.llm, m.client.opts, m.width, m.height)
 			m.pr.setStyles(m.styles, m.isDarkMode)
 			m.pr.displayUsedModel(m.getLlmModelName())
 			m.currentView = viewPRDescription
@@ -802,6 +907,10 @@ func (m *Model) handleSelectedInstruction(instruction string) (tea.Model, tea.Cm
 			instruction:       instruction,
 			withPRDescription: m.withPRDescription,
 			contextEnrichment: m.config.GetContextEnrichment(),
+			contextBudget:     int(m.config.GetContextTokenBudget()),
+			system:            prompt.FormatReviewSystem(m.selectedReviewer.Prompt, instruction),
+			tokenBudget:       int(m.config.GetReviewTokenBudget()),
+			budget:            m.budgetCheck(),
 		},
 	)
 }
@@ -824,6 +933,10 @@ func (m *Model) handleReviewDiffLoaded(msg reviewDiffLoadedMsg) (tea.Model, tea.
 		return m, nil
 	}
 
+	if m.handleBudget(msg.budgetErr) {
+		return m, nil
+	}
+
 	if msg.instruction != "" {
 		m.selectedInstruction = msg.instruction
 	}
@@ -838,17 +951,55 @@ func (m *Model) handleReviewDiffLoaded(msg reviewDiffLoadedMsg) (tea.Model, tea.
 	}
 	m.reviewCancelFunc = cancel
 
-	m.review = newReviewModel(*m.selectedReviewer, system, reviewPrompt, m.width, m.height, m.llm)
+	// Reviews are often repeated after small fixes, so cache the system
+	// prompt and the prompt's stable prefix. Chunk prompts differ from the
+	// single-pass one, so only the system prompt is cached for them.
+	opts := m.client.opts
+	opts.Cache = m.config.GetPromptCache()
+	if opts.Cache && len(msg.chunks) == 0 {
+		opts.CachePrefix = prompt.CacheablePrefix(msg.contextHeader, msg.enclosingContext)
+	}
+
+	m.review = newReviewModel(*m.selectedReviewer, system, reviewPrompt, m.width, m.height, m.client.llm, opts)
 	m.review.setStyles(m.styles, m.isDarkMode)
 	m.review.showRelativeLineNumbers(m.config.GetRelativeNumber())
 	m.review.setUsedModel(m.getLlmModelName())
+	m.review.setHistory(m.storage, msg.diff)
+	if len(msg.chunks) > 0 {
+		m.review.setChunked(chunkedReviewFor(msg, system, prompt.FormatMergeSystem(m.selectedReviewer.Prompt, msg.instruction), int(m.config.GetReviewConcurrency())))
+	}
 	m.currentView = viewReview
 
 	return m, m.review.startReview(ctx)
 }
 
+// chunkedReviewFor builds the map-reduce plan for a diff that exceeds the
+// token budget.
+func chunkedReviewFor(msg reviewDiffLoadedMsg, system, mergeSystem string, concurrency int) chunkedReview {
+	return chunkedReview{
+		chunks:      msg.chunks,
+		concurrency: concurrency,
+		system:      system,
+		prompt: func(ctx context.Context, i int) string {
+			var enclosingContext string
+			if msg.enrich {
+				enclosingCtx, cancel := context.WithTimeout(ctx, gitTimeout)
+				enclosingContext, _ = enclosing.DeclarationsForDiff(enclosingCtx, msg.chunks[i].Diff, msg.ref, msg.baseRef, msg.contextBudget)
+				cancel()
+			}
+
+			header := msg.contextHeader + prompt.FormatChunkHeader(i, len(msg.chunks))
+			return prompt.FormatReviewContent(header, msg.stat, msg.commits, msg.chunks[i].Diff, enclosingContext)
+		},
+		mergeSystem: mergeSystem,
+		mergePrompt: func(reviews []string) string {
+			return prompt.FormatMergeContent(msg.contextHeader, msg.stat, msg.commits, reviews)
+		},
+	}
+}
+
 func (m *Model) handleCommitMessage(commitAll bool) (tea.Model, tea.Cmd) {
-	return m, loadCommitDataCmd(m.config.GetCommitInstructions(), commitAll)
+	return m, loadCommitDataCmd(m.config.GetCommitInstructions(), commitAll, m.budgetCheck())
 }
 
 func (m *Model) handleCommitDataLoaded(msg commitDataLoadedMsg) (tea.Model, tea.Cmd) {
@@ -874,6 +1025,10 @@ func (m *Model) handleCommitDataLoaded(msg commitDataLoadedMsg) (tea.Model, tea.
 		return m, nil
 	}
 
+	if m.handleBudget(msg.budgetErr) {
+		return m, nil
+	}
+
 	commitSystem := prompt.FormatCommitSystem(msg.instructions, m.hint)
 
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
@@ -883,7 +1038,7 @@ func (m *Model) handleCommitDataLoaded(msg commitDataLoadedMsg) (tea.Model, tea.
 	}
 	m.operationCancelFunc = cancel
 
-	m.commitChanges = newCommitChangesModel(m.llm, commitSystem, msg.diff, msg.commitAll, m.width, m.height)
+	m.commitChanges = newCommitChangesModel(m.client.llm, m.client.opts, commitSystem, msg.diff, msg.commitAll, m.width, m.height)
 	m.commitChanges.setStyles(m.styles, m.isDarkMode)
 	m.commitChanges.showRelativeLineNumbers(m.config.GetRelativeNumber())
 	m.commitChanges.displayUsedModel(m.getLlmModelName())
@@ -891,8 +1046,10 @@ func (m *Model) handleCommitDataLoaded(msg commitDataLoadedMsg) (tea.Model, tea.
 	return m, m.commitChanges.startCommitGeneration(ctx)
 }
 
+// handleCommitMessageRetry generates the commit message again, bypassing
+// the response cache so a new one is generated.
 func (m *Model) handleCommitMessageRetry() (tea.Model, tea.Cmd) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	ctx, cancel := context.WithTimeout(cache.WithRefresh(context.Background()), ctxTimeout)
 
 	if m.operationCancelFunc != nil {
 		m.operationCancelFunc()
@@ -910,6 +1067,7 @@ func (m *Model) handlePRDescription() (tea.Model, tea.Cmd) {
 			prNumber:             m.prNumber,
 			branch:               m.branch,
 			maxLines:             m.config.GetMaxDiffLines(),
+			budget:               m.budgetCheck(),
 		},
 	)
 }
@@ -920,6 +1078,10 @@ func (m *Model) handlePRDataLoaded(msg prDataLoadedMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	if m.handleBudget(msg.budgetErr) {
+		return m, nil
+	}
+
 	m.pr.setContent(
 		prompt.FormatPRSystem(msg.instructions),
 		msg.content,
@@ -934,13 +1096,103 @@ func (m *Model) handlePRDataLoaded(msg prDataLoadedMsg) (tea.Model, tea.Cmd) {
 	return m, m.pr.startPRDescriptionGeneration(ctx)
 }
 
-func (m *Model) getLlmModelName() string {
-	model, err := m.config.GetLLMModel()
-	if err != nil {
-		return "unknown"
+// budgetCheck returns the check loaders run before a request is sent.
+func (m *Model) budgetCheck() budgetCheck {
+	cfg, storage, profile := m.config, m.storage, m.client.profile
+	return func(promptTokens int) error {
+		return ledger.CheckBudget(cfg, storage, profile, promptTokens)
+	}
+}
+
+// handleBudget reports the result of a budget check. A request over budget
+// is refused when budget_action is abort, and otherwise goes ahead with a
+// notice; it returns true when the request must not be sent.
+func (m *Model) handleBudget(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if budgetErr, ok := errors.AsType[*ledger.BudgetError](err); ok && !budgetErr.Abort {
+		m.budgetNotice = budgetErr.Error()
+		return false
+	}
+
+	m.error = err
+	return true
+}
+
+// canSwitchModel reports whether the current view runs a task that can be
+// run again with another model.
+func (m *Model) canSwitchModel() bool {
+	if m.client.llm == nil || m.error != nil || m.commitErr != nil || m.message != "" {
+		return false
+	}
+
+	switch m.currentView {
+	case viewReview:
+		return true
+	case viewCommitChanges:
+		// Switching would discard the message being edited.
+		return !m.commitChanges.editor.IsInsertMode()
+	case viewPRDescription:
+		// The PR's diff is loaded after the view opens.
+		return m.pr.prompt != ""
+	default:
+		return false
+	}
+}
+
+// handleModelSwitched makes client the one of the current task and runs
+// the task again with it.
+func (m *Model) handleModelSwitched(client taskClient) (tea.Model, tea.Cmd) {
+	m.modelPicker.switching = ""
+	if client.err != nil {
+		m.modelPicker.err = client.err
+		return m, nil
+	}
+
+	m.showModels = false
+	m.client = client
+	m.clients[m.selectedTask.profileTask()] = client
+
+	// Running the task again asks for a new response, not a cached one.
+	ctx, cancel := context.WithTimeout(cache.WithRefresh(context.Background()), ctxTimeout)
+
+	switch m.currentView {
+	case viewReview:
+		if m.reviewCancelFunc != nil {
+			m.reviewCancelFunc()
+		}
+		m.reviewCancelFunc = cancel
+
+		m.review.setClient(client.llm, m.getLlmModelName())
+		return m, m.review.startReview(ctx)
+
+	case viewCommitChanges:
+		if m.operationCancelFunc != nil {
+			m.operationCancelFunc()
+		}
+		m.operationCancelFunc = cancel
+
+		m.commitChanges.setClient(client.llm, m.getLlmModelName())
+		return m, m.commitChanges.startCommitGeneration(ctx)
+
+	case viewPRDescription:
+		if m.operationCancelFunc != nil {
+			m.operationCancelFunc()
+		}
+		m.operationCancelFunc = cancel
+
+		m.pr.setClient(client.llm, m.getLlmModelName())
+		return m, m.pr.startPRDescriptionGeneration(ctx)
 	}
 
-	return model
+	cancel()
+	return m, nil
+}
+
+func (m *Model) getLlmModelName() string {
+	return cmp.Or(m.client.profile.Model, "unknown")
 }
 
 func performCommit(message string, commitAll bool) tea.Cmd {
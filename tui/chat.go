@@ -0,0 +1,302 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"charm.land/bubbles/v2/spinner"
+	"charm.land/bubbles/v2/textinput"
+	"charm.land/bubbles/v2/viewport"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/ledger"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/utils"
+	"github.com/ionut-t/coffee/styles"
+)
+
+// The chat messages mirror the review stream messages so the coalescing
+// watcher can be shared, while keeping follow-up answers out of the review
+// editor.
+type (
+	chatReadyMsg    streamReadyMsg
+	chatChunkMsg    streamChunkMsg
+	chatCompleteMsg streamCompleteMsg
+	chatErrorMsg    streamErrorMsg
+)
+
+func startChatCmd(client llm.LLM, opts llm.Options, ctx context.Context, system string, messages []llm.Message) tea.Cmd {
+	return func() tea.Msg {
+		startedAt := time.Now()
+		respChan, errChan := client.Chat(ctx, system, messages, opts)
+		return chatReadyMsg{respChan: respChan, errChan: errChan, startedAt: startedAt}
+	}
+}
+
+func watchChatCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd {
+	watch := watchStreamCmd(respChan, errChan)
+	return func() tea.Msg {
+		switch msg := watch().(type) {
+		case streamChunkMsg:
+			return chatChunkMsg(msg)
+		case streamErrorMsg:
+			return chatErrorMsg(msg)
+		case streamCompleteMsg:
+			return chatCompleteMsg(msg)
+		default:
+			return msg
+		}
+	}
+}
+
+// chatModel is the follow-up conversation pane shown under a finished
+// review. The review prompt (diff and context) and the review itself are the
+// first two turns of every request, so answers stay grounded in them.
+type chatModel struct {
+	width, height int
+	llm           llm.LLM
+	opts          llm.Options
+	system        string
+	reviewer      string
+	// messages holds the full conversation; the first context turns are
+	// sent to the model but not shown.
+	messages   []llm.Message
+	context    int
+	input      textinput.Model
+	transcript viewport.Model
+	spinner    spinner.Model
+	respChan   <-chan llm.Response
+	errChan    <-chan error
+	pending    strings.Builder
+	streaming  bool
+	retrying   *llm.Retrying
+	cancel     context.CancelFunc
+	err        error
+	styles     styles.Styles
+}
+
+func newChatModel(client llm.LLM, opts llm.Options, system, prompt, review, reviewer string, s styles.Styles) chatModel {
+	input := textinput.New()
+	input.Placeholder = "Ask a follow-up question about the review..."
+	input.Prompt = "> "
+
+	sp := spinner.New()
+	sp.Spinner = spinner.Points
+	sp.Style = s.Primary
+
+	return chatModel{
+		llm:      client,
+		opts:     opts,
+		system:   system,
+		reviewer: reviewer,
+		messages: []llm.Message{
+			{Role: llm.RoleUser, Content: prompt},
+			{Role: llm.RoleAssistant, Content: review},
+		},
+		context:    2,
+		input:      input,
+		transcript: viewport.New(),
+		spinner:    sp,
+		styles:     s,
+	}
+}
+
+func (m *chatModel) setSize(width, height int) {
+	m.width = width
+	m.height = height
+
+	m.input.SetWidth(max(width-6, 10))
+	m.transcript.SetWidth(max(width-2, 10))
+	// Border, input line and help line.
+	m.transcript.SetHeight(max(height-4, 1))
+	m.render()
+}
+
+func (m *chatModel) focus() tea.Cmd {
+	return m.input.Focus()
+}
+
+func (m chatModel) focused() bool {
+	return m.input.Focused()
+}
+
+func (m *chatModel) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+}
+
+func (m chatModel) Update(msg tea.Msg) (chatModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case spinner.TickMsg:
+		if !m.streaming {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		m.render()
+		return m, cmd
+
+	case chatReadyMsg:
+		m.respChan = msg.respChan
+		m.errChan = msg.errChan
+		return m, watchChatCmd(m.respChan, m.errChan)
+
+	case chatChunkMsg:
+		if msg.retrying != nil {
+			m.retrying = msg.retrying
+		} else if msg.content != "" {
+			m.retrying = nil
+		}
+		m.pending.WriteString(msg.content)
+		m.render()
+		return m, watchChatCmd(m.respChan, m.errChan)
+
+	case chatCompleteMsg:
+		m.finish()
+		return m, nil
+
+	case chatErrorMsg:
+		m.err = msg.error
+		m.finish()
+		return m, nil
+
+	case tea.KeyMsg:
+		if !m.input.Focused() {
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "esc":
+			m.input.Blur()
+			return m, nil
+
+		case "enter":
+			question := strings.TrimSpace(m.input.Value())
+			if question == "" || m.streaming {
+				return m, nil
+			}
+			m.input.Reset()
+			return m, m.ask(question)
+
+		case "ctrl+d", "pgdown":
+			m.transcript.HalfPageDown()
+			return m, nil
+
+		case "ctrl+u", "pgup":
+			m.transcript.HalfPageUp()
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m *chatModel) ask(question string) tea.Cmd {
+	m.messages = append(m.messages, llm.Message{Role: llm.RoleUser, Content: question})
+	m.pending.Reset()
+	m.err = nil
+	m.retrying = nil
+	m.streaming = true
+	m.render()
+
+	ctx, cancel := context.WithTimeout(ledger.WithTask(context.Background(), ledger.TaskChat), ctxTimeout)
+	m.stop()
+	m.cancel = cancel
+
+	// Every question resends the review prompt; cache all of it when the
+	// review was cached (see prompt_cache).
+	opts := m.opts
+	if opts.Cache {
+		opts.CachePrefix = len(m.messages[0].Content)
+	}
+
+	return tea.Batch(m.spinner.Tick, startChatCmd(m.llm, opts, ctx, m.system, m.messages))
+}
+
+// finish records the streamed answer as an assistant turn. A failed or
+// empty answer drops the question so the conversation keeps alternating.
+func (m *chatModel) finish() {
+	m.streaming = false
+	m.retrying = nil
+	m.stop()
+
+	answer := strings.TrimSpace(m.pending.String())
+	m.pending.Reset()
+
+	if answer == "" {
+		if m.err == nil {
+			m.err = errors.New("empty response")
+		}
+		m.messages = m.messages[:len(m.messages)-1]
+	} else {
+		m.messages = append(m.messages, llm.Message{Role: llm.RoleAssistant, Content: answer})
+	}
+
+	m.render()
+}
+
+func (m *chatModel) render() {
+	width := max(m.transcript.Width()-2, 10)
+
+	var sections []string
+	for _, msg := range m.messages[m.context:] {
+		sections = append(sections, m.renderTurn(msg.Role, msg.Content, width))
+	}
+
+	if m.streaming {
+		answer := m.pending.String()
+		if answer == "" {
+			answer = m.spinner.View()
+		}
+		sections = append(sections, m.renderTurn(llm.RoleAssistant, answer, width))
+		if m.retrying != nil {
+			sections = append(sections, m.styles.Subtext0.Render(m.spinner.View()+" "+m.retrying.String()))
+		}
+	}
+
+	if m.err != nil {
+		sections = append(sections, m.styles.Error.Render(styles.Wrap(width, "Error: "+m.err.Error())))
+	}
+
+	if len(sections) == 0 {
+		sections = append(sections, m.styles.Subtext0.Render("Ask why something is a problem, or for the fixed version of the code."))
+	}
+
+	m.transcript.SetContent(strings.Join(sections, "\n\n"))
+	m.transcript.GotoBottom()
+}
+
+func (m chatModel) renderTurn(role llm.Role, content string, width int) string {
+	label := m.styles.Accent.Bold(true).Render("You")
+	if role == llm.RoleAssistant {
+		label = m.styles.Primary.Bold(true).Render(m.reviewer)
+	}
+
+	body := utils.NormaliseCodeFences(strings.TrimSpace(content))
+	return label + "\n" + m.styles.Text.Render(styles.Wrap(width, body))
+}
+
+func (m chatModel) View() string {
+	help := "enter send • esc review • ctrl+d/ctrl+u scroll"
+	if !m.input.Focused() {
+		help = "a ask • x close chat"
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		lipgloss.NewStyle().
+			Width(m.width).
+			Padding(0, 1).
+			Border(lipgloss.NormalBorder(), true, false, false, false).
+			BorderForeground(m.styles.Overlay0.GetForeground()).
+			Render(m.transcript.View()),
+		lipgloss.NewStyle().Padding(0, 1).Render(m.input.View()),
+		lipgloss.NewStyle().Padding(0, 2).Render(m.styles.Subtext0.Render(help)),
+	)
+}
@@ -0,0 +1,166 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/ionut-t/bark/v2/internal/chunk"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/retry"
+)
+
+// chunkedReview holds the state of a review whose diff exceeds the token
+// budget: the chunks are reviewed concurrently, then the partial reviews are
+// merged by a final streamed request.
+type chunkedReview struct {
+	chunks []chunk.Chunk
+	status []chunk.Status
+	// retrying holds the retry each running chunk is waiting on, if any.
+	retrying    []*llm.Retrying
+	concurrency int
+	// merging is set once every chunk is reviewed and the merge streams.
+	merging bool
+	// system is the review system prompt each chunk is sent with.
+	system string
+	// prompt builds the review prompt of the i-th chunk; it may read files
+	// for enclosing context, so it only runs inside the map command.
+	prompt func(ctx context.Context, i int) string
+	// mergeSystem and mergePrompt build the request that merges the partial
+	// reviews into one.
+	mergeSystem string
+	mergePrompt func(reviews []string) string
+}
+
+func (c *chunkedReview) reset() {
+	c.status = make([]chunk.Status, len(c.chunks))
+	c.retrying = make([]*llm.Retrying, len(c.chunks))
+	c.merging = false
+}
+
+func (c chunkedReview) done() int {
+	n := 0
+	for _, s := range c.status {
+		if s == chunk.StatusDone {
+			n++
+		}
+	}
+	return n
+}
+
+type chunkReviewReadyMsg struct {
+	progressChan <-chan chunk.Progress
+	doneChan     <-chan chunksReviewedMsg
+	startedAt    time.Time
+}
+
+type chunkProgressMsg chunk.Progress
+
+// chunksReviewedMsg reports the end of the map step. On success the merge
+// request has already been started and streams on merge.
+type chunksReviewedMsg struct {
+	mergePrompt string
+	merge       streamReadyMsg
+	usage       llm.Usage
+	served      servedBy
+	err         error
+}
+
+// startChunkReviewCmd reviews the chunks in the background, then starts
+// streaming the merged review. It returns the channels its progress and
+// result are delivered on.
+func startChunkReviewCmd(client llm.LLM, opts llm.Options, ctx context.Context, review chunkedReview) tea.Cmd {
+	return func() tea.Msg {
+		startedAt := time.Now()
+		progressChan := make(chan chunk.Progress)
+		doneChan := make(chan chunksReviewedMsg, 1)
+
+		go func() {
+			// A replaced review stops watching; the cancelled context then
+			// unblocks any pending progress send.
+			progress := func(p chunk.Progress) {
+				select {
+				case progressChan <- p:
+				case <-ctx.Done():
+				}
+			}
+
+			responses, err := chunk.Map(ctx, len(review.chunks), review.concurrency, progress, func(ctx context.Context, i int) (llm.Response, error) {
+				ctx = retry.WithNotify(ctx, func(r llm.Retrying) {
+					progress(chunk.Progress{Index: i, Status: chunk.StatusRunning, Retrying: &r})
+				})
+				return client.Generate(ctx, review.system, review.prompt(ctx, i), opts)
+			})
+			if err != nil {
+				doneChan <- chunksReviewedMsg{err: err}
+				return
+			}
+
+			var msg chunksReviewedMsg
+			reviews := make([]string, len(responses))
+			for i, resp := range responses {
+				reviews[i] = resp.Content
+				if resp.Usage != nil {
+					msg.usage = msg.usage.Add(*resp.Usage)
+				}
+				if resp.Model != "" {
+					msg.served = servedByOf(resp)
+				}
+			}
+
+			msg.mergePrompt = review.mergePrompt(reviews)
+			respChan, errChan := client.Stream(ctx, review.mergeSystem, msg.mergePrompt, opts)
+			msg.merge = streamReadyMsg{respChan: respChan, errChan: errChan, startedAt: time.Now()}
+			doneChan <- msg
+		}()
+
+		return chunkReviewReadyMsg{progressChan: progressChan, doneChan: doneChan, startedAt: startedAt}
+	}
+}
+
+func watchChunkReviewCmd(progressChan <-chan chunk.Progress, doneChan <-chan chunksReviewedMsg) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case p := <-progressChan:
+			return chunkProgressMsg(p)
+		case msg := <-doneChan:
+			return msg
+		}
+	}
+}
+
+// chunkProgressView renders one line per chunk with its review status.
+func (m reviewModel) chunkProgressView() string {
+	c := m.chunked
+
+	var sb strings.Builder
+	if c.merging {
+		fmt.Fprintf(&sb, "Merging %d partial reviews...\n", len(c.chunks))
+	} else {
+		fmt.Fprintf(&sb, "Diff exceeds the token budget: %d/%d chunks reviewed\n", c.done(), len(c.chunks))
+	}
+	for i, ch := range c.chunks {
+		var icon string
+		switch c.status[i] {
+		case chunk.StatusDone:
+			icon = m.styles.Success.Render("✓")
+		case chunk.StatusFailed:
+			icon = m.styles.Error.Render("✗")
+		case chunk.StatusRunning:
+			icon = m.spinner.View()
+		default:
+			icon = m.styles.Overlay0.Render("·")
+		}
+
+		title := m.styles.Text.Render(ch.Title())
+		tokens := m.styles.Subtext0.Render(fmt.Sprintf("~%d tokens", ch.Tokens))
+		fmt.Fprintf(&sb, "\n%s %s %s", icon, title, tokens)
+		if r := c.retrying[i]; r != nil && c.status[i] == chunk.StatusRunning {
+			fmt.Fprintf(&sb, " %s", m.styles.Warning.Render(r.String()))
+		}
+	}
+
+	return sb.String()
+}
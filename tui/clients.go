@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"context"
+
+	tea "charm.land/bubbletea/v2"
+
+	"github.com/ionut-t/bark/v2/internal/cache"
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/git"
+	"github.com/ionut-t/bark/v2/internal/ledger"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/llm_factory"
+)
+
+// taskClient is the LLM client of one task. err is reported when the task
+// is selected, so a broken profile only blocks the tasks that use it.
+type taskClient struct {
+	llm     llm.LLM
+	profile config.Profile
+	// opts are the generation options set in the profile.
+	opts llm.Options
+	err  error
+}
+
+// taskClientCreatedMsg carries the client created for task.
+type taskClientCreatedMsg struct {
+	task   string
+	client taskClient
+}
+
+// newTaskClientCmd creates the client of task the first time the task runs,
+// so providers that are never used don't read their credentials.
+func newTaskClientCmd(cfg config.Config, storage, task string) tea.Cmd {
+	return func() tea.Msg {
+		return taskClientCreatedMsg{task: task, client: newTaskClient(cfg, storage, task)}
+	}
+}
+
+// newTaskClient creates the client of task from the profile it resolves to,
+// recording its usage in the ledger under storage and answering from the
+// response cache when it is enabled.
+func newTaskClient(cfg config.Config, storage, task string) taskClient {
+	profile, err := cfg.ResolveProfile(task)
+	if err != nil {
+		return taskClient{err: err}
+	}
+	return newProfileClient(cfg, storage, repoRoot(), profile)
+}
+
+// newProfileClient creates the client of a resolved profile like
+// newTaskClient, with its usage recorded under the repository root.
+func newProfileClient(cfg config.Config, storage, root string, profile config.Profile) taskClient {
+	client, profile, err := llm_factory.FromProfile(context.Background(), cfg, profile)
+	if err == nil {
+		client, err = cachedClient(client, cfg, storage, profile)
+	}
+	if err != nil {
+		return taskClient{err: err}
+	}
+
+	return taskClient{
+		llm:     ledger.Record(client, ledger.New(storage), root, profile.Provider, profile.Model),
+		profile: profile,
+		opts:    llm_factory.Options(profile),
+	}
+}
+
+func repoRoot() string {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	root, _ := git.RepoRoot(ctx)
+	return root
+}
+
+func cachedClient(client llm.LLM, cfg config.Config, storage string, profile config.Profile) (llm.LLM, error) {
+	responses, err := cache.FromConfig(cfg, storage)
+	if err != nil {
+		return nil, err
+	}
+	return cache.Wrap(client, responses, profile.Provider, profile.Model), nil
+}
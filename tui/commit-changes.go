@@ -11,6 +11,7 @@ import (
 	"charm.land/bubbles/v2/viewport"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/ledger"
 	"github.com/ionut-t/bark/v2/internal/llm"
 	"github.com/ionut-t/bark/v2/internal/utils"
 	"github.com/ionut-t/coffee/help"
@@ -125,6 +126,7 @@ type commitResponseMsg struct {
 	usage    llm.Usage
 	hasUsage bool
 	duration time.Duration
+	served   servedBy
 }
 
 type commitChangesModel struct {
@@ -136,6 +138,7 @@ type commitChangesModel struct {
 	loadingMsg      string
 	spinner         spinner.Model
 	llm             llm.LLM
+	opts            llm.Options
 	system          string
 	prompt          string
 	error           error
@@ -172,7 +175,7 @@ type commitStreamStartMsg struct {
 	errChan <-chan error
 }
 
-func newCommitChangesModel(llm llm.LLM, system, prompt string, commitAll bool, width, height int) commitChangesModel {
+func newCommitChangesModel(llm llm.LLM, opts llm.Options, system, prompt string, commitAll bool, width, height int) commitChangesModel {
 	textEditor := editor.New(width, height)
 	textEditor.Focus()
 
@@ -186,6 +189,7 @@ func newCommitChangesModel(llm llm.LLM, system, prompt string, commitAll bool, w
 		spinner:   sp,
 		loading:   true,
 		llm:       llm,
+		opts:      opts,
 		system:    system,
 		prompt:    prompt,
 		commitAll: commitAll,
@@ -230,13 +234,14 @@ func (m commitChangesModel) Init() tea.Cmd {
 }
 
 func (m *commitChangesModel) startCommitGeneration(ctx context.Context) tea.Cmd {
+	ctx = ledger.WithTask(ctx, ledger.TaskCommit)
 	m.error = nil
 	m.loading = true
 
 	return tea.Batch(
 		m.spinner.Tick,
 		m.dispatchCommitGenerationLoadingMsg(),
-		getCommitMessage(ctx, m.llm, m.system, m.prompt),
+		getCommitMessage(ctx, m.llm, m.opts, m.system, m.prompt),
 	)
 }
 
@@ -425,6 +430,7 @@ func (m *commitChangesModel) commitChangesHelp() string {
 		{"tab", "view prompt"},
 		{"ctrl+t", "show LLM usage stats"},
 		{"ctrl+r", "generate a new commit message"},
+		{"ctrl+o", "generate it again with another model"},
 		{"ctrl+c", "quit"},
 	}
 
@@ -447,7 +453,7 @@ func (m *commitChangesModel) commitChangesHelp() string {
 				Description string
 			},
 			) bool {
-				return c.Command == "i" || c.Command == "ctrl+r" || c.Command == "tab"
+				return c.Command == "i" || c.Command == "ctrl+r" || c.Command == "ctrl+o" || c.Command == "tab"
 			},
 		)
 
@@ -462,14 +468,15 @@ func (m *commitChangesModel) commitChangesHelp() string {
 	return help.RenderCmdHelp(m.styles, m.width, commands)
 }
 
-func getCommitMessage(ctx context.Context, llm llm.LLM, system, prompt string) tea.Cmd {
+func getCommitMessage(ctx context.Context, llm llm.LLM, opts llm.Options, system, prompt string) tea.Cmd {
 	return func() tea.Msg {
 		start := time.Now()
-		resp, err := llm.Generate(ctx, system, prompt)
+		resp, err := llm.Generate(ctx, system, prompt, opts)
 		msg := commitResponseMsg{
 			message:  resp.Content,
 			error:    err,
 			duration: time.Since(start),
+			served:   servedByOf(resp),
 		}
 		if resp.Usage != nil {
 			msg.usage = *resp.Usage
@@ -534,3 +541,9 @@ func listenCommitOutput(outChan <-chan string, errChan <-chan error) tea.Cmd {
 		}
 	}
 }
+
+// setClient makes the next generation use another model's client.
+func (m *commitChangesModel) setClient(l llm.LLM, model string) {
+	m.llm = l
+	m.displayUsedModel(model)
+}
@@ -3,11 +3,18 @@ package tui
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 
 	tea "charm.land/bubbletea/v2"
+	"github.com/ionut-t/bark/v2/internal/chunk"
 	"github.com/ionut-t/bark/v2/internal/enclosing"
 	"github.com/ionut-t/bark/v2/internal/git"
+	"github.com/ionut-t/bark/v2/internal/history"
 	"github.com/ionut-t/bark/v2/internal/instructions"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/prompt"
 	"github.com/ionut-t/bark/v2/internal/reviewers"
 	"github.com/ionut-t/bark/v2/internal/utils"
 )
@@ -77,8 +84,18 @@ type reviewDiffLoadedMsg struct {
 	commits          []git.Commit
 	contextHeader    string
 	enclosingContext string
-	err              error
-	branchErr        error
+	// chunks is set when the review exceeds the token budget; enclosing
+	// context is then extracted per chunk from ref and baseRef, within
+	// contextBudget.
+	chunks        []chunk.Chunk
+	ref           string
+	baseRef       string
+	contextBudget int
+	enrich        bool
+	// budgetErr is set when the review would exceed a spending budget.
+	budgetErr error
+	err       error
+	branchErr error
 }
 
 type reviewDiffCmdParams struct {
@@ -91,8 +108,17 @@ type reviewDiffCmdParams struct {
 	instruction       string
 	withPRDescription bool
 	contextEnrichment bool
+	// contextBudget is the estimated tokens the enclosing context may use.
+	contextBudget int
+	// system and tokenBudget decide whether the review is split into chunks.
+	system      string
+	tokenBudget int
+	budget      budgetCheck
 }
 
+// budgetCheck checks a request of promptTokens against the spending budgets.
+type budgetCheck func(promptTokens int) error
+
 func loadReviewDiffCmd(params reviewDiffCmdParams) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
@@ -119,15 +145,33 @@ func loadReviewDiffCmd(params reviewDiffCmdParams) tea.Cmd {
 			return reviewDiffLoadedMsg{instruction: params.instruction, branchErr: branchErr}
 		}
 
+		var chunks []chunk.Chunk
+		if err == nil {
+			overhead := llm.EstimateTokens(params.system) + llm.EstimateTokens(prompt.FormatReviewContent(result.ContextHeader, result.Stat, result.Commits, "", ""))
+			chunks = chunk.Plan(result.Diff, overhead, params.tokenBudget)
+		}
+
+		enrich := params.contextEnrichment && !result.SkipEnrichment
+
 		var enclosingContext string
-		if params.contextEnrichment && err == nil && !result.SkipEnrichment {
+		if enrich && err == nil && len(chunks) == 0 {
 			var ctxErr error
-			enclosingContext, ctxErr = enclosing.DeclarationsForDiff(ctx, result.Diff, result.Ref)
+			enclosingContext, ctxErr = enclosing.DeclarationsForDiff(ctx, result.Diff, result.Ref, result.BaseRef, params.contextBudget)
 			if ctxErr != nil {
 				enclosingContext = ""
 			}
 		}
 
+		var budgetErr error
+		if err == nil {
+			// Chunked reviews send the shared prompt once per chunk.
+			promptTokens := llm.EstimateTokens(params.system) + llm.EstimateTokens(prompt.FormatReviewContent(result.ContextHeader, result.Stat, result.Commits, result.Diff, enclosingContext))
+			if len(chunks) > 0 {
+				promptTokens = len(chunks)*(promptTokens-llm.EstimateTokens(result.Diff)) + llm.EstimateTokens(result.Diff)
+			}
+			budgetErr = params.budget(promptTokens)
+		}
+
 		return reviewDiffLoadedMsg{
 			instruction:      params.instruction,
 			diff:             result.Diff,
@@ -135,6 +179,12 @@ func loadReviewDiffCmd(params reviewDiffCmdParams) tea.Cmd {
 			commits:          result.Commits,
 			contextHeader:    result.ContextHeader,
 			enclosingContext: enclosingContext,
+			chunks:           chunks,
+			ref:              result.Ref,
+			baseRef:          result.BaseRef,
+			contextBudget:    params.contextBudget,
+			enrich:           enrich,
+			budgetErr:        budgetErr,
 			err:              err,
 		}
 	}
@@ -144,10 +194,11 @@ type commitDataLoadedMsg struct {
 	instructions string
 	diff         string
 	commitAll    bool
+	budgetErr    error
 	err          error
 }
 
-func loadCommitDataCmd(fallbackInstructions string, commitAll bool) tea.Cmd {
+func loadCommitDataCmd(fallbackInstructions string, commitAll bool, budget budgetCheck) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
 		defer cancel()
@@ -158,10 +209,17 @@ func loadCommitDataCmd(fallbackInstructions string, commitAll bool) tea.Cmd {
 		}
 
 		diff, err := git.GetWorkingTreeDiff(ctx, commitAll)
+
+		var budgetErr error
+		if err == nil && diff != "" {
+			budgetErr = budget(llm.EstimateTokens(instr) + llm.EstimateTokens(diff))
+		}
+
 		return commitDataLoadedMsg{
 			instructions: instr,
 			diff:         diff,
 			commitAll:    commitAll,
+			budgetErr:    budgetErr,
 			err:          err,
 		}
 	}
@@ -170,6 +228,7 @@ func loadCommitDataCmd(fallbackInstructions string, commitAll bool) tea.Cmd {
 type prDataLoadedMsg struct {
 	instructions string
 	content      string
+	budgetErr    error
 	err          error
 }
 
@@ -178,6 +237,7 @@ type prDataCmdParams struct {
 	prNumber             string
 	branch               string
 	maxLines             uint32
+	budget               budgetCheck
 }
 
 func loadPRDataCmd(params prDataCmdParams) tea.Cmd {
@@ -204,6 +264,98 @@ func loadPRDataCmd(params prDataCmdParams) tea.Cmd {
 			content = git.FormatBranchInfo(branchInfo)
 		}
 
-		return prDataLoadedMsg{instructions: instr, content: content}
+		return prDataLoadedMsg{
+			instructions: instr,
+			content:      content,
+			budgetErr:    params.budget(llm.EstimateTokens(instr) + llm.EstimateTokens(content)),
+		}
+	}
+}
+
+type reviewSavedMsg struct {
+	entry history.Entry
+	err   error
+}
+
+type reviewHistoryParams struct {
+	storage  string
+	reviewer string
+	model    string
+	content  string
+	diff     string
+}
+
+// saveReviewCmd records a finished review in history, fingerprinting the
+// files it touches so its suggestions can be applied safely later. A
+// review without suggestions is returned without being stored.
+func saveReviewCmd(params reviewHistoryParams) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+		defer cancel()
+
+		root, err := git.RepoRoot(ctx)
+		if err != nil {
+			return reviewSavedMsg{err: err}
+		}
+
+		entry := history.NewEntry(root, params.reviewer, params.model, params.content, nil, params.diff)
+		if len(entry.Suggestions()) == 0 {
+			return reviewSavedMsg{entry: entry}
+		}
+		err = history.New(params.storage).Add(&entry)
+		return reviewSavedMsg{entry: entry, err: err}
+	}
+}
+
+type suggestionPreviewMsg struct {
+	suggestion int
+	file       string
+	diff       string
+	err        error
+}
+
+func previewSuggestionCmd(entry history.Entry, n int) tea.Cmd {
+	return func() tea.Msg {
+		file, result, err := entry.Preview(n)
+		if err != nil {
+			return suggestionPreviewMsg{suggestion: n, err: err}
+		}
+		return suggestionPreviewMsg{suggestion: n, file: file, diff: result.Diff(file)}
+	}
+}
+
+type suggestionAppliedMsg struct {
+	entry   history.Entry
+	message string
+	err     error
+}
+
+// applySuggestionCmd applies suggestion n, or undoes the last applied
+// suggestion when n is zero, and saves the updated entry.
+func applySuggestionCmd(storage string, entry history.Entry, n int) tea.Cmd {
+	return func() tea.Msg {
+		// Work on a copy so the model's entry only changes via the message.
+		entry.Files = maps.Clone(entry.Files)
+		entry.Applied = slices.Clone(entry.Applied)
+
+		var message string
+		if n == 0 {
+			applied, err := entry.Undo()
+			if err != nil {
+				return suggestionAppliedMsg{err: err}
+			}
+			message = fmt.Sprintf("Reverted suggestion %d in %s", applied.Suggestion, applied.File)
+		} else {
+			if _, err := entry.Apply(n); err != nil {
+				return suggestionAppliedMsg{err: err}
+			}
+			message = fmt.Sprintf("Applied suggestion %d to %s", n, entry.Applied[len(entry.Applied)-1].File)
+		}
+
+		if err := history.New(storage).Save(&entry); err != nil {
+			return suggestionAppliedMsg{err: err}
+		}
+
+		return suggestionAppliedMsg{entry: entry, message: message}
 	}
 }
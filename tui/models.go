@@ -0,0 +1,183 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"charm.land/bubbles/v2/list"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/bark/v2/internal/llm/llm_factory"
+	"github.com/ionut-t/bark/v2/internal/utils"
+	"github.com/ionut-t/coffee/styles"
+)
+
+const (
+	modelsTimeout     = 30 * time.Second
+	modelPickerWidth  = 72
+	modelPickerHeight = 16
+)
+
+type modelsLoadedMsg struct {
+	models []llm.Model
+	err    error
+}
+
+type modelSelectedMsg struct {
+	model string
+}
+
+type cancelModelSelectionMsg struct{}
+
+// modelSwitchedMsg carries the client created for the selected model.
+type modelSwitchedMsg struct {
+	client taskClient
+}
+
+// loadModelsCmd lists the models of the provider of profile for the picker.
+func loadModelsCmd(cfg config.Config, profile config.Profile) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+		defer cancel()
+
+		models, err := llm_factory.ListModels(ctx, cfg, profile.Provider, profile.BaseURL)
+		return modelsLoadedMsg{models: models, err: err}
+	}
+}
+
+// switchModelCmd creates the client of profile with model instead of its
+// own, which may run an api_key_command.
+func switchModelCmd(cfg config.Config, storage string, profile config.Profile, model string) tea.Cmd {
+	return func() tea.Msg {
+		profile.Model = model
+		return modelSwitchedMsg{client: newProfileClient(cfg, storage, repoRoot(), profile)}
+	}
+}
+
+// modelPickerModel lists the models of the current provider, to run the
+// current task again with one of them.
+type modelPickerModel struct {
+	provider string
+	current  string
+	loading  bool
+	// switching is the model selected, while its client is created.
+	switching string
+	err       error
+	list      list.Model
+	styles    styles.Styles
+	isDark    bool
+}
+
+func newModelPickerModel(provider, current string, s styles.Styles, isDark bool) modelPickerModel {
+	return modelPickerModel{
+		provider: provider,
+		current:  current,
+		loading:  true,
+		styles:   s,
+		isDark:   isDark,
+	}
+}
+
+// setModels shows the models listed, with the current one selected.
+func (m *modelPickerModel) setModels(models []llm.Model, err error) {
+	m.loading = false
+	m.err = err
+	if err != nil {
+		return
+	}
+
+	items := make([]list.Item, 0, len(models))
+	selected := 0
+	for i, model := range models {
+		if model.ID == m.current {
+			selected = i
+		}
+		items = append(items, item{title: modelLabel(model, model.ID == m.current), key: model.ID})
+	}
+
+	m.list = newListModel(fmt.Sprintf("Switch model (%s)", m.provider), items, m.styles, m.isDark)
+	m.list.SetSize(modelPickerWidth, modelPickerHeight)
+	m.list.SetShowHelp(false)
+	m.list.Select(selected)
+}
+
+// modelLabel describes model on one line: its ID, context window and
+// capabilities.
+func modelLabel(model llm.Model, current bool) string {
+	var details []string
+	if model.ContextWindow > 0 {
+		details = append(details, formatTokens(model.ContextWindow)+" ctx")
+	}
+	if len(model.Capabilities) > 0 {
+		details = append(details, strings.Join(model.Capabilities, ", "))
+	}
+	if current {
+		details = append(details, "current")
+	}
+
+	if len(details) == 0 {
+		return model.ID
+	}
+	return model.ID + "  " + strings.Join(details, " · ")
+}
+
+// busy reports whether the picker has no list to choose from.
+func (m modelPickerModel) busy() bool {
+	return m.loading || m.switching != "" || m.err != nil || len(m.list.Items()) == 0
+}
+
+func (m modelPickerModel) Update(msg tea.Msg) (modelPickerModel, tea.Cmd) {
+	if m.busy() {
+		if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "esc" {
+			return m, utils.DispatchMsg(cancelModelSelectionMsg{})
+		}
+		return m, nil
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok && m.list.FilterState() != list.Filtering {
+		switch msg.String() {
+		case "esc":
+			return m, utils.DispatchMsg(cancelModelSelectionMsg{})
+		case "enter":
+			if item, ok := m.list.SelectedItem().(item); ok {
+				return m, utils.DispatchMsg(modelSelectedMsg{model: item.key})
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m modelPickerModel) View() string {
+	var content string
+	switch {
+	case m.loading:
+		content = m.styles.Text.Render(fmt.Sprintf("Loading the %s models…", m.provider))
+	case m.switching != "":
+		content = m.styles.Text.Render(fmt.Sprintf("Switching to %s…", m.switching))
+	case m.err != nil:
+		content = m.styles.Error.Width(modelPickerWidth).Render(m.err.Error())
+	case len(m.list.Items()) == 0:
+		content = m.styles.Text.Render(fmt.Sprintf("%s lists no models.", m.provider))
+	default:
+		content = m.list.View()
+	}
+
+	help := "enter to run the task again with the model • / to filter • esc to close"
+	if m.busy() {
+		help = "esc to close"
+	}
+	content += "\n\n" + m.styles.Subtext0.Render(help)
+
+	return lipgloss.NewStyle().
+		Padding(1, 2).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.styles.Primary.GetForeground()).
+		Render(content)
+}
@@ -8,6 +8,7 @@ import (
 	"charm.land/bubbles/v2/spinner"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/ledger"
 	"github.com/ionut-t/bark/v2/internal/llm"
 	"github.com/ionut-t/coffee/styles"
 	editor "github.com/ionut-t/goeditor"
@@ -125,6 +126,7 @@ type prResponseMsg struct {
 	usage    llm.Usage
 	hasUsage bool
 	duration time.Duration
+	served   servedBy
 }
 
 type prModel struct {
@@ -134,6 +136,7 @@ type prModel struct {
 	loadingMsgPicker *loadingMessagePicker
 	spinner          spinner.Model
 	llm              llm.LLM
+	opts             llm.Options
 	error            error
 	system           string
 	prompt           string
@@ -142,7 +145,7 @@ type prModel struct {
 	styles           styles.Styles
 }
 
-func newPRModel(llm llm.LLM, width, height int) prModel {
+func newPRModel(llm llm.LLM, opts llm.Options, width, height int) prModel {
 	textEditor := editor.New(width, height)
 	textEditor.SetExtraHighlightedContextLines(300)
 	textEditor.Focus()
@@ -155,6 +158,7 @@ func newPRModel(llm llm.LLM, width, height int) prModel {
 		spinner:          sp,
 		loading:          true,
 		llm:              llm,
+		opts:             opts,
 		loadingMsgPicker: newLoadingMessagePicker(prLoadingMessages[:]),
 	}
 
@@ -189,13 +193,14 @@ func (m prModel) Init() tea.Cmd {
 }
 
 func (m *prModel) startPRDescriptionGeneration(ctx context.Context) tea.Cmd {
+	ctx = ledger.WithTask(ctx, ledger.TaskPR)
 	m.error = nil
 	m.loading = true
 
 	return tea.Batch(
 		m.spinner.Tick,
 		m.dispatchLoadingMsg(),
-		getPRMessage(ctx, m.llm, m.system, m.prompt),
+		getPRMessage(ctx, m.llm, m.opts, m.system, m.prompt),
 	)
 }
 
@@ -285,14 +290,15 @@ func (m prModel) View() string {
 	return m.editor.View()
 }
 
-func getPRMessage(ctx context.Context, llm llm.LLM, system, prompt string) tea.Cmd {
+func getPRMessage(ctx context.Context, llm llm.LLM, opts llm.Options, system, prompt string) tea.Cmd {
 	return func() tea.Msg {
 		start := time.Now()
-		resp, err := llm.Generate(ctx, system, prompt)
+		resp, err := llm.Generate(ctx, system, prompt, opts)
 		msg := prResponseMsg{
 			message:  resp.Content,
 			error:    err,
 			duration: time.Since(start),
+			served:   servedByOf(resp),
 		}
 		if resp.Usage != nil {
 			msg.usage = *resp.Usage
@@ -309,3 +315,9 @@ func (m *prModel) dispatchLoadingMsg() tea.Cmd {
 func (m *prModel) getLoadingMessage() string {
 	return m.loadingMsgPicker.next()
 }
+
+// setClient makes the next generation use another model's client.
+func (m *prModel) setClient(l llm.LLM, model string) {
+	m.llm = l
+	m.displayUsedModel(model)
+}
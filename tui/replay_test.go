@@ -0,0 +1,209 @@
+package tui
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/ionut-t/bark/v2/internal/config"
+	"github.com/ionut-t/bark/v2/internal/ledger"
+	"github.com/ionut-t/bark/v2/internal/reviewers"
+	"github.com/ionut-t/coffee/styles"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures were recorded from a real provider with --record, for the
+// diff in mean.diff.
+const fixtures = "../testdata/replay"
+
+// replayClient returns the client of task created from a config that plays
+// back fixture, and the storage its usage is recorded under.
+func replayClient(t *testing.T, task, fixture string) (taskClient, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(viper.Reset)
+
+	viper.Set(config.LLMProviderKey, "replay")
+	viper.Set(config.LLMModelKey, "claude-sonnet-4-5")
+	viper.Set(config.ReplayKey+".file", filepath.Join(fixtures, fixture))
+	viper.Set(config.ReplayKey+".instant", true)
+
+	storage := t.TempDir()
+	client := newTaskClient(config.New(), storage, task)
+	require.NoError(t, client.err)
+	return client, storage
+}
+
+func readDiff(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(fixtures, "mean.diff"))
+	require.NoError(t, err)
+	return string(data)
+}
+
+// started runs the commands a start command batches, concurrently since
+// the spinner and loading messages wait, and returns the message of type T.
+func started[T tea.Msg](t *testing.T, cmd tea.Cmd) tea.Msg {
+	t.Helper()
+	batch, ok := cmd().(tea.BatchMsg)
+	require.True(t, ok)
+
+	msgs := make(chan tea.Msg, len(batch))
+	for _, cmd := range batch {
+		if cmd != nil {
+			go func() { msgs <- cmd() }()
+		}
+	}
+	for msg := range msgs {
+		if _, ok := msg.(T); ok {
+			return msg
+		}
+	}
+	return nil
+}
+
+// runReview streams a review into m the way the program does, until the
+// stream completes or fails.
+func runReview(t *testing.T, m reviewModel) reviewModel {
+	t.Helper()
+	msg := started[streamReadyMsg](t, m.startReview(context.Background()))
+	for range 1000 {
+		m, _ = m.Update(msg)
+		switch msg.(type) {
+		case streamCompleteMsg, streamErrorMsg:
+			return m
+		}
+		msg = watchStreamCmd(m.respChan, m.errChan)()
+	}
+
+	t.Fatal("the review never completed")
+	return m
+}
+
+func TestReplay_ReviewStreams(t *testing.T) {
+	client, storage := replayClient(t, config.TaskReview, "review.jsonl")
+	m := newReviewModel(reviewers.Reviewer{Name: "Rob Pike"}, "system", readDiff(t), 100, 40, client.llm, client.opts)
+	m.setUsedModel(client.profile.Model)
+
+	m = runReview(t, m)
+	require.NoError(t, m.error)
+	assert.False(t, m.loading)
+	assert.Contains(t, m.response, "### high correctness: stats.go:13")
+
+	entries, err := ledger.New(storage).Entries(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ledger.TaskReview, entries[0].Task)
+	assert.Equal(t, "replay", entries[0].Provider)
+}
+
+func TestReplay_ReviewShowsErrors(t *testing.T) {
+	client, _ := replayClient(t, config.TaskReview, "unauthorized.jsonl")
+	m := newReviewModel(reviewers.Reviewer{Name: "Rob Pike"}, "system", readDiff(t), 100, 40, client.llm, client.opts)
+
+	m = runReview(t, m)
+	require.Error(t, m.error)
+	assert.Contains(t, m.View(), "invalid x-api-key")
+	assert.Contains(t, m.View(), "Press r to retry")
+}
+
+func TestReplay_CommitMessage(t *testing.T) {
+	client, _ := replayClient(t, config.TaskCommit, "commit.jsonl")
+	m := newCommitChangesModel(client.llm, client.opts, "system", readDiff(t), false, 100, 40)
+
+	m, _ = m.Update(started[commitResponseMsg](t, m.startCommitGeneration(context.Background())))
+	require.NoError(t, m.error)
+	assert.False(t, m.loading)
+	assert.Contains(t, m.response, "feat(stats): add Mean function")
+	assert.NotContains(t, m.response, "```")
+}
+
+func TestReplay_PRDescription(t *testing.T) {
+	client, _ := replayClient(t, config.TaskPR, "pr.jsonl")
+	m := newPRModel(client.llm, client.opts, 100, 40)
+	m.setContent("system", readDiff(t))
+
+	m, _ = m.Update(started[prResponseMsg](t, m.startPRDescriptionGeneration(context.Background())))
+	require.NoError(t, m.error)
+	assert.Contains(t, m.response, "# Add Mean function to stats package")
+}
+
+func TestReplay_ModelPickerRerunsReview(t *testing.T) {
+	_, storage := replayClient(t, config.TaskReview, "review.jsonl")
+
+	// The fixture answers the review twice, recorded from two models.
+	data, err := os.ReadFile(filepath.Join(fixtures, "review.jsonl"))
+	require.NoError(t, err)
+	other := strings.ReplaceAll(string(data), `"model":"claude-sonnet-4-5"`, `"model":"claude-haiku-4-5"`)
+	fixture := filepath.Join(t.TempDir(), "review.jsonl")
+	require.NoError(t, os.WriteFile(fixture, []byte(string(data)+other), 0o644))
+	viper.Set(config.ReplayKey+".file", fixture)
+
+	cfg := config.New()
+	client := newTaskClient(cfg, storage, config.TaskReview)
+	require.NoError(t, client.err)
+
+	m := newReviewModel(reviewers.Reviewer{Name: "Rob Pike"}, "system", readDiff(t), 100, 40, client.llm, client.opts)
+	m.setUsedModel(client.profile.Model)
+	m = runReview(t, m)
+	require.NoError(t, m.error)
+
+	picker := newModelPickerModel(client.profile.Provider, client.profile.Model, styles.New(true), true)
+	assert.Contains(t, picker.View(), "Loading the replay models")
+
+	loaded, ok := loadModelsCmd(cfg, client.profile)().(modelsLoadedMsg)
+	require.True(t, ok)
+	require.NoError(t, loaded.err)
+	picker.setModels(loaded.models, loaded.err)
+	require.Len(t, picker.list.Items(), 2)
+	assert.Equal(t, "claude-sonnet-4-5", picker.list.SelectedItem().(item).key, "the current model is selected")
+
+	picker, _ = picker.Update(tea.KeyPressMsg{Code: tea.KeyDown})
+	_, cmd := picker.Update(tea.KeyPressMsg{Code: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	selected, ok := cmd().(modelSelectedMsg)
+	require.True(t, ok)
+	assert.Equal(t, "claude-haiku-4-5", selected.model)
+
+	switched, ok := switchModelCmd(cfg, storage, client.profile, selected.model)().(modelSwitchedMsg)
+	require.True(t, ok)
+	require.NoError(t, switched.client.err)
+	assert.Equal(t, "claude-haiku-4-5", switched.client.profile.Model)
+
+	m.setClient(switched.client.llm, switched.client.profile.Model)
+	m = runReview(t, m)
+	require.NoError(t, m.error)
+	assert.Equal(t, "claude-haiku-4-5", m.modelLabel())
+	assert.Contains(t, m.response, "### high correctness: stats.go:13")
+}
+
+func TestHandleSelectedTask_CreatesClientWhenTaskRuns(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(viper.Reset)
+	viper.Set(config.LLMProviderKey, "replay")
+	viper.Set(config.LLMModelKey, "claude-sonnet-4-5")
+	viper.Set(config.ReplayKey+".file", filepath.Join(fixtures, "review.jsonl"))
+	// The commit profile is never used, so its error never shows.
+	viper.Set(config.CommitProfileKey, "missing")
+
+	m := &Model{clients: make(map[string]taskClient), config: config.New(), storage: t.TempDir()}
+
+	_, cmd := m.handleSelectedTask(TaskReview)
+	require.NotNil(t, cmd)
+	assert.Empty(t, m.clients, "no client before the task runs")
+
+	created, ok := cmd().(taskClientCreatedMsg)
+	require.True(t, ok)
+	updated, _ := m.Update(created)
+	m = updated.(*Model)
+	require.NoError(t, m.error)
+	assert.Equal(t, "replay", m.client.profile.Provider)
+	assert.Equal(t, viewReviewOptions, m.currentView)
+	assert.NotContains(t, m.clients, config.TaskCommit)
+}
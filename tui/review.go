@@ -1,8 +1,10 @@
 package tui
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"slices"
 	"strings"
 	"time"
@@ -10,6 +12,10 @@ import (
 	"charm.land/bubbles/v2/spinner"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/chunk"
+	"github.com/ionut-t/bark/v2/internal/findings"
+	"github.com/ionut-t/bark/v2/internal/history"
+	"github.com/ionut-t/bark/v2/internal/ledger"
 	"github.com/ionut-t/bark/v2/internal/llm"
 	"github.com/ionut-t/bark/v2/internal/reviewers"
 	"github.com/ionut-t/bark/v2/internal/utils"
@@ -109,15 +115,30 @@ type streamErrorMsg struct {
 	error error
 }
 
+// servedBy names the provider and model that served a request, when a
+// fallback chain reports them.
+type servedBy struct {
+	provider, model string
+}
+
+func servedByOf(resp llm.Response) servedBy {
+	return servedBy{provider: resp.Provider, model: resp.Model}
+}
+
 type streamChunkMsg struct {
 	content  string
+	thinking string
 	usage    llm.Usage
 	hasUsage bool
+	served   servedBy
+	// retrying is set when the stream failed and is about to be retried.
+	retrying *llm.Retrying
 }
 
 type streamCompleteMsg struct {
 	usage    llm.Usage
 	hasUsage bool
+	served   servedBy
 }
 
 type reviewLoadingMsg struct {
@@ -128,6 +149,7 @@ type reviewModel struct {
 	width, height    int
 	editor           editor.Model
 	llm              llm.LLM
+	opts             llm.Options
 	respChan         <-chan llm.Response
 	errChan          <-chan error
 	contentBuilder   *strings.Builder
@@ -136,6 +158,7 @@ type reviewModel struct {
 	prompt           string
 	showPrompt       bool
 	response         string
+	findings         []findings.Finding
 	spinner          spinner.Model
 	loading          bool
 	loadingChunks    bool
@@ -144,9 +167,33 @@ type reviewModel struct {
 	error            error
 	styles           styles.Styles
 	llmModel         string
+	// served is set when a fallback provider streams the review.
+	served servedBy
+	// retrying is the retry the stream is waiting on, if any.
+	retrying *llm.Retrying
+
+	// storage and diff are used to save the finished review to history;
+	// entry is set once it has been saved.
+	storage         string
+	diff            string
+	entry           *history.Entry
+	suggestions     suggestionsModel
+	showSuggestions bool
+	isDarkMode      bool
+
+	// chat is the follow-up conversation about the finished review.
+	chat     chatModel
+	showChat bool
+
+	thinking thinkingModel
+
+	// chunked is set when the diff is reviewed in chunks before a merge pass.
+	chunked      *chunkedReview
+	progressChan <-chan chunk.Progress
+	doneChan     <-chan chunksReviewedMsg
 }
 
-func newReviewModel(reviewer reviewers.Reviewer, system, prompt string, width, height int, llm llm.LLM) reviewModel {
+func newReviewModel(reviewer reviewers.Reviewer, system, prompt string, width, height int, llm llm.LLM, opts llm.Options) reviewModel {
 	textEditor := editor.New(width, height)
 	textEditor.DisableInsertMode(true)
 	textEditor.SetExtraHighlightedContextLines(streamingHighlightContextLines)
@@ -160,12 +207,14 @@ func newReviewModel(reviewer reviewers.Reviewer, system, prompt string, width, h
 		height:           height,
 		editor:           textEditor,
 		llm:              llm,
+		opts:             opts,
 		system:           system,
 		prompt:           prompt,
 		loading:          true,
 		loadingChunks:    true,
 		spinner:          sp,
 		reviewer:         reviewer,
+		thinking:         newThinkingModel(),
 		loadingMsgPicker: newLoadingMessagePicker(loadingMessages[:]),
 	}
 
@@ -178,23 +227,66 @@ func (m *reviewModel) setUsedModel(model string) {
 	m.llmModel = model
 }
 
+// modelLabel is the model shown in the status line: the configured one, or
+// the one that served the review when a fallback took over.
+func (m reviewModel) modelLabel() string {
+	if m.served.model == "" || m.served.model == m.llmModel {
+		return m.llmModel
+	}
+	return fmt.Sprintf("%s (%s fallback)", m.served.model, m.served.provider)
+}
+
+// setChunked makes the review run as a map-reduce over the given chunks.
+func (m *reviewModel) setChunked(c chunkedReview) {
+	c.reset()
+	m.chunked = &c
+}
+
+// setHistory enables saving the finished review to history under storage.
+func (m *reviewModel) setHistory(storage, diff string) {
+	m.storage = storage
+	m.diff = diff
+}
+
 func (m *reviewModel) showRelativeLineNumbers(enabled bool) {
 	m.editor.ShowRelativeLineNumbers(enabled)
 }
 
 func (m *reviewModel) setStyles(s styles.Styles, isDarkMode bool) {
 	m.styles = s
+	m.isDarkMode = isDarkMode
 
 	m.editor.WithTheme(styles.EditorTheme(s))
 	m.editor.SetLanguage("markdown", styles.EditorLanguageTheme(isDarkMode))
 	m.spinner.Style = s.Primary
+	m.thinking.styles = s
+	m.thinking.render()
 }
 
 func (m *reviewModel) setSize(width, height int) {
 	m.width = width
 	m.height = height
 
-	m.editor.SetSize(width, height)
+	// The suggestions list is only created when it is shown.
+	if m.showSuggestions {
+		m.suggestions.setSize(width, height)
+	}
+
+	// The thinking pane sits above the review and is hidden with the prompt.
+	if !m.showPrompt {
+		thinkingHeight := m.thinking.paneHeight(height)
+		m.thinking.setSize(width, thinkingHeight)
+		height -= thinkingHeight
+	}
+
+	if !m.showChat {
+		m.editor.SetSize(width, max(height, 1))
+		return
+	}
+
+	chatHeight := max(height*2/5, 8)
+	m.editor.SetSize(width, max(height-chatHeight, 1))
+	m.chat.setSize(width, chatHeight)
 }
 
 func (m reviewModel) Init() tea.Cmd {
@@ -204,11 +296,17 @@ func (m reviewModel) Init() tea.Cmd {
 func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 	switch msg := msg.(type) {
 	case spinner.TickMsg:
-		var cmd tea.Cmd
+		var cmd, chatCmd tea.Cmd
 		m.spinner, cmd = m.spinner.Update(msg)
 		if m.loadingChunks && !m.loading {
 			m.setStreamingStatusLine()
 		}
+		m.chat, chatCmd = m.chat.Update(msg)
+		return m, tea.Batch(cmd, chatCmd)
+
+	case chatReadyMsg, chatChunkMsg, chatCompleteMsg, chatErrorMsg:
+		var cmd tea.Cmd
+		m.chat, cmd = m.chat.Update(msg)
 		return m, cmd
 
 	case reviewLoadingMsg:
@@ -224,13 +322,71 @@ func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 		m.errChan = msg.errChan
 		return m, watchStreamCmd(m.respChan, m.errChan)
 
+	case chunkReviewReadyMsg:
+		m.progressChan = msg.progressChan
+		m.doneChan = msg.doneChan
+		return m, watchChunkReviewCmd(m.progressChan, m.doneChan)
+
+	case chunkProgressMsg:
+		if m.chunked != nil {
+			m.chunked.status[msg.Index] = msg.Status
+			m.chunked.retrying[msg.Index] = msg.Retrying
+		}
+		return m, watchChunkReviewCmd(m.progressChan, m.doneChan)
+
+	case chunksReviewedMsg:
+		if msg.err != nil {
+			m.loading = false
+			m.loadingChunks = false
+			m.error = msg.err
+			return m, nil
+		}
+
+		// The merged review is what the reviewer "saw", so the prompt
+		// preview and follow-up chat use the merge request.
+		m.chunked.merging = true
+		m.system = m.chunked.mergeSystem
+		m.prompt = msg.mergePrompt
+		return m.Update(msg.merge)
+
 	case streamChunkMsg:
+		if msg.retrying != nil {
+			m.retrying = msg.retrying
+			// The reply starts over and streams its thinking again.
+			if msg.retrying.Restart {
+				msg.thinking = ""
+				if m.thinking.visible() {
+					m.thinking.reset()
+					m.setSize(m.width, m.height)
+				}
+			}
+		} else if msg.content != "" || msg.thinking != "" {
+			m.retrying = nil
+		}
+		if msg.served.model != "" {
+			m.served = msg.served
+		}
+
+		// Stay on the loading view while a request that hasn't produced
+		// anything yet is retried.
+		if m.loading && msg.retrying != nil && msg.content == "" && msg.thinking == "" {
+			return m, watchStreamCmd(m.respChan, m.errChan)
+		}
+
 		if m.loading {
 			m.spinner.Spinner = spinner.Points
 		}
 
 		m.loading = false
 
+		if msg.thinking != "" {
+			visible := m.thinking.visible()
+			m.thinking.append(msg.thinking)
+			if !visible {
+				m.setSize(m.width, m.height)
+			}
+		}
+
 		m.contentBuilder.WriteString(msg.content)
 		content := utils.NormaliseCodeFences(m.contentBuilder.String())
 
@@ -250,18 +406,41 @@ func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 	case streamErrorMsg:
 		m.loading = false
 		m.loadingChunks = false
+		m.retrying = nil
 		m.error = msg.error
 		return m, nil
 
 	case streamCompleteMsg:
 		m.loadingChunks = false
+		m.retrying = nil
 		m.editor.SetExtraHighlightedContextLines(finalHighlightContextLines)
 		m.response = m.editor.GetCurrentContent() + "\n\n"
 		m.editor.SetContent(m.response)
+		m.findings = findings.ParseMarkdown(m.response).Findings
 		reviewerInfo := m.styles.Accent.Render("Reviewed by " + m.reviewer.Name + " ")
-		m.editor.StatusLineFunc = createEditorStatusLine(m.llmModel, reviewerInfo)
+		m.editor.StatusLineFunc = createEditorStatusLine(m.modelLabel(), findingsSummary(m.findings), reviewerInfo)
 		_ = m.editor.SetCursorPosition(0, 0)
 
+		var cmd tea.Cmd
+		m.editor, cmd = m.editor.Update(msg)
+		return m, tea.Batch(cmd, m.saveHistory())
+
+	case reviewSavedMsg:
+		if msg.err != nil {
+			return m, m.editor.DispatchError(fmt.Errorf("review not saved to history: %w", msg.err), 3*time.Second)
+		}
+		m.entry = &msg.entry
+		return m, nil
+
+	case closeSuggestionsMsg:
+		m.showSuggestions = false
+		return m, nil
+
+	case suggestionPreviewMsg, suggestionAppliedMsg:
+		var cmd tea.Cmd
+		m.suggestions, cmd = m.suggestions.Update(msg)
+		return m, cmd
+
 	case editor.QuitMsg:
 		return m, tea.Quit
 
@@ -277,7 +456,75 @@ func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 		return m, m.editor.DispatchError(msg, 2*time.Second)
 
 	case tea.KeyMsg:
+		if m.showSuggestions {
+			var cmd tea.Cmd
+			m.suggestions, cmd = m.suggestions.Update(msg)
+			return m, cmd
+		}
+
+		if m.showChat && m.chat.focused() {
+			var cmd tea.Cmd
+			m.chat, cmd = m.chat.Update(msg)
+			return m, cmd
+		}
+
 		switch msg.String() {
+		case "a":
+			if m.loadingChunks || m.response == "" || m.showPrompt || m.editor.IsSearchMode() {
+				break
+			}
+
+			if m.chat.llm == nil {
+				m.chat = newChatModel(m.llm, m.opts, m.system, m.prompt, m.response, m.reviewer.Name, m.styles)
+			}
+			m.showChat = true
+			m.setSize(m.width, m.height)
+			return m, m.chat.focus()
+
+		case "x":
+			if !m.showChat || m.editor.IsSearchMode() {
+				break
+			}
+
+			m.chat.stop()
+			m.showChat = false
+			m.setSize(m.width, m.height)
+			return m, nil
+
+		case "s":
+			if m.loadingChunks || m.showPrompt || m.editor.IsSearchMode() {
+				break
+			}
+
+			if m.entry == nil {
+				return m, m.editor.DispatchError(errors.New("review is not saved to history yet"), 2*time.Second)
+			}
+
+			m.suggestions = newSuggestionsModel(m.storage, *m.entry, m.styles, m.isDarkMode, m.width, m.height)
+			m.showSuggestions = true
+			return m, m.suggestions.Init()
+
+		case "t":
+			if !m.thinking.visible() || m.showPrompt || m.editor.IsSearchMode() {
+				break
+			}
+
+			m.thinking.toggle()
+			m.setSize(m.width, m.height)
+			return m, nil
+
+		case "[", "]":
+			if !m.thinking.expanded || !m.thinking.visible() || m.showPrompt || m.editor.IsSearchMode() {
+				break
+			}
+
+			if msg.String() == "]" {
+				m.thinking.scrollDown()
+			} else {
+				m.thinking.scrollUp()
+			}
+			return m, nil
+
 		case "tab":
 			if m.loadingChunks {
 				return m, nil
@@ -289,6 +536,7 @@ func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 			} else {
 				m.editor.SetContent(m.response)
 			}
+			m.setSize(m.width, m.height)
 		}
 	}
 
@@ -298,10 +546,24 @@ func (m reviewModel) Update(msg tea.Msg) (reviewModel, tea.Cmd) {
 	return m, cmd
 }
 
-func startStreamCmd(llm llm.LLM, ctx context.Context, system, prompt string) tea.Cmd {
+func (m *reviewModel) saveHistory() tea.Cmd {
+	if m.storage == "" {
+		return nil
+	}
+
+	return saveReviewCmd(reviewHistoryParams{
+		storage:  m.storage,
+		reviewer: m.reviewer.Name,
+		model:    cmp.Or(m.served.model, m.llmModel),
+		content:  m.response,
+		diff:     m.diff,
+	})
+}
+
+func startStreamCmd(llm llm.LLM, opts llm.Options, ctx context.Context, system, prompt string) tea.Cmd {
 	return func() tea.Msg {
 		startedAt := time.Now()
-		respChan, errChan := llm.Stream(ctx, system, prompt)
+		respChan, errChan := llm.Stream(ctx, system, prompt, opts)
 		return streamReadyMsg{
 			respChan:  respChan,
 			errChan:   errChan,
@@ -312,10 +574,11 @@ func startStreamCmd(llm llm.LLM, ctx context.Context, system, prompt string) tea
 
 func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd {
 	return func() tea.Msg {
-		var buf strings.Builder
+		var buf, thinking strings.Builder
 		var deadline <-chan time.Time
 		var usage llm.Usage
 		var hasUsage bool
+		var served servedBy
 
 		for {
 			select {
@@ -324,8 +587,8 @@ func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd
 					// Flush any buffered content first; the streamChunkMsg
 					// handler re-arms the watch, which then sees the closed
 					// channel and completes.
-					if buf.Len() > 0 {
-						return streamChunkMsg{content: buf.String(), usage: usage, hasUsage: hasUsage}
+					if buf.Len() > 0 || thinking.Len() > 0 {
+						return streamChunkMsg{content: buf.String(), thinking: thinking.String(), usage: usage, hasUsage: hasUsage, served: served}
 					}
 					// Providers buffer a late error before closing both
 					// channels, and select picks randomly between ready
@@ -338,7 +601,7 @@ func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd
 						}
 					default:
 					}
-					return streamCompleteMsg{usage: usage, hasUsage: hasUsage}
+					return streamCompleteMsg{usage: usage, hasUsage: hasUsage, served: served}
 				}
 
 				if resp.Usage != nil {
@@ -347,13 +610,22 @@ func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd
 					usage = *resp.Usage
 					hasUsage = true
 				}
+				if resp.Model != "" {
+					served = servedByOf(resp)
+				}
+				if resp.Retrying != nil {
+					// Report the retry at once, with whatever was
+					// buffered before the stream broke.
+					return streamChunkMsg{content: buf.String(), thinking: thinking.String(), usage: usage, hasUsage: hasUsage, served: served, retrying: resp.Retrying}
+				}
 				buf.WriteString(resp.Content)
+				thinking.WriteString(resp.Thinking)
 				if deadline == nil {
 					deadline = time.After(streamCoalesceWindow)
 				}
 
 			case <-deadline:
-				return streamChunkMsg{content: buf.String(), usage: usage, hasUsage: hasUsage}
+				return streamChunkMsg{content: buf.String(), thinking: thinking.String(), usage: usage, hasUsage: hasUsage, served: served}
 
 			case err, ok := <-errChan:
 				if !ok {
@@ -366,7 +638,7 @@ func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd
 				if err != nil {
 					// Context cancellation is not an error to surface
 					if errors.Is(err, context.Canceled) {
-						return streamCompleteMsg{usage: usage, hasUsage: hasUsage}
+						return streamCompleteMsg{usage: usage, hasUsage: hasUsage, served: served}
 					}
 					return streamErrorMsg{error: err}
 				}
@@ -377,7 +649,15 @@ func watchStreamCmd(respChan <-chan llm.Response, errChan <-chan error) tea.Cmd
 
 func (m reviewModel) View() string {
 	if m.loading {
-		return lipgloss.NewStyle().Padding(2).Render(m.spinner.View() + " " + m.styles.Accent.Render(m.loadingMsg))
+		message := m.loadingMsg
+		if m.retrying != nil {
+			message = m.reviewer.Name + " is " + m.retrying.String()
+		}
+		loading := m.spinner.View() + " " + m.styles.Accent.Render(message)
+		if m.chunked != nil {
+			loading += "\n\n" + m.chunkProgressView()
+		}
+		return lipgloss.NewStyle().Padding(2).Render(loading)
 	}
 
 	if m.error != nil {
@@ -393,21 +673,50 @@ func (m reviewModel) View() string {
 		)
 	}
 
-	return m.editor.View()
+	if m.showSuggestions {
+		return m.suggestions.View()
+	}
+
+	views := []string{m.editor.View()}
+	if m.thinking.visible() && !m.showPrompt {
+		views = append([]string{m.thinking.View()}, views...)
+	}
+	if m.showChat {
+		views = append(views, m.chat.View())
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, views...)
 }
 
 func (m *reviewModel) startReview(ctx context.Context) tea.Cmd {
+	ctx = ledger.WithTask(ctx, ledger.TaskReview)
 	m.contentBuilder = &strings.Builder{}
+	m.chat.stop()
+	m.chat = chatModel{}
+	m.showChat = false
+	m.thinking.reset()
+	m.setSize(m.width, m.height)
 	m.loading = true
 	m.error = nil
+	m.retrying = nil
+	m.served = servedBy{}
 	m.spinner.Spinner = spinner.Dot
 	m.editor.SetExtraHighlightedContextLines(streamingHighlightContextLines)
 	m.editor.SetContent("")
 
+	if m.chunked != nil {
+		m.chunked.reset()
+		return tea.Batch(
+			m.spinner.Tick,
+			m.dispatchLoadingMsg(),
+			startChunkReviewCmd(m.llm, m.opts, ctx, *m.chunked),
+		)
+	}
+
 	return tea.Batch(
 		m.spinner.Tick,
 		m.dispatchLoadingMsg(),
-		startStreamCmd(m.llm, ctx, m.system, m.prompt),
+		startStreamCmd(m.llm, m.opts, ctx, m.system, m.prompt),
 	)
 }
 
@@ -415,10 +724,41 @@ func (m *reviewModel) startReview(ctx context.Context) tea.Cmd {
 // status line; call it on every spinner tick while streaming so the spinner
 // animates between coalesced chunk flushes.
 func (m *reviewModel) setStreamingStatusLine() {
-	reviewerAction := m.styles.Accent.Background(m.styles.Surface1.GetBackground()).Render(m.reviewer.Name + " is reviewing... ")
+	action := "reviewing..."
+	if m.retrying != nil {
+		action = m.retrying.String()
+	}
+	reviewerAction := m.styles.Accent.Background(m.styles.Surface1.GetBackground()).Render(m.reviewer.Name + " is " + action + " ")
 	spacer := m.styles.Surface1.Render(" ")
 	reviewerInfo := m.styles.Surface1.Render(m.spinner.View()) + spacer + reviewerAction
-	m.editor.StatusLineFunc = createEditorStatusLine(m.llmModel, reviewerInfo)
+	m.editor.StatusLineFunc = createEditorStatusLine(m.modelLabel(), reviewerInfo)
+}
+
+// findingsSummary renders the finding count for the status line, e.g.
+// "4 findings (1 high, 2 medium)". Low and info findings are only counted.
+func findingsSummary(list []findings.Finding) string {
+	if len(list) == 0 {
+		return "no findings"
+	}
+
+	label := "findings"
+	if len(list) == 1 {
+		label = "finding"
+	}
+
+	counts := findings.Count(list)
+	var notable []string
+	for _, severity := range []findings.Severity{findings.SeverityHigh, findings.SeverityMedium} {
+		if counts[severity] > 0 {
+			notable = append(notable, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+	}
+
+	if len(notable) == 0 {
+		return fmt.Sprintf("%d %s", len(list), label)
+	}
+
+	return fmt.Sprintf("%d %s (%s)", len(list), label, strings.Join(notable, ", "))
 }
 
 func (m *reviewModel) dispatchLoadingMsg() tea.Cmd {
@@ -436,7 +776,13 @@ func reviewHelp(width int, forCommits bool, s styles.Styles) string {
 		{"tab", "toggle between review and prompt"},
 		{"c", "generate commit message (for staged changes)"},
 		{"C", "generate commit message for all changes (staged and unstaged)"},
+		{"s", "list, preview and apply suggested diffs"},
+		{"a", "ask follow-up questions about the review"},
+		{"x", "close the follow-up chat"},
+		{"t", "expand or collapse the model's thinking"},
+		{"[ ]", "scroll the model's thinking"},
 		{"ctrl+t", "show LLM usage stats"},
+		{"ctrl+o", "review again with another model"},
 		{"esc", "close help"},
 		{"ctrl+c", "quit"},
 	}
@@ -454,5 +800,13 @@ func reviewHelp(width int, forCommits bool, s styles.Styles) string {
 }
 
 func (m *reviewModel) canGenerateCommitMessage() bool {
-	return !m.editor.IsSearchMode()
+	return !m.editor.IsSearchMode() && !m.showSuggestions && !(m.showChat && m.chat.focused())
+}
+
+// setClient makes the review use another model's client the next time it
+// starts.
+func (m *reviewModel) setClient(l llm.LLM, model string) {
+	m.llm = l
+	m.llmModel = model
+	m.entry = nil
 }
@@ -62,6 +62,40 @@ func TestWatchStreamFlushesBufferOnClose(t *testing.T) {
 	require.Equal(t, streamCompleteMsg{}, watchStreamCmd(respChan, errChan)())
 }
 
+// Thinking deltas are batched apart from content, so the review never mixes
+// the two.
+func TestWatchStreamSeparatesThinking(t *testing.T) {
+	respChan := make(chan llm.Response, 3)
+	errChan := make(chan error, 1)
+
+	respChan <- llm.Response{Thinking: "check the ", Time: time.Now()}
+	respChan <- llm.Response{Thinking: "loop", Time: time.Now()}
+	respChan <- llm.Response{Content: "LGTM", Time: time.Now()}
+	close(respChan)
+	close(errChan)
+
+	msg := watchStreamCmd(respChan, errChan)()
+	require.IsType(t, streamChunkMsg{}, msg)
+	require.Equal(t, "check the loop", msg.(streamChunkMsg).thinking)
+	require.Equal(t, "LGTM", msg.(streamChunkMsg).content)
+}
+
+func TestWatchStreamReportsRetryAtOnce(t *testing.T) {
+	respChan := make(chan llm.Response, 3)
+	errChan := make(chan error, 1)
+
+	retrying := &llm.Retrying{Attempt: 2, MaxAttempts: 5, Delay: time.Second}
+	respChan <- llm.Response{Content: "The change", Time: time.Now()}
+	respChan <- llm.Response{Retrying: retrying, Time: time.Now()}
+	respChan <- llm.Response{Content: " looks good.", Time: time.Now()}
+
+	msg := watchStreamCmd(respChan, errChan)()
+	require.IsType(t, streamChunkMsg{}, msg)
+	require.Equal(t, "The change", msg.(streamChunkMsg).content)
+	require.Equal(t, retrying, msg.(streamChunkMsg).retrying)
+	require.Equal(t, "retrying (2/5)…", retrying.String())
+}
+
 // An error buffered just before the provider closes both channels must be
 // surfaced, not randomly swallowed as a normal completion.
 func TestWatchStreamPrefersPendingErrorOverCompletion(t *testing.T) {
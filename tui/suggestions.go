@@ -0,0 +1,200 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"charm.land/bubbles/v2/list"
+	"charm.land/bubbles/v2/viewport"
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/history"
+	"github.com/ionut-t/bark/v2/internal/patch"
+	"github.com/ionut-t/bark/v2/internal/utils"
+	"github.com/ionut-t/coffee/styles"
+)
+
+type closeSuggestionsMsg struct{}
+
+type suggestionItem struct {
+	index      int
+	suggestion patch.Suggestion
+}
+
+func (i suggestionItem) Title() string {
+	return fmt.Sprintf("%d. %s", i.index, i.suggestion.Title())
+}
+
+func (i suggestionItem) FilterValue() string { return i.suggestion.File }
+
+// suggestionsModel lists the diff blocks of a saved review, previews the
+// selected one against the current file and applies or undoes it.
+type suggestionsModel struct {
+	width, height int
+	storage       string
+	entry         history.Entry
+	list          list.Model
+	preview       viewport.Model
+	previewed     int
+	status        string
+	statusErr     bool
+	styles        styles.Styles
+}
+
+func newSuggestionsModel(storage string, entry history.Entry, s styles.Styles, isDarkMode bool, width, height int) suggestionsModel {
+	suggestions := entry.Suggestions()
+	items := make([]list.Item, len(suggestions))
+	for i, suggestion := range suggestions {
+		items[i] = suggestionItem{index: i + 1, suggestion: suggestion}
+	}
+
+	m := suggestionsModel{
+		storage: storage,
+		entry:   entry,
+		list:    newListModel("Suggestions", items, s, isDarkMode),
+		preview: viewport.New(),
+		styles:  s,
+	}
+	m.list.SetShowHelp(false)
+	m.setSize(width, height)
+
+	return m
+}
+
+func (m *suggestionsModel) setSize(width, height int) {
+	m.width = width
+	m.height = height
+
+	listHeight := min(len(m.list.Items())+4, max(height/3, 6))
+	m.list.SetSize(width, listHeight)
+
+	// Leave room for the list, the status line and the help line.
+	m.preview.SetWidth(max(width-4, 10))
+	m.preview.SetHeight(max(height-listHeight-5, 3))
+}
+
+// selected returns the 1-based number of the highlighted suggestion, or 0.
+func (m suggestionsModel) selected() int {
+	if item, ok := m.list.SelectedItem().(suggestionItem); ok {
+		return item.index
+	}
+	return 0
+}
+
+func (m suggestionsModel) Init() tea.Cmd {
+	if n := m.selected(); n > 0 {
+		return previewSuggestionCmd(m.entry, n)
+	}
+	return nil
+}
+
+func (m suggestionsModel) Update(msg tea.Msg) (suggestionsModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case suggestionPreviewMsg:
+		if msg.suggestion != m.selected() {
+			return m, nil
+		}
+
+		m.previewed = msg.suggestion
+		if msg.err != nil {
+			m.preview.SetContent(m.styles.Error.Render(styles.Wrap(m.preview.Width(), msg.err.Error())))
+		} else {
+			m.preview.SetContent(m.renderDiff(msg.diff))
+		}
+		m.preview.GotoTop()
+		return m, nil
+
+	case suggestionAppliedMsg:
+		if msg.err != nil {
+			m.status, m.statusErr = msg.err.Error(), true
+			return m, nil
+		}
+
+		m.entry = msg.entry
+		m.status, m.statusErr = msg.message, false
+		return m, previewSuggestionCmd(m.entry, m.selected())
+
+	case tea.KeyMsg:
+		if m.list.FilterState() == list.Filtering {
+			break
+		}
+
+		switch msg.String() {
+		case "esc":
+			return m, utils.DispatchMsg(closeSuggestionsMsg{})
+		case "enter", "a":
+			if n := m.selected(); n > 0 {
+				return m, applySuggestionCmd(m.storage, m.entry, n)
+			}
+			return m, nil
+		case "u":
+			return m, applySuggestionCmd(m.storage, m.entry, 0)
+		case "ctrl+d", "pgdown":
+			m.preview.HalfPageDown()
+			return m, nil
+		case "ctrl+u", "pgup":
+			m.preview.HalfPageUp()
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+
+	if n := m.selected(); n > 0 && n != m.previewed {
+		m.previewed = n
+		m.preview.SetContent(m.styles.Subtext0.Render("Loading preview..."))
+		return m, tea.Batch(cmd, previewSuggestionCmd(m.entry, n))
+	}
+
+	return m, cmd
+}
+
+func (m suggestionsModel) renderDiff(diff string) string {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+			lines[i] = m.styles.Text.Bold(true).Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = m.styles.Accent.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = m.styles.Success.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = m.styles.Error.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m suggestionsModel) View() string {
+	if len(m.list.Items()) == 0 {
+		return m.styles.Info.Padding(2).Render(
+			"This review has no suggested diffs.\n\nPress esc to go back.",
+		)
+	}
+
+	status := m.styles.Subtext0.Render(fmt.Sprintf("Review %s", m.entry.ID))
+	if m.status != "" {
+		style := m.styles.Success
+		if m.statusErr {
+			style = m.styles.Error
+		}
+		status = style.Render(m.status)
+	}
+
+	help := m.styles.Subtext0.Render("enter apply • u undo last • ctrl+d/ctrl+u scroll preview • esc back")
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		renderList(m.list.View()),
+		lipgloss.NewStyle().
+			Width(m.width).
+			Padding(0, 1).
+			Border(lipgloss.NormalBorder(), true, false).
+			BorderForeground(m.styles.Overlay0.GetForeground()).
+			Render(m.preview.View()),
+		lipgloss.NewStyle().Padding(0, 2).Render(status),
+		lipgloss.NewStyle().Padding(0, 2).Render(help),
+	)
+}
@@ -3,6 +3,7 @@ package tui
 import (
 	"charm.land/bubbles/v2/list"
 	tea "charm.land/bubbletea/v2"
+	"github.com/ionut-t/bark/v2/internal/config"
 	"github.com/ionut-t/bark/v2/internal/utils"
 	"github.com/ionut-t/coffee/styles"
 )
@@ -29,6 +30,20 @@ func (c Task) String() string {
 	}
 }
 
+// profileTask returns the config task whose profile c uses.
+func (c Task) profileTask() string {
+	switch c {
+	case TaskReview:
+		return config.TaskReview
+	case TaskCommit:
+		return config.TaskCommit
+	case TaskPRDescription:
+		return config.TaskPR
+	default:
+		return ""
+	}
+}
+
 type taskSelectedMsg struct {
 	task Task
 }
@@ -0,0 +1,100 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"charm.land/bubbles/v2/viewport"
+	"charm.land/lipgloss/v2"
+	"github.com/ionut-t/bark/v2/internal/llm"
+	"github.com/ionut-t/coffee/styles"
+)
+
+// thinkingModel is the collapsible pane above a review that shows the
+// model's reasoning. It renders nothing until thinking arrives.
+type thinkingModel struct {
+	width, height int
+	content       string
+	expanded      bool
+	viewport      viewport.Model
+	styles        styles.Styles
+}
+
+func newThinkingModel() thinkingModel {
+	return thinkingModel{viewport: viewport.New()}
+}
+
+func (m *thinkingModel) reset() {
+	m.content = ""
+	m.viewport.SetContent("")
+}
+
+func (m *thinkingModel) append(thinking string) {
+	m.content += thinking
+	m.render()
+	m.viewport.GotoBottom()
+}
+
+func (m *thinkingModel) toggle() {
+	m.expanded = !m.expanded
+}
+
+func (m thinkingModel) visible() bool {
+	return m.content != ""
+}
+
+// paneHeight is the height the pane takes out of height, the review's.
+func (m thinkingModel) paneHeight(height int) int {
+	if !m.visible() {
+		return 0
+	}
+	if !m.expanded {
+		return 1
+	}
+	return max(height/3, 5)
+}
+
+func (m *thinkingModel) setSize(width, height int) {
+	m.width = width
+	m.height = height
+
+	m.viewport.SetWidth(max(width-2, 10))
+	// Title and bottom border.
+	m.viewport.SetHeight(max(height-2, 1))
+	m.render()
+}
+
+func (m *thinkingModel) render() {
+	m.viewport.SetContent(m.styles.Subtext0.Render(styles.Wrap(max(m.viewport.Width()-2, 10), m.content)))
+}
+
+func (m *thinkingModel) scrollDown() {
+	m.viewport.HalfPageDown()
+}
+
+func (m *thinkingModel) scrollUp() {
+	m.viewport.HalfPageUp()
+}
+
+func (m thinkingModel) View() string {
+	tokens := formatTokens(int64(llm.EstimateTokens(m.content)))
+
+	if !m.expanded {
+		return m.styles.Subtext0.Width(m.width).Padding(0, 1).
+			Render(fmt.Sprintf("▸ Thinking (~%s tokens) • t expand", tokens))
+	}
+
+	title := m.styles.Subtext0.Padding(0, 1).
+		Render(fmt.Sprintf("▾ Thinking (~%s tokens) • t collapse • [ ] scroll", tokens))
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		title,
+		lipgloss.NewStyle().
+			Width(m.width).
+			Padding(0, 1).
+			Border(lipgloss.NormalBorder(), false, false, true, false).
+			BorderForeground(m.styles.Overlay0.GetForeground()).
+			Render(strings.TrimRight(m.viewport.View(), "\n")),
+	)
+}
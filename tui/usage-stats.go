@@ -63,7 +63,10 @@ func renderUsageStats(stats *usageStats, s styles.Styles) string {
 		row("Provider:", stats.provider),
 		row("Model:", stats.model),
 		row("Input:", formatTokens(stats.usage.InputTokens)+" tokens"),
+		row("Cache read:", formatTokens(stats.usage.CacheReadTokens)+" tokens"),
+		row("Cache write:", formatTokens(stats.usage.CacheWriteTokens)+" tokens"),
 		row("Output:", formatTokens(stats.usage.OutputTokens)+" tokens"),
+		row("Thinking:", formatTokens(stats.usage.ThinkingTokens)+" tokens"),
 		row("Total:", formatTokens(stats.usage.TotalTokens)+" tokens"),
 		row("Duration:", formatDuration(d)),
 		"",
@@ -77,6 +80,23 @@ func renderUsageStats(stats *usageStats, s styles.Styles) string {
 		Render(content)
 }
 
+func renderBudgetNotice(notice string, s styles.Styles) string {
+	content := lipgloss.JoinVertical(
+		lipgloss.Left,
+		s.Warning.Bold(true).Render("Budget exceeded"),
+		"",
+		s.Text.Width(50).Render(notice+". The request was sent anyway; set budget_action = \"abort\" to refuse such requests."),
+		"",
+		s.Subtext0.Render("press any key to close"),
+	)
+
+	return lipgloss.NewStyle().
+		Padding(1, 2).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(s.Warning.GetForeground()).
+		Render(content)
+}
+
 func formatTokens(n int64) string {
 	s := strconv.FormatInt(n, 10)
 	if len(s) <= 3 {